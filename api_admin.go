@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// AuthenticateAdmin authenticates a request and ensures that it was made by an administrator.
+func AuthenticateAdmin(c *Context, w http.ResponseWriter, r *http.Request) (*Account, error) {
+	account, err := Authenticate(c, w, r)
+	if err != nil {
+		return nil, err
+	}
+
+	if !account.Admin {
+		apiErr := APIError{
+			Code:    CodeAdminRequired,
+			Message: fmt.Sprintf("Account [%s] is not an administrator.", account.Name),
+			Hint:    "Only administrators may use this endpoint.",
+			Retry:   false,
+		}
+		apiErr.Log(account).Report(http.StatusForbidden, w)
+		return nil, &apiErr
+	}
+
+	return account, nil
+}
+
+// WorkerPoolHandler reports the occupancy of the job runner's worker pool.
+func WorkerPoolHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	if _, err := AuthenticateAdmin(c, w, r); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Authentication failure.")
+		return
+	}
+
+	stats := c.Pool.Stats()
+	stats.Hosts = c.Hosts.Stats()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// RetentionPreviewHandler lists the jobs that would be archived and purged if the retention policy
+// were applied right now, without modifying anything.
+func RetentionPreviewHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	type ExpiredJob struct {
+		JID        uint64     `json:"jid"`
+		Account    string     `json:"account"`
+		Status     string     `json:"status"`
+		FinishedAt StoredTime `json:"finished_at"`
+	}
+
+	type Response struct {
+		RetainHours map[string]int `json:"retain_hours"`
+		Jobs        []ExpiredJob   `json:"jobs"`
+	}
+
+	account, err := AuthenticateAdmin(c, w, r)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Authentication failure.")
+		return
+	}
+
+	limit := 1000
+	if rawLimit := r.FormValue("limit"); rawLimit != "" {
+		parsed, err := strconv.ParseInt(rawLimit, 10, 0)
+		if err != nil || parsed < 1 {
+			APIError{
+				Code:    CodeUnableToParseQuery,
+				Message: fmt.Sprintf("Invalid limit [%s]", rawLimit),
+				Hint:    "Please specify a valid, positive integral limit.",
+				Retry:   false,
+			}.Log(account).Report(http.StatusBadRequest, w)
+			return
+		}
+		limit = int(parsed)
+	}
+
+	policy := c.RetentionPolicy()
+	expired, err := ExpiredJobs(c, policy, time.Now(), limit)
+	if err != nil {
+		APIError{
+			Code:    CodeRetentionFailure,
+			Message: fmt.Sprintf("Unable to list expired jobs: %v", err),
+			Hint:    "This is most likely a database problem.",
+			Retry:   true,
+		}.Log(account).Report(http.StatusServiceUnavailable, w)
+		return
+	}
+
+	response := Response{
+		RetainHours: make(map[string]int, len(policy)),
+		Jobs:        make([]ExpiredJob, len(expired)),
+	}
+	for status, age := range policy {
+		response.RetainHours[status] = int(age / time.Hour)
+	}
+	for i, job := range expired {
+		response.Jobs[i] = ExpiredJob{
+			JID:        job.JID,
+			Account:    job.Account,
+			Status:     job.Status,
+			FinishedAt: job.FinishedAt,
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"account": account.Name,
+		"expired": len(expired),
+	}).Debug("Previewed the retention policy.")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// AccountPriorityHandler sets the highest priority that an account may request for its jobs. A
+// max_priority of zero reverts the account to the server's default.
+func AccountPriorityHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	account, err := AuthenticateAdmin(c, w, r)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Authentication failure.")
+		return
+	}
+
+	if err = r.ParseForm(); err != nil {
+		APIError{
+			Code:    CodeInvalidJobForm,
+			Message: fmt.Sprintf("Unable to parse Admin: Priority payload as a POST body: %v", err),
+			Hint:    "Please use valid form encoding in your request.",
+			Retry:   false,
+		}.Log(account).Report(http.StatusBadRequest, w)
+		return
+	}
+
+	name := r.PostFormValue("account")
+	if name == "" {
+		APIError{
+			Code:    CodeInvalidJobForm,
+			Message: "Admin: Priority requires an account name.",
+			Hint:    `Please provide the name of the account to update as "account".`,
+			Retry:   false,
+		}.Log(account).Report(http.StatusBadRequest, w)
+		return
+	}
+
+	rawMax := r.PostFormValue("max_priority")
+	max, err := strconv.Atoi(rawMax)
+	if err != nil || max < 0 {
+		APIError{
+			Code:    CodeInvalidPriority,
+			Message: fmt.Sprintf("Invalid maximum priority [%s]", rawMax),
+			Hint:    `Please provide a non-negative integer as "max_priority".`,
+			Retry:   false,
+		}.Log(account).Report(http.StatusBadRequest, w)
+		return
+	}
+
+	// Create the account if it hasn't been seen yet, so that limits can be granted in advance.
+	if _, err = c.GetAccount(name); err == nil {
+		err = c.UpdateAccountMaxPriority(name, max)
+	}
+	if err != nil {
+		APIError{
+			Code:    CodeAccountUpdateFailure,
+			Message: fmt.Sprintf("Unable to update account [%s]: %v", name, err),
+			Hint:    "This is probably a storage error on our end.",
+			Retry:   true,
+		}.Log(account).Report(http.StatusInternalServerError, w)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"account":      name,
+		"admin":        account.Name,
+		"max priority": max,
+	}).Info("Account priority limit updated.")
+
+	OKResponse(w)
+}
+
+// JobReprioritizeHandler changes the priority of a queued job on behalf of any account. Jobs that
+// have already been claimed can't be reprioritized.
+func JobReprioritizeHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	account, err := AuthenticateAdmin(c, w, r)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Authentication failure.")
+		return
+	}
+
+	if err = r.ParseForm(); err != nil {
+		APIError{
+			Code:    CodeInvalidJobForm,
+			Message: fmt.Sprintf("Unable to parse Admin: Reprioritize payload as a POST body: %v", err),
+			Hint:    "Please use valid form encoding in your request.",
+			Retry:   false,
+		}.Log(account).Report(http.StatusBadRequest, w)
+		return
+	}
+
+	jidstr := r.PostFormValue("jid")
+	jid, err := strconv.ParseUint(jidstr, 10, 64)
+	if err != nil {
+		APIError{
+			Code:    CodeInvalidJobForm,
+			Message: fmt.Sprintf("Unable to parse Admin: Reprioritize payload as a valid JID: %v", err),
+			Hint:    "Please provide a valid integer job ID to Admin: Reprioritize.",
+			Retry:   false,
+		}.Log(account).Report(http.StatusBadRequest, w)
+		return
+	}
+
+	rawPriority := r.PostFormValue("priority")
+	priority, err := strconv.Atoi(rawPriority)
+	if err != nil || priority < 0 {
+		APIError{
+			Code:    CodeInvalidPriority,
+			Message: fmt.Sprintf("Invalid priority [%s]", rawPriority),
+			Hint:    `Please provide a non-negative integer as "priority".`,
+			Retry:   false,
+		}.Log(account).Report(http.StatusBadRequest, w)
+		return
+	}
+
+	err = c.ReprioritizeJob(jid, priority)
+	if err == ErrNotFound {
+		// Distinguish a job that doesn't exist from one that has left the queue.
+		jobs, listErr := c.ListJobs(JobQuery{JIDs: []uint64{jid}})
+		if listErr == nil && len(jobs) == 0 {
+			APIError{
+				Code:    CodeJobNotFound,
+				Message: fmt.Sprintf("Unable to find a job with ID [%d].", jid),
+				Hint:    "Make sure that the JID is still valid.",
+				Retry:   false,
+			}.Log(account).Report(http.StatusNotFound, w)
+			return
+		}
+
+		APIError{
+			Code:    CodeJobNotQueued,
+			Message: fmt.Sprintf("Job [%d] is no longer queued.", jid),
+			Hint:    "Only queued jobs may be reprioritized.",
+			Retry:   false,
+		}.Log(account).Report(http.StatusConflict, w)
+		return
+	}
+	if err != nil {
+		APIError{
+			Code:    CodeJobUpdateFailure,
+			Message: fmt.Sprintf("Unable to reprioritize job [%d]: %v", jid, err),
+			Hint:    "This is probably a storage error on our end.",
+			Retry:   true,
+		}.Log(account).Report(http.StatusInternalServerError, w)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"jid":      jid,
+		"admin":    account.Name,
+		"priority": priority,
+	}).Info("Job reprioritized.")
+
+	OKResponse(w)
+}
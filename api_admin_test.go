@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetentionPreview(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{
+		Settings: Settings{
+			AdminName:  "admin",
+			AdminKey:   "12345",
+			RetainDone: 1,
+		},
+		Storage: s,
+	}
+
+	old := StoreTime(time.Now().Add(-2 * time.Hour))
+	jids := insertJobs(t, s,
+		SubmittedJob{Account: "alice", Status: StatusDone, FinishedAt: old},
+		SubmittedJob{Account: "alice", Status: StatusDone, FinishedAt: StoreTime(time.Now())},
+		SubmittedJob{Account: "alice", Status: StatusError, FinishedAt: old},
+	)
+
+	r, err := http.NewRequest("GET", "https://localhost/v1/admin/retention", nil)
+	if err != nil {
+		t.Fatalf("Unable to create request: %v", err)
+	}
+	r.SetBasicAuth("admin", "12345")
+	w := httptest.NewRecorder()
+
+	RetentionPreviewHandler(c, w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected HTTP status: [%d] [%s]", w.Code, w.Body.String())
+	}
+
+	var response struct {
+		RetainHours map[string]int `json:"retain_hours"`
+		Jobs        []struct {
+			JID     uint64 `json:"jid"`
+			Account string `json:"account"`
+		} `json:"jobs"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Unable to parse response body [%s]: %v", w.Body.String(), err)
+	}
+
+	if response.RetainHours[StatusDone] != 1 || len(response.RetainHours) != 1 {
+		t.Errorf("Unexpected retention policy: %v", response.RetainHours)
+	}
+	if len(response.Jobs) != 1 || response.Jobs[0].JID != jids[0] || response.Jobs[0].Account != "alice" {
+		t.Errorf("Unexpected expired jobs: %#v", response.Jobs)
+	}
+
+	// Previewing doesn't purge anything.
+	expectJIDs(t, s, JobQuery{}, jids...)
+}
+
+// AcceptingAuthService is an AuthService that accepts every account.
+type AcceptingAuthService struct {
+	NullAuthService
+}
+
+func (service AcceptingAuthService) Validate(accountName, apiKey string) (bool, error) {
+	return true, nil
+}
+
+func TestRetentionPreviewRequiresAdmin(t *testing.T) {
+	c := &Context{
+		Settings:    Settings{AdminName: "admin", AdminKey: "12345"},
+		Storage:     NewMemoryStorage(),
+		AuthService: AcceptingAuthService{},
+	}
+
+	r, err := http.NewRequest("GET", "https://localhost/v1/admin/retention", nil)
+	if err != nil {
+		t.Fatalf("Unable to create request: %v", err)
+	}
+	r.SetBasicAuth("alice", "secret")
+	w := httptest.NewRecorder()
+
+	RetentionPreviewHandler(c, w, r)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Unexpected HTTP status: [%d]", w.Code)
+	}
+}
+
+func TestWorkerPoolStats(t *testing.T) {
+	c := &Context{
+		Settings: Settings{AdminName: "admin", AdminKey: "12345"},
+		Storage:  NewMemoryStorage(),
+		Pool:     NewWorkerPool(4),
+	}
+	c.Pool.Reserve()
+
+	r, err := http.NewRequest("GET", "https://localhost/v1/admin/workers", nil)
+	if err != nil {
+		t.Fatalf("Unable to create request: %v", err)
+	}
+	r.SetBasicAuth("admin", "12345")
+	w := httptest.NewRecorder()
+
+	WorkerPoolHandler(c, w, r)
+
+	var stats PoolStats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("Unable to parse response body [%s]: %v", w.Body.String(), err)
+	}
+	if stats.Size != 4 || stats.Busy != 1 {
+		t.Errorf("Unexpected pool stats: %#v", stats)
+	}
+}
+
+// adminPost issues a form-encoded POST to an administrative handler as the administrator.
+func adminPost(t *testing.T, c *Context, handler ContextHandler, form url.Values) *httptest.ResponseRecorder {
+	r, err := http.NewRequest("POST", "https://localhost/v1/admin", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatalf("Unable to create request: %v", err)
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth("admin", "12345")
+	w := httptest.NewRecorder()
+
+	handler(c, w, r)
+	return w
+}
+
+func TestReprioritizeJob(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{
+		Settings: Settings{AdminName: "admin", AdminKey: "12345"},
+		Storage:  s,
+	}
+
+	jids := insertJobs(t, s,
+		SubmittedJob{Account: "alice", Status: StatusQueued},
+		SubmittedJob{Account: "alice", Status: StatusDone},
+	)
+
+	w := adminPost(t, c, JobReprioritizeHandler, url.Values{
+		"jid":      {fmt.Sprintf("%d", jids[0])},
+		"priority": {"7"},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected HTTP status: [%d] [%s]", w.Code, w.Body.String())
+	}
+	jobs, err := s.ListJobs(JobQuery{JIDs: jids[:1]})
+	if err != nil {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].Priority != 7 {
+		t.Errorf("Expected the job to be reprioritized, got %#v", jobs)
+	}
+
+	w = adminPost(t, c, JobReprioritizeHandler, url.Values{
+		"jid":      {fmt.Sprintf("%d", jids[1])},
+		"priority": {"7"},
+	})
+	hasError(t, w, http.StatusConflict, APIError{
+		Code:    CodeJobNotQueued,
+		Message: fmt.Sprintf("Job [%d] is no longer queued.", jids[1]),
+	})
+
+	w = adminPost(t, c, JobReprioritizeHandler, url.Values{"jid": {"999"}, "priority": {"7"}})
+	hasError(t, w, http.StatusNotFound, APIError{
+		Code:    CodeJobNotFound,
+		Message: "Unable to find a job with ID [999].",
+	})
+
+	w = adminPost(t, c, JobReprioritizeHandler, url.Values{
+		"jid":      {fmt.Sprintf("%d", jids[0])},
+		"priority": {"high"},
+	})
+	hasError(t, w, http.StatusBadRequest, APIError{
+		Code:    CodeInvalidPriority,
+		Message: "Invalid priority [high]",
+	})
+}
+
+func TestAccountPriority(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{
+		Settings: Settings{AdminName: "admin", AdminKey: "12345", DefaultMaxPriority: 2},
+		Storage:  s,
+	}
+
+	w := adminPost(t, c, AccountPriorityHandler, url.Values{"account": {"alice"}, "max_priority": {"5"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected HTTP status: [%d] [%s]", w.Code, w.Body.String())
+	}
+
+	alice, err := s.GetAccount("alice")
+	if err != nil {
+		t.Fatalf("Unable to get an account: %v", err)
+	}
+	if alice.MaxPriority != 5 || c.PriorityLimit(alice) != 5 {
+		t.Errorf("Expected alice's limit to be raised, got %#v", alice)
+	}
+
+	bob, err := s.GetAccount("bob")
+	if err != nil {
+		t.Fatalf("Unable to get an account: %v", err)
+	}
+	if c.PriorityLimit(bob) != 2 {
+		t.Errorf("Expected bob to receive the default limit, got [%d]", c.PriorityLimit(bob))
+	}
+
+	w = adminPost(t, c, AccountPriorityHandler, url.Values{"account": {"alice"}, "max_priority": {"-1"}})
+	hasError(t, w, http.StatusBadRequest, APIError{
+		Code:    CodeInvalidPriority,
+		Message: "Invalid maximum priority [-1]",
+	})
+}
+
+// accountFailureStorage fails to look up or update a single account.
+type accountFailureStorage struct {
+	Storage
+
+	account   string
+	getErr    error
+	updateErr error
+}
+
+func (s accountFailureStorage) GetAccount(name string) (*Account, error) {
+	if name == s.account && s.getErr != nil {
+		return nil, s.getErr
+	}
+	return s.Storage.GetAccount(name)
+}
+
+func (s accountFailureStorage) UpdateAccountMaxPriority(name string, max int) error {
+	if name == s.account && s.updateErr != nil {
+		return s.updateErr
+	}
+	return s.Storage.UpdateAccountMaxPriority(name, max)
+}
+
+func TestAccountPriorityStorageFailure(t *testing.T) {
+	failure := errors.New("storage is down")
+	c := &Context{Settings: Settings{AdminName: "admin", AdminKey: "12345"}}
+	form := url.Values{"account": {"alice"}, "max_priority": {"5"}}
+
+	c.Storage = accountFailureStorage{Storage: NewMemoryStorage(), account: "alice", getErr: failure}
+	w := adminPost(t, c, AccountPriorityHandler, form)
+	hasError(t, w, http.StatusInternalServerError, APIError{
+		Code:    CodeAccountUpdateFailure,
+		Message: "Unable to update account [alice]: storage is down",
+		Retry:   true,
+	})
+
+	c.Storage = accountFailureStorage{Storage: NewMemoryStorage(), account: "alice", updateErr: failure}
+	w = adminPost(t, c, AccountPriorityHandler, form)
+	hasError(t, w, http.StatusInternalServerError, APIError{
+		Code:    CodeAccountUpdateFailure,
+		Message: "Unable to update account [alice]: storage is down",
+		Retry:   true,
+	})
+}
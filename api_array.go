@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// ArrayIDVariable and ArrayIndexVariable are set in the environment of every element of a job
+	// array, to the array's ID and the element's position within it.
+	ArrayIDVariable    = "CLOUDPIPE_ARRAY_ID"
+	ArrayIndexVariable = "CLOUDPIPE_ARRAY_INDEX"
+
+	// maxArraySize is the largest number of elements that a single job array may have.
+	maxArraySize = 10000
+)
+
+// ArraySummary reports on the elements of a job array, with the number of them in each status.
+type ArraySummary struct {
+	ID     uint64         `json:"id"`
+	Size   int            `json:"size"`
+	Counts map[string]int `json:"counts"`
+	JIDs   []uint64       `json:"jids"`
+}
+
+// expandArray builds the elements of a job array from a template, giving each element its own
+// stdin and environment overrides, if any, along with the array's variables. stdin and env may be
+// empty, but if both are given they must be the same length.
+func expandArray(template Job, stdin [][]byte, env []map[string]string, array uint64) ([]Job, *APIError) {
+	size := len(stdin)
+	if len(env) > size {
+		size = len(env)
+	}
+
+	if size == 0 {
+		return nil, &APIError{
+			Code:    CodeInvalidArray,
+			Message: "Job arrays must have at least one element.",
+			Hint:    `Give each element its own "stdin" or "env".`,
+		}
+	}
+	if size > maxArraySize {
+		return nil, &APIError{
+			Code:    CodeInvalidArray,
+			Message: fmt.Sprintf("Job arrays may have at most %d elements, not %d.", maxArraySize, size),
+			Hint:    "Split your array into several smaller ones.",
+		}
+	}
+	if len(stdin) > 0 && len(env) > 0 && len(stdin) != len(env) {
+		return nil, &APIError{
+			Code:    CodeInvalidArray,
+			Message: fmt.Sprintf("The array has %d stdin elements but %d env elements.", len(stdin), len(env)),
+			Hint:    `"stdin" and "env" must have one entry for each element.`,
+		}
+	}
+
+	jobs := make([]Job, size)
+	for index := range jobs {
+		job := template
+		if len(stdin) > 0 {
+			job.Stdin = stdin[index]
+		}
+
+		job.Environment = make(map[string]string, len(template.Environment)+2)
+		for key, value := range template.Environment {
+			job.Environment[key] = value
+		}
+		if len(env) > 0 {
+			for key, value := range env[index] {
+				job.Environment[key] = value
+			}
+		}
+		job.Environment[ArrayIDVariable] = strconv.FormatUint(array, 10)
+		job.Environment[ArrayIndexVariable] = strconv.Itoa(index)
+
+		jobs[index] = job
+	}
+	return jobs, nil
+}
+
+// JobArrayHandler dispatches API calls to /job/array based on request type.
+func JobArrayHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		JobArraySummaryHandler(c, w, r)
+	case "POST":
+		JobArraySubmitHandler(c, w, r)
+	default:
+		APIError{
+			Code:    CodeMethodNotSupported,
+			Message: "Method not supported",
+			Hint:    "Use GET or POST against this endpoint.",
+			Retry:   false,
+		}.Report(http.StatusMethodNotAllowed, w)
+	}
+}
+
+// JobArraySubmitHandler expands a job template into a job array and enqueues every element of it,
+// all or nothing.
+func JobArraySubmitHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	type Request struct {
+		Job   Job                 `json:"job"`
+		Stdin [][]byte            `json:"stdin"`
+		Env   []map[string]string `json:"env"`
+	}
+
+	type Response struct {
+		Array uint64   `json:"array"`
+		JIDs  []uint64 `json:"jids"`
+	}
+
+	account, err := Authenticate(c, w, r)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Authentication failure.")
+		return
+	}
+
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		APIError{
+			Code:    CodeInvalidJobJSON,
+			Message: fmt.Sprintf("Unable to parse job array payload as JSON: %v", err),
+			Hint:    "Please supply valid JSON in your request.",
+			Retry:   false,
+		}.Log(account).Report(http.StatusBadRequest, w)
+		return
+	}
+
+	array, err := c.NextArrayID()
+	if err != nil {
+		APIError{
+			Code:    CodeEnqueueFailure,
+			Message: fmt.Sprintf("Unable to allocate a job array: %v", err),
+			Hint:    "This is probably a storage error on our end.",
+			Retry:   true,
+		}.Log(account).Report(http.StatusServiceUnavailable, w)
+		return
+	}
+
+	jobs, apiErr := expandArray(req.Job, req.Stdin, req.Env, array)
+	if apiErr != nil {
+		apiErr.Log(account).Report(http.StatusBadRequest, w)
+		return
+	}
+
+	jids, ok := submitJobs(c, w, account, jobs, array)
+	if !ok {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"array":   array,
+		"size":    len(jids),
+		"account": account.Name,
+	}).Info("Successfully submitted a job array.")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Response{Array: array, JIDs: jids})
+}
+
+// JobArraySummaryHandler counts the elements of a job array in each status.
+func JobArraySummaryHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	account, err := Authenticate(c, w, r)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Authentication failure.")
+		return
+	}
+
+	array, jobs := findArray(c, account, w, r)
+	if jobs == nil {
+		return
+	}
+
+	summary := ArraySummary{
+		ID:     array,
+		Size:   len(jobs),
+		Counts: make(map[string]int),
+		JIDs:   make([]uint64, len(jobs)),
+	}
+	for i, job := range jobs {
+		summary.Counts[job.Status]++
+		summary.JIDs[i] = job.JID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+// JobArrayKillHandler kills every element of a job array that hasn't already finished.
+func JobArrayKillHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	account, err := Authenticate(c, w, r)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Authentication failure.")
+		return
+	}
+
+	array, jobs := findArray(c, account, w, r)
+	if jobs == nil {
+		return
+	}
+
+	killed := []uint64{}
+	for i := range jobs {
+		job := &jobs[i]
+		if completedStatus[job.Status] {
+			continue
+		}
+
+		if _, err := KillJob(c, job); err != nil {
+			err.Log(account).Report(http.StatusInternalServerError, w)
+			return
+		}
+		killed = append(killed, job.JID)
+	}
+
+	log.WithFields(log.Fields{
+		"array":   array,
+		"killed":  len(killed),
+		"account": account.Name,
+	}).Info("Job array kill requested.")
+
+	var response struct {
+		Killed []uint64 `json:"killed"`
+	}
+	response.Killed = killed
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// findArray loads the elements of one of an account's job arrays by the "id" in a request's form.
+// If it can't, it reports an error to the client and returns nil.
+func findArray(c *Context, account *Account, w http.ResponseWriter, r *http.Request) (uint64, []SubmittedJob) {
+	if err := r.ParseForm(); err != nil {
+		APIError{
+			Code:    CodeUnableToParseQuery,
+			Message: fmt.Sprintf("Unable to parse the request's form: %v", err),
+			Hint:    "Please use valid form encoding in your request.",
+			Retry:   false,
+		}.Log(account).Report(http.StatusBadRequest, w)
+		return 0, nil
+	}
+
+	rawID := r.FormValue("id")
+	array, err := strconv.ParseUint(rawID, 10, 64)
+	if err != nil {
+		APIError{
+			Code:    CodeUnableToParseQuery,
+			Message: fmt.Sprintf("Unable to parse array ID [%s]: %v", rawID, err),
+			Hint:    "Please provide a valid integer array ID.",
+			Retry:   false,
+		}.Log(account).Report(http.StatusBadRequest, w)
+		return 0, nil
+	}
+	if array == 0 {
+		// Zero marks jobs that don't belong to any array.
+		APIError{
+			Code:    CodeUnableToParseQuery,
+			Message: "Array IDs start at 1.",
+			Hint:    "Please provide the ID returned when the array was submitted.",
+			Retry:   false,
+		}.Log(account).Report(http.StatusBadRequest, w)
+		return 0, nil
+	}
+
+	jobs, err := c.ListJobs(JobQuery{AccountName: account.Name, Array: array, SkipOutput: true})
+	if err != nil {
+		APIError{
+			Code:    CodeListFailure,
+			Message: fmt.Sprintf("Unable to list jobs: %v", err),
+			Hint:    "This is most likely a database problem.",
+			Retry:   true,
+		}.Log(account).Report(http.StatusServiceUnavailable, w)
+		return 0, nil
+	}
+	if len(jobs) == 0 {
+		APIError{
+			Code:    CodeArrayNotFound,
+			Message: fmt.Sprintf("Unable to find a job array with ID [%d].", array),
+			Hint:    "Make sure that the array ID is still valid.",
+			Retry:   false,
+		}.Log(account).Report(http.StatusNotFound, w)
+		return 0, nil
+	}
+	return array, jobs
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// submitArray posts a job array to JobArrayHandler as the admin account.
+func submitArray(t *testing.T, c *Context, body string) *httptest.ResponseRecorder {
+	r, err := http.NewRequest("POST", "https://localhost/v1/job/array", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Unable to create request: %v", err)
+	}
+	r.SetBasicAuth("admin", "12345")
+	w := httptest.NewRecorder()
+
+	JobArrayHandler(c, w, r)
+	return w
+}
+
+func TestSubmitInvalidArray(t *testing.T) {
+	c := &Context{
+		Settings: Settings{AdminName: "admin", AdminKey: "12345"},
+		Storage:  NewMemoryStorage(),
+		Cores:    DefaultCoreCatalog(),
+	}
+
+	job := `{"cmd": "id", "result_source": "stdout", "result_type": "binary"}`
+	cases := map[string]string{
+		CodeInvalidJobJSON: `{`,
+		CodeInvalidArray:   `{"job": ` + job + `}`,
+		CodeMissingCommand: `{"job": {"result_source": "stdout", "result_type": "binary"}, "env": [{}]}`,
+	}
+	for code, body := range cases {
+		w := submitArray(t, c, body)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), code) {
+			t.Errorf("Expected a [%s] error, got [%d] [%s]", code, w.Code, w.Body.String())
+		}
+	}
+
+	w := submitArray(t, c, `{"job": `+job+`, "stdin": ["YQ==", "Yg=="], "env": [{"A": "1"}]}`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), CodeInvalidArray) {
+		t.Errorf("Expected mismatched elements to be rejected, got [%d] [%s]", w.Code, w.Body.String())
+	}
+
+	if jobs, _ := c.ListJobs(JobQuery{}); len(jobs) != 0 {
+		t.Errorf("Expected no jobs to be submitted, got %d", len(jobs))
+	}
+}
+
+func TestJobArrayInMemory(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{
+		Settings: Settings{AdminName: "admin", AdminKey: "12345"},
+		Storage:  s,
+		Cores:    DefaultCoreCatalog(),
+	}
+
+	// Submit an unrelated job first so that JIDs and array indices differ.
+	insertJobs(t, s, SubmittedJob{Account: "admin", Status: StatusQueued})
+
+	w := submitArray(t, c, `{
+		"job": {"cmd": "sweep", "env": {"MODE": "fast", "RATE": "1"}, "result_source": "stdout", "result_type": "binary"},
+		"stdin": ["YQ==", "Yg==", "Yw=="],
+		"env": [{"RATE": "2"}, {}, {"RATE": "3"}]
+	}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected HTTP status: [%d] [%s]", w.Code, w.Body.String())
+	}
+
+	var submitted struct {
+		Array uint64   `json:"array"`
+		JIDs  []uint64 `json:"jids"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&submitted); err != nil {
+		t.Fatalf("Unable to parse the response: %v", err)
+	}
+	if submitted.Array == 0 || len(submitted.JIDs) != 3 {
+		t.Fatalf("Unexpected response: %#v", submitted)
+	}
+
+	jobs, _ := s.ListJobs(JobQuery{Array: submitted.Array})
+	if len(jobs) != 3 {
+		t.Fatalf("Expected three elements, got %d", len(jobs))
+	}
+	rates, stdin := []string{"2", "1", "3"}, []string{"a", "b", "c"}
+	for i, job := range jobs {
+		if job.ArrayIndex == nil || *job.ArrayIndex != i || job.Account != "admin" || job.Core != "c1" {
+			t.Errorf("Unexpected element %d: %#v", i, job)
+			continue
+		}
+		env := job.Environment
+		if env["MODE"] != "fast" || env["RATE"] != rates[i] || env[ArrayIndexVariable] != strconv.Itoa(i) || env[ArrayIDVariable] != "1" {
+			t.Errorf("Unexpected environment for element %d: %v", i, env)
+		}
+		if string(job.Stdin) != stdin[i] {
+			t.Errorf("Unexpected stdin for element %d: %q", i, job.Stdin)
+		}
+	}
+
+	// The job list can be narrowed to the array.
+	r, _ := http.NewRequest("GET", "https://localhost/v1/job?array=1", nil)
+	r.SetBasicAuth("admin", "12345")
+	w = httptest.NewRecorder()
+	JobListHandler(c, w, r)
+
+	var listed struct {
+		Jobs []SubmittedJob `json:"jobs"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&listed); err != nil {
+		t.Fatalf("Unable to parse the response: %v", err)
+	}
+	if len(listed.Jobs) != 3 {
+		t.Errorf("Expected the array's elements to be listed, got %d jobs", len(listed.Jobs))
+	}
+
+	// Finish one element, then kill the rest.
+	jobs[0].Status = StatusDone
+	s.UpdateJob(&jobs[0])
+
+	id := url.Values{"id": {"1"}}
+	w = adminPost(t, c, JobArrayKillHandler, id)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unable to kill the array: [%d] [%s]", w.Code, w.Body.String())
+	}
+
+	var killed struct {
+		Killed []uint64 `json:"killed"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&killed); err != nil {
+		t.Fatalf("Unable to parse the response: %v", err)
+	}
+	if len(killed.Killed) != 2 || killed.Killed[0] != jobs[1].JID || killed.Killed[1] != jobs[2].JID {
+		t.Errorf("Expected the unfinished elements to be killed, got %v", killed.Killed)
+	}
+
+	// The summary counts the elements in each status.
+	r, _ = http.NewRequest("GET", "https://localhost/v1/job/array?id=1", nil)
+	r.SetBasicAuth("admin", "12345")
+	w = httptest.NewRecorder()
+	JobArrayHandler(c, w, r)
+
+	var summary ArraySummary
+	if err := json.NewDecoder(w.Body).Decode(&summary); err != nil {
+		t.Fatalf("Unable to parse the response: %v", err)
+	}
+	if summary.ID != 1 || summary.Size != 3 || summary.Counts[StatusDone] != 1 || summary.Counts[StatusKilled] != 2 {
+		t.Errorf("Unexpected summary: %#v", summary)
+	}
+
+	// Arrays that don't exist, or belong to other accounts, aren't found.
+	w = adminPost(t, c, JobArrayKillHandler, url.Values{"id": {"2"}})
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), CodeArrayNotFound) {
+		t.Errorf("Expected a missing array to be reported, got [%d] [%s]", w.Code, w.Body.String())
+	}
+
+	// Zero is never an array ID, since it marks jobs that don't belong to one.
+	w = adminPost(t, c, JobArrayKillHandler, url.Values{"id": {"0"}})
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), CodeUnableToParseQuery) {
+		t.Errorf("Expected array ID zero to be rejected, got [%d] [%s]", w.Code, w.Body.String())
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -63,54 +64,156 @@ func JobSubmitHandler(c *Context, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	jids := make([]uint64, len(req.Jobs))
-	for index, job := range req.Jobs {
-		// Validate the job.
+	jids, ok := submitJobs(c, w, account, req.Jobs, 0)
+	if !ok {
+		return
+	}
+
+	response := Response{JIDs: jids}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// submitJobs validates a batch of jobs and enqueues them on behalf of an account, all or nothing.
+// array is the ID of the job array that the jobs make up, or 0 if they're independent. If the jobs
+// can't be submitted, the error is reported to the client and ok is false.
+func submitJobs(c *Context, w http.ResponseWriter, account *Account, jobs []Job, array uint64) (jids []uint64, ok bool) {
+	// Validate every job before enqueueing any of them, so that a batch is accepted or rejected as
+	// a whole.
+	priorityLimit := c.PriorityLimit(account)
+	dependencies := []uint64{}
+	createdAt := StoreTime(time.Now())
+	submitted := make([]SubmittedJob, len(jobs))
+	for index, job := range jobs {
 		if err := job.Validate(); err != nil {
 			log.WithFields(log.Fields{
 				"account": account.Name,
 				"job":     job,
+				"index":   index,
 				"error":   err,
 			}).Error("Invalid job submitted.")
 
 			err.Report(http.StatusBadRequest, w)
-			return
+			return nil, false
 		}
+		if _, err := c.Cores.Resolve(&job); err != nil {
+			log.WithFields(log.Fields{
+				"account": account.Name,
+				"job":     job,
+				"index":   index,
+				"error":   err,
+			}).Error("Invalid job submitted.")
 
-		// Pack the job into a SubmittedJob and store it.
-		submitted := SubmittedJob{
-			Job:       job,
-			CreatedAt: StoreTime(time.Now()),
-			Status:    StatusQueued,
-			Account:   account.Name,
+			err.Report(http.StatusBadRequest, w)
+			return nil, false
 		}
-		jid, err := c.InsertJob(submitted)
-		if err != nil {
+		if err := checkPriority(job.Priority, priorityLimit); err != nil {
 			log.WithFields(log.Fields{
 				"account": account.Name,
+				"job":     job,
+				"index":   index,
 				"error":   err,
-			}).Error("Unable to enqueue a submitted job.")
+			}).Error("Invalid job submitted.")
+
+			err.Report(http.StatusBadRequest, w)
+			return nil, false
+		}
+
+		if jid, ok := job.Dependency(); ok {
+			dependencies = append(dependencies, jid)
+		}
 
+		// Pack the job into a SubmittedJob.
+		submitted[index] = SubmittedJob{
+			Job:       job,
+			CreatedAt: createdAt,
+			Status:    StatusQueued,
+			Account:   account.Name,
+		}
+		if array != 0 {
+			position := index
+			submitted[index].Array = array
+			submitted[index].ArrayIndex = &position
+		}
+	}
+
+	// Jobs may only depend on existing jobs from the same account. They wait in StatusWaiting until
+	// their dependency is done.
+	if len(dependencies) > 0 {
+		found, err := c.ListJobs(JobQuery{AccountName: account.Name, JIDs: dependencies, SkipOutput: true})
+		if err != nil {
 			APIError{
-				Code:    CodeEnqueueFailure,
-				Message: "Unable to enqueue your job.",
+				Code:    CodeListFailure,
+				Message: "Unable to list jobs.",
+				Hint:    "This is probably a storage error on our end.",
 				Retry:   true,
-			}.Report(http.StatusServiceUnavailable, w)
-			return
+			}.Log(account).Report(http.StatusInternalServerError, w)
+			return nil, false
 		}
 
-		jids[index] = jid
+		statuses := make(map[uint64]string, len(found))
+		for _, job := range found {
+			statuses[job.JID] = job.Status
+		}
+
+		for index := range submitted {
+			jid, ok := submitted[index].Dependency()
+			if !ok {
+				continue
+			}
+
+			status, exists := statuses[jid]
+			if !exists {
+				APIError{
+					Code:    CodeInvalidDependency,
+					Message: fmt.Sprintf("Unable to find dependency [%d].", jid),
+					Hint:    "Jobs may only depend on jobs that you've already submitted.",
+					Retry:   false,
+				}.Log(account).Report(http.StatusBadRequest, w)
+				return nil, false
+			}
+			if status != StatusDone {
+				submitted[index].Status = StatusWaiting
+			}
+		}
+	}
+
+	// Jobs that shouldn't run yet are scheduled instead. Any dependency is checked again once their
+	// time comes.
+	for index := range submitted {
+		if submitted[index].RunAt > createdAt {
+			submitted[index].Status = StatusScheduled
+		}
+	}
+
+	jids, err := c.InsertJobs(submitted)
+	if err != nil {
 		log.WithFields(log.Fields{
-			"jid":     jid,
-			"job":     job,
 			"account": account.Name,
-		}).Info("Successfully submitted a job.")
+			"count":   len(submitted),
+			"error":   err,
+		}).Error("Unable to enqueue submitted jobs.")
+
+		APIError{
+			Code:    CodeEnqueueFailure,
+			Message: "Unable to enqueue your jobs.",
+			Retry:   true,
+		}.Report(http.StatusServiceUnavailable, w)
+		return nil, false
 	}
 
-	response := Response{JIDs: jids}
+	// Let the job runner claim the new jobs right away.
+	c.Wakeup.Notify()
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	for index, jid := range jids {
+		log.WithFields(log.Fields{
+			"jid":     jid,
+			"job":     jobs[index],
+			"account": account.Name,
+		}).Info("Successfully submitted a job.")
+	}
+	return jids, true
 }
 
 // JobListHandler provides updated details about one or more jobs currently submitted to the
@@ -156,6 +259,19 @@ func JobListHandler(c *Context, w http.ResponseWriter, r *http.Request) {
 	if statuses, ok := r.Form["status"]; ok {
 		q.Statuses = statuses
 	}
+	if rawArray := r.FormValue("array"); rawArray != "" {
+		array, err := strconv.ParseUint(rawArray, 10, 64)
+		if err != nil {
+			APIError{
+				Code:    CodeUnableToParseQuery,
+				Message: fmt.Sprintf("Unable to parse array ID [%s]: %v", rawArray, err),
+				Hint:    "Please specify a valid integral array ID.",
+				Retry:   false,
+			}.Log(account).Report(http.StatusBadRequest, w)
+			return
+		}
+		q.Array = array
+	}
 	if rawLimit := r.FormValue("limit"); rawLimit != "" {
 		limit, err := strconv.ParseInt(rawLimit, 10, 0)
 		if err != nil {
@@ -212,6 +328,43 @@ func JobListHandler(c *Context, w http.ResponseWriter, r *http.Request) {
 		q.After = after
 	}
 
+	switch order := r.FormValue("order"); order {
+	case "", "asc":
+	case "desc":
+		q.Descending = true
+	default:
+		APIError{
+			Code:    CodeUnableToParseQuery,
+			Message: fmt.Sprintf("Invalid order [%s]", order),
+			Hint:    `The "order" must be either "asc" or "desc".`,
+			Retry:   false,
+		}.Log(account).Report(http.StatusBadRequest, w)
+		return
+	}
+
+	if rawCursor := r.FormValue("cursor"); rawCursor != "" {
+		cursor, err := ParseJobCursor(rawCursor)
+		if err != nil {
+			APIError{
+				Code:    CodeUnableToParseQuery,
+				Message: fmt.Sprintf("Unable to parse cursor [%s]: %v", rawCursor, err),
+				Hint:    `Please pass back the "next" cursor from a previous listing unmodified.`,
+				Retry:   false,
+			}.Log(account).Report(http.StatusBadRequest, w)
+			return
+		}
+		if r.FormValue("order") != "" && cursor.Descending != q.Descending {
+			APIError{
+				Code:    CodeUnableToParseQuery,
+				Message: "The cursor was issued for a listing in the opposite order.",
+				Hint:    `Omit "order" when passing a cursor, or keep it the same.`,
+				Retry:   false,
+			}.Log(account).Report(http.StatusBadRequest, w)
+			return
+		}
+		cursor.Apply(&q)
+	}
+
 	results, err := c.ListJobs(q)
 	if err != nil {
 		re := APIError{
@@ -224,11 +377,33 @@ func JobListHandler(c *Context, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Results are only shipped when specific jobs are requested. They can also be downloaded
+	// individually from /job/result.
+	if len(q.JIDs) > 0 {
+		for i := range results {
+			if err := LoadResult(c, &results[i]); err != nil {
+				APIError{
+					Code:    CodeResultFailure,
+					Message: fmt.Sprintf("Unable to load the result of job [%d]: %v", results[i].JID, err),
+					Hint:    "This is most likely a problem with our result store.",
+					Retry:   true,
+				}.Log(account).Report(http.StatusServiceUnavailable, w)
+				return
+			}
+		}
+	}
+
 	var response struct {
 		Jobs []SubmittedJob `json:"jobs"`
+		Next string         `json:"next,omitempty"`
 	}
 	response.Jobs = results
 
+	// A full page may be followed by more jobs.
+	if len(results) > 0 && len(results) == q.Limit {
+		response.Next = JobCursor{Descending: q.Descending, JID: results[len(results)-1].JID}.String()
+	}
+
 	log.WithFields(log.Fields{
 		"query":        q,
 		"result count": len(results),
@@ -239,6 +414,94 @@ func JobListHandler(c *Context, w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// JobCursor marks a position within an ordered job listing. It's handed to clients as an opaque
+// string and resumes the listing just past the last job that they've seen.
+type JobCursor struct {
+	Descending bool   `json:"d,omitempty"`
+	JID        uint64 `json:"j"`
+}
+
+// ParseJobCursor decodes a JobCursor from its opaque string form.
+func ParseJobCursor(raw string) (JobCursor, error) {
+	var cursor JobCursor
+
+	b, err := base64.URLEncoding.DecodeString(raw)
+	if err != nil {
+		return cursor, err
+	}
+	if err := json.Unmarshal(b, &cursor); err != nil {
+		return cursor, err
+	}
+	if cursor.JID == 0 {
+		return cursor, fmt.Errorf("missing JID")
+	}
+	return cursor, nil
+}
+
+// String encodes the cursor as an opaque string.
+func (cursor JobCursor) String() string {
+	b, _ := json.Marshal(cursor)
+	return base64.URLEncoding.EncodeToString(b)
+}
+
+// Apply narrows a query to the jobs that follow the cursor, in the cursor's order.
+func (cursor JobCursor) Apply(q *JobQuery) {
+	q.Descending = cursor.Descending
+	if cursor.Descending {
+		if q.Before == 0 || cursor.JID < q.Before {
+			q.Before = cursor.JID
+		}
+	} else {
+		if cursor.JID+1 > q.After {
+			q.After = cursor.JID + 1
+		}
+	}
+}
+
+// KillJob requests that a job be killed. Jobs that haven't started are killed immediately, while
+// running jobs have their container killed and are left for the job runner to finish. It reports
+// whether the job was running.
+func KillJob(c *Context, job *SubmittedJob) (bool, *APIError) {
+	job.KillRequested = true
+
+	// If the container ID hasn't been assigned yet, the job most likely isn't running.
+	// If it's already left StatusQueued, StatusScheduled or StatusWaiting, let the job runner handle
+	// the transition to StatusKilled. Otherwise, set it to StatusKilled ourselves to remove it from the
+	// queue.
+	// A waiting job may still carry the ID of a container from an earlier attempt, which has already
+	// been removed.
+	running := true
+	if job.Status == StatusQueued || job.Status == StatusScheduled || job.Status == StatusWaiting {
+		job.Status = StatusKilled
+		job.FinishedAt = StoreTime(time.Now())
+		running = false
+	}
+
+	if err := c.UpdateJob(job); err != nil {
+		return false, &APIError{
+			Code:    CodeJobUpdateFailure,
+			Message: fmt.Sprintf("Unable to request a job kill: %v", err),
+			Hint:    "This is probably a storage error on our end.",
+			Retry:   true,
+		}
+	}
+
+	if !running || job.ContainerID == "" {
+		return false, nil
+	}
+
+	err := c.DockerFor(job.Host).KillContainer(docker.KillContainerOptions{ID: job.ContainerID})
+	if err != nil {
+		return true, &APIError{
+			Code:    CodeJobKillFailure,
+			Message: fmt.Sprintf("Unable to kill a running job: %v", err),
+			Hint:    "The container is misbehaving somehow.",
+			Retry:   true,
+		}
+	}
+	return true, nil
+}
+
 // JobKillHandler allows a user to prematurely terminate a running job.
 func JobKillHandler(c *Context, w http.ResponseWriter, r *http.Request) {
 	account, err := Authenticate(c, w, r)
@@ -313,38 +576,13 @@ func JobKillHandler(c *Context, w http.ResponseWriter, r *http.Request) {
 
 	job := &jobs[0]
 
-	job.KillRequested = true
-
-	// If the container ID hasn't been assigned yet, the job most likely isn't running.
-	// If it's already left StatusQueued, let the job runner handle the transition to
-	// StatusKilled. Otherwise, set it to StatusKilled ourselves to remove it from the queue.
-	if job.Status == StatusQueued {
-		job.Status = StatusKilled
-	}
-
-	err = c.UpdateJob(job)
-	if err != nil {
-		APIError{
-			Code:    CodeJobUpdateFailure,
-			Message: fmt.Sprintf("Unable to request a job kill: %v", err),
-			Hint:    "This is probably a storage error on our end.",
-			Retry:   true,
-		}.Log(account).Report(http.StatusInternalServerError, w)
+	running, kerr := KillJob(c, job)
+	if kerr != nil {
+		kerr.Log(account).Report(http.StatusInternalServerError, w)
 		return
 	}
 
-	if job.ContainerID != "" {
-		err = c.KillContainer(docker.KillContainerOptions{ID: job.ContainerID})
-		if err != nil {
-			APIError{
-				Code:    CodeJobKillFailure,
-				Message: fmt.Sprintf("Unable to kill a running job: %v", err),
-				Hint:    "The container is misbehaving somehow.",
-				Retry:   true,
-			}.Log(account).Report(http.StatusInternalServerError, w)
-			return
-		}
-
+	if running {
 		log.WithFields(log.Fields{
 			"jid":     job.JID,
 			"account": account.Name,
@@ -361,6 +599,76 @@ func JobKillHandler(c *Context, w http.ResponseWriter, r *http.Request) {
 	OKResponse(w)
 }
 
+// JobResultHandler downloads the raw result payload of a single job.
+func JobResultHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	account, err := Authenticate(c, w, r)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Authentication failure.")
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		APIError{
+			Code:    CodeUnableToParseQuery,
+			Message: fmt.Sprintf("Unable to parse query parameters: %v", err),
+			Hint:    "You broke Go's URL parsing somehow! Make URLs that suck less.",
+			Retry:   false,
+		}.Log(account).Report(http.StatusBadRequest, w)
+		return
+	}
+
+	rawJID := r.FormValue("jid")
+	jid, err := strconv.ParseUint(rawJID, 10, 64)
+	if err != nil {
+		APIError{
+			Code:    CodeUnableToParseQuery,
+			Message: fmt.Sprintf("Unable to parse JID [%s]: %v", rawJID, err),
+			Hint:    "Please provide a valid integer job ID.",
+			Retry:   false,
+		}.Log(account).Report(http.StatusBadRequest, w)
+		return
+	}
+
+	jobs, err := c.ListJobs(JobQuery{AccountName: account.Name, JIDs: []uint64{jid}})
+	if err != nil {
+		APIError{
+			Code:    CodeListFailure,
+			Message: fmt.Sprintf("Unable to list jobs: %v", err),
+			Hint:    "This is most likely a database problem.",
+			Retry:   true,
+		}.Log(account).Report(http.StatusServiceUnavailable, w)
+		return
+	}
+	if len(jobs) == 0 {
+		APIError{
+			Code:    CodeJobNotFound,
+			Message: fmt.Sprintf("Unable to find a job with ID [%d].", jid),
+			Hint:    "Make sure that the JID is still valid.",
+			Retry:   false,
+		}.Log(account).Report(http.StatusNotFound, w)
+		return
+	}
+	job := &jobs[0]
+
+	if err := LoadResult(c, job); err != nil {
+		APIError{
+			Code:    CodeResultFailure,
+			Message: fmt.Sprintf("Unable to load the result of job [%d]: %v", jid, err),
+			Hint:    "This is most likely a problem with our result store.",
+			Retry:   true,
+		}.Log(account).Report(http.StatusServiceUnavailable, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if job.ResultBlob != nil {
+		w.Header().Set("X-Result-SHA256", job.ResultBlob.Checksum)
+	}
+	w.Write(job.Result)
+}
+
 // JobKillAllHandler allows a user to terminate all jobs associated with their account.
 func JobKillAllHandler(c *Context, w http.ResponseWriter, r *http.Request) {
 	//
@@ -2,10 +2,12 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 // JobStorage is a fake Storage implementation that only provides job-relevant storage methods.
@@ -16,10 +18,13 @@ type JobStorage struct {
 	Query     JobQuery
 }
 
-func (storage *JobStorage) InsertJob(job SubmittedJob) (uint64, error) {
-	storage.Submitted = job
-
-	return 42, nil
+func (storage *JobStorage) InsertJobs(jobs []SubmittedJob) ([]uint64, error) {
+	jids := make([]uint64, len(jobs))
+	for i, job := range jobs {
+		storage.Submitted = job
+		jids[i] = 42 + uint64(i)
+	}
+	return jids, nil
 }
 
 func (storage *JobStorage) ListJobs(query JobQuery) ([]SubmittedJob, error) {
@@ -99,6 +104,7 @@ func TestSubmitJob(t *testing.T) {
 			AdminKey:  "12345",
 		},
 		Storage: s,
+		Cores:   DefaultCoreCatalog(),
 	}
 
 	JobHandler(c, w, r)
@@ -128,6 +134,10 @@ func TestSubmitJob(t *testing.T) {
 		t.Errorf("Expected submitted job to be in state queued, not [%s]", s.Submitted.Status)
 	}
 
+	if s.Submitted.Core != "c1" || s.Submitted.Multicore != 1 {
+		t.Errorf("Expected the job to be assigned one c1 core, got [%s] x [%d]", s.Submitted.Core, s.Submitted.Multicore)
+	}
+
 	if s.Submitted.CreatedAt == 0 {
 		t.Error("Expected the job's CreatedAt time to be populated.")
 	}
@@ -207,6 +217,164 @@ func TestSubmitJobBadResultType(t *testing.T) {
 	})
 }
 
+func TestSubmitJobBadCore(t *testing.T) {
+	body := strings.NewReader(`
+	{
+		"jobs": [{
+			"cmd": "id",
+			"core": "c2",
+			"multicore": 64,
+			"result_source": "stdout",
+			"result_type": "binary"
+		}]
+	}
+	`)
+	r, err := http.NewRequest("POST", "https://localhost/v1/jobs", body)
+	if err != nil {
+		t.Fatalf("Unable to create request: %v", err)
+	}
+	r.SetBasicAuth("admin", "12345")
+	w := httptest.NewRecorder()
+	c := &Context{
+		Settings: Settings{
+			AdminName: "admin",
+			AdminKey:  "12345",
+		},
+		Storage: &JobStorage{},
+		Cores:   DefaultCoreCatalog(),
+	}
+
+	JobHandler(c, w, r)
+
+	hasError(t, w, http.StatusBadRequest, APIError{
+		Code:    CodeInvalidCore,
+		Message: "Invalid multicore count [64] for core type [c2]",
+		Retry:   false,
+	})
+}
+
+func TestSubmitJobPriorityLimit(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{
+		Settings:    Settings{DefaultMaxPriority: 1},
+		Storage:     s,
+		Cores:       DefaultCoreCatalog(),
+		AuthService: AcceptingAuthService{},
+	}
+	if _, err := s.GetAccount("alice"); err != nil {
+		t.Fatalf("Unable to create an account: %v", err)
+	}
+	if err := s.UpdateAccountMaxPriority("alice", 3); err != nil {
+		t.Fatalf("Unable to update an account: %v", err)
+	}
+
+	submit := func(account string, priority int) *httptest.ResponseRecorder {
+		body := strings.NewReader(fmt.Sprintf(`{"jobs": [{
+			"cmd": "id",
+			"priority": %d,
+			"result_source": "stdout",
+			"result_type": "binary"
+		}]}`, priority))
+		r, err := http.NewRequest("POST", "https://localhost/v1/jobs", body)
+		if err != nil {
+			t.Fatalf("Unable to create request: %v", err)
+		}
+		r.SetBasicAuth(account, "12345")
+		w := httptest.NewRecorder()
+
+		JobHandler(c, w, r)
+		return w
+	}
+
+	if w := submit("alice", 3); w.Code != http.StatusOK {
+		t.Errorf("Expected alice to submit at her own limit, got [%d] [%s]", w.Code, w.Body.String())
+	}
+	hasError(t, submit("alice", 4), http.StatusBadRequest, APIError{
+		Code:    CodeInvalidPriority,
+		Message: "Invalid priority [4]",
+	})
+	if w := submit("bob", 1); w.Code != http.StatusOK {
+		t.Errorf("Expected bob to submit at the default limit, got [%d] [%s]", w.Code, w.Body.String())
+	}
+	hasError(t, submit("bob", 2), http.StatusBadRequest, APIError{
+		Code:    CodeInvalidPriority,
+		Message: "Invalid priority [2]",
+	})
+	hasError(t, submit("bob", -1), http.StatusBadRequest, APIError{
+		Code:    CodeInvalidPriority,
+		Message: "Invalid priority [-1]",
+	})
+
+	jobs, err := s.ListJobs(JobQuery{})
+	if err != nil {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+	if len(jobs) != 2 {
+		t.Errorf("Expected only the jobs within their limits to be enqueued, got %#v", jobs)
+	}
+}
+
+func TestSubmitJobDependencies(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{
+		Storage:     s,
+		Cores:       DefaultCoreCatalog(),
+		AuthService: AcceptingAuthService{},
+	}
+
+	deps := insertJobs(t, s,
+		SubmittedJob{Account: "alice", Status: StatusDone},
+		SubmittedJob{Account: "alice", Status: StatusProcessing},
+		SubmittedJob{Account: "bob", Status: StatusDone},
+	)
+
+	submit := func(dependsOn string) *httptest.ResponseRecorder {
+		body := strings.NewReader(fmt.Sprintf(`{"jobs": [{
+			"cmd": "id",
+			"depends_on": %q,
+			"result_source": "stdout",
+			"result_type": "binary"
+		}]}`, dependsOn))
+		r, err := http.NewRequest("POST", "https://localhost/v1/jobs", body)
+		if err != nil {
+			t.Fatalf("Unable to create request: %v", err)
+		}
+		r.SetBasicAuth("alice", "12345")
+		w := httptest.NewRecorder()
+
+		JobHandler(c, w, r)
+		return w
+	}
+
+	submitted := func(w *httptest.ResponseRecorder) uint64 {
+		var response struct {
+			JIDs []uint64 `json:"jids"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || len(response.JIDs) != 1 {
+			t.Fatalf("Unexpected response: [%d] [%s]", w.Code, w.Body.String())
+		}
+		return response.JIDs[0]
+	}
+
+	queued := submitted(submit(fmt.Sprintf("%d", deps[0])))
+	waiting := submitted(submit(fmt.Sprintf("%d", deps[1])))
+	expectJIDs(t, s, JobQuery{Statuses: []string{StatusQueued}}, queued)
+	expectJIDs(t, s, JobQuery{Statuses: []string{StatusWaiting}}, waiting)
+
+	hasError(t, submit(fmt.Sprintf("%d", deps[2])), http.StatusBadRequest, APIError{
+		Code:    CodeInvalidDependency,
+		Message: fmt.Sprintf("Unable to find dependency [%d].", deps[2]),
+	})
+	hasError(t, submit("999"), http.StatusBadRequest, APIError{
+		Code:    CodeInvalidDependency,
+		Message: "Unable to find dependency [999].",
+	})
+	hasError(t, submit("latest"), http.StatusBadRequest, APIError{
+		Code:    CodeInvalidDependency,
+		Message: "Invalid dependency [latest]",
+	})
+}
+
 func TestListJobsAll(t *testing.T) {
 	r, err := http.NewRequest("GET", "https://localhost/v1/jobs", nil)
 	if err != nil {
@@ -372,3 +540,412 @@ func TestSubmitJobKill(t *testing.T) {
 		t.Error("Expected a job kill to be requested")
 	}
 }
+
+func TestSubmitAndListJobsInMemory(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{
+		Settings: Settings{
+			AdminName: "admin",
+			AdminKey:  "12345",
+		},
+		Storage: s,
+		Cores:   DefaultCoreCatalog(),
+		Wakeup:  NewWakeup(),
+	}
+
+	body := strings.NewReader(`
+	{
+		"jobs": [
+			{"cmd": "id", "name": "first", "result_source": "stdout", "result_type": "binary"},
+			{"cmd": "id", "name": "second", "result_source": "stdout", "result_type": "binary"}
+		]
+	}
+	`)
+	r, err := http.NewRequest("POST", "https://localhost/v1/jobs", body)
+	if err != nil {
+		t.Fatalf("Unable to create request: %v", err)
+	}
+	r.SetBasicAuth("admin", "12345")
+	w := httptest.NewRecorder()
+
+	JobHandler(c, w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected HTTP status: [%d]", w.Code)
+	}
+	if !c.Wakeup.Wait(0) {
+		t.Error("Expected the submission to wake the job runner")
+	}
+
+	// Claim the first job so that the two differ in status.
+	if _, err := s.ClaimJob(Lease{}, nil); err != nil {
+		t.Fatalf("Unable to claim a job: %v", err)
+	}
+
+	r, err = http.NewRequest("GET", "https://localhost/v1/jobs?status=queued", nil)
+	if err != nil {
+		t.Fatalf("Unable to create request: %v", err)
+	}
+	r.SetBasicAuth("admin", "12345")
+	w = httptest.NewRecorder()
+
+	JobHandler(c, w, r)
+
+	var response struct {
+		Jobs []SubmittedJob `json:"jobs"`
+	}
+	out := w.Body.Bytes()
+	if err := json.Unmarshal(out, &response); err != nil {
+		t.Fatalf("Unable to parse response body as JSON: [%s]", string(out))
+	}
+
+	if len(response.Jobs) != 1 {
+		t.Fatalf("Expected one queued job, got [%d]", len(response.Jobs))
+	}
+	if name := response.Jobs[0].Name; name == nil || *name != "second" {
+		t.Errorf("Expected the queued job to be [second], got [%v]", name)
+	}
+}
+
+func TestSubmitScheduledJobsInMemory(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{
+		Settings: Settings{
+			AdminName: "admin",
+			AdminKey:  "12345",
+		},
+		Storage: s,
+		Cores:   DefaultCoreCatalog(),
+	}
+
+	later := StoreTime(time.Now().Add(time.Hour))
+	earlier := StoreTime(time.Now().Add(-time.Hour))
+	body := strings.NewReader(fmt.Sprintf(`
+	{
+		"jobs": [
+			{"cmd": "id", "name": "later", "result_source": "stdout", "result_type": "binary", "run_at": "%s"},
+			{"cmd": "id", "name": "earlier", "result_source": "stdout", "result_type": "binary", "run_at": "%s"}
+		]
+	}
+	`, later.String(), earlier.String()))
+	r, err := http.NewRequest("POST", "https://localhost/v1/jobs", body)
+	if err != nil {
+		t.Fatalf("Unable to create request: %v", err)
+	}
+	r.SetBasicAuth("admin", "12345")
+	w := httptest.NewRecorder()
+
+	JobHandler(c, w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected HTTP status: [%d] [%s]", w.Code, w.Body.String())
+	}
+
+	expectJIDs(t, s, JobQuery{Statuses: []string{StatusScheduled}}, 1)
+	expectJIDs(t, s, JobQuery{Statuses: []string{StatusQueued}}, 2)
+
+	jobs, _ := s.ListJobs(JobQuery{JIDs: []uint64{1}})
+	if jobs[0].RunAt.AsTime().Unix() != later.AsTime().Unix() {
+		t.Errorf("Expected the job to run at [%s], got [%s]", later.String(), jobs[0].RunAt.String())
+	}
+
+	// Only the job whose time has come may be claimed.
+	if job, _ := s.ClaimJob(Lease{}, nil); job == nil || job.JID != 2 {
+		t.Errorf("Expected to claim the job that's due, got %#v", job)
+	}
+	if job, _ := s.ClaimJob(Lease{}, nil); job != nil {
+		t.Errorf("Expected the scheduled job not to be claimed, got [%d]", job.JID)
+	}
+
+	// Scheduled jobs can be killed before they start.
+	r, err = http.NewRequest("POST", "https://localhost/v1/jobs/kill", strings.NewReader("jid=1"))
+	if err != nil {
+		t.Fatalf("Unable to create request: %v", err)
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth("admin", "12345")
+	w = httptest.NewRecorder()
+
+	JobKillHandler(c, w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Unexpected HTTP status: [%d]", w.Code)
+	}
+	expectJIDs(t, s, JobQuery{Statuses: []string{StatusKilled}}, 1)
+}
+
+func TestSubmitInvalidBatchInMemory(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{
+		Settings: Settings{
+			AdminName: "admin",
+			AdminKey:  "12345",
+		},
+		Storage: s,
+		Cores:   DefaultCoreCatalog(),
+	}
+
+	body := strings.NewReader(`
+	{
+		"jobs": [
+			{"cmd": "id", "result_source": "stdout", "result_type": "binary"},
+			{"cmd": "id", "result_source": "nope", "result_type": "binary"},
+			{"cmd": "id", "result_source": "stdout", "result_type": "binary"}
+		]
+	}
+	`)
+	r, err := http.NewRequest("POST", "https://localhost/v1/jobs", body)
+	if err != nil {
+		t.Fatalf("Unable to create request: %v", err)
+	}
+	r.SetBasicAuth("admin", "12345")
+	w := httptest.NewRecorder()
+
+	JobHandler(c, w, r)
+
+	hasError(t, w, http.StatusBadRequest, APIError{
+		Code:    CodeInvalidResultSource,
+		Message: "Invalid result source [nope]",
+		Retry:   false,
+	})
+
+	// None of the batch may be enqueued.
+	expectJIDs(t, s, JobQuery{})
+
+	// The next batch receives a contiguous block of JIDs.
+	body = strings.NewReader(`
+	{
+		"jobs": [
+			{"cmd": "id", "result_source": "stdout", "result_type": "binary"},
+			{"cmd": "id", "result_source": "stdout", "result_type": "binary"}
+		]
+	}
+	`)
+	r, err = http.NewRequest("POST", "https://localhost/v1/jobs", body)
+	if err != nil {
+		t.Fatalf("Unable to create request: %v", err)
+	}
+	r.SetBasicAuth("admin", "12345")
+	w = httptest.NewRecorder()
+
+	JobHandler(c, w, r)
+
+	var response struct {
+		JIDs []uint64 `json:"jids"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Unable to parse response body as JSON: [%s]", w.Body.String())
+	}
+	if len(response.JIDs) != 2 || response.JIDs[0] != 1 || response.JIDs[1] != 2 {
+		t.Errorf("Expected JIDs [1 2], got %v", response.JIDs)
+	}
+}
+
+func TestKillQueuedJobInMemory(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{
+		Settings: Settings{
+			AdminName: "admin",
+			AdminKey:  "12345",
+		},
+		Storage: s,
+	}
+
+	jid, err := s.InsertJob(SubmittedJob{Account: "admin", Status: StatusQueued})
+	if err != nil {
+		t.Fatalf("Unable to insert a job: %v", err)
+	}
+
+	r, err := http.NewRequest("POST", "https://localhost/v1/jobs/kill", strings.NewReader("jid=1"))
+	if err != nil {
+		t.Fatalf("Unable to create request: %v", err)
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth("admin", "12345")
+	w := httptest.NewRecorder()
+
+	JobKillHandler(c, w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Unexpected HTTP status: [%d]", w.Code)
+	}
+
+	jobs, err := s.ListJobs(JobQuery{JIDs: []uint64{jid}})
+	if err != nil {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+	if jobs[0].Status != StatusKilled || !jobs[0].KillRequested {
+		t.Errorf("Expected the queued job to be killed, got status [%s]", jobs[0].Status)
+	}
+
+	if job, _ := s.ClaimJob(Lease{}, nil); job != nil {
+		t.Errorf("Expected the killed job to leave the queue, but claimed [%d]", job.JID)
+	}
+}
+
+func TestJobResult(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{
+		Settings: Settings{
+			AdminName: "admin",
+			AdminKey:  "12345",
+		},
+		Storage: s,
+		Results: NewMemoryBlobStore(),
+	}
+
+	job := SubmittedJob{Account: "admin", Status: StatusDone}
+	jid, err := s.InsertJob(job)
+	if err != nil {
+		t.Fatalf("Unable to insert a job: %v", err)
+	}
+	job.JID = jid
+	job.Result = []byte("the answer")
+	if err := StoreResult(c, &job); err != nil {
+		t.Fatalf("Unable to store a result: %v", err)
+	}
+	if err := s.UpdateJob(&job); err != nil {
+		t.Fatalf("Unable to update a job: %v", err)
+	}
+
+	r, err := http.NewRequest("GET", "https://localhost/v1/job/result?jid=1", nil)
+	if err != nil {
+		t.Fatalf("Unable to create request: %v", err)
+	}
+	r.SetBasicAuth("admin", "12345")
+	w := httptest.NewRecorder()
+
+	JobResultHandler(c, w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Unexpected HTTP status: [%d]", w.Code)
+	}
+	if body := w.Body.String(); body != "the answer" {
+		t.Errorf("Unexpected result body: [%s]", body)
+	}
+
+	// The result is also included when the job is listed by JID, but not in general listings.
+	for url, expected := range map[string]string{
+		"https://localhost/v1/jobs?jid=1": "the answer",
+		"https://localhost/v1/jobs":       "",
+	} {
+		r, _ = http.NewRequest("GET", url, nil)
+		r.SetBasicAuth("admin", "12345")
+		w = httptest.NewRecorder()
+
+		JobHandler(c, w, r)
+
+		var response struct {
+			Jobs []SubmittedJob `json:"jobs"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || len(response.Jobs) != 1 {
+			t.Fatalf("Unable to parse response body: [%s]", w.Body.String())
+		}
+		if result := string(response.Jobs[0].Result); result != expected {
+			t.Errorf("Listing [%s]: expected result [%s], got [%s]", url, expected, result)
+		}
+	}
+}
+
+func TestListJobsBeforeAndAfter(t *testing.T) {
+	q := jobListQuery(t, "https://localhost/v1/jobs?after=10&before=20&order=desc")
+
+	if q.After != 10 || q.Before != 20 {
+		t.Errorf("Expected bounds [10, 20), got [%d, %d)", q.After, q.Before)
+	}
+	if !q.Descending {
+		t.Error("Expected a descending query")
+	}
+}
+
+func TestPaginateJobsInMemory(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{
+		Settings: Settings{
+			AdminName: "admin",
+			AdminKey:  "12345",
+		},
+		Storage: s,
+	}
+	for i := 0; i < 5; i++ {
+		s.InsertJob(SubmittedJob{Account: "admin", Status: StatusQueued})
+	}
+
+	// Filters are repeated alongside the cursor on each page.
+	paginate := func(base string) []uint64 {
+		var jids []uint64
+		url := base
+		for pages := 0; pages < 10; pages++ {
+			r, err := http.NewRequest("GET", url, nil)
+			if err != nil {
+				t.Fatalf("Unable to create request: %v", err)
+			}
+			r.SetBasicAuth("admin", "12345")
+			w := httptest.NewRecorder()
+
+			JobHandler(c, w, r)
+
+			var response struct {
+				Jobs []SubmittedJob `json:"jobs"`
+				Next string         `json:"next"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Unable to parse response body as JSON: [%s]", w.Body.String())
+			}
+			for _, job := range response.Jobs {
+				jids = append(jids, job.JID)
+			}
+
+			if response.Next == "" {
+				return jids
+			}
+			url = base + "&cursor=" + response.Next
+		}
+		t.Fatalf("Pagination never terminated: %v", jids)
+		return nil
+	}
+
+	expectSequence := func(actual []uint64, expected ...uint64) {
+		ok := len(actual) == len(expected)
+		for i := 0; ok && i < len(actual); i++ {
+			ok = actual[i] == expected[i]
+		}
+		if !ok {
+			t.Errorf("Expected JIDs %v, got %v", expected, actual)
+		}
+	}
+
+	expectSequence(paginate("https://localhost/v1/jobs?limit=2"), 1, 2, 3, 4, 5)
+	expectSequence(paginate("https://localhost/v1/jobs?limit=2&order=desc"), 5, 4, 3, 2, 1)
+	expectSequence(paginate("https://localhost/v1/jobs?limit=2&order=desc&after=2&before=5"), 4, 3, 2)
+}
+
+func TestListJobsInvalidCursor(t *testing.T) {
+	c := &Context{
+		Settings: Settings{
+			AdminName: "admin",
+			AdminKey:  "12345",
+		},
+		Storage: NewMemoryStorage(),
+	}
+
+	for _, url := range []string{
+		"https://localhost/v1/jobs?cursor=%%%",
+		"https://localhost/v1/jobs?cursor=bm9wZQ==",
+		"https://localhost/v1/jobs?order=sideways",
+		"https://localhost/v1/jobs?order=asc&cursor=" + JobCursor{Descending: true, JID: 3}.String(),
+	} {
+		r, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatalf("Unable to create request: %v", err)
+		}
+		r.SetBasicAuth("admin", "12345")
+		w := httptest.NewRecorder()
+
+		JobHandler(c, w, r)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("[%s]: expected HTTP status 400, got [%d]", url, w.Code)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// ScheduleHandler dispatches API calls to /schedule based on request type.
+func ScheduleHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		ScheduleListHandler(c, w, r)
+	case "POST":
+		ScheduleCreateHandler(c, w, r)
+	default:
+		APIError{
+			Code:    CodeMethodNotSupported,
+			Message: "Method not supported",
+			Hint:    "Use GET or POST against this endpoint.",
+			Retry:   false,
+		}.Report(http.StatusMethodNotAllowed, w)
+	}
+}
+
+// ScheduleCreateHandler creates a schedule that submits jobs on behalf of the authenticated account.
+func ScheduleCreateHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	type Request struct {
+		Name     string `json:"name"`
+		Cron     string `json:"cron"`
+		Timezone string `json:"timezone"`
+		Overlap  string `json:"overlap"`
+		Job      Job    `json:"job"`
+	}
+
+	account, err := Authenticate(c, w, r)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Authentication failure.")
+		return
+	}
+
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		APIError{
+			Code:    CodeInvalidScheduleJSON,
+			Message: fmt.Sprintf("Unable to parse schedule payload as JSON: %v", err),
+			Hint:    "Please supply valid JSON in your request.",
+			Retry:   false,
+		}.Log(account).Report(http.StatusBadRequest, w)
+		return
+	}
+
+	now := time.Now()
+	schedule := Schedule{
+		Account:   account.Name,
+		Name:      req.Name,
+		Cron:      req.Cron,
+		Timezone:  req.Timezone,
+		Overlap:   req.Overlap,
+		Job:       req.Job,
+		CreatedAt: StoreTime(now),
+	}
+	if schedule.Timezone == "" {
+		schedule.Timezone = "UTC"
+	}
+	if schedule.Overlap == "" {
+		schedule.Overlap = OverlapSkip
+	}
+
+	// The job template is held to the same standards as a submitted job.
+	if err := schedule.Validate(); err != nil {
+		err.Log(account).Report(http.StatusBadRequest, w)
+		return
+	}
+	if err := schedule.Job.Validate(); err != nil {
+		err.Log(account).Report(http.StatusBadRequest, w)
+		return
+	}
+	if _, err := c.Cores.Resolve(&schedule.Job); err != nil {
+		err.Log(account).Report(http.StatusBadRequest, w)
+		return
+	}
+	if err := checkPriority(schedule.Job.Priority, c.PriorityLimit(account)); err != nil {
+		err.Log(account).Report(http.StatusBadRequest, w)
+		return
+	}
+
+	schedule.NextRunAt, err = schedule.NextRun(now)
+	if err != nil || schedule.NextRunAt == 0 {
+		APIError{
+			Code:    CodeInvalidCron,
+			Message: fmt.Sprintf("The cron expression [%s] never matches.", schedule.Cron),
+			Hint:    "Check for impossible dates, like the 30th of February.",
+			Retry:   false,
+		}.Log(account).Report(http.StatusBadRequest, w)
+		return
+	}
+
+	schedule.ID, err = c.InsertSchedule(schedule)
+	if err != nil {
+		APIError{
+			Code:    CodeScheduleUpdateFailure,
+			Message: fmt.Sprintf("Unable to create the schedule: %v", err),
+			Hint:    "This is probably a storage error on our end.",
+			Retry:   true,
+		}.Log(account).Report(http.StatusServiceUnavailable, w)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"schedule": schedule.ID,
+		"cron":     schedule.Cron,
+		"timezone": schedule.Timezone,
+		"account":  account.Name,
+	}).Info("Successfully created a schedule.")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&schedule)
+}
+
+// ScheduleListHandler lists the schedules of the authenticated account.
+func ScheduleListHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	account, err := Authenticate(c, w, r)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Authentication failure.")
+		return
+	}
+
+	schedules, err := c.ListSchedules(account.Name)
+	if err != nil {
+		APIError{
+			Code:    CodeScheduleListFailure,
+			Message: fmt.Sprintf("Unable to list schedules: %v", err),
+			Hint:    "This is most likely a database problem.",
+			Retry:   true,
+		}.Log(account).Report(http.StatusServiceUnavailable, w)
+		return
+	}
+
+	var response struct {
+		Schedules []Schedule `json:"schedules"`
+	}
+	response.Schedules = schedules
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// SchedulePauseHandler stops a schedule from submitting jobs until it's resumed.
+func SchedulePauseHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	pauseSchedule(c, w, r, true)
+}
+
+// ScheduleResumeHandler lets a paused schedule submit jobs again, starting with the next time that
+// its cron expression matches. Runs that were missed while it was paused aren't made up.
+func ScheduleResumeHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	pauseSchedule(c, w, r, false)
+}
+
+// pauseSchedule pauses or resumes the schedule identified by a request.
+func pauseSchedule(c *Context, w http.ResponseWriter, r *http.Request, paused bool) {
+	account, err := Authenticate(c, w, r)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Authentication failure.")
+		return
+	}
+
+	schedule := findSchedule(c, account, w, r)
+	if schedule == nil {
+		return
+	}
+
+	var next StoredTime
+	if !paused {
+		if next, err = schedule.NextRun(time.Now()); err != nil {
+			APIError{
+				Code:    CodeWTF,
+				Message: fmt.Sprintf("Stored schedule [%d] is invalid: %v", schedule.ID, err),
+				Hint:    "Delete the schedule and create it again.",
+				Retry:   false,
+			}.Log(account).Report(http.StatusInternalServerError, w)
+			return
+		}
+	}
+
+	if err := c.PauseSchedule(schedule.ID, paused, next); err != nil {
+		APIError{
+			Code:    CodeScheduleUpdateFailure,
+			Message: fmt.Sprintf("Unable to update schedule [%d]: %v", schedule.ID, err),
+			Hint:    "This is probably a storage error on our end.",
+			Retry:   true,
+		}.Log(account).Report(http.StatusServiceUnavailable, w)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"schedule": schedule.ID,
+		"account":  account.Name,
+		"paused":   paused,
+	}).Info("Schedule paused or resumed.")
+
+	OKResponse(w)
+}
+
+// ScheduleDeleteHandler permanently removes a schedule. Jobs that it already submitted are kept.
+func ScheduleDeleteHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	account, err := Authenticate(c, w, r)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Authentication failure.")
+		return
+	}
+
+	schedule := findSchedule(c, account, w, r)
+	if schedule == nil {
+		return
+	}
+
+	if err := c.DeleteSchedule(schedule.ID); err != nil {
+		APIError{
+			Code:    CodeScheduleUpdateFailure,
+			Message: fmt.Sprintf("Unable to delete schedule [%d]: %v", schedule.ID, err),
+			Hint:    "This is probably a storage error on our end.",
+			Retry:   true,
+		}.Log(account).Report(http.StatusServiceUnavailable, w)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"schedule": schedule.ID,
+		"account":  account.Name,
+	}).Info("Schedule deleted.")
+
+	OKResponse(w)
+}
+
+// ScheduleHistoryHandler lists the recent runs of a schedule, most recent first, along with the
+// current status of each job that they submitted.
+func ScheduleHistoryHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	account, err := Authenticate(c, w, r)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Authentication failure.")
+		return
+	}
+
+	schedule := findSchedule(c, account, w, r)
+	if schedule == nil {
+		return
+	}
+
+	jids := []uint64{}
+	for _, run := range schedule.Runs {
+		if run.JID != 0 {
+			jids = append(jids, run.JID)
+		}
+	}
+
+	statuses := make(map[uint64]string, len(jids))
+	if len(jids) > 0 {
+		jobs, err := c.ListJobs(JobQuery{AccountName: account.Name, JIDs: jids})
+		if err != nil {
+			APIError{
+				Code:    CodeListFailure,
+				Message: fmt.Sprintf("Unable to list jobs: %v", err),
+				Hint:    "This is most likely a database problem.",
+				Retry:   true,
+			}.Log(account).Report(http.StatusServiceUnavailable, w)
+			return
+		}
+		for _, job := range jobs {
+			statuses[job.JID] = job.Status
+		}
+	}
+
+	var response struct {
+		Runs []ScheduleRun `json:"runs"`
+	}
+	response.Runs = make([]ScheduleRun, 0, len(schedule.Runs))
+	for i := len(schedule.Runs) - 1; i >= 0; i-- {
+		run := schedule.Runs[i]
+		run.Status = statuses[run.JID]
+		response.Runs = append(response.Runs, run)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// findSchedule loads one of an account's schedules by the "id" in a request's form. If it can't, it
+// reports an error to the client and returns nil.
+func findSchedule(c *Context, account *Account, w http.ResponseWriter, r *http.Request) *Schedule {
+	if err := r.ParseForm(); err != nil {
+		APIError{
+			Code:    CodeInvalidScheduleForm,
+			Message: fmt.Sprintf("Unable to parse the request's form: %v", err),
+			Hint:    "Please use valid form encoding in your request.",
+			Retry:   false,
+		}.Log(account).Report(http.StatusBadRequest, w)
+		return nil
+	}
+
+	rawID := r.FormValue("id")
+	id, err := strconv.ParseUint(rawID, 10, 64)
+	if err != nil {
+		APIError{
+			Code:    CodeInvalidScheduleForm,
+			Message: fmt.Sprintf("Unable to parse schedule ID [%s]: %v", rawID, err),
+			Hint:    "Please provide a valid integer schedule ID.",
+			Retry:   false,
+		}.Log(account).Report(http.StatusBadRequest, w)
+		return nil
+	}
+
+	schedules, err := c.ListSchedules(account.Name)
+	if err != nil {
+		APIError{
+			Code:    CodeScheduleListFailure,
+			Message: fmt.Sprintf("Unable to list schedules: %v", err),
+			Hint:    "This is most likely a database problem.",
+			Retry:   true,
+		}.Log(account).Report(http.StatusServiceUnavailable, w)
+		return nil
+	}
+
+	for i := range schedules {
+		if schedules[i].ID == id {
+			return &schedules[i]
+		}
+	}
+
+	APIError{
+		Code:    CodeScheduleNotFound,
+		Message: fmt.Sprintf("Unable to find a schedule with ID [%d].", id),
+		Hint:    "Make sure that the schedule hasn't been deleted.",
+		Retry:   false,
+	}.Log(account).Report(http.StatusNotFound, w)
+	return nil
+}
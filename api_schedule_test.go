@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// createSchedule posts a schedule to ScheduleHandler as the admin account.
+func createSchedule(t *testing.T, c *Context, body string) *httptest.ResponseRecorder {
+	r, err := http.NewRequest("POST", "https://localhost/v1/schedule", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Unable to create request: %v", err)
+	}
+	r.SetBasicAuth("admin", "12345")
+	w := httptest.NewRecorder()
+
+	ScheduleHandler(c, w, r)
+	return w
+}
+
+func TestCreateInvalidSchedule(t *testing.T) {
+	c := &Context{
+		Settings: Settings{AdminName: "admin", AdminKey: "12345"},
+		Storage:  NewMemoryStorage(),
+		Cores:    DefaultCoreCatalog(),
+	}
+
+	job := `{"cmd": "id", "result_source": "stdout", "result_type": "binary"}`
+	cases := map[string]string{
+		CodeInvalidScheduleJSON: `{`,
+		CodeInvalidCron:         `{"cron": "* * *", "job": ` + job + `}`,
+		CodeInvalidTimezone:     `{"cron": "@daily", "timezone": "Mars/Olympus_Mons", "job": ` + job + `}`,
+		CodeInvalidOverlap:      `{"cron": "@daily", "overlap": "sometimes", "job": ` + job + `}`,
+		CodeInvalidScheduleJob:  `{"cron": "@daily", "job": {"cmd": "id", "result_source": "stdout", "result_type": "binary", "depends_on": "1"}}`,
+		CodeMissingCommand:      `{"cron": "@daily", "job": {"result_source": "stdout", "result_type": "binary"}}`,
+		CodeInvalidPriority:     `{"cron": "@daily", "job": {"cmd": "id", "result_source": "stdout", "result_type": "binary", "priority": 5}}`,
+	}
+
+	for code, body := range cases {
+		w := createSchedule(t, c, body)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), code) {
+			t.Errorf("Expected a [%s] error, got [%d] [%s]", code, w.Code, w.Body.String())
+		}
+	}
+
+	if w := createSchedule(t, c, `{"cron": "0 0 30 2 *", "job": `+job+`}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a schedule that never runs to be rejected, got [%d]", w.Code)
+	}
+}
+
+func TestScheduleLifecycleInMemory(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{
+		Settings: Settings{AdminName: "admin", AdminKey: "12345"},
+		Storage:  s,
+		Cores:    DefaultCoreCatalog(),
+	}
+
+	w := createSchedule(t, c, `{
+		"name": "report",
+		"cron": "0 9 * * *",
+		"timezone": "America/New_York",
+		"job": {"cmd": "report", "result_source": "stdout", "result_type": "binary"}
+	}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected HTTP status: [%d] [%s]", w.Code, w.Body.String())
+	}
+
+	var created Schedule
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatalf("Unable to parse the response: %v", err)
+	}
+	if created.ID == 0 || created.Overlap != OverlapSkip || created.Job.Core != "c1" {
+		t.Errorf("Expected the schedule to be created with defaults, got %#v", created)
+	}
+
+	// The next run is at 9am in New York.
+	loc, _ := time.LoadLocation("America/New_York")
+	next := created.NextRunAt.AsTime().In(loc)
+	if next.Hour() != 9 || next.Minute() != 0 || !next.After(time.Now()) {
+		t.Errorf("Expected the next run at 9am in New York, got [%v]", next)
+	}
+
+	stored := getSchedule(t, s, created.ID)
+	if stored.Account != "admin" || stored.Name != "report" {
+		t.Errorf("Expected the schedule to belong to the admin account, got %#v", stored)
+	}
+
+	// List the account's schedules.
+	r, _ := http.NewRequest("GET", "https://localhost/v1/schedule", nil)
+	r.SetBasicAuth("admin", "12345")
+	w = httptest.NewRecorder()
+	ScheduleHandler(c, w, r)
+
+	var listed struct {
+		Schedules []Schedule `json:"schedules"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&listed); err != nil {
+		t.Fatalf("Unable to parse the response: %v", err)
+	}
+	if len(listed.Schedules) != 1 || listed.Schedules[0].ID != created.ID {
+		t.Errorf("Expected the schedule to be listed, got %#v", listed.Schedules)
+	}
+
+	// Pause and resume it.
+	id := url.Values{"id": {"1"}}
+	if w := adminPost(t, c, SchedulePauseHandler, id); w.Code != http.StatusOK {
+		t.Fatalf("Unable to pause the schedule: [%d] [%s]", w.Code, w.Body.String())
+	}
+	if stored := getSchedule(t, s, created.ID); !stored.Paused || stored.NextRunAt != 0 {
+		t.Errorf("Expected the schedule to be paused, got %#v", stored)
+	}
+	if w := adminPost(t, c, ScheduleResumeHandler, id); w.Code != http.StatusOK {
+		t.Fatalf("Unable to resume the schedule: [%d] [%s]", w.Code, w.Body.String())
+	}
+	if stored := getSchedule(t, s, created.ID); stored.Paused || stored.NextRunAt != created.NextRunAt {
+		t.Errorf("Expected the schedule to be resumed, got %#v", stored)
+	}
+
+	// Its history reports the status of each job that it submitted, most recent first.
+	jids := insertJobs(t, s,
+		SubmittedJob{Account: "admin", Status: StatusDone, Schedule: created.ID},
+		SubmittedJob{Account: "admin", Status: StatusProcessing, Schedule: created.ID},
+	)
+	s.RecordScheduleRun(created.ID, ScheduleRun{ScheduledAt: 1, JID: jids[0]})
+	s.RecordScheduleRun(created.ID, ScheduleRun{ScheduledAt: 2, JID: jids[1]})
+	s.RecordScheduleRun(created.ID, ScheduleRun{ScheduledAt: 3, Skipped: "still running"})
+
+	r, _ = http.NewRequest("GET", "https://localhost/v1/schedule/history?id=1", nil)
+	r.SetBasicAuth("admin", "12345")
+	w = httptest.NewRecorder()
+	ScheduleHistoryHandler(c, w, r)
+
+	var history struct {
+		Runs []ScheduleRun `json:"runs"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&history); err != nil {
+		t.Fatalf("Unable to parse the response: %v", err)
+	}
+	runs := history.Runs
+	if len(runs) != 3 || runs[0].Skipped == "" || runs[1].Status != StatusProcessing || runs[2].Status != StatusDone {
+		t.Errorf("Unexpected history: %#v", runs)
+	}
+
+	// Delete it.
+	if w := adminPost(t, c, ScheduleDeleteHandler, id); w.Code != http.StatusOK {
+		t.Fatalf("Unable to delete the schedule: [%d] [%s]", w.Code, w.Body.String())
+	}
+	if schedules, _ := s.ListSchedules(""); len(schedules) != 0 {
+		t.Errorf("Expected the schedule to be deleted, got %#v", schedules)
+	}
+	if w := adminPost(t, c, ScheduleDeleteHandler, id); w.Code != http.StatusNotFound {
+		t.Errorf("Expected a missing schedule to be reported, got [%d]", w.Code)
+	}
+}
+
+func TestScheduleBelongsToItsAccount(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{
+		Settings: Settings{AdminName: "admin", AdminKey: "12345"},
+		Storage:  s,
+	}
+
+	id, err := s.InsertSchedule(Schedule{Account: "alice", Cron: "@daily", Timezone: "UTC", NextRunAt: 100})
+	if err != nil {
+		t.Fatalf("Unable to insert a schedule: %v", err)
+	}
+
+	w := adminPost(t, c, SchedulePauseHandler, url.Values{"id": {"1"}})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected another account's schedule to be hidden, got [%d]", w.Code)
+	}
+	if stored := getSchedule(t, s, id); stored.Paused {
+		t.Error("Expected another account's schedule to be left alone")
+	}
+}
@@ -18,6 +18,10 @@ type Account struct {
 
 	// TotalJobs tracks the number of jobs submitted on behalf of this account.
 	TotalJobs int64 `bson:"total_jobs"`
+
+	// MaxPriority is the highest priority that this account may request for its jobs. Zero defers
+	// to the server's DefaultMaxPriority.
+	MaxPriority int `bson:"max_priority"`
 }
 
 // Authenticate reads authentication information from HTTP basic auth and attempts to locate a
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	mgo "github.com/cloudpipe/mgo"
+)
+
+// BlobStore enumerates interactions with the store that holds job results, which are kept out of
+// the job documents so that they aren't bound by document size limits or shipped with every listing.
+type BlobStore interface {
+	Put(key string, data []byte) error
+	Get(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+// ResultBlob references a job's result within the result BlobStore.
+type ResultBlob struct {
+	Key      string `json:"-" bson:"key"`
+	Size     int64  `json:"size" bson:"size"`
+	Checksum string `json:"sha256" bson:"sha256"`
+}
+
+// NewBlobStore initializes the BlobStore selected by the ResultStore setting. "gridfs" stores
+// results in GridFS alongside MongoDB storage, "file:///path/to/dir" keeps one file per result
+// within a local directory, and "memory" keeps results in process memory.
+func NewBlobStore(c *Context) (BlobStore, error) {
+	address := c.ResultStore
+
+	switch {
+	case address == "gridfs":
+		mongo, ok := c.Storage.(*MongoStorage)
+		if !ok {
+			return nil, fmt.Errorf("the gridfs result store requires mongo storage")
+		}
+		return &GridFSBlobStore{GridFS: mongo.Database.GridFS("results")}, nil
+	case strings.HasPrefix(address, "file://"):
+		return NewFileBlobStore(address[len("file://"):])
+	case address == "memory":
+		return NewMemoryBlobStore(), nil
+	default:
+		return nil, fmt.Errorf("unrecognized result store URL [%s]", address)
+	}
+}
+
+// resultKey derives the BlobStore key used to store the result of a job.
+func resultKey(job *SubmittedJob) string {
+	return fmt.Sprintf("job-%d-result", job.JID)
+}
+
+// StoreResult moves a job's Result payload into the result BlobStore and replaces it with a
+// ResultBlob that records its key, size and checksum.
+func StoreResult(c *Context, job *SubmittedJob) error {
+	sum := sha256.Sum256(job.Result)
+	blob := &ResultBlob{
+		Key:      resultKey(job),
+		Size:     int64(len(job.Result)),
+		Checksum: hex.EncodeToString(sum[:]),
+	}
+
+	if err := c.Results.Put(blob.Key, job.Result); err != nil {
+		return err
+	}
+
+	job.ResultBlob = blob
+	job.Result = nil
+	return nil
+}
+
+// LoadResult fetches a job's result payload from the result BlobStore into its Result field,
+// verifying its size and checksum. Jobs without a ResultBlob are left untouched.
+func LoadResult(c *Context, job *SubmittedJob) error {
+	if job.ResultBlob == nil {
+		return nil
+	}
+
+	r, err := c.Results.Get(job.ResultBlob.Key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(data)
+	if int64(len(data)) != job.ResultBlob.Size || hex.EncodeToString(sum[:]) != job.ResultBlob.Checksum {
+		return fmt.Errorf("result of job %d does not match its recorded size and checksum", job.JID)
+	}
+
+	job.Result = data
+	return nil
+}
+
+// GridFSBlobStore is a BlobStore that keeps blobs in a MongoDB GridFS.
+type GridFSBlobStore struct {
+	GridFS *mgo.GridFS
+}
+
+// Put replaces the blob with the provided key.
+func (store *GridFSBlobStore) Put(key string, data []byte) error {
+	if err := store.GridFS.Remove(key); err != nil {
+		return err
+	}
+
+	f, err := store.GridFS.Create(key)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Get opens the blob with the provided key for reading.
+func (store *GridFSBlobStore) Get(key string) (io.ReadCloser, error) {
+	return store.GridFS.Open(key)
+}
+
+// Delete removes the blob with the provided key, if it exists.
+func (store *GridFSBlobStore) Delete(key string) error {
+	return store.GridFS.Remove(key)
+}
+
+// FileBlobStore is a BlobStore that keeps each blob in its own file within a local directory.
+type FileBlobStore struct {
+	Root string
+}
+
+// NewFileBlobStore creates the directory at root, if necessary, and stores blobs within it.
+func NewFileBlobStore(root string) (*FileBlobStore, error) {
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, err
+	}
+	return &FileBlobStore{Root: root}, nil
+}
+
+func (store *FileBlobStore) path(key string) string {
+	return path.Join(store.Root, path.Base(key))
+}
+
+// Put replaces the blob with the provided key. The blob is written to a temporary file first, so
+// readers never see a partially written blob.
+func (store *FileBlobStore) Put(key string, data []byte) error {
+	f, err := ioutil.TempFile(store.Root, ".incoming-")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), store.path(key))
+}
+
+// Get opens the blob with the provided key for reading.
+func (store *FileBlobStore) Get(key string) (io.ReadCloser, error) {
+	f, err := os.Open(store.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Delete removes the blob with the provided key, if it exists.
+func (store *FileBlobStore) Delete(key string) error {
+	err := os.Remove(store.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// MemoryBlobStore is a thread-safe BlobStore that keeps blobs in process memory.
+type MemoryBlobStore struct {
+	sync.Mutex
+
+	blobs map[string][]byte
+}
+
+// NewMemoryBlobStore creates an empty MemoryBlobStore.
+func NewMemoryBlobStore() *MemoryBlobStore {
+	return &MemoryBlobStore{blobs: make(map[string][]byte)}
+}
+
+// Put replaces the blob with the provided key.
+func (store *MemoryBlobStore) Put(key string, data []byte) error {
+	store.Lock()
+	defer store.Unlock()
+
+	store.blobs[key] = append([]byte(nil), data...)
+	return nil
+}
+
+// Get opens the blob with the provided key for reading.
+func (store *MemoryBlobStore) Get(key string) (io.ReadCloser, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	data, ok := store.blobs[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Delete removes the blob with the provided key, if it exists.
+func (store *MemoryBlobStore) Delete(key string) error {
+	store.Lock()
+	defer store.Unlock()
+
+	delete(store.blobs, key)
+	return nil
+}
+
+// Ensure that the BlobStore implementations adhere to the BlobStore interface.
+var (
+	_ BlobStore = &GridFSBlobStore{}
+	_ BlobStore = &FileBlobStore{}
+	_ BlobStore = &MemoryBlobStore{}
+)
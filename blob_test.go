@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestFileBlobStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloudpipe-blobs")
+	if err != nil {
+		t.Fatalf("Unable to create a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileBlobStore(path.Join(dir, "results"))
+	if err != nil {
+		t.Fatalf("Unable to create a file blob store: %v", err)
+	}
+
+	if _, err := store.Get("missing"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for a missing blob, got [%v]", err)
+	}
+
+	if err := store.Put("job-1-result", []byte("first")); err != nil {
+		t.Fatalf("Unable to put a blob: %v", err)
+	}
+	if err := store.Put("job-1-result", []byte("second")); err != nil {
+		t.Fatalf("Unable to replace a blob: %v", err)
+	}
+
+	r, err := store.Get("job-1-result")
+	if err != nil {
+		t.Fatalf("Unable to get a blob: %v", err)
+	}
+	data, _ := ioutil.ReadAll(r)
+	r.Close()
+	if string(data) != "second" {
+		t.Errorf("Unexpected blob contents: [%s]", data)
+	}
+
+	if err := store.Delete("job-1-result"); err != nil {
+		t.Fatalf("Unable to delete a blob: %v", err)
+	}
+	if err := store.Delete("job-1-result"); err != nil {
+		t.Errorf("Expected deleting a missing blob to succeed, got [%v]", err)
+	}
+	if _, err := store.Get("job-1-result"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for a deleted blob, got [%v]", err)
+	}
+}
+
+func TestNewBlobStore(t *testing.T) {
+	c := &Context{Settings: Settings{ResultStore: "memory"}}
+	store, err := NewBlobStore(c)
+	if err != nil {
+		t.Fatalf("Unexpected error creating a blob store: %v", err)
+	}
+	if _, ok := store.(*MemoryBlobStore); !ok {
+		t.Errorf("Expected %#v to be a MemoryBlobStore", store)
+	}
+
+	c = &Context{Settings: Settings{ResultStore: "gridfs"}, Storage: NewMemoryStorage()}
+	if _, err := NewBlobStore(c); err == nil {
+		t.Error("Expected an error using GridFS without MongoDB storage")
+	}
+}
+
+func TestStoreAndLoadResult(t *testing.T) {
+	store := NewMemoryBlobStore()
+	c := &Context{Results: store}
+	job := &SubmittedJob{JID: 12, Result: []byte("42")}
+
+	if err := StoreResult(c, job); err != nil {
+		t.Fatalf("Unable to store a result: %v", err)
+	}
+	if job.Result != nil {
+		t.Error("Expected the result to be removed from the job")
+	}
+	if job.ResultBlob == nil || job.ResultBlob.Size != 2 {
+		t.Fatalf("Unexpected result blob: %#v", job.ResultBlob)
+	}
+	if job.ResultBlob.Checksum != "73475cb40a568e8da8a045ced110137e159f890ac4da883b6b17dc651b3a8049" {
+		t.Errorf("Unexpected checksum: [%s]", job.ResultBlob.Checksum)
+	}
+
+	if err := LoadResult(c, job); err != nil {
+		t.Fatalf("Unable to load a result: %v", err)
+	}
+	if string(job.Result) != "42" {
+		t.Errorf("Unexpected result: [%s]", job.Result)
+	}
+
+	// A corrupted blob must be detected.
+	store.Put(job.ResultBlob.Key, []byte("43"))
+	if err := LoadResult(c, job); err == nil {
+		t.Error("Expected an error loading a result with a mismatched checksum")
+	}
+}
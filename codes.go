@@ -8,6 +8,8 @@ const (
 
 	// CodeCredentialsMissing means a request that was required to be authenticated had no auth data.
 	CodeCredentialsMissing = "ANONE"
+	// CodeAdminRequired means that a non-administrator attempted an administrative action.
+	CodeAdminRequired = "ADMIN"
 	// CodeCredentialsIncorrect means auth data on a request was present, but incorrect.
 	CodeCredentialsIncorrect = "AFAIL"
 	// CodeAuthServiceConnection means the auth service could not be reached.
@@ -28,6 +30,13 @@ const (
 	CodeInvalidResultSource = "JRSRC"
 	// CodeInvalidResultType means a job has an invalid result type.
 	CodeInvalidResultType = "JRTYPE"
+	// CodeInvalidCore means a job requested an unknown core type or an unavailable number of cores.
+	CodeInvalidCore = "JCORE"
+	// CodeInvalidDependency means a job depends on a job that doesn't exist or belongs to another
+	// account.
+	CodeInvalidDependency = "JDEP"
+	// CodeInvalidPriority means a job requested a priority beyond what its account is allowed.
+	CodeInvalidPriority = "JPRIO"
 	// CodeEnqueueFailure means a job could not be enqueued in the storage engine.
 	CodeEnqueueFailure = "JQUEUE"
 	// CodeListFailure means that a query for jobs could not be performed by storage engine.
@@ -38,4 +47,40 @@ const (
 	CodeJobUpdateFailure = "JUPD"
 	// CodeJobNotFound means that an action was attempted on a job that doesn't exist.
 	CodeJobNotFound = "JNF"
+	// CodeJobNotQueued means that an action that requires a queued job was attempted on a job that
+	// has already been claimed or finished.
+	CodeJobNotQueued = "JNQ"
+	// CodeInvalidArray means that a job array submission had no elements, too many of them, or
+	// per-element lists of different lengths.
+	CodeInvalidArray = "JARRAY"
+	// CodeArrayNotFound means that an action was attempted on a job array that doesn't exist.
+	CodeArrayNotFound = "JANF"
+	// CodeResultFailure means that a job's result could not be fetched from the result store.
+	CodeResultFailure = "JRSLT"
+
+	// CodeInvalidScheduleJSON means a POST body to /schedule was not parseable JSON.
+	CodeInvalidScheduleJSON = "SPRS"
+	// CodeInvalidScheduleForm means that a schedule action's POST body didn't contain a valid schedule
+	// ID.
+	CodeInvalidScheduleForm = "SFRM"
+	// CodeInvalidCron means a schedule's cron expression couldn't be parsed.
+	CodeInvalidCron = "SCRON"
+	// CodeInvalidTimezone means a schedule named a timezone that doesn't exist.
+	CodeInvalidTimezone = "STZ"
+	// CodeInvalidOverlap means a schedule requested an unknown overlap policy.
+	CodeInvalidOverlap = "SOVER"
+	// CodeInvalidScheduleJob means a schedule's job template set a field that schedules control.
+	CodeInvalidScheduleJob = "SJOB"
+	// CodeScheduleNotFound means that an action was attempted on a schedule that doesn't exist.
+	CodeScheduleNotFound = "SNF"
+	// CodeScheduleListFailure means that a query for schedules could not be performed by the storage
+	// engine.
+	CodeScheduleListFailure = "SLIST"
+	// CodeScheduleUpdateFailure means that a schedule could not be created, changed or deleted.
+	CodeScheduleUpdateFailure = "SUPD"
+
+	// CodeAccountUpdateFailure means that an account could not be updated.
+	CodeAccountUpdateFailure = "AUPD"
+	// CodeRetentionFailure means that the jobs expired by the retention policy could not be listed.
+	CodeRetentionFailure = "RLIST"
 )
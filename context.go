@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"strings"
 
 	log "github.com/Sirupsen/logrus"
 	docker "github.com/fsouza/go-dockerclient"
@@ -26,6 +27,15 @@ type Context struct {
 	// Shared clients.
 	HTTPS       *http.Client
 	AuthService AuthService
+	Results     BlobStore
+	Cores       *CoreCatalog
+
+	// Job execution state. Jobs run on the Docker client above unless Hosts spreads them across
+	// several Docker daemons.
+	Pool      *WorkerPool
+	Hosts     *DockerPool
+	Scheduler Scheduler
+	Wakeup    *Wakeup
 }
 
 // Settings contains configuration options loaded from the environment.
@@ -33,7 +43,9 @@ type Settings struct {
 	Port         int
 	LogLevel     string
 	LogColors    bool
+	Storage      string
 	MongoURL     string
+	ResultStore  string
 	AdminName    string
 	AdminKey     string
 	DockerHost   string
@@ -42,8 +54,60 @@ type Settings struct {
 	Cert         string
 	Key          string
 	DefaultImage string
-	Poll         int
-	AuthService  string
+	CoreCatalog  string
+
+	// DockerHosts optionally names a JSON file that lists several Docker daemons to spread jobs
+	// across, in place of DockerHost.
+	DockerHosts string
+
+	// Missing images are pulled before a job runs. Jobs stall if their pull makes no progress for
+	// PullTimeout seconds.
+	PullTimeout int
+
+	Poll        int
+	Workers     int
+	AuthService string
+
+	// The job runner claims jobs as soon as they're submitted to this instance, and checks the
+	// storage's queue revision every FeedPoll milliseconds for jobs queued by other instances. It
+	// polls the queue itself every Poll milliseconds regardless.
+	FeedPoll int
+
+	// Seconds to wait for running jobs and in-flight requests to finish after a SIGTERM or SIGINT.
+	DrainTimeout int
+
+	// Scheduling strategy used to choose the next queued job: "fifo" or "fairshare". Under
+	// "fairshare", recent usage is forgotten with a half-life of FairShareHalfLife minutes.
+	Scheduler         string
+	FairShareHalfLife int
+
+	// Limits on job wall-clock runtime, in seconds. DefaultMaxRuntime applies to jobs that don't
+	// request a MaxRuntime; RuntimeLimit caps every job. Zero means unlimited.
+	DefaultMaxRuntime int
+	RuntimeLimit      int
+
+	// The highest job priority that accounts without their own MaxPriority may request.
+	DefaultMaxPriority int
+
+	// Restartable jobs that fail because of Docker errors are retried up to MaxAttempts attempts in
+	// total, waiting RetryBackoff seconds before the first retry and twice as long before each one
+	// after that.
+	MaxAttempts  int
+	RetryBackoff int
+
+	// RunnerID identifies this job runner among every runner sharing the same storage. Its claims
+	// lapse unless it renews them within LeaseDuration seconds. A LeaseDuration of zero is replaced by
+	// the default of 60 seconds; only a negative LeaseDuration turns leases off.
+	RunnerID      string
+	LeaseDuration int
+
+	// Retention policy. Completed jobs are archived and purged once they're older than the number of
+	// hours configured for their status. Zero keeps them forever.
+	RetainDone        int
+	RetainError       int
+	RetainKilled      int
+	RetentionInterval int
+	ArchiveDir        string
 }
 
 // NewContext loads the active configuration and applies any immediate, global settings like the
@@ -70,21 +134,58 @@ func NewContext() (*Context, error) {
 	// Summarize the loaded settings.
 
 	log.WithFields(log.Fields{
-		"port":               c.Port,
-		"logging level":      c.LogLevel,
-		"log with color":     c.LogColors,
-		"mongo URL":          c.MongoURL,
-		"admin account":      c.AdminName,
-		"docker host":        c.DockerHost,
-		"docker TLS enabled": c.DockerTLS,
-		"CA cert":            c.CACert,
-		"cert":               c.Cert,
-		"key":                c.Key,
-		"default image":      c.DefaultImage,
-		"polling interval":   c.Poll,
-		"auth service":       c.Settings.AuthService,
+		"port":                 c.Port,
+		"logging level":        c.LogLevel,
+		"log with color":       c.LogColors,
+		"storage":              c.Settings.Storage,
+		"mongo URL":            c.MongoURL,
+		"result store":         c.ResultStore,
+		"admin account":        c.AdminName,
+		"docker host":          c.DockerHost,
+		"docker TLS enabled":   c.DockerTLS,
+		"docker hosts":         c.DockerHosts,
+		"CA cert":              c.CACert,
+		"cert":                 c.Cert,
+		"key":                  c.Key,
+		"default image":        c.DefaultImage,
+		"core catalog":         c.CoreCatalog,
+		"pull timeout":         c.PullTimeout,
+		"polling interval":     c.Poll,
+		"feed poll interval":   c.FeedPoll,
+		"workers":              c.Workers,
+		"drain timeout":        c.DrainTimeout,
+		"scheduler":            c.Settings.Scheduler,
+		"fair share half life": c.FairShareHalfLife,
+		"auth service":         c.Settings.AuthService,
+		"default max runtime":  c.DefaultMaxRuntime,
+		"runtime limit":        c.RuntimeLimit,
+		"default max priority": c.DefaultMaxPriority,
+		"max attempts":         c.MaxAttempts,
+		"retry backoff":        c.Settings.RetryBackoff,
+		"runner ID":            c.RunnerID,
+		"lease duration":       c.LeaseDuration,
+		"retain done":          c.RetainDone,
+		"retain error":         c.RetainError,
+		"retain killed":        c.RetainKilled,
+		"retention interval":   c.RetentionInterval,
+		"archive dir":          c.ArchiveDir,
 	}).Info("Initializing with loaded settings.")
 
+	c.Wakeup = NewWakeup()
+
+	c.Pool = NewWorkerPool(c.Workers)
+	c.Pool.NotifyOnRelease(c.Wakeup)
+
+	c.Scheduler, err = NewScheduler(c)
+	if err != nil {
+		return c, err
+	}
+
+	c.Cores, err = LoadCoreCatalog(c.CoreCatalog)
+	if err != nil {
+		return c, err
+	}
+
 	// Configure a HTTP(S) client to use the provided TLS credentials.
 
 	caCertPool := x509.NewCertPool()
@@ -111,9 +212,9 @@ func NewContext() (*Context, error) {
 	transport := &http.Transport{TLSClientConfig: tlsConfig}
 	c.HTTPS = &http.Client{Transport: transport}
 
-	// Connect to MongoDB.
+	// Connect to the storage engine.
 
-	c.Storage, err = NewMongoStorage(c)
+	c.Storage, err = NewStorage(c)
 	if err != nil {
 		return c, err
 	}
@@ -121,9 +222,26 @@ func NewContext() (*Context, error) {
 		return c, err
 	}
 
+	c.Results, err = NewBlobStore(c)
+	if err != nil {
+		return c, err
+	}
+
 	// Connect to Docker.
 
-	if c.DockerTLS {
+	if c.DockerHosts != "" {
+		c.Hosts, err = LoadDockerPool(c.DockerHosts, c.Settings, c.Cores)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"docker hosts": c.DockerHosts,
+				"error":        err,
+			}).Error("Unable to connect to the Docker hosts.")
+			return c, err
+		}
+
+		// Jobs that weren't placed on a host run on the first one.
+		c.Docker = c.Hosts.Default()
+	} else if c.DockerTLS {
 		c.Docker, err = docker.NewTLSClient(c.DockerHost, c.Cert, c.Key, c.CACert)
 		if err != nil {
 			log.WithFields(log.Fields{
@@ -169,14 +287,49 @@ func (c *Context) Load() error {
 		c.LogLevel = "info"
 	}
 
+	if c.Settings.Storage == "" {
+		c.Settings.Storage = "mongo"
+	}
+
 	if c.MongoURL == "" {
 		c.MongoURL = "mongo"
 	}
 
+	if c.ResultStore == "" {
+		switch c.Settings.Storage {
+		case "mongo":
+			c.ResultStore = "gridfs"
+		case "memory":
+			c.ResultStore = "memory"
+		default:
+			c.ResultStore = "file://" + path.Join(c.dataDir(), "results")
+		}
+	}
+
 	if c.Poll == 0 {
 		c.Poll = 500
 	}
 
+	if c.FeedPoll == 0 {
+		c.FeedPoll = 100
+	}
+
+	if c.Workers == 0 {
+		c.Workers = 8
+	}
+
+	if c.DrainTimeout == 0 {
+		c.DrainTimeout = 60
+	}
+
+	if c.Settings.Scheduler == "" {
+		c.Settings.Scheduler = "fifo"
+	}
+
+	if c.FairShareHalfLife == 0 {
+		c.FairShareHalfLife = 60
+	}
+
 	if c.DockerHost == "" {
 		if host := os.Getenv("DOCKER_HOST"); host != "" {
 			c.DockerHost = host
@@ -206,10 +359,42 @@ func (c *Context) Load() error {
 		c.DefaultImage = "cloudpipe/runner-py2"
 	}
 
+	if c.PullTimeout == 0 {
+		c.PullTimeout = 300
+	}
+
 	if c.Settings.AuthService == "" {
 		c.Settings.AuthService = "https://authstore:9001/v1"
 	}
 
+	if c.MaxAttempts == 0 {
+		c.MaxAttempts = 3
+	}
+
+	if c.Settings.RetryBackoff == 0 {
+		c.Settings.RetryBackoff = 10
+	}
+
+	if c.RunnerID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("unable to derive a runner ID from the hostname: %v", err)
+		}
+		c.RunnerID = hostname
+	}
+
+	if c.LeaseDuration == 0 {
+		c.LeaseDuration = 60
+	}
+
+	if c.RetentionInterval == 0 {
+		c.RetentionInterval = 60
+	}
+
+	if c.ArchiveDir == "" {
+		c.ArchiveDir = path.Join(c.dataDir(), "archive")
+	}
+
 	if _, err := log.ParseLevel(c.LogLevel); err != nil {
 		return err
 	}
@@ -217,6 +402,17 @@ func (c *Context) Load() error {
 	return nil
 }
 
+// dataDir chooses a directory for the files that cloudpipe keeps beside its storage. Embedded
+// storage keeps them next to its database file, so that a dev box needs no system directories.
+func (c *Context) dataDir() string {
+	for _, scheme := range []string{"bolt://", "sqlite://"} {
+		if strings.HasPrefix(c.Settings.Storage, scheme) {
+			return path.Dir(c.Settings.Storage[len(scheme):])
+		}
+	}
+	return "/var/lib/cloudpipe"
+}
+
 // ListenAddr generates an address to bind the net/http server to based on the current settings.
 func (c *Context) ListenAddr() string {
 	return fmt.Sprintf(":%d", c.Port)
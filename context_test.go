@@ -11,17 +11,39 @@ func TestLoadFromEnvironment(t *testing.T) {
 	os.Setenv("PIPE_PORT", "1234")
 	os.Setenv("PIPE_LOGLEVEL", "debug")
 	os.Setenv("PIPE_LOGCOLORS", "true")
+	os.Setenv("PIPE_STORAGE", "bolt:///var/lib/pipe.db")
 	os.Setenv("PIPE_MONGOURL", "server.example.com")
+	os.Setenv("PIPE_RESULTSTORE", "file:///lockbox/results")
 	os.Setenv("PIPE_ADMINNAME", "fake")
 	os.Setenv("PIPE_ADMINKEY", "12345")
 	os.Setenv("PIPE_POLL", "5000")
+	os.Setenv("PIPE_FEEDPOLL", "250")
+	os.Setenv("PIPE_CORECATALOG", "/lockbox/cores.json")
+	os.Setenv("PIPE_WORKERS", "3")
+	os.Setenv("PIPE_DRAINTIMEOUT", "120")
+	os.Setenv("PIPE_SCHEDULER", "fairshare")
+	os.Setenv("PIPE_FAIRSHAREHALFLIFE", "30")
 	os.Setenv("PIPE_DEFAULTIMAGE", "cloudpipe/runner-trial")
+	os.Setenv("PIPE_PULLTIMEOUT", "30")
+	os.Setenv("PIPE_DOCKERHOSTS", "/etc/cloudpipe/hosts.json")
 	os.Setenv("PIPE_DOCKERHOST", "tcp://1.2.3.4:4567/")
 	os.Setenv("PIPE_DOCKERTLS", "true")
 	os.Setenv("PIPE_CACERT", "/lockbox/ca.pem")
 	os.Setenv("PIPE_CERT", "/lockbox/cert.pem")
 	os.Setenv("PIPE_KEY", "/lockbox/key.pem")
 	os.Setenv("PIPE_AUTHSERVICE", "https://auth")
+	os.Setenv("PIPE_DEFAULTMAXRUNTIME", "600")
+	os.Setenv("PIPE_RUNTIMELIMIT", "86400")
+	os.Setenv("PIPE_DEFAULTMAXPRIORITY", "4")
+	os.Setenv("PIPE_MAXATTEMPTS", "5")
+	os.Setenv("PIPE_RETRYBACKOFF", "30")
+	os.Setenv("PIPE_RUNNERID", "runner-a")
+	os.Setenv("PIPE_LEASEDURATION", "90")
+	os.Setenv("PIPE_RETAINDONE", "720")
+	os.Setenv("PIPE_RETAINERROR", "2160")
+	os.Setenv("PIPE_RETAINKILLED", "24")
+	os.Setenv("PIPE_RETENTIONINTERVAL", "15")
+	os.Setenv("PIPE_ARCHIVEDIR", "/lockbox/archive")
 
 	if err := c.Load(); err != nil {
 		t.Errorf("Error loading configuration: %v", err)
@@ -39,14 +61,42 @@ func TestLoadFromEnvironment(t *testing.T) {
 		t.Error("Expected log coloring to be enabled")
 	}
 
+	if c.Settings.Storage != "bolt:///var/lib/pipe.db" {
+		t.Errorf("Unexpected storage URL: [%s]", c.Settings.Storage)
+	}
+
 	if c.MongoURL != "server.example.com" {
 		t.Errorf("Unexpected MongoDB URL: [%s]", c.MongoURL)
 	}
 
+	if c.ResultStore != "file:///lockbox/results" {
+		t.Errorf("Unexpected result store URL: [%s]", c.ResultStore)
+	}
+
 	if c.Poll != 5000 {
 		t.Errorf("Unexpected polling interval: [%d]", c.Poll)
 	}
 
+	if c.FeedPoll != 250 {
+		t.Errorf("Unexpected feed polling interval: [%d]", c.FeedPoll)
+	}
+
+	if c.CoreCatalog != "/lockbox/cores.json" {
+		t.Errorf("Unexpected core catalog: [%s]", c.CoreCatalog)
+	}
+
+	if c.Workers != 3 {
+		t.Errorf("Unexpected worker count: [%d]", c.Workers)
+	}
+
+	if c.DrainTimeout != 120 {
+		t.Errorf("Unexpected drain timeout: [%d]", c.DrainTimeout)
+	}
+
+	if c.Settings.Scheduler != "fairshare" || c.FairShareHalfLife != 30 {
+		t.Errorf("Unexpected scheduler: [%s] [%d]", c.Settings.Scheduler, c.FairShareHalfLife)
+	}
+
 	if c.DockerHost != "tcp://1.2.3.4:4567/" {
 		t.Errorf("Unexpected docker host: [%s]", c.DockerHost)
 	}
@@ -71,6 +121,14 @@ func TestLoadFromEnvironment(t *testing.T) {
 		t.Errorf("Unexpected default image: [%s]", c.DefaultImage)
 	}
 
+	if c.PullTimeout != 30 {
+		t.Errorf("Unexpected pull timeout: [%d]", c.PullTimeout)
+	}
+
+	if c.DockerHosts != "/etc/cloudpipe/hosts.json" {
+		t.Errorf("Unexpected Docker hosts: [%s]", c.DockerHosts)
+	}
+
 	if c.AdminName != "fake" {
 		t.Errorf("Unexpected administrator name: [%s]", c.AdminName)
 	}
@@ -82,6 +140,34 @@ func TestLoadFromEnvironment(t *testing.T) {
 	if c.Settings.AuthService != "https://auth" {
 		t.Errorf("Unexpected authentication service URL: [%s]", c.AuthService)
 	}
+
+	if c.DefaultMaxRuntime != 600 || c.RuntimeLimit != 86400 {
+		t.Errorf("Unexpected runtime limits: [%d] [%d]", c.DefaultMaxRuntime, c.RuntimeLimit)
+	}
+
+	if c.DefaultMaxPriority != 4 {
+		t.Errorf("Unexpected default maximum priority: [%d]", c.DefaultMaxPriority)
+	}
+
+	if c.MaxAttempts != 5 || c.Settings.RetryBackoff != 30 {
+		t.Errorf("Unexpected retry settings: [%d] [%d]", c.MaxAttempts, c.Settings.RetryBackoff)
+	}
+
+	if c.RunnerID != "runner-a" || c.LeaseDuration != 90 {
+		t.Errorf("Unexpected lease settings: [%s] [%d]", c.RunnerID, c.LeaseDuration)
+	}
+
+	if c.RetainDone != 720 || c.RetainError != 2160 || c.RetainKilled != 24 {
+		t.Errorf("Unexpected retention ages: [%d] [%d] [%d]", c.RetainDone, c.RetainError, c.RetainKilled)
+	}
+
+	if c.RetentionInterval != 15 {
+		t.Errorf("Unexpected retention interval: [%d]", c.RetentionInterval)
+	}
+
+	if c.ArchiveDir != "/lockbox/archive" {
+		t.Errorf("Unexpected archive directory: [%s]", c.ArchiveDir)
+	}
 }
 
 func TestDefaultValues(t *testing.T) {
@@ -90,10 +176,18 @@ func TestDefaultValues(t *testing.T) {
 	os.Setenv("PIPE_PORT", "")
 	os.Setenv("PIPE_LOGLEVEL", "")
 	os.Setenv("PIPE_LOGCOLORS", "")
+	os.Setenv("PIPE_STORAGE", "")
 	os.Setenv("PIPE_MONGOURL", "")
+	os.Setenv("PIPE_RESULTSTORE", "")
 	os.Setenv("PIPE_ADMINNAME", "")
 	os.Setenv("PIPE_ADMINKEY", "")
 	os.Setenv("PIPE_POLL", "")
+	os.Setenv("PIPE_FEEDPOLL", "")
+	os.Setenv("PIPE_CORECATALOG", "")
+	os.Setenv("PIPE_WORKERS", "")
+	os.Setenv("PIPE_DRAINTIMEOUT", "")
+	os.Setenv("PIPE_SCHEDULER", "")
+	os.Setenv("PIPE_FAIRSHAREHALFLIFE", "")
 	os.Setenv("PIPE_DOCKERHOST", "")
 	os.Setenv("DOCKER_HOST", "")
 	os.Setenv("PIPE_DOCKERTLS", "")
@@ -103,7 +197,21 @@ func TestDefaultValues(t *testing.T) {
 	os.Setenv("DOCKER_TLS_VERIFY", "")
 	os.Setenv("DOCKER_CERT_PATH", "")
 	os.Setenv("PIPE_DEFAULTIMAGE", "")
+	os.Setenv("PIPE_PULLTIMEOUT", "")
+	os.Setenv("PIPE_DOCKERHOSTS", "")
 	os.Setenv("PIPE_AUTHSERVICE", "")
+	os.Setenv("PIPE_DEFAULTMAXRUNTIME", "")
+	os.Setenv("PIPE_RUNTIMELIMIT", "")
+	os.Setenv("PIPE_DEFAULTMAXPRIORITY", "")
+	os.Setenv("PIPE_MAXATTEMPTS", "")
+	os.Setenv("PIPE_RETRYBACKOFF", "")
+	os.Setenv("PIPE_RUNNERID", "")
+	os.Setenv("PIPE_LEASEDURATION", "")
+	os.Setenv("PIPE_RETAINDONE", "")
+	os.Setenv("PIPE_RETAINERROR", "")
+	os.Setenv("PIPE_RETAINKILLED", "")
+	os.Setenv("PIPE_RETENTIONINTERVAL", "")
+	os.Setenv("PIPE_ARCHIVEDIR", "")
 
 	if err := c.Load(); err != nil {
 		t.Errorf("Error loading configuration: %v", err)
@@ -121,14 +229,38 @@ func TestDefaultValues(t *testing.T) {
 		t.Error("Expected logging colors to be disabled by default")
 	}
 
+	if c.Settings.Storage != "mongo" {
+		t.Errorf("Unexpected storage URL: [%s]", c.Settings.Storage)
+	}
+
 	if c.MongoURL != "mongo" {
 		t.Errorf("Unexpected MongoDB connection URL: [%s]", c.MongoURL)
 	}
 
+	if c.ResultStore != "gridfs" {
+		t.Errorf("Unexpected result store URL: [%s]", c.ResultStore)
+	}
+
 	if c.Poll != 500 {
 		t.Errorf("Unexpected polling interval: [%d]", c.Poll)
 	}
 
+	if c.FeedPoll != 100 {
+		t.Errorf("Unexpected feed polling interval: [%d]", c.FeedPoll)
+	}
+
+	if c.Workers != 8 {
+		t.Errorf("Unexpected worker count: [%d]", c.Workers)
+	}
+
+	if c.DrainTimeout != 60 {
+		t.Errorf("Unexpected drain timeout: [%d]", c.DrainTimeout)
+	}
+
+	if c.Settings.Scheduler != "fifo" || c.FairShareHalfLife != 60 {
+		t.Errorf("Unexpected scheduler: [%s] [%d]", c.Settings.Scheduler, c.FairShareHalfLife)
+	}
+
 	if c.DockerHost != "unix:///var/run/docker.sock" {
 		t.Errorf("Unexpected docker host: [%s]", c.DockerHost)
 	}
@@ -153,9 +285,45 @@ func TestDefaultValues(t *testing.T) {
 		t.Errorf("Unexpected default image: [%s]", c.DefaultImage)
 	}
 
+	if c.PullTimeout != 300 {
+		t.Errorf("Unexpected pull timeout: [%d]", c.PullTimeout)
+	}
+
+	if c.DockerHosts != "" {
+		t.Errorf("Unexpected Docker hosts: [%s]", c.DockerHosts)
+	}
+
 	if c.Settings.AuthService != "https://authstore:9001/v1" {
 		t.Errorf("Unexpected default auth service: [%s]", c.AuthService)
 	}
+
+	if c.DefaultMaxRuntime != 0 || c.RuntimeLimit != 0 {
+		t.Errorf("Expected job runtime to be unlimited by default, got [%d] [%d]", c.DefaultMaxRuntime, c.RuntimeLimit)
+	}
+
+	if c.DefaultMaxPriority != 0 {
+		t.Errorf("Expected job priorities to be disabled by default, got [%d]", c.DefaultMaxPriority)
+	}
+
+	if c.MaxAttempts != 3 || c.Settings.RetryBackoff != 10 {
+		t.Errorf("Unexpected retry settings: [%d] [%d]", c.MaxAttempts, c.Settings.RetryBackoff)
+	}
+
+	if hostname, _ := os.Hostname(); c.RunnerID != hostname || c.LeaseDuration != 60 {
+		t.Errorf("Unexpected lease settings: [%s] [%d]", c.RunnerID, c.LeaseDuration)
+	}
+
+	if len(c.RetentionPolicy()) != 0 {
+		t.Errorf("Expected jobs to be retained forever by default, got %v", c.RetentionPolicy())
+	}
+
+	if c.RetentionInterval != 60 {
+		t.Errorf("Unexpected retention interval: [%d]", c.RetentionInterval)
+	}
+
+	if c.ArchiveDir != "/var/lib/cloudpipe/archive" {
+		t.Errorf("Unexpected archive directory: [%s]", c.ArchiveDir)
+	}
 }
 
 func TestUseDockerHost(t *testing.T) {
@@ -172,6 +340,26 @@ func TestUseDockerHost(t *testing.T) {
 	}
 }
 
+func TestEmbeddedStorageKeepsFilesBesideIt(t *testing.T) {
+	os.Setenv("PIPE_STORAGE", "bolt:///home/dev/cloudpipe/pipe.db")
+	os.Setenv("PIPE_RESULTSTORE", "")
+	os.Setenv("PIPE_ARCHIVEDIR", "")
+
+	c := Context{}
+	if err := c.Load(); err != nil {
+		t.Errorf("Error loading configuration: %v", err)
+	}
+
+	if c.ResultStore != "file:///home/dev/cloudpipe/results" {
+		t.Errorf("Unexpected result store URL: [%s]", c.ResultStore)
+	}
+	if c.ArchiveDir != "/home/dev/cloudpipe/archive" {
+		t.Errorf("Unexpected archive directory: [%s]", c.ArchiveDir)
+	}
+
+	os.Setenv("PIPE_STORAGE", "")
+}
+
 func TestAddressString(t *testing.T) {
 	c := Context{
 		Settings: Settings{Port: 1234},
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// CoreType describes the resources allotted to each core of a given type. A job receives the
+// resources of one core for each of its Multicore count.
+type CoreType struct {
+	// CPUShares is the relative CPU weight of one core.
+	CPUShares int64 `json:"cpu_shares"`
+
+	// CPUSet optionally pins jobs to specific host CPUs, like "0-3".
+	CPUSet string `json:"cpuset,omitempty"`
+
+	// Memory is the memory limit of one core, in bytes.
+	Memory int64 `json:"memory"`
+
+	// Swap is the swap space allowed for one core, in bytes, beyond its memory limit. A negative
+	// value allows unlimited swap.
+	Swap int64 `json:"swap"`
+
+	// MaxMulticore is the largest number of cores of this type that a single job may request.
+	MaxMulticore int `json:"max_multicore"`
+}
+
+// CoreCatalog enumerates the core types that jobs may request.
+type CoreCatalog struct {
+	// Default is the core type assigned to jobs that don't request one.
+	Default string              `json:"default"`
+	Types   map[string]CoreType `json:"types"`
+}
+
+// DefaultCoreCatalog creates the catalog of core types used when none is configured.
+func DefaultCoreCatalog() *CoreCatalog {
+	return &CoreCatalog{
+		Default: "c1",
+		Types: map[string]CoreType{
+			"c1": {CPUShares: 1024, Memory: 1 << 30, MaxMulticore: 8},
+			"c2": {CPUShares: 1024, Memory: 4 << 30, MaxMulticore: 8},
+		},
+	}
+}
+
+// LoadCoreCatalog reads a CoreCatalog from a JSON file. An empty path selects the
+// DefaultCoreCatalog.
+func LoadCoreCatalog(path string) (*CoreCatalog, error) {
+	if path == "" {
+		return DefaultCoreCatalog(), nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var catalog CoreCatalog
+	if err := json.NewDecoder(f).Decode(&catalog); err != nil {
+		return nil, fmt.Errorf("unable to parse core catalog [%s]: %v", path, err)
+	}
+
+	if _, ok := catalog.Types[catalog.Default]; !ok {
+		return nil, fmt.Errorf("default core type [%s] is not in the core catalog", catalog.Default)
+	}
+	for name, core := range catalog.Types {
+		if core.MaxMulticore < 1 {
+			return nil, fmt.Errorf("core type [%s] must allow at least one core", name)
+		}
+	}
+
+	return &catalog, nil
+}
+
+// Names lists the core types within the catalog in a stable order.
+func (catalog *CoreCatalog) Names() []string {
+	names := make([]string, 0, len(catalog.Types))
+	for name := range catalog.Types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Resolve assigns the default core type and a single core to a job that doesn't request them, and
+// ensures that the requested core type and count are available.
+func (catalog *CoreCatalog) Resolve(job *Job) (CoreType, *APIError) {
+	if job.Core == "" {
+		job.Core = catalog.Default
+	}
+	if job.Multicore == 0 {
+		job.Multicore = 1
+	}
+
+	core, ok := catalog.Types[job.Core]
+	if !ok {
+		return core, &APIError{
+			Code:    CodeInvalidCore,
+			Message: fmt.Sprintf("Invalid core type [%s]", job.Core),
+			Hint:    fmt.Sprintf(`The "core" must be one of the following: %s`, strings.Join(catalog.Names(), ", ")),
+		}
+	}
+
+	if job.Multicore < 1 || job.Multicore > core.MaxMulticore {
+		return core, &APIError{
+			Code:    CodeInvalidCore,
+			Message: fmt.Sprintf("Invalid multicore count [%d] for core type [%s]", job.Multicore, job.Core),
+			Hint:    fmt.Sprintf(`The "multicore" count must be between 1 and %d.`, core.MaxMulticore),
+		}
+	}
+
+	return core, nil
+}
+
+// Constrain applies the resource limits of multicore cores of this type to a container's
+// configuration.
+func (core CoreType) Constrain(config *docker.Config, multicore int) {
+	config.CPUShares = core.CPUShares * int64(multicore)
+	config.CPUSet = core.CPUSet
+	config.Memory = core.Memory * int64(multicore)
+
+	// Swap can only be limited alongside memory.
+	switch {
+	case config.Memory == 0:
+		config.MemorySwap = 0
+	case core.Swap < 0:
+		config.MemorySwap = -1
+	default:
+		config.MemorySwap = config.Memory + core.Swap*int64(multicore)
+	}
+}
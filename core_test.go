@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestResolveCore(t *testing.T) {
+	catalog := DefaultCoreCatalog()
+
+	job := &Job{}
+	core, err := catalog.Resolve(job)
+	if err != nil {
+		t.Fatalf("Unexpected error resolving the default core: %v", err)
+	}
+	if job.Core != "c1" || job.Multicore != 1 {
+		t.Errorf("Expected the job to default to one c1 core, got [%s] x [%d]", job.Core, job.Multicore)
+	}
+	if core.Memory != 1<<30 {
+		t.Errorf("Unexpected core type: %#v", core)
+	}
+
+	for _, invalid := range []Job{
+		{Core: "z9"},
+		{Core: "c2", Multicore: 9},
+		{Core: "c2", Multicore: -1},
+	} {
+		if _, err := catalog.Resolve(&invalid); err == nil || err.Code != CodeInvalidCore {
+			t.Errorf("Expected [%s] x [%d] to be rejected, got [%v]", invalid.Core, invalid.Multicore, err)
+		}
+	}
+}
+
+func TestConstrainCore(t *testing.T) {
+	var config docker.Config
+
+	CoreType{CPUShares: 512, CPUSet: "0-1", Memory: 100, Swap: 50}.Constrain(&config, 3)
+	if config.CPUShares != 1536 || config.CPUSet != "0-1" || config.Memory != 300 || config.MemorySwap != 450 {
+		t.Errorf("Unexpected container limits: %#v", config)
+	}
+
+	CoreType{CPUShares: 1024, Memory: 100, Swap: -1}.Constrain(&config, 1)
+	if config.MemorySwap != -1 || config.CPUSet != "" {
+		t.Errorf("Expected unlimited swap, got %#v", config)
+	}
+
+	CoreType{CPUShares: 1024}.Constrain(&config, 2)
+	if config.Memory != 0 || config.MemorySwap != 0 {
+		t.Errorf("Expected unlimited memory, got %#v", config)
+	}
+}
+
+func TestLoadCoreCatalog(t *testing.T) {
+	write := func(content string) string {
+		f, err := ioutil.TempFile("", "cores")
+		if err != nil {
+			t.Fatalf("Unable to create a temporary file: %v", err)
+		}
+		defer f.Close()
+		f.WriteString(content)
+		return f.Name()
+	}
+
+	valid := write(`{"default": "big", "types": {"big": {"cpu_shares": 2048, "memory": 8589934592, "max_multicore": 2}}}`)
+	defer os.Remove(valid)
+
+	catalog, err := LoadCoreCatalog(valid)
+	if err != nil {
+		t.Fatalf("Unable to load the core catalog: %v", err)
+	}
+	if catalog.Default != "big" || catalog.Types["big"].CPUShares != 2048 || catalog.Types["big"].MaxMulticore != 2 {
+		t.Errorf("Unexpected core catalog: %#v", catalog)
+	}
+
+	for _, content := range []string{
+		`{"default": "missing", "types": {"big": {"max_multicore": 1}}}`,
+		`{"default": "big", "types": {"big": {}}}`,
+		`nope`,
+	} {
+		path := write(content)
+		defer os.Remove(path)
+
+		if _, err := LoadCoreCatalog(path); err == nil {
+			t.Errorf("Expected an error loading the core catalog [%s]", content)
+		}
+	}
+
+	if catalog, err := LoadCoreCatalog(""); err != nil || catalog.Default != "c1" {
+		t.Errorf("Expected the default core catalog, got %#v [%v]", catalog, err)
+	}
+}
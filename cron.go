@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronMacros expands the shorthand expressions that cron traditionally accepts.
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronField describes the range of values, and any names for them, within one field of a cron
+// expression.
+type cronField struct {
+	name     string
+	min, max int
+	names    []string
+}
+
+var (
+	cronMinute     = cronField{name: "minute", min: 0, max: 59}
+	cronHour       = cronField{name: "hour", min: 0, max: 23}
+	cronDayOfMonth = cronField{name: "day of month", min: 1, max: 31}
+	cronMonth      = cronField{name: "month", min: 1, max: 12, names: []string{
+		"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec",
+	}}
+	// Both 0 and 7 mean Sunday.
+	cronDayOfWeek = cronField{name: "day of week", min: 0, max: 7, names: []string{
+		"sun", "mon", "tue", "wed", "thu", "fri", "sat",
+	}}
+)
+
+// CronSpec is a parsed, five-field cron expression: minute, hour, day of month, month and day of
+// week. Each field is a comma-separated list of "*", single values or ranges, any of which may be
+// followed by a "/step". Months and days of the week may also be given by their three-letter names.
+//
+// As in traditional cron, a day matches if it satisfies both the day of month and the day of week,
+// unless neither of them begins with "*", in which case satisfying either one is enough.
+type CronSpec struct {
+	minute, hour, dayOfMonth, month, dayOfWeek uint64
+
+	anyDayOfMonth, anyDayOfWeek bool
+}
+
+// ParseCron parses a cron expression, or one of the "@daily"-style macros.
+func ParseCron(expr string) (*CronSpec, error) {
+	if expanded, ok := cronMacros[strings.ToLower(strings.TrimSpace(expr))]; ok {
+		expr = expanded
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected five fields in cron expression [%s], found %d", expr, len(fields))
+	}
+
+	spec := &CronSpec{
+		anyDayOfMonth: strings.HasPrefix(fields[2], "*"),
+		anyDayOfWeek:  strings.HasPrefix(fields[4], "*"),
+	}
+
+	var err error
+	if spec.minute, err = cronMinute.parse(fields[0]); err != nil {
+		return nil, err
+	}
+	if spec.hour, err = cronHour.parse(fields[1]); err != nil {
+		return nil, err
+	}
+	if spec.dayOfMonth, err = cronDayOfMonth.parse(fields[2]); err != nil {
+		return nil, err
+	}
+	if spec.month, err = cronMonth.parse(fields[3]); err != nil {
+		return nil, err
+	}
+	if spec.dayOfWeek, err = cronDayOfWeek.parse(fields[4]); err != nil {
+		return nil, err
+	}
+
+	// Fold Sunday-as-7 onto Sunday-as-0.
+	if spec.dayOfWeek&(1<<7) != 0 {
+		spec.dayOfWeek |= 1
+	}
+
+	return spec, nil
+}
+
+// parse converts a single field of a cron expression into a bit set of the values that it matches.
+func (field cronField) parse(expr string) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(expr, ",") {
+		rangeExpr, step := part, 1
+		if slash := strings.Index(part, "/"); slash >= 0 {
+			rangeExpr = part[:slash]
+
+			var err error
+			step, err = strconv.Atoi(part[slash+1:])
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in %s [%s]", field.name, part)
+			}
+		}
+
+		var low, high int
+		switch {
+		case rangeExpr == "*":
+			low, high = field.min, field.max
+		case strings.Contains(rangeExpr, "-"):
+			bounds := strings.SplitN(rangeExpr, "-", 2)
+
+			var err error
+			if low, err = field.value(bounds[0]); err != nil {
+				return 0, err
+			}
+			if high, err = field.value(bounds[1]); err != nil {
+				return 0, err
+			}
+			if high < low {
+				return 0, fmt.Errorf("backwards range in %s [%s]", field.name, part)
+			}
+		default:
+			var err error
+			if low, err = field.value(rangeExpr); err != nil {
+				return 0, err
+			}
+
+			// A single value with a step runs through the end of the field's range.
+			high = low
+			if step > 1 {
+				high = field.max
+			}
+		}
+
+		for value := low; value <= high; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+
+	return bits, nil
+}
+
+// value parses a single number or name within a field, checking that it's in range.
+func (field cronField) value(expr string) (int, error) {
+	for i, name := range field.names {
+		if strings.EqualFold(expr, name) {
+			return field.min + i, nil
+		}
+	}
+
+	value, err := strconv.Atoi(expr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s [%s]", field.name, expr)
+	}
+	if value < field.min || value > field.max {
+		return 0, fmt.Errorf("%s [%d] is not between %d and %d", field.name, value, field.min, field.max)
+	}
+	return value, nil
+}
+
+// matchesDay reports whether the spec runs on the day of t.
+func (spec *CronSpec) matchesDay(t time.Time) bool {
+	dayOfMonth := spec.dayOfMonth&(1<<uint(t.Day())) != 0
+	dayOfWeek := spec.dayOfWeek&(1<<uint(t.Weekday())) != 0
+
+	if spec.anyDayOfMonth || spec.anyDayOfWeek {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+// Next returns the first minute strictly after a time that the spec matches, in the time's own
+// location. Its wall clock time is later than the given time's as well, so that the hour repeated
+// when daylight saving time ends doesn't run anything twice. It returns the zero Time if the spec
+// doesn't match any minute within the next five years, as happens with impossible dates like
+// "0 0 30 2 *".
+func (spec *CronSpec) Next(after time.Time) time.Time {
+	loc := after.Location()
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	previous := cronWallClock(after)
+
+	for limit := t.Year() + 5; t.Year() <= limit; {
+		if spec.month&(1<<uint(t.Month())) == 0 {
+			t = cronForward(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc))
+			continue
+		}
+		if !spec.matchesDay(t) {
+			t = cronForward(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc))
+			continue
+		}
+		if spec.hour&(1<<uint(t.Hour())) == 0 {
+			t = cronForward(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc))
+			continue
+		}
+		if spec.minute&(1<<uint(t.Minute())) == 0 || !cronWallClock(t).After(previous) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
+
+// cronWallClock returns the minute that a time's wall clock shows, without its location, so that
+// times on either side of a daylight saving transition can be compared by what the clock read.
+func cronWallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+}
+
+// cronForward makes sure that Next moves forward from t to next. A wall clock time that falls into
+// the gap left by a daylight saving transition may be resolved to a time before the gap, in which
+// case it's pushed past the gap an hour at a time.
+func cronForward(t, next time.Time) time.Time {
+	for !next.After(t) {
+		next = next.Add(time.Hour)
+	}
+	return next
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	valid := []string{
+		"* * * * *",
+		"*/15 9-17 * * mon-fri",
+		"0 0 1,15 * *",
+		"30 4 * jan,jul sun",
+		"5/20 * * * 7",
+		"@daily",
+		"@HOURLY",
+	}
+	for _, expr := range valid {
+		if _, err := ParseCron(expr); err != nil {
+			t.Errorf("Expected [%s] to parse, got [%v]", expr, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * smarch *",
+		"@fortnightly",
+	}
+	for _, expr := range invalid {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("Expected [%s] to be rejected", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("Unable to load a timezone: %v", err)
+	}
+
+	cases := []struct {
+		expr     string
+		after    time.Time
+		expected time.Time
+	}{
+		// Every minute runs on the following minute, even from partway through one.
+		{"* * * * *", time.Date(2015, 6, 1, 4, 0, 30, 0, time.UTC), time.Date(2015, 6, 1, 4, 1, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2015, 6, 1, 4, 15, 0, 0, time.UTC), time.Date(2015, 6, 1, 4, 30, 0, 0, time.UTC)},
+		{"0 0 * * *", time.Date(2015, 12, 31, 23, 59, 0, 0, time.UTC), time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"30 9 * * mon-fri", time.Date(2015, 6, 5, 10, 0, 0, 0, time.UTC), time.Date(2015, 6, 8, 9, 30, 0, 0, time.UTC)},
+		{"0 12 29 2 *", time.Date(2015, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2016, 2, 29, 12, 0, 0, 0, time.UTC)},
+
+		// With both days restricted, either one matches: the 13th, or any Friday.
+		{"0 0 13 * fri", time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC), time.Date(2015, 6, 5, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * fri", time.Date(2015, 6, 12, 0, 0, 0, 0, time.UTC), time.Date(2015, 6, 13, 0, 0, 0, 0, time.UTC)},
+
+		// Times are matched in the location of the time given.
+		{"0 9 * * *", time.Date(2015, 6, 1, 10, 0, 0, 0, newYork), time.Date(2015, 6, 2, 9, 0, 0, 0, newYork)},
+		{"30 2 * * *", time.Date(2015, 3, 8, 0, 0, 0, 0, newYork), time.Date(2015, 3, 9, 2, 30, 0, 0, newYork)},
+
+		// The hour repeated when daylight saving time ends doesn't run anything again.
+		{"30 1 * * *", time.Date(2026, 11, 1, 5, 30, 0, 0, time.UTC).In(newYork), time.Date(2026, 11, 2, 1, 30, 0, 0, newYork)},
+		{"*/15 * * * *", time.Date(2026, 11, 1, 5, 45, 0, 0, time.UTC).In(newYork), time.Date(2026, 11, 1, 7, 0, 0, 0, time.UTC)},
+	}
+
+	for _, c := range cases {
+		spec, err := ParseCron(c.expr)
+		if err != nil {
+			t.Fatalf("Unable to parse [%s]: %v", c.expr, err)
+		}
+		if next := spec.Next(c.after); !next.Equal(c.expected) {
+			t.Errorf("Expected [%s] after [%v] to run at [%v], got [%v]", c.expr, c.after, c.expected, next)
+		}
+	}
+
+	impossible, _ := ParseCron("0 0 30 2 *")
+	if next := impossible.Next(time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)); !next.IsZero() {
+		t.Errorf("Expected an impossible date never to run, got [%v]", next)
+	}
+}
@@ -14,6 +14,9 @@ type Docker interface {
 	CopyFromContainer(docker.CopyFromContainerOptions) error
 	RemoveContainer(docker.RemoveContainerOptions) error
 	KillContainer(docker.KillContainerOptions) error
+	InspectContainer(string) (*docker.Container, error)
+	InspectImage(string) (*docker.Image, error)
+	PullImage(docker.PullImageOptions, docker.AuthConfiguration) error
 }
 
 // NullDocker is an embeddable struct that implements the full Docker interface as no-ops, allowing
@@ -55,5 +58,20 @@ func (n NullDocker) KillContainer(docker.KillContainerOptions) error {
 	return nil
 }
 
+// InspectContainer is a no-op that always returns nil and no error.
+func (n NullDocker) InspectContainer(string) (*docker.Container, error) {
+	return nil, nil
+}
+
+// InspectImage is a no-op that always returns nil and no error, as though every image is present.
+func (n NullDocker) InspectImage(string) (*docker.Image, error) {
+	return nil, nil
+}
+
+// PullImage is a no-op.
+func (n NullDocker) PullImage(docker.PullImageOptions, docker.AuthConfiguration) error {
+	return nil
+}
+
 // Ensure that NullDocker adheres to the Docker interface.
 var _ Docker = NullDocker{}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// errHostsFull is returned by DockerPool.Place when every Docker host that runs a core type is busy.
+var errHostsFull = errors.New("every Docker host for the core type is full")
+
+// DockerEndpoint is one of the Docker daemons that run jobs. It runs at most Capacity jobs at once,
+// of the core types listed in Cores, or of any core type if Cores is empty.
+type DockerEndpoint struct {
+	Docker
+
+	Name     string
+	Capacity int
+	Cores    []string
+
+	running int
+}
+
+// serves reports whether the endpoint runs jobs of a core type.
+func (endpoint *DockerEndpoint) serves(core string) bool {
+	if len(endpoint.Cores) == 0 {
+		return true
+	}
+	for _, name := range endpoint.Cores {
+		if name == core {
+			return true
+		}
+	}
+	return false
+}
+
+// DockerEndpointConfig describes a DockerEndpoint within the JSON file named by the DockerHosts
+// setting. TLS credentials that aren't given default to the CACert, Cert and Key settings.
+type DockerEndpointConfig struct {
+	Name     string   `json:"name"`
+	Host     string   `json:"host"`
+	TLS      bool     `json:"tls"`
+	CACert   string   `json:"ca_cert,omitempty"`
+	Cert     string   `json:"cert,omitempty"`
+	Key      string   `json:"key,omitempty"`
+	Capacity int      `json:"capacity"`
+	Cores    []string `json:"cores,omitempty"`
+}
+
+// DockerPool spreads jobs across several Docker daemons. Each job is placed on a single endpoint,
+// which is recorded as the job's Host so that the job can be found again to be killed or recovered.
+//
+// A nil DockerPool places every job on the Context's own Docker client, with an empty Host.
+type DockerPool struct {
+	sync.Mutex
+
+	endpoints []*DockerEndpoint
+}
+
+// NewDockerPool creates a DockerPool from endpoints that are already connected.
+func NewDockerPool(endpoints ...*DockerEndpoint) *DockerPool {
+	return &DockerPool{endpoints: endpoints}
+}
+
+// LoadDockerPool reads a JSON list of DockerEndpointConfigs from a file and connects to each of
+// the daemons that it describes. Every core type that an endpoint lists must be in the catalog.
+func LoadDockerPool(path string, settings Settings, catalog *CoreCatalog) (*DockerPool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var configs []DockerEndpointConfig
+	if err := json.NewDecoder(f).Decode(&configs); err != nil {
+		return nil, fmt.Errorf("unable to parse Docker hosts [%s]: %v", path, err)
+	}
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no Docker hosts are listed in [%s]", path)
+	}
+
+	pool := NewDockerPool()
+	names := make(map[string]bool)
+	for _, config := range configs {
+		if config.Name == "" || config.Host == "" {
+			return nil, errors.New("every Docker host must have a name and a host")
+		}
+		if names[config.Name] {
+			return nil, fmt.Errorf("Docker host [%s] is listed more than once", config.Name)
+		}
+		names[config.Name] = true
+
+		if config.Capacity < 1 {
+			return nil, fmt.Errorf("Docker host [%s] must have room for at least one job", config.Name)
+		}
+		for _, core := range config.Cores {
+			if _, ok := catalog.Types[core]; !ok {
+				return nil, fmt.Errorf("core type [%s] of Docker host [%s] is not in the core catalog", core, config.Name)
+			}
+		}
+
+		if config.CACert == "" {
+			config.CACert = settings.CACert
+		}
+		if config.Cert == "" {
+			config.Cert = settings.Cert
+		}
+		if config.Key == "" {
+			config.Key = settings.Key
+		}
+
+		client, err := connectToDocker(config)
+		if err != nil {
+			return nil, fmt.Errorf("unable to connect to Docker host [%s]: %v", config.Name, err)
+		}
+
+		pool.endpoints = append(pool.endpoints, &DockerEndpoint{
+			Docker:   client,
+			Name:     config.Name,
+			Capacity: config.Capacity,
+			Cores:    config.Cores,
+		})
+	}
+
+	return pool, nil
+}
+
+// connectToDocker creates a client for the daemon that an endpoint describes.
+func connectToDocker(config DockerEndpointConfig) (Docker, error) {
+	if config.TLS {
+		client, err := docker.NewTLSClient(config.Host, config.Cert, config.Key, config.CACert)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	}
+
+	client, err := docker.NewClient(config.Host)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// Default returns the first endpoint in the pool.
+func (pool *DockerPool) Default() *DockerEndpoint {
+	return pool.endpoints[0]
+}
+
+// Endpoint returns the endpoint with the given name, or nil if there isn't one.
+func (pool *DockerPool) Endpoint(name string) *DockerEndpoint {
+	if pool == nil {
+		return nil
+	}
+
+	for _, endpoint := range pool.endpoints {
+		if endpoint.Name == name {
+			return endpoint
+		}
+	}
+	return nil
+}
+
+// Place reserves room for a job of a core type on the endpoint with the most free slots among those
+// that run it, and returns the endpoint's name. It returns errHostsFull if every such endpoint is
+// busy, and a different error if none of them run the core type at all.
+func (pool *DockerPool) Place(core string) (string, error) {
+	if pool == nil {
+		return "", nil
+	}
+
+	pool.Lock()
+	defer pool.Unlock()
+
+	var best *DockerEndpoint
+	served := false
+	for _, endpoint := range pool.endpoints {
+		if !endpoint.serves(core) {
+			continue
+		}
+		served = true
+
+		free := endpoint.Capacity - endpoint.running
+		if free > 0 && (best == nil || free > best.Capacity-best.running) {
+			best = endpoint
+		}
+	}
+
+	if !served {
+		return "", fmt.Errorf("no Docker host runs core type [%s]", core)
+	}
+	if best == nil {
+		return "", errHostsFull
+	}
+
+	best.running++
+	return best.Name, nil
+}
+
+// FullCores lists the core types, among names and those that endpoints list, that some endpoint
+// runs but none has room for. A nil pool is never full.
+func (pool *DockerPool) FullCores(names []string) []string {
+	if pool == nil {
+		return nil
+	}
+
+	pool.Lock()
+	defer pool.Unlock()
+
+	var full []string
+	seen := make(map[string]bool)
+	check := func(core string) {
+		if seen[core] {
+			return
+		}
+		seen[core] = true
+
+		served := false
+		for _, endpoint := range pool.endpoints {
+			if !endpoint.serves(core) {
+				continue
+			}
+			if endpoint.running < endpoint.Capacity {
+				return
+			}
+			served = true
+		}
+		if served {
+			full = append(full, core)
+		}
+	}
+
+	for _, core := range names {
+		check(core)
+	}
+	for _, endpoint := range pool.endpoints {
+		for _, core := range endpoint.Cores {
+			check(core)
+		}
+	}
+	return full
+}
+
+// Occupy takes up room on an endpoint for a job that's already running there, even if the endpoint
+// is full.
+func (pool *DockerPool) Occupy(name string) {
+	if pool == nil {
+		return
+	}
+
+	pool.Lock()
+	defer pool.Unlock()
+
+	if endpoint := pool.Endpoint(name); endpoint != nil {
+		endpoint.running++
+	}
+}
+
+// Release frees the room taken up on an endpoint by a job that's finished.
+func (pool *DockerPool) Release(name string) {
+	if pool == nil {
+		return
+	}
+
+	pool.Lock()
+	defer pool.Unlock()
+
+	if endpoint := pool.Endpoint(name); endpoint != nil {
+		endpoint.running--
+	}
+}
+
+// HostStats summarizes the occupancy of a single DockerEndpoint.
+type HostStats struct {
+	Name     string   `json:"name"`
+	Capacity int      `json:"capacity"`
+	Busy     int      `json:"busy"`
+	Cores    []string `json:"cores,omitempty"`
+}
+
+// Stats reports the occupancy of each endpoint in the pool.
+func (pool *DockerPool) Stats() []HostStats {
+	if pool == nil {
+		return nil
+	}
+
+	pool.Lock()
+	defer pool.Unlock()
+
+	stats := make([]HostStats, 0, len(pool.endpoints))
+	for _, endpoint := range pool.endpoints {
+		stats = append(stats, HostStats{
+			Name:     endpoint.Name,
+			Capacity: endpoint.Capacity,
+			Busy:     endpoint.running,
+			Cores:    endpoint.Cores,
+		})
+	}
+	return stats
+}
+
+// DockerFor returns the client for the Docker host that a job was placed on. Jobs that weren't
+// placed on a host, or whose host is no longer configured, use the Context's own Docker client.
+func (c *Context) DockerFor(host string) Docker {
+	if endpoint := c.Hosts.Endpoint(host); endpoint != nil {
+		return endpoint
+	}
+
+	if host != "" {
+		log.WithFields(log.Fields{"host": host}).Warn("Unknown Docker host. Using the default.")
+	}
+	return c.Docker
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// KillRecordingDocker is a fake Docker implementation that remembers which containers it was asked
+// to kill.
+type KillRecordingDocker struct {
+	NullDocker
+
+	Killed []string
+}
+
+func (d *KillRecordingDocker) KillContainer(opts docker.KillContainerOptions) error {
+	d.Killed = append(d.Killed, opts.ID)
+	return nil
+}
+
+// awaitCompletion waits for each of the jobs to reach a completed status, and returns them.
+func awaitCompletion(t *testing.T, s Storage, jids ...uint64) []SubmittedJob {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		jobs, err := s.ListJobs(JobQuery{JIDs: jids})
+		if err != nil {
+			t.Fatalf("Unable to list jobs: %v", err)
+		}
+
+		done := len(jobs) == len(jids)
+		for _, job := range jobs {
+			done = done && completedStatus[job.Status]
+		}
+		if done {
+			return jobs
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for jobs %v to complete", jids)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPlaceJobsAcrossHosts(t *testing.T) {
+	pool := NewDockerPool(
+		&DockerEndpoint{Name: "east", Capacity: 2, Cores: []string{"c1"}},
+		&DockerEndpoint{Name: "west", Capacity: 1},
+	)
+
+	expect := func(core, expected string) {
+		host, err := pool.Place(core)
+		if err != nil || host != expected {
+			t.Errorf("Expected a [%s] job to be placed on [%s], got [%s] and [%v]", core, expected, host, err)
+		}
+	}
+
+	// The host with the most free slots is chosen, in order of appearance when they're tied.
+	expect("c1", "east")
+	expect("c1", "east")
+	expect("c1", "west")
+
+	if _, err := pool.Place("c1"); err != errHostsFull {
+		t.Errorf("Expected every host to be full, got [%v]", err)
+	}
+	if _, err := pool.Place("c2"); err != errHostsFull {
+		t.Errorf("Expected every host for c2 to be full, got [%v]", err)
+	}
+
+	pool.Release("west")
+	expect("c2", "west")
+
+	pool.Release("east")
+	pool.Occupy("east")
+	stats := pool.Stats()
+	if len(stats) != 2 || stats[0].Busy != 2 || stats[1].Busy != 1 {
+		t.Errorf("Unexpected host stats: %#v", stats)
+	}
+
+	picky := NewDockerPool(&DockerEndpoint{Name: "gpu", Capacity: 1, Cores: []string{"c2"}})
+	if _, err := picky.Place("c1"); err == nil || err == errHostsFull {
+		t.Errorf("Expected no host to run c1 jobs, got [%v]", err)
+	}
+
+	var none *DockerPool
+	if host, err := none.Place("c1"); host != "" || err != nil {
+		t.Errorf("Expected a nil pool to place jobs on the default client, got [%s] and [%v]", host, err)
+	}
+}
+
+func TestLoadDockerPool(t *testing.T) {
+	load := func(config string) (*DockerPool, error) {
+		f, err := ioutil.TempFile("", "docker-hosts")
+		if err != nil {
+			t.Fatalf("Unable to create a temporary file: %v", err)
+		}
+		defer os.Remove(f.Name())
+
+		f.WriteString(config)
+		f.Close()
+
+		return LoadDockerPool(f.Name(), Settings{CACert: "ca.pem", Cert: "cert.pem", Key: "key.pem"}, DefaultCoreCatalog())
+	}
+
+	pool, err := load(`[
+		{"name": "east", "host": "tcp://10.0.0.1:2376", "tls": true, "capacity": 4, "cores": ["c1"]},
+		{"name": "west", "host": "tcp://10.0.0.2:2375", "capacity": 2}
+	]`)
+	if err != nil {
+		t.Fatalf("Unable to load Docker hosts: %v", err)
+	}
+
+	stats := pool.Stats()
+	if len(stats) != 2 {
+		t.Fatalf("Expected two hosts, got %#v", stats)
+	}
+	if stats[0].Name != "east" || stats[0].Capacity != 4 || len(stats[0].Cores) != 1 || stats[0].Cores[0] != "c1" {
+		t.Errorf("Unexpected first host: %#v", stats[0])
+	}
+	if stats[1].Name != "west" || stats[1].Capacity != 2 || len(stats[1].Cores) != 0 {
+		t.Errorf("Unexpected second host: %#v", stats[1])
+	}
+	if pool.Default().Name != "east" {
+		t.Errorf("Expected the first host to be the default, got [%s]", pool.Default().Name)
+	}
+
+	invalid := map[string]string{
+		"empty":          `[]`,
+		"unnamed":        `[{"host": "tcp://10.0.0.1:2375", "capacity": 1}]`,
+		"duplicate":      `[{"name": "a", "host": "tcp://h:2375", "capacity": 1}, {"name": "a", "host": "tcp://h:2375", "capacity": 1}]`,
+		"no capacity":    `[{"name": "a", "host": "tcp://h:2375"}]`,
+		"unknown core":   `[{"name": "a", "host": "tcp://h:2375", "capacity": 1, "cores": ["z9"]}]`,
+		"malformed json": `{`,
+	}
+	for name, config := range invalid {
+		if _, err := load(config); err == nil {
+			t.Errorf("Expected the %s config to be rejected", name)
+		}
+	}
+}
+
+func TestRunJobsAcrossHostsInMemory(t *testing.T) {
+	s := NewMemoryStorage()
+	east := &ScriptedDocker{Stdout: "east\n"}
+	west := &ScriptedDocker{Stdout: "west\n"}
+	c := &Context{
+		Storage:   s,
+		Docker:    NullDocker{},
+		Results:   NewMemoryBlobStore(),
+		Cores:     DefaultCoreCatalog(),
+		Pool:      NewWorkerPool(4),
+		Scheduler: FIFOScheduler{},
+		Hosts: NewDockerPool(
+			&DockerEndpoint{Docker: east, Name: "east", Capacity: 1, Cores: []string{"c1"}},
+			&DockerEndpoint{Docker: west, Name: "west", Capacity: 1, Cores: []string{"c2"}},
+		),
+	}
+
+	jids := insertJobs(t, s,
+		SubmittedJob{
+			Job:     Job{Command: "true", Core: "c2", ResultSource: "stdout", ResultType: ResultBinary},
+			Account: "admin",
+			Status:  StatusQueued,
+		},
+		SubmittedJob{
+			Job:     Job{Command: "true", Core: "c1", ResultSource: "stdout", ResultType: ResultBinary},
+			Account: "admin",
+			Status:  StatusQueued,
+		},
+		SubmittedJob{
+			Job:     Job{Command: "true", Core: "c2", ResultSource: "stdout", ResultType: ResultBinary},
+			Account: "admin",
+			Status:  StatusQueued,
+		},
+	)
+
+	// The third job doesn't fit until the first has finished, so it stays in the queue.
+	Claim(c)
+	Claim(c)
+	Claim(c)
+	expectJIDs(t, s, JobQuery{Statuses: []string{StatusQueued}}, jids[2])
+
+	jobs := awaitCompletion(t, s, jids[0], jids[1])
+	for i, expected := range []string{"west", "east"} {
+		if jobs[i].Host != expected || jobs[i].Stdout != expected+"\n" {
+			t.Errorf("Expected job [%d] to run on [%s], got [%s] with stdout [%s]", jobs[i].JID, expected, jobs[i].Host, jobs[i].Stdout)
+		}
+	}
+
+	// Wait for the hosts to be released.
+	deadline := time.Now().Add(5 * time.Second)
+	for len(c.Pool.Stats().Running) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the worker pool to empty")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	Claim(c)
+	jobs = awaitCompletion(t, s, jids[2])
+	if jobs[0].Host != "west" || jobs[0].Status != StatusDone {
+		t.Errorf("Expected the waiting job to run on [west], got [%s] and [%s]", jobs[0].Host, jobs[0].Status)
+	}
+}
+
+func TestFullCores(t *testing.T) {
+	pool := NewDockerPool(
+		&DockerEndpoint{Name: "east", Capacity: 1, Cores: []string{"c1"}},
+		&DockerEndpoint{Name: "west", Capacity: 1, Cores: []string{"c1", "c2"}},
+	)
+	if full := pool.FullCores([]string{"c1", "c2", "c3"}); len(full) != 0 {
+		t.Errorf("Expected no core types to be full, got %v", full)
+	}
+
+	pool.Occupy("west")
+	if full := pool.FullCores([]string{"c1", "c2", "c3"}); !reflect.DeepEqual(full, []string{"c2"}) {
+		t.Errorf("Expected c2 to be full, got %v", full)
+	}
+
+	pool.Occupy("east")
+	if full := pool.FullCores(nil); !reflect.DeepEqual(full, []string{"c1", "c2"}) {
+		t.Errorf("Expected c1 and c2 to be full, got %v", full)
+	}
+
+	anything := NewDockerPool(&DockerEndpoint{Name: "any", Capacity: 1})
+	anything.Occupy("any")
+	if full := anything.FullCores([]string{"c1", "c2"}); !reflect.DeepEqual(full, []string{"c1", "c2"}) {
+		t.Errorf("Expected every cataloged core type to be full, got %v", full)
+	}
+
+	var none *DockerPool
+	if full := none.FullCores([]string{"c1"}); full != nil {
+		t.Errorf("Expected a nil pool never to be full, got %v", full)
+	}
+}
+
+func TestFullCoreDoesNotBlockQueueInMemory(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{
+		Storage:   s,
+		Docker:    NullDocker{},
+		Results:   NewMemoryBlobStore(),
+		Cores:     DefaultCoreCatalog(),
+		Pool:      NewWorkerPool(4),
+		Scheduler: FIFOScheduler{},
+		Hosts: NewDockerPool(
+			&DockerEndpoint{Docker: &ScriptedDocker{}, Name: "east", Capacity: 1, Cores: []string{"c1"}},
+			&DockerEndpoint{Docker: &ScriptedDocker{}, Name: "west", Capacity: 1, Cores: []string{"c2"}},
+		),
+	}
+	c.Hosts.Occupy("west")
+
+	jids := insertJobs(t, s,
+		SubmittedJob{
+			Job:     Job{Command: "true", Core: "c2", ResultSource: "stdout", ResultType: ResultBinary},
+			Account: "admin",
+			Status:  StatusQueued,
+		},
+		SubmittedJob{
+			Job:     Job{Command: "true", ResultSource: "stdout", ResultType: ResultBinary},
+			Account: "admin",
+			Status:  StatusQueued,
+		},
+	)
+	revision, err := s.QueueRevision()
+	if err != nil {
+		t.Fatalf("Unable to read the queue revision: %v", err)
+	}
+
+	// The c2 job is passed over without being claimed, so the job behind it runs.
+	if !Claim(c) {
+		t.Fatal("Expected to claim the job for the default core type")
+	}
+	if Claim(c) {
+		t.Error("Expected nothing else to be claimed while west is full")
+	}
+	awaitCompletion(t, s, jids[1])
+	expectJIDs(t, s, JobQuery{Statuses: []string{StatusQueued}}, jids[0])
+
+	if after, _ := s.QueueRevision(); after != revision {
+		t.Errorf("Expected the queue revision to stay at [%d], got [%d]", revision, after)
+	}
+}
+
+func TestClaimJobForUnservedCoreInMemory(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{
+		Storage:   s,
+		Docker:    NullDocker{},
+		Cores:     DefaultCoreCatalog(),
+		Pool:      NewWorkerPool(1),
+		Scheduler: FIFOScheduler{},
+		Hosts:     NewDockerPool(&DockerEndpoint{Docker: &ScriptedDocker{}, Name: "east", Capacity: 1, Cores: []string{"c1"}}),
+	}
+
+	jids := insertJobs(t, s, SubmittedJob{
+		Job:     Job{Command: "true", Core: "c2", ResultSource: "stdout", ResultType: ResultBinary},
+		Account: "admin",
+		Status:  StatusQueued,
+	})
+
+	Claim(c)
+
+	expectJIDs(t, s, JobQuery{Statuses: []string{StatusError}}, jids[0])
+	if stats := c.Pool.Stats(); stats.Busy != 0 {
+		t.Errorf("Expected the worker slot to be released, got %#v", stats)
+	}
+}
+
+func TestKillJobOnItsHost(t *testing.T) {
+	s := NewMemoryStorage()
+	east, west := &KillRecordingDocker{}, &KillRecordingDocker{}
+	c := &Context{
+		Settings: Settings{AdminName: "admin", AdminKey: "12345"},
+		Storage:  s,
+		Docker:   east,
+		Hosts: NewDockerPool(
+			&DockerEndpoint{Docker: east, Name: "east", Capacity: 1},
+			&DockerEndpoint{Docker: west, Name: "west", Capacity: 1},
+		),
+	}
+
+	insertJobs(t, s, SubmittedJob{
+		Account:     "admin",
+		Status:      StatusProcessing,
+		Host:        "west",
+		ContainerID: "c0ffee",
+	})
+
+	r, err := http.NewRequest("POST", "https://localhost/v1/jobs/kill", strings.NewReader("jid=1"))
+	if err != nil {
+		t.Fatalf("Unable to create request: %v", err)
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth("admin", "12345")
+	w := httptest.NewRecorder()
+
+	JobKillHandler(c, w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Unexpected HTTP status: [%d]", w.Code)
+	}
+	if len(west.Killed) != 1 || west.Killed[0] != "c0ffee" {
+		t.Errorf("Expected the container to be killed on its host, got %v", west.Killed)
+	}
+	if len(east.Killed) != 0 {
+		t.Errorf("Expected no containers to be killed on another host, got %v", east.Killed)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// ImagePull reports on the pull of an image that a job needed before it could run.
+type ImagePull struct {
+	Image      string     `json:"image" bson:"image"`
+	Progress   string     `json:"progress,omitempty" bson:"progress,omitempty"`
+	StartedAt  StoredTime `json:"started_at" bson:"started_at"`
+	FinishedAt StoredTime `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+	Error      string     `json:"error,omitempty" bson:"error,omitempty"`
+}
+
+// pullReportInterval is how often the progress of an image pull is saved to its job.
+const pullReportInterval = time.Second
+
+// parseImageName splits an image name like "localhost:5000/cloudpipe/runner:2.7" into the
+// repository and tag that Docker pulls. Images without a tag use "latest", since Docker would
+// otherwise pull every tag of the repository.
+func parseImageName(image string) (repository, tag string) {
+	if at := strings.Index(image, "@"); at != -1 {
+		return image[:at], image[at+1:]
+	}
+
+	// A colon before the last slash separates a registry host from its port.
+	if colon := strings.LastIndex(image, ":"); colon > strings.LastIndex(image, "/") {
+		return image[:colon], image[colon+1:]
+	}
+	return image, "latest"
+}
+
+// pullProgress is an io.Writer that consumes the newline-delimited JSON messages that Docker
+// streams during an image pull and remembers the most recent one. The pull writes to it while the
+// job runner reads from it.
+type pullProgress struct {
+	sync.Mutex
+
+	partial []byte
+	latest  string
+	changed bool
+}
+
+// Write decodes each complete message.
+func (p *pullProgress) Write(b []byte) (int, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.partial = append(p.partial, b...)
+	for {
+		i := bytes.IndexByte(p.partial, '\n')
+		if i == -1 {
+			break
+		}
+		line := bytes.TrimSpace(p.partial[:i])
+		p.partial = p.partial[i+1:]
+
+		var message struct {
+			ID       string `json:"id"`
+			Status   string `json:"status"`
+			Progress string `json:"progress"`
+			Error    string `json:"error"`
+		}
+		if len(line) == 0 || json.Unmarshal(line, &message) != nil {
+			continue
+		}
+
+		var parts []string
+		if message.ID != "" {
+			parts = append(parts, message.ID+":")
+		}
+		for _, part := range []string{message.Status, message.Progress, message.Error} {
+			if part != "" {
+				parts = append(parts, part)
+			}
+		}
+		p.latest = strings.Join(parts, " ")
+		p.changed = true
+	}
+
+	return len(b), nil
+}
+
+// take returns the most recent message, and whether any messages have arrived since the last call.
+func (p *pullProgress) take() (string, bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	changed := p.changed
+	p.changed = false
+	return p.latest, changed
+}
+
+// ensureImage pulls an image that isn't present locally, reporting the pull's progress on the job.
+// It returns false if the pull fails or hangs, in which case the job has stalled.
+func (e *execution) ensureImage(image string) bool {
+	job := e.job
+
+	_, err := e.docker.InspectImage(image)
+	if err != docker.ErrNoSuchImage {
+		// Any other error will resurface when the container is created.
+		e.checkErr("Inspected the job's image", err)
+		return true
+	}
+
+	log.WithFields(e.fields).WithField("image", image).Info("Pulling the job's image.")
+
+	job.ImagePull = &ImagePull{Image: image, StartedAt: StoreTime(time.Now())}
+	e.updateJob("image pull")
+
+	err = e.pullImage(image)
+	job.ImagePull.FinishedAt = StoreTime(time.Now())
+	if err != nil {
+		e.stall(err)
+		return false
+	}
+
+	e.updateJob("image pull")
+	return true
+}
+
+// pullImage pulls an image, saving its progress on the job as it arrives. The pull fails if it
+// goes PullTimeout seconds without making progress. Docker can't cancel a pull that hangs, so it's
+// left to finish or fail in the background.
+func (e *execution) pullImage(image string) error {
+	c, job := e.c, e.job
+
+	progress := &pullProgress{}
+	repository, tag := parseImageName(image)
+
+	pulled := make(chan error, 1)
+	go func() {
+		pulled <- e.docker.PullImage(docker.PullImageOptions{
+			Repository:    repository,
+			Tag:           tag,
+			OutputStream:  progress,
+			RawJSONStream: true,
+		}, docker.AuthConfiguration{})
+	}()
+
+	timeout := time.Duration(c.PullTimeout) * time.Second
+	ticker := time.NewTicker(pullReportInterval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case err := <-pulled:
+			if latest, changed := progress.take(); changed {
+				job.ImagePull.Progress = latest
+			}
+			return err
+		case now := <-ticker.C:
+			latest, changed := progress.take()
+			if changed {
+				last = now
+				job.ImagePull.Progress = latest
+				e.updateJob("image pull progress")
+			} else if timeout > 0 && now.Sub(last) >= timeout {
+				return fmt.Errorf("no progress in %v", timeout)
+			}
+		}
+	}
+}
+
+// stall gives up on a job whose image couldn't be pulled.
+func (e *execution) stall(cause error) {
+	job := e.job
+
+	now := time.Now()
+	e.attempt.FinishedAt = StoreTime(now)
+	e.attempt.Error = cause.Error()
+	job.Attempts = append(job.Attempts, e.attempt)
+
+	job.ImagePull.Error = cause.Error()
+	fmt.Fprintf(e.stderr, "Unable to pull the image [%s]: %v\n", job.ImagePull.Image, cause)
+
+	job.Status = StatusStalled
+	job.FinishedAt = StoreTime(now)
+	e.updateJob("status")
+
+	log.WithFields(e.fields).WithFields(log.Fields{
+		"image": job.ImagePull.Image,
+		"error": cause,
+	}).Warn("Job stalled pulling its image.")
+}
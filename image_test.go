@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// RegistryDocker is a ScriptedDocker backed by a stand-in image registry. Containers can only be
+// created from images that are present locally, and images are only present once they've been
+// pulled from the registry.
+type RegistryDocker struct {
+	*ScriptedDocker
+	sync.Mutex
+
+	// Registry maps each image that can be pulled to the progress messages of its pull.
+	Registry map[string][]string
+
+	// Pulls of the Hang image stall until Hang is closed.
+	HangImage string
+	Hang      chan struct{}
+
+	local map[string]bool
+}
+
+func NewRegistryDocker(scripted *ScriptedDocker) *RegistryDocker {
+	return &RegistryDocker{
+		ScriptedDocker: scripted,
+		Registry:       make(map[string][]string),
+		Hang:           make(chan struct{}),
+		local:          make(map[string]bool),
+	}
+}
+
+// imageKey normalizes an image name to its repository and tag.
+func imageKey(image string) string {
+	repository, tag := parseImageName(image)
+	return repository + ":" + tag
+}
+
+func (d *RegistryDocker) InspectImage(name string) (*docker.Image, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	if !d.local[imageKey(name)] {
+		return nil, docker.ErrNoSuchImage
+	}
+	return &docker.Image{ID: imageKey(name)}, nil
+}
+
+func (d *RegistryDocker) PullImage(opts docker.PullImageOptions, auth docker.AuthConfiguration) error {
+	key := opts.Repository + ":" + opts.Tag
+	encoder := json.NewEncoder(opts.OutputStream)
+
+	messages, ok := d.Registry[key]
+	if !ok {
+		message := fmt.Sprintf("image %s not found", key)
+		encoder.Encode(map[string]string{"error": message})
+		return fmt.Errorf("Error: %s", message)
+	}
+
+	for _, message := range messages {
+		encoder.Encode(map[string]string{"id": opts.Tag, "status": message})
+	}
+	if key == d.HangImage {
+		<-d.Hang
+	}
+
+	d.Lock()
+	d.local[key] = true
+	d.Unlock()
+	return nil
+}
+
+func (d *RegistryDocker) CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error) {
+	if _, err := d.InspectImage(opts.Config.Image); err != nil {
+		return nil, err
+	}
+	return d.ScriptedDocker.CreateContainer(opts)
+}
+
+func TestParseImageName(t *testing.T) {
+	cases := []struct {
+		image, repository, tag string
+	}{
+		{"cloudpipe/runner-py2", "cloudpipe/runner-py2", "latest"},
+		{"cloudpipe/runner-py2:2.7", "cloudpipe/runner-py2", "2.7"},
+		{"localhost:5000/runner", "localhost:5000/runner", "latest"},
+		{"localhost:5000/runner:edge", "localhost:5000/runner", "edge"},
+		{"runner@sha256:abc123", "runner", "sha256:abc123"},
+	}
+
+	for _, c := range cases {
+		if repository, tag := parseImageName(c.image); repository != c.repository || tag != c.tag {
+			t.Errorf("Expected [%s] to parse as [%s] [%s], got [%s] [%s]", c.image, c.repository, c.tag, repository, tag)
+		}
+	}
+}
+
+func TestPullProgress(t *testing.T) {
+	p := &pullProgress{}
+
+	if _, changed := p.take(); changed {
+		t.Error("Expected no progress before any messages")
+	}
+
+	p.Write([]byte(`{"status":"Pulling fs layer","id":"abc"}` + "\r\n" + `{"status":"Downl`))
+	if latest, changed := p.take(); !changed || latest != "abc: Pulling fs layer" {
+		t.Errorf("Unexpected progress: [%s] [%v]", latest, changed)
+	}
+
+	p.Write([]byte(`oading","progress":"[=>   ] 1 MB/10 MB","id":"abc"}` + "\n"))
+	if latest, changed := p.take(); !changed || latest != "abc: Downloading [=>   ] 1 MB/10 MB" {
+		t.Errorf("Unexpected progress: [%s] [%v]", latest, changed)
+	}
+
+	if _, changed := p.take(); changed {
+		t.Error("Expected no progress without new messages")
+	}
+}
+
+// runWithRegistry runs a job using an image that must be pulled from a stand-in registry.
+func runWithRegistry(t *testing.T, d *RegistryDocker, image string) SubmittedJob {
+	s := NewMemoryStorage()
+	c := &Context{
+		Settings: Settings{DefaultImage: "cloudpipe/runner-py2", PullTimeout: 1},
+		Storage:  s,
+		Docker:   d,
+		Results:  NewMemoryBlobStore(),
+		Cores:    DefaultCoreCatalog(),
+	}
+
+	jids := insertJobs(t, s, SubmittedJob{
+		Job: Job{
+			Command:      "echo hello",
+			Layers:       []JobLayer{{Name: image}},
+			ResultSource: "stdout",
+			ResultType:   ResultBinary,
+		},
+		Account: "admin",
+		Status:  StatusQueued,
+	})
+
+	job, err := s.ClaimJob(Lease{}, nil)
+	if err != nil || job == nil {
+		t.Fatalf("Unable to claim a job: %v", err)
+	}
+	Execute(c, job)
+
+	jobs, err := s.ListJobs(JobQuery{JIDs: jids})
+	if err != nil {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+	return jobs[0]
+}
+
+func TestPullMissingImageInMemory(t *testing.T) {
+	d := NewRegistryDocker(&ScriptedDocker{Stdout: "hello\n"})
+	d.Registry["localhost:5000/runner:2.7"] = []string{"Pulling fs layer", "Download complete"}
+
+	job := runWithRegistry(t, d, "localhost:5000/runner:2.7")
+
+	if job.Status != StatusDone {
+		t.Errorf("Expected the job to be done, was [%s] with stderr [%s]", job.Status, job.Stderr)
+	}
+	if d.Created.Config == nil || d.Created.Config.Image != "localhost:5000/runner:2.7" {
+		t.Errorf("Expected a container to be created from the pulled image, got %#v", d.Created)
+	}
+
+	pull := job.ImagePull
+	if pull == nil {
+		t.Fatal("Expected the image pull to be reported on the job")
+	}
+	if pull.Image != "localhost:5000/runner:2.7" || pull.Progress != "2.7: Download complete" || pull.Error != "" {
+		t.Errorf("Unexpected image pull report: %#v", pull)
+	}
+	if pull.StartedAt == 0 || pull.FinishedAt < pull.StartedAt {
+		t.Errorf("Expected the image pull to be timed, got %#v", pull)
+	}
+
+	// Images that are already present aren't pulled again.
+	job = runWithRegistry(t, d, "localhost:5000/runner:2.7")
+	if job.Status != StatusDone || job.ImagePull != nil {
+		t.Errorf("Expected the job to run without a pull, got [%s] and %#v", job.Status, job.ImagePull)
+	}
+}
+
+func TestStallOnFailedPullInMemory(t *testing.T) {
+	d := NewRegistryDocker(&ScriptedDocker{})
+
+	job := runWithRegistry(t, d, "localhost:5000/missing")
+
+	if job.Status != StatusStalled {
+		t.Errorf("Expected the job to stall, was [%s]", job.Status)
+	}
+	if job.ImagePull == nil || !strings.Contains(job.ImagePull.Error, "not found") {
+		t.Errorf("Expected the pull's failure to be reported, got %#v", job.ImagePull)
+	}
+	if !strings.Contains(job.Stderr, "Unable to pull the image [localhost:5000/missing]") {
+		t.Errorf("Expected stderr to explain the stall, got [%s]", job.Stderr)
+	}
+	if len(job.Attempts) != 1 || job.FinishedAt == 0 {
+		t.Errorf("Expected the stalled attempt to be recorded, got %#v", job.Attempts)
+	}
+	if d.Created.Config != nil {
+		t.Errorf("Expected no container to be created, got %#v", d.Created)
+	}
+}
+
+func TestStallOnHungPullInMemory(t *testing.T) {
+	d := NewRegistryDocker(&ScriptedDocker{})
+	d.Registry["localhost:5000/slow:latest"] = []string{"Pulling fs layer"}
+	d.HangImage = "localhost:5000/slow:latest"
+	defer close(d.Hang)
+
+	start := time.Now()
+	job := runWithRegistry(t, d, "localhost:5000/slow")
+
+	if job.Status != StatusStalled {
+		t.Errorf("Expected the job to stall, was [%s]", job.Status)
+	}
+	if job.ImagePull == nil || !strings.Contains(job.ImagePull.Error, "no progress") {
+		t.Errorf("Expected the hung pull to be reported, got %#v", job.ImagePull)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the hung pull to time out promptly, took %v", elapsed)
+	}
+}
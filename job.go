@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -26,6 +28,9 @@ const (
 	// StatusWaiting indicates that a job has been submitted, but has not yet entered the queue.
 	StatusWaiting = "waiting"
 
+	// StatusScheduled indicates that a job has been submitted with a RunAt time that hasn't come yet.
+	StatusScheduled = "scheduled"
+
 	// StatusQueued indicates that a job has been placed into the execution queue.
 	StatusQueued = "queued"
 
@@ -42,8 +47,18 @@ const (
 	// StatusKilled indicates that the user requested that the job be terminated.
 	StatusKilled = "killed"
 
-	// StatusStalled indicates that the job has gotten stuck (usually fetching dependencies).
+	// StatusStalled indicates that the job has gotten stuck (usually fetching dependencies, like an
+	// image that couldn't be pulled).
 	StatusStalled = "stalled"
+
+	// StatusTimedOut indicates that the job was killed for exceeding its maximum runtime.
+	StatusTimedOut = "timed_out"
+
+	// StreamStdout identifies output that a job wrote to stdout.
+	StreamStdout = "stdout"
+
+	// StreamStderr identifies output that a job wrote to stderr.
+	StreamStderr = "stderr"
 )
 
 var (
@@ -51,19 +66,22 @@ var (
 
 	validStatus = map[string]bool{
 		StatusWaiting:    true,
+		StatusScheduled:  true,
 		StatusQueued:     true,
 		StatusProcessing: true,
 		StatusDone:       true,
 		StatusError:      true,
 		StatusKilled:     true,
 		StatusStalled:    true,
+		StatusTimedOut:   true,
 	}
 
 	completedStatus = map[string]bool{
-		StatusDone:    true,
-		StatusError:   true,
-		StatusKilled:  true,
-		StatusStalled: true,
+		StatusDone:     true,
+		StatusError:    true,
+		StatusKilled:   true,
+		StatusStalled:  true,
+		StatusTimedOut: true,
 	}
 )
 
@@ -89,10 +107,44 @@ type Job struct {
 	ResultSource string            `json:"result_source" bson:"result_source"`
 	ResultType   string            `json:"result_type" bson:"result_type"`
 	MaxRuntime   int               `json:"max_runtime" bson:"max_runtime"`
+	Priority     int               `json:"priority" bson:"priority"`
 	Stdin        []byte            `json:"stdin" bson:"stdin"`
 
-	Profile   *bool   `json:"profile,omitempty" bson:"profile,omitempty"`
+	Profile *bool `json:"profile,omitempty" bson:"profile,omitempty"`
+
+	// DependsOn holds the JID of a job that must finish successfully before this one is queued.
 	DependsOn *string `json:"depends_on,omitempty" bson:"depends_on,omitempty"`
+
+	// RunAt holds the job in StatusScheduled until the given time.
+	RunAt StoredTime `json:"run_at,omitempty" bson:"run_at,omitempty"`
+}
+
+// Env formats the job's environment as the KEY=value pairs that Docker expects, sorted by key.
+func (j Job) Env() []string {
+	if len(j.Environment) == 0 {
+		return nil
+	}
+
+	env := make([]string, 0, len(j.Environment))
+	for key, value := range j.Environment {
+		env = append(env, key+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// Dependency parses the JID of the job that this job depends on. ok is false if the job has no
+// dependency or it isn't a valid JID.
+func (j Job) Dependency() (jid uint64, ok bool) {
+	if j.DependsOn == nil {
+		return 0, false
+	}
+
+	jid, err := strconv.ParseUint(*j.DependsOn, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return jid, true
 }
 
 // Validate ensures that all required fields have non-zero values, and that enum-like fields have
@@ -130,9 +182,26 @@ func (j Job) Validate() *APIError {
 		}
 	}
 
+	// DependsOn
+	if _, ok := j.Dependency(); j.DependsOn != nil && !ok {
+		return &APIError{
+			Code:    CodeInvalidDependency,
+			Message: fmt.Sprintf("Invalid dependency [%s]", *j.DependsOn),
+			Hint:    `The "depends_on" element must be the JID of a job you've already submitted.`,
+		}
+	}
+
 	return nil
 }
 
+// JobAttempt records a single attempt at running a job. Error describes the Docker failure that cut
+// the attempt short, if any.
+type JobAttempt struct {
+	StartedAt  StoredTime `json:"started_at" bson:"started_at"`
+	FinishedAt StoredTime `json:"finished_at" bson:"finished_at"`
+	Error      string     `json:"error,omitempty" bson:"error,omitempty"`
+}
+
 // SubmittedJob is a Job that has already been submitted.
 type SubmittedJob struct {
 	Job
@@ -142,16 +211,47 @@ type SubmittedJob struct {
 	FinishedAt StoredTime `json:"finished_at,omitempty" bson:"finished_at"`
 
 	Status        string `json:"status" bson:"status"`
-	Result        []byte `json:"result" bson:"result"`
+	Result        []byte `json:"result" bson:"result,omitempty"`
 	ReturnCode    string `json:"return_code" bson:"return_code"`
 	Runtime       int64  `json:"runtime" bson:"runtime"`
 	QueueDelay    int64  `json:"queue_delay" bson:"queue_delay"`
 	OverheadDelay int64  `json:"overhead_delay" bson:"overhead_delay"`
-	Stderr        string `json:"stderr" bson:"stderr"`
-	Stdout        string `json:"stdout" bson:"stdout"`
+
+	// Stderr and Stdout are assembled from the job's OutputChunks when it's listed. They're never
+	// stored in the job document itself.
+	Stderr string `json:"stderr" bson:"-"`
+	Stdout string `json:"stdout" bson:"-"`
+
+	// ResultBlob locates the job's result within the result BlobStore. Result is only populated
+	// from it on request.
+	ResultBlob *ResultBlob `json:"result_blob,omitempty" bson:"result_blob,omitempty"`
 
 	Collected Collected `json:"collected,omitempty" bson:"collected,omitempty"`
 
+	// ImagePull reports on the image that the job had to pull before it could run, if any.
+	ImagePull *ImagePull `json:"image_pull,omitempty" bson:"image_pull,omitempty"`
+
+	// Attempts records each time the job was launched. Restartable jobs that are waiting to be
+	// retried after a Docker failure have a RetryAt time.
+	Attempts []JobAttempt `json:"attempts,omitempty" bson:"attempts,omitempty"`
+	RetryAt  StoredTime   `json:"retry_at,omitempty" bson:"retry_at,omitempty"`
+
+	// Owner is the RunnerID of the job runner that claimed the job most recently. Its claim lapses at
+	// LeaseExpires unless heartbeats renew it. A zero LeaseExpires never lapses.
+	Owner        string     `json:"owner,omitempty" bson:"owner,omitempty"`
+	LeaseExpires StoredTime `json:"lease_expires,omitempty" bson:"lease_expires,omitempty"`
+
+	// Host names the Docker host that the job was placed on, when jobs are spread across several.
+	Host string `json:"host,omitempty" bson:"host,omitempty"`
+
+	// Schedule is the ID of the Schedule that submitted the job, if any.
+	Schedule uint64 `json:"schedule,omitempty" bson:"schedule,omitempty"`
+
+	// Array is the ID of the job array that the job is an element of, if any, and ArrayIndex is its
+	// position within the array, starting from 0.
+	Array      uint64 `json:"array,omitempty" bson:"array,omitempty"`
+	ArrayIndex *int   `json:"array_index,omitempty" bson:"array_index,omitempty"`
+
 	JID           uint64 `json:"jid" bson:"_id"`
 	Account       string `json:"-" bson:"account"`
 	ContainerID   string `json:"-" bson:"container_id,omitempty"`
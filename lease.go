@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// Lease records the job runner that claimed a job, and when its claim lapses unless it's renewed. A
+// zero Expires never lapses.
+type Lease struct {
+	Owner   string
+	Expires StoredTime
+}
+
+// NewLease creates a lease held by this job runner that lapses LeaseDuration seconds after now.
+// Leases never lapse if LeaseDuration isn't positive.
+func (c *Context) NewLease(now time.Time) Lease {
+	lease := Lease{Owner: c.RunnerID}
+	if c.LeaseDuration > 0 {
+		lease.Expires = StoreTime(now.Add(time.Duration(c.LeaseDuration) * time.Second))
+	}
+	return lease
+}
+
+// grant places the job under a lease.
+func (j *SubmittedJob) grant(lease Lease) {
+	j.Owner = lease.Owner
+	j.LeaseExpires = lease.Expires
+}
+
+// leaseHeldBy reports whether the job is processing under a lease held by owner, or by nobody.
+func (j *SubmittedJob) leaseHeldBy(owner string) bool {
+	return j.Status == StatusProcessing && (j.Owner == owner || j.Owner == "")
+}
+
+// leaseLapsed reports whether the job is processing under a lease that lapsed before now.
+func (j *SubmittedJob) leaseLapsed(now StoredTime) bool {
+	return j.Status == StatusProcessing && j.LeaseExpires != 0 && j.LeaseExpires < now
+}
+
+// Sweeper periodically takes over the jobs whose leases have lapsed. It returns at once if leases
+// never lapse.
+func Sweeper(c *Context) {
+	if c.LeaseDuration <= 0 {
+		return
+	}
+
+	for {
+		if err := SweepLapsedLeases(c, time.Now()); err != nil {
+			log.WithFields(log.Fields{"error": err}).Error("Unable to sweep lapsed leases.")
+		}
+
+		time.Sleep(time.Duration(c.LeaseDuration) * time.Second / 2)
+	}
+}
+
+// SweepLapsedLeases takes over the processing jobs whose runners stopped renewing their leases,
+// most likely because they died. Restartable jobs are queued again until they run out of attempts;
+// other jobs fail.
+func SweepLapsedLeases(c *Context, now time.Time) error {
+	for {
+		job, err := c.ClaimLapsedJob(c.NewLease(now), StoreTime(now))
+		if err != nil {
+			return err
+		}
+		if job == nil {
+			return nil
+		}
+
+		attempt := len(job.Attempts) + 1
+		var reason string
+		switch {
+		case job.KillRequested:
+			job.Status = StatusKilled
+			job.FinishedAt = StoreTime(now)
+		case job.Restartable && attempt < c.MaxAttempts:
+			reason = fmt.Sprintf("Attempt %d was abandoned by its runner. Queued again.\n", attempt)
+			job.Status = StatusQueued
+		default:
+			reason = fmt.Sprintf("Attempt %d was abandoned by its runner.\n", attempt)
+			job.Status = StatusError
+			job.FinishedAt = StoreTime(now)
+		}
+
+		if reason != "" {
+			chunk := OutputChunk{JID: job.JID, Stream: StreamStderr, Sequence: lateOutput(job), Data: []byte(reason)}
+			if err := c.AppendOutput(chunk); err != nil {
+				log.WithFields(log.Fields{
+					"jid":   job.JID,
+					"error": err,
+				}).Error("Unable to record why a job was abandoned.")
+			}
+		}
+
+		job.Attempts = append(job.Attempts, JobAttempt{
+			StartedAt:  job.StartedAt,
+			FinishedAt: StoreTime(now),
+			Error:      "the job's lease lapsed",
+		})
+		if err := c.UpdateJob(job); err != nil {
+			return err
+		}
+
+		log.WithFields(log.Fields{
+			"jid":     job.JID,
+			"account": job.Account,
+			"status":  job.Status,
+		}).Warn("Swept a job with a lapsed lease.")
+
+		if job.Status == StatusQueued {
+			c.Wakeup.Notify()
+		}
+	}
+}
+
+// startHeartbeat renews the job's lease in the background until the returned function is called.
+// If the lease lapses and another runner takes the job over, the attempt is abandoned: its container
+// is killed and the job is left to its new owner.
+func (e *execution) startHeartbeat() (stop func()) {
+	c, job := e.c, e.job
+	if c.LeaseDuration <= 0 {
+		return func() {}
+	}
+
+	// The job is shared with the execution, so read everything needed from it up front.
+	jid, name := job.JID, job.ContainerName()
+	fields := log.Fields{
+		"jid":     jid,
+		"account": job.Account,
+	}
+
+	done := make(chan struct{})
+	ticker := time.NewTicker(time.Duration(c.LeaseDuration) * time.Second / 3)
+
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				err := c.RenewLease(jid, c.NewLease(now))
+				if err == ErrNotFound {
+					atomic.StoreInt32(&e.abandoned, 1)
+					log.WithFields(fields).Warn("Another runner has taken over the job. Abandoning it.")
+
+					err := e.docker.KillContainer(docker.KillContainerOptions{ID: name})
+					if err != nil {
+						log.WithFields(fields).WithField("err", err).Debug("Unable to kill the abandoned container.")
+					}
+					return
+				}
+				if err != nil {
+					log.WithFields(fields).WithField("err", err).Error("Unable to renew the job's lease.")
+				}
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
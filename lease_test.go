@@ -0,0 +1,151 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewLease(t *testing.T) {
+	now := time.Now()
+	c := &Context{Settings: Settings{RunnerID: "runner-a", LeaseDuration: 60}}
+
+	lease := c.NewLease(now)
+	if lease.Owner != "runner-a" || lease.Expires != StoreTime(now.Add(time.Minute)) {
+		t.Errorf("Unexpected lease: %#v", lease)
+	}
+
+	c.LeaseDuration = 0
+	if lease := c.NewLease(now); lease.Expires != 0 {
+		t.Errorf("Expected leases to never lapse without a duration, got %#v", lease)
+	}
+}
+
+func TestSweeperWithoutLeases(t *testing.T) {
+	c := &Context{Settings: Settings{LeaseDuration: -1}, Storage: NewMemoryStorage()}
+
+	done := make(chan struct{})
+	go func() {
+		Sweeper(c)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the sweeper to stop when leases never lapse")
+	}
+}
+
+func TestSweepLapsedLeasesInMemory(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{
+		Settings: Settings{RunnerID: "sweeper", LeaseDuration: 60, MaxAttempts: 3},
+		Storage:  s,
+	}
+
+	now := time.Now()
+	lapsed := StoreTime(now.Add(-time.Second))
+	processing := func(restartable bool, expires StoredTime) SubmittedJob {
+		return SubmittedJob{
+			Job:          Job{Command: "sleep 1000", Restartable: restartable},
+			Account:      "admin",
+			Status:       StatusProcessing,
+			Owner:        "runner-a",
+			LeaseExpires: expires,
+		}
+	}
+	jids := insertJobs(t, s,
+		processing(true, lapsed),
+		processing(false, lapsed),
+		processing(false, StoreTime(now.Add(time.Minute))),
+		processing(false, 0),
+	)
+
+	if err := SweepLapsedLeases(c, now); err != nil {
+		t.Fatalf("Unable to sweep lapsed leases: %v", err)
+	}
+
+	jobs, err := s.ListJobs(JobQuery{JIDs: jids})
+	if err != nil {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+
+	expected := []string{StatusQueued, StatusError, StatusProcessing, StatusProcessing}
+	for i, job := range jobs {
+		if job.Status != expected[i] {
+			t.Errorf("Expected job [%d] to have status [%s], was [%s]", i, expected[i], job.Status)
+		}
+	}
+
+	if requeued := jobs[0]; len(requeued.Attempts) != 1 || !strings.Contains(requeued.Stderr, "Queued again") {
+		t.Errorf("Expected the re-queued job to record its abandoned attempt, got %#v", requeued)
+	}
+	if failed := jobs[1]; failed.FinishedAt != StoreTime(now) || !strings.Contains(failed.Stderr, "abandoned") {
+		t.Errorf("Expected the failed job to explain its failure, got %#v", failed)
+	}
+	if live := jobs[2]; live.Owner != "runner-a" {
+		t.Errorf("Expected a live lease to be left alone, got [%s]", live.Owner)
+	}
+}
+
+func TestAbandonJobAfterLosingLeaseInMemory(t *testing.T) {
+	s := NewMemoryStorage()
+	d := NewHangingDocker("working\n")
+	c := &Context{
+		Settings: Settings{RunnerID: "runner-a", LeaseDuration: 1},
+		Storage:  s,
+		Docker:   d,
+		Results:  NewMemoryBlobStore(),
+		Cores:    DefaultCoreCatalog(),
+	}
+
+	insertJobs(t, s, SubmittedJob{
+		Job:     Job{Command: "sleep 1000", ResultSource: "stdout", ResultType: ResultBinary},
+		Account: "admin",
+		Status:  StatusQueued,
+	})
+
+	start := time.Now()
+	job, err := s.ClaimJob(c.NewLease(start), nil)
+	if err != nil || job == nil {
+		t.Fatalf("Unable to claim a job: %v", err)
+	}
+	jid := job.JID
+
+	finished := make(chan struct{})
+	go func() {
+		Execute(c, job)
+		close(finished)
+	}()
+
+	// Heartbeats keep renewing the lease while the job runs.
+	time.Sleep(500 * time.Millisecond)
+	jobs, err := s.ListJobs(JobQuery{JIDs: []uint64{jid}})
+	if err != nil {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+	if renewed := jobs[0].LeaseExpires; renewed <= StoreTime(start.Add(time.Second)) {
+		t.Errorf("Expected the lease to be renewed, expires at [%s]", renewed.String())
+	}
+
+	// Another runner takes the job over, and the next heartbeat abandons it.
+	takeover, err := s.ClaimLapsedJob(Lease{Owner: "runner-b", Expires: StoreTime(start.Add(time.Hour))}, StoreTime(start.Add(time.Minute)))
+	if err != nil || takeover == nil {
+		t.Fatalf("Unable to take over the job: [%#v] and [%v]", takeover, err)
+	}
+
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the job to be abandoned")
+	}
+
+	jobs, err = s.ListJobs(JobQuery{JIDs: []uint64{jid}})
+	if err != nil {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+	if abandoned := jobs[0]; abandoned.Status != StatusProcessing || abandoned.Owner != "runner-b" {
+		t.Errorf("Expected the job to be left to its new owner, got [%s] [%s]", abandoned.Status, abandoned.Owner)
+	}
+}
@@ -3,7 +3,11 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -23,6 +27,17 @@ func main() {
 	log.Info("Launching job runner.")
 	go Runner(c)
 
+	log.Info("Launching queue watcher.")
+	go WatchQueue(c)
+
+	log.Info("Launching lease sweeper.")
+	go Sweeper(c)
+
+	if len(c.RetentionPolicy()) > 0 {
+		log.Info("Launching retention sweeper.")
+		go Retention(c)
+	}
+
 	// v1 routes
 	http.HandleFunc("/v1/auth_service", BindContext(c, AuthDiscoverHandler))
 
@@ -30,11 +45,53 @@ func main() {
 	http.HandleFunc("/v1/job/kill", BindContext(c, JobKillHandler))
 	http.HandleFunc("/v1/job/kill_all", BindContext(c, JobKillAllHandler))
 	http.HandleFunc("/v1/job/queue_stats", BindContext(c, JobQueueStatsHandler))
+	http.HandleFunc("/v1/job/result", BindContext(c, JobResultHandler))
+	http.HandleFunc("/v1/job/array", BindContext(c, JobArrayHandler))
+	http.HandleFunc("/v1/job/array/kill", BindContext(c, JobArrayKillHandler))
+
+	http.HandleFunc("/v1/schedule", BindContext(c, ScheduleHandler))
+	http.HandleFunc("/v1/schedule/pause", BindContext(c, SchedulePauseHandler))
+	http.HandleFunc("/v1/schedule/resume", BindContext(c, ScheduleResumeHandler))
+	http.HandleFunc("/v1/schedule/delete", BindContext(c, ScheduleDeleteHandler))
+	http.HandleFunc("/v1/schedule/history", BindContext(c, ScheduleHistoryHandler))
+
+	http.HandleFunc("/v1/admin/priority", BindContext(c, AccountPriorityHandler))
+	http.HandleFunc("/v1/admin/reprioritize", BindContext(c, JobReprioritizeHandler))
+	http.HandleFunc("/v1/admin/retention", BindContext(c, RetentionPreviewHandler))
+	http.HandleFunc("/v1/admin/workers", BindContext(c, WorkerPoolHandler))
+
+	listener, err := net.Listen("tcp", c.ListenAddr())
+	if err != nil {
+		log.WithFields(log.Fields{
+			"address": c.ListenAddr(),
+			"error":   err,
+		}).Fatal("Unable to listen.")
+		return
+	}
+
+	server := NewDrainingServer(nil)
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(listener) }()
 
 	log.WithFields(log.Fields{
 		"address": c.ListenAddr(),
 	}).Info("Web API listening.")
-	http.ListenAndServe(c.ListenAddr(), nil)
+
+	// Drain gracefully when asked to stop.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	select {
+	case sig := <-signals:
+		log.WithFields(log.Fields{
+			"signal": sig,
+		}).Info("Shutting down.")
+		Shutdown(c, server, time.Now().Add(time.Duration(c.DrainTimeout)*time.Second))
+	case err := <-served:
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Web API stopped unexpectedly.")
+	}
 }
 
 // ContextHandler is an HTTP HandlerFunc that accepts an additional parameter containing the
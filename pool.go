@@ -0,0 +1,134 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// WorkerPool bounds the number of jobs that execute concurrently. The Runner reserves a slot before
+// claiming each job, so it stops claiming jobs while every slot is occupied, or once the pool is
+// draining.
+type WorkerPool struct {
+	sync.Mutex
+
+	size     int
+	reserved int
+	draining bool
+	running  map[uint64]time.Time
+	released *Wakeup
+}
+
+// NewWorkerPool creates an empty WorkerPool with the given number of slots.
+func NewWorkerPool(size int) *WorkerPool {
+	return &WorkerPool{
+		size:    size,
+		running: make(map[uint64]time.Time),
+	}
+}
+
+// NotifyOnRelease notifies a Wakeup each time a job finishes and frees its slot, so that the
+// Runner can claim the next job right away.
+func (pool *WorkerPool) NotifyOnRelease(w *Wakeup) {
+	pool.Lock()
+	defer pool.Unlock()
+
+	pool.released = w
+}
+
+// Reserve claims a free slot, returning false if the pool is full or draining.
+func (pool *WorkerPool) Reserve() bool {
+	pool.Lock()
+	defer pool.Unlock()
+
+	if pool.draining || pool.reserved >= pool.size {
+		return false
+	}
+	pool.reserved++
+	return true
+}
+
+// Occupy claims a slot for a job that's already running, even if the pool is full. Jobs recovered
+// after a restart keep running regardless of the pool's size, so the Runner waits for them to finish
+// before it claims more jobs than it has room for.
+func (pool *WorkerPool) Occupy() {
+	pool.Lock()
+	defer pool.Unlock()
+
+	pool.reserved++
+}
+
+// Unreserve returns a reserved slot that wasn't used.
+func (pool *WorkerPool) Unreserve() {
+	pool.Lock()
+	defer pool.Unlock()
+
+	pool.reserved--
+}
+
+// Go executes a job in a new goroutine using a previously reserved slot. The slot is released once
+// f returns.
+func (pool *WorkerPool) Go(job *SubmittedJob, f func()) {
+	pool.Lock()
+	pool.running[job.JID] = time.Now()
+	pool.Unlock()
+
+	go func() {
+		defer func() {
+			pool.Lock()
+			delete(pool.running, job.JID)
+			pool.reserved--
+			released := pool.released
+			pool.Unlock()
+
+			released.Notify()
+		}()
+
+		f()
+	}()
+}
+
+// Drain stops the pool from reserving any more slots. Jobs that are already running are unaffected.
+func (pool *WorkerPool) Drain() {
+	pool.Lock()
+	defer pool.Unlock()
+
+	pool.draining = true
+}
+
+// Wait blocks until every slot is free, or until the deadline passes. It returns the JIDs of any
+// jobs that are still running.
+func (pool *WorkerPool) Wait(deadline time.Time) []uint64 {
+	for {
+		stats := pool.Stats()
+		if stats.Busy == 0 || !time.Now().Before(deadline) {
+			return stats.Running
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// PoolStats summarizes the occupancy of a WorkerPool.
+type PoolStats struct {
+	Size     int      `json:"size"`
+	Busy     int      `json:"busy"`
+	Draining bool     `json:"draining"`
+	Running  []uint64 `json:"running"`
+
+	// Hosts reports the occupancy of each Docker host, when jobs are spread across several.
+	Hosts []HostStats `json:"hosts,omitempty"`
+}
+
+// Stats reports the current occupancy of the pool, including the JIDs of the jobs that are running.
+func (pool *WorkerPool) Stats() PoolStats {
+	pool.Lock()
+	defer pool.Unlock()
+
+	running := make([]uint64, 0, len(pool.running))
+	for jid := range pool.running {
+		running = append(running, jid)
+	}
+	sort.Sort(jidSlice(running))
+
+	return PoolStats{Size: pool.size, Busy: pool.reserved, Draining: pool.draining, Running: running}
+}
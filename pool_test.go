@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWorkerPool(t *testing.T) {
+	pool := NewWorkerPool(2)
+
+	if !pool.Reserve() || !pool.Reserve() {
+		t.Fatal("Expected to reserve two slots")
+	}
+	if pool.Reserve() {
+		t.Error("Expected a full pool to refuse a reservation")
+	}
+
+	pool.Unreserve()
+	if stats := pool.Stats(); stats.Size != 2 || stats.Busy != 1 {
+		t.Errorf("Unexpected pool stats: %#v", stats)
+	}
+
+	release := make(chan struct{})
+	done := make(chan struct{})
+	pool.Reserve()
+	pool.Go(&SubmittedJob{JID: 12}, func() {
+		<-release
+		close(done)
+	})
+
+	if stats := pool.Stats(); stats.Busy != 2 || len(stats.Running) != 1 || stats.Running[0] != 12 {
+		t.Errorf("Unexpected pool stats: %#v", stats)
+	}
+
+	close(release)
+	<-done
+
+	// The slot is released just after the job returns.
+	for i := 0; !pool.Reserve(); i++ {
+		if i > 1000 {
+			t.Fatal("Expected the job's slot to be released")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if stats := pool.Stats(); len(stats.Running) != 0 {
+		t.Errorf("Expected no running jobs, got %v", stats.Running)
+	}
+}
+
+func TestDrainWorkerPool(t *testing.T) {
+	pool := NewWorkerPool(2)
+
+	release := make(chan struct{})
+	pool.Reserve()
+	pool.Go(&SubmittedJob{JID: 12}, func() { <-release })
+
+	pool.Drain()
+	if pool.Reserve() {
+		t.Error("Expected a draining pool to refuse a reservation")
+	}
+	if stats := pool.Stats(); !stats.Draining {
+		t.Errorf("Expected the pool to report that it's draining, got %#v", stats)
+	}
+
+	if running := pool.Wait(time.Now().Add(100 * time.Millisecond)); len(running) != 1 || running[0] != 12 {
+		t.Errorf("Expected job [12] to still be running at the deadline, got %v", running)
+	}
+
+	close(release)
+	if running := pool.Wait(time.Now().Add(5 * time.Second)); len(running) != 0 {
+		t.Errorf("Expected every job to finish, got %v", running)
+	}
+}
+
+func TestWorkerPoolNotifiesOnRelease(t *testing.T) {
+	pool := NewWorkerPool(1)
+	w := NewWakeup()
+	pool.NotifyOnRelease(w)
+
+	pool.Reserve()
+	pool.Go(&SubmittedJob{JID: 1}, func() {})
+
+	if !w.Wait(5 * time.Second) {
+		t.Fatal("Expected the released slot to wake the job runner")
+	}
+	if !pool.Reserve() {
+		t.Error("Expected the slot to be free by the time the job runner wakes")
+	}
+}
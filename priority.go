@@ -0,0 +1,24 @@
+package main
+
+import "fmt"
+
+// PriorityLimit determines the highest priority that an account may request for its jobs. Accounts
+// without a MaxPriority of their own receive the DefaultMaxPriority.
+func (c *Context) PriorityLimit(account *Account) int {
+	if account.MaxPriority > 0 {
+		return account.MaxPriority
+	}
+	return c.DefaultMaxPriority
+}
+
+// checkPriority ensures that a priority falls between zero and limit, inclusive.
+func checkPriority(priority, limit int) *APIError {
+	if priority < 0 || priority > limit {
+		return &APIError{
+			Code:    CodeInvalidPriority,
+			Message: fmt.Sprintf("Invalid priority [%d]", priority),
+			Hint:    fmt.Sprintf(`The "priority" must be between 0 and %d.`, limit),
+		}
+	}
+	return nil
+}
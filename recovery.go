@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// Recover reconciles the jobs left in StatusProcessing by a job runner that died with the state of
+// their containers. It runs once as the job runner starts, before any new jobs are claimed. Only
+// jobs claimed under this runner's RunnerID, or claimed before leases existed, are recovered; the
+// jobs of other runners are left to the Sweeper once their leases lapse.
+//
+// Containers that are still running are reattached and monitored as usual. Containers that exited
+// while nobody was watching have their exit code and result collected. Jobs whose container is gone
+// are queued again if they're restartable and failed otherwise.
+func Recover(c *Context) error {
+	jobs, err := c.ListJobs(JobQuery{Statuses: []string{StatusProcessing}, SkipOutput: true})
+	if err != nil {
+		return err
+	}
+
+	for i := range jobs {
+		job := &jobs[i]
+		if job.Owner != c.RunnerID && job.Owner != "" {
+			continue
+		}
+
+		// Take the lease back before touching the job, in case another runner swept it already.
+		if err := c.RenewLease(job.JID, c.NewLease(time.Now())); err == ErrNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		recoverJob(c, job)
+	}
+	return nil
+}
+
+// recoverJob reconciles a single processing job with the state of its container.
+func recoverJob(c *Context, job *SubmittedJob) {
+	e := newExecution(c, job)
+	e.attempt.StartedAt = job.StartedAt
+	e.launched = job.StartedAt.AsTime().Add(time.Duration(job.OverheadDelay))
+	e.recovered = true
+
+	// The output collected before the restart occupies the start of this attempt's block.
+	e.stdout.sequence = lateOutput(job)
+	e.stderr.sequence = lateOutput(job)
+
+	log.WithFields(e.fields).Info("Recovering a job.")
+
+	// The container ID is recorded just after the container is created, and a retried job still
+	// carries the ID of its previous attempt's container until then. Containers are named after their
+	// job, though, so look for the name when the ID comes up empty.
+	var container *docker.Container
+	var err error
+	for _, id := range []string{job.ContainerID, job.ContainerName()} {
+		if id == "" {
+			continue
+		}
+		container, err = e.docker.InspectContainer(id)
+		if _, ok := err.(*docker.NoSuchContainer); ok {
+			container, err = nil, nil
+		}
+		if err != nil || container != nil {
+			break
+		}
+	}
+	if err == nil && container == nil {
+		e.fail(errors.New("its container was lost while the job runner was down"))
+		return
+	}
+	if e.checkErr("Inspected the job's container", err) {
+		// Leave the job alone. It will be recovered the next time that the job runner starts.
+		return
+	}
+	e.adopt(container)
+
+	switch {
+	case container.State.Running:
+		log.WithFields(e.fields).Info("Reattaching to a running job.")
+
+		// The interrupted attempt ends here. The container's output from now on belongs to a new
+		// attempt, so that it's numbered after the output that was collected before the restart.
+		now := time.Now()
+		e.attempt.FinishedAt = StoreTime(now)
+		e.attempt.Error = "the job runner restarted"
+		job.Attempts = append(job.Attempts, e.attempt)
+
+		resumed := newExecution(c, job)
+		resumed.launched = e.launched
+		resumed.recovered = true
+		resumed.adopt(container)
+		if !resumed.updateJob("attempts") {
+			return
+		}
+
+		stop := resumed.startHeartbeat()
+		c.Pool.Occupy()
+		c.Hosts.Occupy(job.Host)
+		c.Pool.Go(job, func() {
+			defer c.Hosts.Release(job.Host)
+			defer stop()
+
+			resumed.attach(nil)
+			resumed.wait()
+		})
+	case container.State.StartedAt.IsZero():
+		e.fail(errors.New("the job runner stopped before its container started"))
+	default:
+		finishedAt := container.State.FinishedAt
+		if finishedAt.IsZero() {
+			finishedAt = time.Now()
+		}
+
+		maxRuntime := c.MaxRuntime(job)
+		timedOut := maxRuntime > 0 && finishedAt.Sub(e.launched) >= maxRuntime
+
+		e.complete(container.State.ExitCode, timedOut, finishedAt)
+	}
+}
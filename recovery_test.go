@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// LeftoverDocker is a fake Docker implementation holding the containers left behind by a job runner
+// that died. Running containers exit with status zero as soon as they're waited on.
+type LeftoverDocker struct {
+	NullDocker
+	sync.Mutex
+
+	Containers map[string]*docker.Container
+	Logs       string
+	Removed    []string
+}
+
+func (d *LeftoverDocker) InspectContainer(id string) (*docker.Container, error) {
+	if container, ok := d.Containers[id]; ok {
+		return container, nil
+	}
+	return nil, &docker.NoSuchContainer{ID: id}
+}
+
+func (d *LeftoverDocker) AttachToContainer(opts docker.AttachToContainerOptions) error {
+	if opts.Logs {
+		opts.OutputStream.Write([]byte(d.Logs))
+	}
+	return nil
+}
+
+func (d *LeftoverDocker) RemoveContainer(opts docker.RemoveContainerOptions) error {
+	d.Lock()
+	defer d.Unlock()
+
+	d.Removed = append(d.Removed, opts.ID)
+	return nil
+}
+
+func TestRecoverJobsInMemory(t *testing.T) {
+	s := NewMemoryStorage()
+	d := &LeftoverDocker{Logs: "all of the output\n"}
+	c := &Context{
+		Settings: Settings{MaxAttempts: 3, RetryBackoff: 10},
+		Storage:  s,
+		Docker:   d,
+		Results:  NewMemoryBlobStore(),
+		Cores:    DefaultCoreCatalog(),
+		Pool:     NewWorkerPool(1),
+	}
+	s.GetAccount("admin")
+
+	started := time.Now().Add(-time.Minute)
+	processing := func(containerID string, restartable bool) SubmittedJob {
+		return SubmittedJob{
+			Job: Job{
+				Command:      "echo hello",
+				ResultSource: "stdout",
+				ResultType:   ResultBinary,
+				Restartable:  restartable,
+			},
+			Account:     "admin",
+			Status:      StatusProcessing,
+			StartedAt:   StoreTime(started),
+			ContainerID: containerID,
+		}
+	}
+	jids := insertJobs(t, s,
+		processing("running", false),
+		processing("exited", false),
+		processing("crashed", false),
+		processing("lost", true),
+		processing("lost", false),
+		processing("", false),
+	)
+
+	// The last job's container was created, but its ID was never recorded.
+	unrecorded := fmt.Sprintf("job_%d_unnamed", jids[5])
+	d.Containers = map[string]*docker.Container{
+		"running": {ID: "running", State: docker.State{Running: true, StartedAt: started}},
+		"exited": {ID: "exited", State: docker.State{
+			StartedAt:  started,
+			FinishedAt: started.Add(30 * time.Second),
+		}},
+		"crashed": {ID: "crashed", State: docker.State{
+			StartedAt:  started,
+			FinishedAt: started.Add(30 * time.Second),
+			ExitCode:   1,
+		}},
+		unrecorded: {ID: unrecorded},
+	}
+
+	if err := Recover(c); err != nil {
+		t.Fatalf("Unable to recover jobs: %v", err)
+	}
+
+	// The reattached job occupies the pool until its container exits.
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Pool.Stats().Busy != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the reattached job to finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	jobs, err := s.ListJobs(JobQuery{JIDs: jids})
+	if err != nil {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+	byJID := make(map[uint64]SubmittedJob, len(jobs))
+	for _, job := range jobs {
+		byJID[job.JID] = job
+	}
+
+	expected := []struct {
+		status   string
+		attempts int
+	}{
+		{StatusDone, 2},
+		{StatusDone, 1},
+		{StatusError, 1},
+		{StatusWaiting, 1},
+		{StatusError, 1},
+		{StatusError, 1},
+	}
+	for i, e := range expected {
+		job := byJID[jids[i]]
+		if job.Status != e.status {
+			t.Errorf("Expected job [%d] to have status [%s], was [%s]", i, e.status, job.Status)
+		}
+		if len(job.Attempts) != e.attempts {
+			t.Errorf("Expected job [%d] to have [%d] attempts, got %#v", i, e.attempts, job.Attempts)
+		}
+	}
+
+	for _, i := range []int{0, 1} {
+		job := byJID[jids[i]]
+		if err := LoadResult(c, &job); err != nil {
+			t.Fatalf("Unable to load the result of job [%d]: %v", i, err)
+		}
+		if string(job.Result) != d.Logs {
+			t.Errorf("Expected job [%d] to have its result replayed from its logs, got [%s]", i, job.Result)
+		}
+	}
+
+	if exited := byJID[jids[1]]; exited.Runtime != int64(30*time.Second) {
+		t.Errorf("Expected the exited job's runtime to be measured by its container, got [%d]", exited.Runtime)
+	}
+
+	if lost := byJID[jids[3]]; !strings.Contains(lost.Stderr, "lost while the job runner was down") {
+		t.Errorf("Expected the lost job's stderr to explain its retry, got [%s]", lost.Stderr)
+	}
+
+	removed := strings.Join(d.Removed, ",")
+	for _, id := range []string{"running", "exited", "crashed", unrecorded} {
+		if !strings.Contains(removed, id) {
+			t.Errorf("Expected container [%s] to be removed, removed [%s]", id, removed)
+		}
+	}
+}
+
+func TestRecoverJobsAcrossHostsInMemory(t *testing.T) {
+	s := NewMemoryStorage()
+	started := time.Now().Add(-time.Minute)
+	east := &LeftoverDocker{Containers: map[string]*docker.Container{}}
+	west := &LeftoverDocker{
+		Logs: "west\n",
+		Containers: map[string]*docker.Container{
+			"running": {ID: "running", State: docker.State{Running: true, StartedAt: started}},
+		},
+	}
+	c := &Context{
+		Storage: s,
+		Docker:  east,
+		Results: NewMemoryBlobStore(),
+		Cores:   DefaultCoreCatalog(),
+		Pool:    NewWorkerPool(1),
+		Hosts: NewDockerPool(
+			&DockerEndpoint{Docker: east, Name: "east", Capacity: 1},
+			&DockerEndpoint{Docker: west, Name: "west", Capacity: 1},
+		),
+	}
+
+	jids := insertJobs(t, s, SubmittedJob{
+		Job:         Job{Command: "echo west", ResultSource: "stdout", ResultType: ResultBinary},
+		Account:     "admin",
+		Status:      StatusProcessing,
+		StartedAt:   StoreTime(started),
+		ContainerID: "running",
+		Host:        "west",
+	})
+
+	if err := Recover(c); err != nil {
+		t.Fatalf("Unable to recover jobs: %v", err)
+	}
+
+	jobs := awaitCompletion(t, s, jids...)
+	if jobs[0].Status != StatusDone {
+		t.Errorf("Expected the job to be recovered from its host, was [%s]", jobs[0].Status)
+	}
+	if len(west.Removed) != 1 || len(east.Removed) != 0 {
+		t.Errorf("Expected the container to be removed from its host, got %v and %v", west.Removed, east.Removed)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Hosts.Stats()[1].Busy != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the host to be released")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// retentionBatchSize limits the number of expired jobs that are archived and purged at once.
+const retentionBatchSize = 500
+
+// RetentionPolicy maps a completed job status to the age, measured from the time that the job
+// finished, after which jobs with that status expire. Jobs that never recorded when they finished
+// age from when they were created. Jobs with statuses that aren't present are kept forever.
+type RetentionPolicy map[string]time.Duration
+
+// RetentionPolicy assembles the retention policy from the current settings. Jobs that timed out are
+// retained for as long as jobs that failed.
+func (c *Context) RetentionPolicy() RetentionPolicy {
+	policy := RetentionPolicy{}
+	for status, hours := range map[string]int{
+		StatusDone:     c.RetainDone,
+		StatusError:    c.RetainError,
+		StatusTimedOut: c.RetainError,
+		StatusKilled:   c.RetainKilled,
+	} {
+		if hours > 0 {
+			policy[status] = time.Duration(hours) * time.Hour
+		}
+	}
+	return policy
+}
+
+// Statuses lists the statuses governed by the policy in a stable order.
+func (policy RetentionPolicy) Statuses() []string {
+	statuses := make([]string, 0, len(policy))
+	for status := range policy {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	return statuses
+}
+
+// ExpiredJobs lists up to limit jobs that have expired under a retention policy as of now. A limit
+// of zero lists every expired job.
+func ExpiredJobs(c *Context, policy RetentionPolicy, now time.Time, limit int) ([]SubmittedJob, error) {
+	expired := []SubmittedJob{}
+	for _, status := range policy.Statuses() {
+		q := JobQuery{
+			Statuses:       []string{status},
+			FinishedBefore: StoreTime(now.Add(-policy[status])),
+		}
+		if limit > 0 {
+			q.Limit = limit - len(expired)
+			if q.Limit <= 0 {
+				break
+			}
+		}
+
+		jobs, err := c.ListJobs(q)
+		if err != nil {
+			return nil, err
+		}
+		expired = append(expired, jobs...)
+	}
+	return expired, nil
+}
+
+// archivedJob is the representation of a SubmittedJob within an archive. Unlike the API, it
+// includes the owning account.
+type archivedJob struct {
+	*SubmittedJob
+	Account string `json:"account"`
+}
+
+// ArchiveJobs writes a batch of jobs, including their output and any results that have been loaded
+// into them, to a new gzip-compressed JSONL file within the ArchiveDir and returns its path. The
+// archive is fully written and synced before it appears under its final name.
+func ArchiveJobs(c *Context, jobs []SubmittedJob, now time.Time) (string, error) {
+	if err := os.MkdirAll(c.ArchiveDir, 0700); err != nil {
+		return "", err
+	}
+
+	f, err := ioutil.TempFile(c.ArchiveDir, ".incoming-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	z := gzip.NewWriter(f)
+	encoder := json.NewEncoder(z)
+	for i := range jobs {
+		job := &jobs[i]
+		if err := encoder.Encode(archivedJob{SubmittedJob: job, Account: job.Account}); err != nil {
+			return "", err
+		}
+	}
+	if err := z.Close(); err != nil {
+		return "", err
+	}
+	if err := f.Sync(); err != nil {
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("jobs-%s-%d.jsonl.gz", now.UTC().Format("20060102T150405Z"), jobs[0].JID)
+	archivePath := path.Join(c.ArchiveDir, name)
+	if err := os.Rename(f.Name(), archivePath); err != nil {
+		return "", err
+	}
+	return archivePath, nil
+}
+
+// Purge archives and then deletes every job that has expired under a retention policy as of now,
+// along with its output and result. Jobs whose results can't be loaded are left for a later pass.
+// It returns the number of jobs that were purged.
+func Purge(c *Context, policy RetentionPolicy, now time.Time) (int, error) {
+	purged := 0
+	skipped := make(map[uint64]bool)
+	for {
+		// Ask for enough jobs to fill a batch around the ones that have been skipped.
+		limit := retentionBatchSize + len(skipped)
+		expired, err := ExpiredJobs(c, policy, now, limit)
+		if err != nil {
+			return purged, err
+		}
+
+		jobs := make([]SubmittedJob, 0, len(expired))
+		for i := range expired {
+			job := &expired[i]
+			if skipped[job.JID] {
+				continue
+			}
+			if err := LoadResult(c, job); err != nil {
+				log.WithFields(log.Fields{
+					"jid":   job.JID,
+					"error": err,
+				}).Error("Unable to load the result of an expired job. Leaving it for later.")
+				skipped[job.JID] = true
+				continue
+			}
+			jobs = append(jobs, *job)
+		}
+		if len(jobs) == 0 {
+			if len(expired) < limit {
+				return purged, nil
+			}
+			continue
+		}
+
+		archivePath, err := ArchiveJobs(c, jobs, now)
+		if err != nil {
+			return purged, err
+		}
+
+		jids := make([]uint64, len(jobs))
+		for i, job := range jobs {
+			jids[i] = job.JID
+		}
+		if err := c.DeleteJobs(jids); err != nil {
+			return purged, err
+		}
+		purged += len(jobs)
+
+		// A leftover result only wastes space, so failing to remove one isn't fatal.
+		for _, job := range jobs {
+			if job.ResultBlob == nil {
+				continue
+			}
+			if err := c.Results.Delete(job.ResultBlob.Key); err != nil {
+				log.WithFields(log.Fields{
+					"jid":   job.JID,
+					"error": err,
+				}).Warn("Unable to delete the result of a purged job.")
+			}
+		}
+
+		log.WithFields(log.Fields{
+			"jobs":    len(jobs),
+			"archive": archivePath,
+		}).Info("Archived and purged expired jobs.")
+	}
+}
+
+// Retention is the main entry point for the retention goroutine. It periodically purges jobs that
+// have expired under the configured retention policy.
+func Retention(c *Context) {
+	for {
+		policy := c.RetentionPolicy()
+
+		purged, err := Purge(c, policy, time.Now())
+		if err != nil {
+			log.WithFields(log.Fields{
+				"purged": purged,
+				"error":  err,
+			}).Error("Unable to purge expired jobs.")
+		}
+
+		time.Sleep(time.Duration(c.RetentionInterval) * time.Minute)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRetentionPolicy(t *testing.T) {
+	c := &Context{Settings: Settings{RetainDone: 24, RetainKilled: 1}}
+	policy := c.RetentionPolicy()
+
+	if len(policy) != 2 || policy[StatusDone] != 24*time.Hour || policy[StatusKilled] != time.Hour {
+		t.Errorf("Unexpected retention policy: %v", policy)
+	}
+
+	c.RetainError = 48
+	policy = c.RetentionPolicy()
+	if policy[StatusError] != 48*time.Hour || policy[StatusTimedOut] != 48*time.Hour {
+		t.Errorf("Unexpected retention policy: %v", policy)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloudpipe-archive")
+	if err != nil {
+		t.Fatalf("Unable to create a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := NewMemoryStorage()
+	c := &Context{
+		Settings: Settings{RetainDone: 24, RetainError: 48, ArchiveDir: dir},
+		Storage:  s,
+		Results:  NewMemoryBlobStore(),
+	}
+
+	now := time.Now()
+	hoursAgo := func(hours int) StoredTime {
+		return StoreTime(now.Add(-time.Duration(hours) * time.Hour))
+	}
+
+	jids := insertJobs(t, s,
+		SubmittedJob{Account: "alice", Status: StatusDone, FinishedAt: hoursAgo(25)},
+		SubmittedJob{Account: "alice", Status: StatusDone, FinishedAt: hoursAgo(23)},
+		SubmittedJob{Account: "bob", Status: StatusError, FinishedAt: hoursAgo(25)},
+		SubmittedJob{Account: "bob", Status: StatusError, FinishedAt: hoursAgo(49)},
+		SubmittedJob{Account: "bob", Status: StatusKilled, FinishedAt: hoursAgo(1000)},
+		SubmittedJob{Account: "bob", Status: StatusQueued},
+	)
+
+	// Give the first expired job some output and a result.
+	s.AppendOutput(OutputChunk{JID: jids[0], Stream: StreamStdout, Data: []byte("hello")})
+	listed, err := s.ListJobs(JobQuery{JIDs: jids[:1]})
+	if err != nil || len(listed) != 1 {
+		t.Fatalf("Unable to list a job: %v", err)
+	}
+	expiring := listed[0]
+	expiring.Result = []byte("42")
+	if err := StoreResult(c, &expiring); err != nil {
+		t.Fatalf("Unable to store a result: %v", err)
+	}
+	if err := s.UpdateJob(&expiring); err != nil {
+		t.Fatalf("Unable to update a job: %v", err)
+	}
+
+	expired, err := ExpiredJobs(c, c.RetentionPolicy(), now, 0)
+	if err != nil {
+		t.Fatalf("Unable to list expired jobs: %v", err)
+	}
+	if len(expired) != 2 {
+		t.Errorf("Expected two expired jobs, got %#v", expired)
+	}
+
+	purged, err := Purge(c, c.RetentionPolicy(), now)
+	if err != nil {
+		t.Fatalf("Unable to purge expired jobs: %v", err)
+	}
+	if purged != 2 {
+		t.Errorf("Expected two jobs to be purged, got [%d]", purged)
+	}
+	expectJIDs(t, s, JobQuery{}, jids[1], jids[2], jids[4], jids[5])
+
+	if _, err := c.Results.Get(expiring.ResultBlob.Key); err != ErrNotFound {
+		t.Errorf("Expected the purged job's result to be deleted, got [%v]", err)
+	}
+
+	// The purged jobs are archived, along with their output and results.
+	archives, _ := filepath.Glob(filepath.Join(dir, "jobs-*.jsonl.gz"))
+	if len(archives) != 1 {
+		t.Fatalf("Expected one archive, got %v", archives)
+	}
+
+	f, err := os.Open(archives[0])
+	if err != nil {
+		t.Fatalf("Unable to open the archive: %v", err)
+	}
+	defer f.Close()
+	z, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("Unable to decompress the archive: %v", err)
+	}
+
+	var archived []map[string]interface{}
+	scanner := bufio.NewScanner(z)
+	for scanner.Scan() {
+		var record map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("Unable to parse archived job [%s]: %v", scanner.Text(), err)
+		}
+		archived = append(archived, record)
+	}
+	if len(archived) != 2 {
+		t.Fatalf("Expected two archived jobs, got %v", archived)
+	}
+
+	first := archived[0]
+	if first["jid"] != float64(jids[0]) || first["account"] != "alice" || first["stdout"] != "hello" {
+		t.Errorf("Unexpected archived job: %v", first)
+	}
+	// []byte fields are base64-encoded in JSON.
+	if first["result"] != "NDI=" {
+		t.Errorf("Unexpected archived result: %v", first["result"])
+	}
+
+	// Purging again has nothing left to do.
+	if purged, err := Purge(c, c.RetentionPolicy(), now); err != nil || purged != 0 {
+		t.Errorf("Expected a second purge to be a no-op, got [%d] [%v]", purged, err)
+	}
+}
+
+func TestPurgeSkipsMissingResults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloudpipe-archive")
+	if err != nil {
+		t.Fatalf("Unable to create a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := NewMemoryStorage()
+	c := &Context{
+		Settings: Settings{RetainDone: 24, ArchiveDir: dir},
+		Storage:  s,
+		Results:  NewMemoryBlobStore(),
+	}
+
+	now := time.Now()
+	old := StoreTime(now.Add(-48 * time.Hour))
+	jids := insertJobs(t, s,
+		SubmittedJob{Account: "alice", Status: StatusDone, FinishedAt: old, ResultBlob: &ResultBlob{Key: "missing", Size: 2}},
+		SubmittedJob{Account: "alice", Status: StatusDone, FinishedAt: old},
+	)
+
+	purged, err := Purge(c, c.RetentionPolicy(), now)
+	if err != nil || purged != 1 {
+		t.Fatalf("Expected the job with a result to be purged, got [%d] [%v]", purged, err)
+	}
+	expectJIDs(t, s, JobQuery{}, jids[0])
+}
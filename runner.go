@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -13,62 +14,117 @@ import (
 )
 
 // OutputCollector is an io.Writer that accumulates output from a specified stream in an attached
-// Docker container and appends it to the appropriate field within a SubmittedJob.
+// Docker container. Each write is stored as the next OutputChunk of the stream and appended to the
+// appropriate field within the in-memory SubmittedJob.
 type OutputCollector struct {
 	context  *Context
 	job      *SubmittedJob
 	isStdout bool
+	sequence int
 }
 
 // DescribeStream returns "stdout" or "stderr" to indicate which stream this collector is consuming.
-func (c OutputCollector) DescribeStream() string {
+func (c *OutputCollector) DescribeStream() string {
 	if c.isStdout {
-		return "stdout"
+		return StreamStdout
 	}
-	return "stderr"
+	return StreamStderr
 }
 
-// Write appends bytes to the selected stream and updates the SubmittedJob.
-func (c OutputCollector) Write(p []byte) (int, error) {
+// Write stores bytes as the next chunk of the selected stream and appends them to the SubmittedJob.
+func (c *OutputCollector) Write(p []byte) (int, error) {
 	log.WithFields(log.Fields{
 		"length": len(p),
 		"bytes":  string(p),
 		"stream": c.DescribeStream(),
 	}).Debug("Received output from a job")
 
+	err := c.context.AppendOutput(OutputChunk{
+		JID:      c.job.JID,
+		Stream:   c.DescribeStream(),
+		Sequence: c.sequence,
+		Data:     p,
+	})
+	if err != nil {
+		return 0, err
+	}
+	c.sequence++
+
 	if c.isStdout {
 		c.job.Stdout += string(p)
 	} else {
 		c.job.Stderr += string(p)
 	}
 
-	if err := c.context.UpdateJob(c.job); err != nil {
-		return 0, err
-	}
-
 	return len(p), nil
 }
 
+// maxRetryBackoff caps the delay between attempts at running a restartable job.
+const maxRetryBackoff = time.Hour
+
+// outputBlockSize is the number of output sequence numbers reserved for each attempt at running a
+// job, so that the output of a retry follows the output of the attempts before it.
+const outputBlockSize = 1 << 24
+
+// outputBlock returns the first output sequence number available to a job's next attempt.
+func outputBlock(job *SubmittedJob) int {
+	return len(job.Attempts) * outputBlockSize
+}
+
+// lateOutput returns the first output sequence number available to output that's written on behalf
+// of an interrupted attempt, after anything that the attempt wrote itself.
+func lateOutput(job *SubmittedJob) int {
+	return outputBlock(job) + outputBlockSize/2
+}
+
 // Runner is the main entry point for the job runner goroutine.
 func Runner(c *Context) {
+	if err := Recover(c); err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Unable to recover processing jobs.")
+	}
+
 	for {
-		Claim(c)
+		if err := FireSchedules(c, time.Now()); err != nil {
+			log.WithFields(log.Fields{"error": err}).Error("Unable to fire schedules.")
+		}
+		if err := ReleaseScheduledJobs(c, time.Now()); err != nil {
+			log.WithFields(log.Fields{"error": err}).Error("Unable to release scheduled jobs.")
+		}
+		if err := ReleaseWaitingJobs(c, time.Now()); err != nil {
+			log.WithFields(log.Fields{"error": err}).Error("Unable to release waiting jobs.")
+		}
+
+		// Claim everything that can be claimed before going back to sleep.
+		c.Scheduler.Begin()
+		for Claim(c) {
+		}
 
-		time.Sleep(time.Duration(c.Poll) * time.Millisecond)
+		c.Wakeup.Wait(time.Duration(c.Poll) * time.Millisecond)
 	}
 }
 
-// Claim acquires the oldest single pending job and launches a goroutine to execute its command in
-// a new container.
-func Claim(c *Context) {
-	job, err := c.ClaimJob()
+// Claim acquires the single pending job chosen by the Scheduler and launches a goroutine to execute
+// its command in a new container. Nothing is claimed while the worker pool is full or draining, and
+// jobs of the core types that every Docker host is too busy to run are passed over. It returns true
+// if a job was taken from the queue, in which case there may be more to claim.
+func Claim(c *Context) bool {
+	if !c.Pool.Reserve() {
+		log.WithFields(log.Fields{
+			"workers": c.Workers,
+		}).Debug("Worker pool is full or draining.")
+		return false
+	}
+
+	job, err := c.Scheduler.Claim(c, c.fullCores())
 	if err != nil {
+		c.Pool.Unreserve()
 		log.WithFields(log.Fields{"error": err}).Error("Unable to claim a job.")
-		return
+		return false
 	}
 	if job == nil {
 		// Nothing to claim.
-		return
+		c.Pool.Unreserve()
+		return false
 	}
 	if err := job.Validate(); err != nil {
 		fields := log.Fields{
@@ -80,234 +136,449 @@ func Claim(c *Context) {
 		log.WithFields(fields).Error("Invalid job in queue.")
 
 		job.Status = StatusError
+		job.FinishedAt = StoreTime(time.Now())
 		if err := c.UpdateJob(job); err != nil {
 			fields["error"] = err
 			log.WithFields(fields).Error("Unable to update job status.")
 		}
 
-		return
-	}
-
-	go Execute(c, job)
-}
-
-// Execute launches a container to process the submitted job. It passes any provided stdin data
-// to the container and consumes stdout and stderr, updating Mongo as it runs. Once completed, it
-// acquires the job's result from its configured source and marks the job as finished.
-func Execute(c *Context, job *SubmittedJob) {
-	defaultFields := log.Fields{
-		"jid":     job.JID,
-		"account": job.Account,
+		c.Pool.Unreserve()
+		return true
 	}
 
-	// Logging utility messages.
-	debug := func(message string) {
-		log.WithFields(defaultFields).Debug(message)
+	// Place the job on a Docker host with room for its core type.
+	core := job.Core
+	if core == "" && c.Cores != nil {
+		core = c.Cores.Default
 	}
-	reportErr := func(message string, err error) {
-		fs := log.Fields{}
-		for k, v := range defaultFields {
-			fs[k] = v
+	host, err := c.Hosts.Place(core)
+	if err != nil {
+		fields := log.Fields{
+			"jid":     job.JID,
+			"account": job.Account,
+			"core":    core,
 		}
-		fs["err"] = err
-		log.WithFields(fs).Error(message)
-	}
-	checkErr := func(message string, err error) bool {
-		if err == nil {
-			debug(fmt.Sprintf("%s: ok", message))
+
+		if err == errHostsFull {
+			// The job stays at the front of the queue. It isn't new work, so other runners aren't
+			// woken for it.
+			log.WithFields(fields).Debug("Every Docker host for the job's core type is full. Queueing it again.")
+			if err := c.UnclaimJob(job.JID, Lease{Owner: job.Owner}); err != nil {
+				fields["error"] = err
+				log.WithFields(fields).Error("Unable to return the job to the queue.")
+			}
+
+			c.Pool.Unreserve()
 			return false
 		}
 
-		reportErr(fmt.Sprintf("%s: ERROR", message), err)
-		return true
-	}
-
-	// Update the job model in Mongo, reporting any errors along the way.
-	// This also updates our job model with any changes from Mongo, such as the kill request flag.
-	updateJob := func(message string) bool {
+		fields["error"] = err
+		log.WithFields(fields).Error("Unable to place the job on a Docker host.")
+		job.Status = StatusError
+		job.FinishedAt = StoreTime(time.Now())
 		if err := c.UpdateJob(job); err != nil {
-			reportErr(fmt.Sprintf("Unable to update the job's %s.", message), err)
-			return false
+			fields["error"] = err
+			log.WithFields(fields).Error("Unable to update job status.")
 		}
+
+		c.Pool.Unreserve()
 		return true
 	}
+	job.Host = host
 
-	log.WithFields(defaultFields).Info("Launching a job.")
+	c.Pool.Go(job, func() {
+		defer c.Hosts.Release(host)
 
-	job.StartedAt = StoreTime(time.Now())
-	job.QueueDelay = job.StartedAt.AsTime().Sub(job.CreatedAt.AsTime()).Nanoseconds()
+		Execute(c, job)
+	})
+	return true
+}
 
-	image := c.DefaultImage
-	if len(job.Layers) != 0 {
-		image = job.Layers[0].Name
-		if len(job.Layers) > 1 {
-			log.WithFields(log.Fields{
-				"layers": job.Layers,
-			}).Warn("More than one layer used")
-		}
+// fullCores lists the core types that every Docker host is too busy to run. Jobs that don't name a
+// core type run as the default one, so they're passed over along with it.
+func (c *Context) fullCores() []string {
+	if c.Cores == nil {
+		return c.Hosts.FullCores(nil)
+	}
 
+	full := c.Hosts.FullCores(c.Cores.Names())
+	if passedOver(c.Cores.Default, full) {
+		full = append(full, "")
 	}
+	return full
+}
 
-	container, err := c.CreateContainer(docker.CreateContainerOptions{
-		Name: job.ContainerName(),
-		Config: &docker.Config{
-			Image:     image,
-			Cmd:       []string{"/bin/bash", "-c", job.Command},
-			OpenStdin: true,
-			StdinOnce: true,
-		},
-	})
-	if checkErr("Created the job's container", err) {
-		job.Status = StatusError
-		updateJob("status")
-		return
+// MaxRuntime determines the wall-clock time that a job may run before it's killed, applying the
+// server's default and limit. Zero means that the job may run forever.
+func (c *Context) MaxRuntime(job *SubmittedJob) time.Duration {
+	seconds := job.MaxRuntime
+	if seconds <= 0 {
+		seconds = c.DefaultMaxRuntime
+	}
+	if c.RuntimeLimit > 0 && (seconds <= 0 || seconds > c.RuntimeLimit) {
+		seconds = c.RuntimeLimit
 	}
+	return time.Duration(seconds) * time.Second
+}
 
-	// Record the job's container ID.
-	job.ContainerID = container.ID
-	if !updateJob("start timestamp and container id") {
-		return
+// RetryBackoff determines how long a restartable job waits before it's queued again after its
+// attempts-th attempt fails. The delay starts at RetryBackoff seconds and doubles with each attempt,
+// up to maxRetryBackoff.
+func (c *Context) RetryBackoff(attempts int) time.Duration {
+	delay := time.Duration(c.Settings.RetryBackoff) * time.Second
+	for i := 1; i < attempts && delay < maxRetryBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryBackoff {
+		delay = maxRetryBackoff
 	}
+	return delay
+}
 
-	// Include container information in this job's logging messages.
-	defaultFields["container id"] = container.ID
-	defaultFields["container name"] = container.Name
+// execution tracks a single attempt at running a job within a container. It's shared by Execute,
+// which launches new containers, and Recover, which adopts the containers left behind by a previous
+// job runner.
+type execution struct {
+	c       *Context
+	job     *SubmittedJob
+	attempt JobAttempt
 
-	// Was a kill requested between the time the job was claimed, and the time the container was
-	// created? If so: transition the job to StatusKilled and jump ahead to removing the container
-	// we just created. If not: continue with job execution normally.
+	// docker is the client for the Docker host that the job was placed on.
+	docker Docker
 
-	// If a kill was requested before the job was claimed, it would have been removed from the queue.
-	// If a kill is requested after the container was created, it will have the containerID that we
-	// just sent and be able to kill the running container.
+	container *docker.Container
+	stdout    *OutputCollector
+	stderr    *OutputCollector
 
-	if job.KillRequested {
-		job.Status = StatusKilled
-	} else {
-		// Prepare the input and output streams.
-		stdin := bytes.NewReader(job.Stdin)
-		stdout := OutputCollector{
+	// launched is the moment that the container started, from which the job's runtime is measured.
+	launched time.Time
+
+	// recovered is set when the container outlived a previous job runner. Some of its output may have
+	// been written while nobody was listening.
+	recovered bool
+
+	// abandoned is set atomically once another runner takes over the job after its lease lapses.
+	abandoned int32
+
+	fields log.Fields
+}
+
+// newExecution prepares to track an attempt at running a job. Each attempt numbers its output after
+// the attempts before it.
+func newExecution(c *Context, job *SubmittedJob) *execution {
+	// Heartbeats renew the job's lease directly in storage. Drop it from this copy of the job, so
+	// that updating the job never rolls the lease back.
+	job.Owner, job.LeaseExpires = "", 0
+
+	e := &execution{
+		c:       c,
+		job:     job,
+		attempt: JobAttempt{StartedAt: StoreTime(time.Now())},
+		docker:  c.DockerFor(job.Host),
+		stdout: &OutputCollector{
 			context:  c,
 			job:      job,
 			isStdout: true,
-		}
-		stderr := OutputCollector{
+			sequence: outputBlock(job),
+		},
+		stderr: &OutputCollector{
 			context:  c,
 			job:      job,
 			isStdout: false,
-		}
+			sequence: outputBlock(job),
+		},
+		fields: log.Fields{
+			"jid":     job.JID,
+			"account": job.Account,
+		},
+	}
+	if job.Host != "" {
+		e.fields["host"] = job.Host
+	}
+	return e
+}
 
-		go func() {
-			err = c.AttachToContainer(docker.AttachToContainerOptions{
-				Container:    container.ID,
-				Stream:       true,
-				InputStream:  stdin,
-				OutputStream: stdout,
-				ErrorStream:  stderr,
-				Stdin:        true,
-				Stdout:       true,
-				Stderr:       true,
-			})
-			checkErr("Attached to the container", err)
-		}()
-
-		// Start the created container.
-		err = c.StartContainer(container.ID, &docker.HostConfig{})
-		if checkErr("Started the container", err) {
-			job.Status = StatusError
-			updateJob("status")
-			return
+// Logging utility messages.
+func (e *execution) debug(message string) {
+	log.WithFields(e.fields).Debug(message)
+}
+
+func (e *execution) reportErr(message string, err error) {
+	log.WithFields(e.fields).WithField("err", err).Error(message)
+}
+
+func (e *execution) checkErr(message string, err error) bool {
+	if err == nil {
+		e.debug(fmt.Sprintf("%s: ok", message))
+		return false
+	}
+
+	e.reportErr(fmt.Sprintf("%s: ERROR", message), err)
+	return true
+}
+
+// updateJob updates the job model in storage, reporting any errors along the way.
+// This also updates our job model with any changes from storage, such as the kill request flag.
+func (e *execution) updateJob(message string) bool {
+	if atomic.LoadInt32(&e.abandoned) == 1 {
+		e.debug(fmt.Sprintf("Left the job's %s to the runner that took it over.", message))
+		return false
+	}
+
+	if err := e.c.UpdateJob(e.job); err != nil {
+		e.reportErr(fmt.Sprintf("Unable to update the job's %s.", message), err)
+		return false
+	}
+	return true
+}
+
+// adopt records the container that runs this attempt.
+func (e *execution) adopt(container *docker.Container) {
+	e.container = container
+	e.job.ContainerID = container.ID
+
+	// Include container information in this job's logging messages.
+	e.fields["container id"] = container.ID
+	e.fields["container name"] = container.Name
+}
+
+// fail cuts the attempt short after a failure. Restartable jobs wait out a backoff and are queued
+// again until they run out of attempts, unless the failure isn't transient; other jobs fail.
+func (e *execution) fail(cause error) {
+	c, job := e.c, e.job
+
+	now := time.Now()
+	e.attempt.FinishedAt = StoreTime(now)
+	e.attempt.Error = cause.Error()
+	job.Attempts = append(job.Attempts, e.attempt)
+
+	if e.container != nil {
+		err := e.docker.RemoveContainer(docker.RemoveContainerOptions{ID: e.container.ID, Force: true})
+		e.checkErr("Removed the container", err)
+	}
+
+	killed, err := c.JobKillRequested(job.JID)
+	e.checkErr("Checked the job kill status", err)
+
+	if job.Restartable && !killed && transient(cause) && len(job.Attempts) < c.MaxAttempts {
+		delay := c.RetryBackoff(len(job.Attempts))
+		fmt.Fprintf(e.stderr, "Attempt %d failed: %v. Retrying in %v.\n", len(job.Attempts), cause, delay)
+
+		job.Status = StatusWaiting
+		job.RetryAt = StoreTime(now.Add(delay))
+	} else if killed {
+		job.Status = StatusKilled
+		job.FinishedAt = StoreTime(now)
+	} else {
+		job.Status = StatusError
+		job.FinishedAt = StoreTime(now)
+	}
+	e.updateJob("status")
+
+	log.WithFields(e.fields).WithFields(log.Fields{
+		"attempts": len(job.Attempts),
+		"status":   job.Status,
+	}).Info("Job attempt failed.")
+}
+
+// transient reports whether an attempt that failed with err might succeed if it's tried again. Jobs
+// that the server itself rejects, like those with an unknown core type, fail the same way each time,
+// as do requests that Docker refuses with a client error. Connection failures and Docker's own
+// server errors may clear up.
+func transient(err error) bool {
+	switch err := err.(type) {
+	case *APIError:
+		return false
+	case *docker.Error:
+		return err.Status >= 500
+	}
+	return err != docker.ErrNoSuchImage
+}
+
+// attach streams the container's output into the job in the background, feeding it stdin if any is
+// provided.
+func (e *execution) attach(stdin io.Reader) {
+	go func() {
+		err := e.docker.AttachToContainer(docker.AttachToContainerOptions{
+			Container:    e.container.ID,
+			Stream:       true,
+			InputStream:  stdin,
+			OutputStream: e.stdout,
+			ErrorStream:  e.stderr,
+			Stdin:        stdin != nil,
+			Stdout:       true,
+			Stderr:       true,
+		})
+		e.checkErr("Attached to the container", err)
+	}()
+}
+
+// wait blocks until the container exits, killing it if it outlives the job's maximum runtime, and
+// completes the job.
+func (e *execution) wait() {
+	c, job := e.c, e.job
+
+	// Kill the container if it outlives its maximum runtime.
+	var timedOut int32
+	if maxRuntime := c.MaxRuntime(job); maxRuntime > 0 {
+		timer := time.AfterFunc(maxRuntime-time.Since(e.launched), func() {
+			atomic.StoreInt32(&timedOut, 1)
+
+			log.WithFields(e.fields).WithField("max runtime", maxRuntime).Info("Killing an overdue job.")
+			err := e.docker.KillContainer(docker.KillContainerOptions{ID: e.container.ID})
+			e.checkErr("Killed the overdue container", err)
+		})
+		defer timer.Stop()
+	}
+
+	status, err := e.docker.WaitContainer(e.container.ID)
+	if atomic.LoadInt32(&e.abandoned) == 1 {
+		err := e.docker.RemoveContainer(docker.RemoveContainerOptions{ID: e.container.ID, Force: true})
+		e.checkErr("Removed the abandoned container", err)
+		return
+	}
+	if e.checkErr("Waited for the container to complete", err) {
+		e.fail(err)
+		return
+	}
+
+	e.complete(status, atomic.LoadInt32(&timedOut) == 1, time.Now())
+}
+
+// complete records the outcome of a container that exited with the given status code at finishedAt.
+// Successful jobs have their result extracted and stored.
+func (e *execution) complete(status int, timedOut bool, finishedAt time.Time) {
+	c, job := e.c, e.job
+
+	job.FinishedAt = StoreTime(finishedAt)
+	job.Runtime = finishedAt.Sub(e.launched).Nanoseconds()
+	if status == 0 {
+		// Successful termination.
+		job.Status = StatusDone
+
+		// Extract the result from the job.
+		if job.ResultSource == "stdout" {
+			e.resultFromStdout()
+		} else if strings.HasPrefix(job.ResultSource, "file:") {
+			e.resultFromFile(job.ResultSource[len("file:"):len(job.ResultSource)])
 		}
 
-		// Measure the container-launch overhead here.
-		overhead := time.Now()
-		job.OverheadDelay = overhead.Sub(job.StartedAt.AsTime()).Nanoseconds()
-		updateJob("overhead delay")
+		// Move the result out of the job document and into the result store.
+		if job.Status == StatusDone {
+			err := StoreResult(c, job)
+			if e.checkErr("Stored the job's result", err) {
+				job.Status = StatusError
+			}
+		}
+	} else {
+		// Something went wrong.
 
-		status, err := c.WaitContainer(container.ID)
-		if checkErr("Waited for the container to complete", err) {
-			job.Status = StatusError
-			updateJob("status")
+		// See if the job was killed for running too long, or if a kill was explicitly requested.
+		// If so, transition to StatusTimedOut or StatusKilled. Otherwise, transition to StatusError.
+		killed, err := c.JobKillRequested(job.JID)
+		if err != nil {
+			e.reportErr("Check the job kill status: ERROR", err)
 			return
 		}
 
-		job.FinishedAt = StoreTime(time.Now())
-		job.Runtime = job.FinishedAt.AsTime().Sub(overhead).Nanoseconds()
-		if status == 0 {
-			// Successful termination.
-			job.Status = StatusDone
-
-			// Extract the result from the job.
-			if job.ResultSource == "stdout" {
-				job.Result = []byte(job.Stdout)
-				debug("Acquired job result from stdout: ok")
-			} else if strings.HasPrefix(job.ResultSource, "file:") {
-				resultPath := job.ResultSource[len("file:"):len(job.ResultSource)]
-
-				var resultBuffer bytes.Buffer
-				err = c.CopyFromContainer(docker.CopyFromContainerOptions{
-					Container:    container.ID,
-					Resource:     resultPath,
-					OutputStream: &resultBuffer,
-				})
-				if checkErr(fmt.Sprintf("Acquired the job's result from the file [%s]", resultPath), err) {
-					job.Status = StatusError
-				} else {
-					// CopyFromContainer returns the file contents as a tarball.
-					var content bytes.Buffer
-					r := bytes.NewReader(resultBuffer.Bytes())
-					tr := tar.NewReader(r)
-
-					for {
-						_, err := tr.Next()
-						if err == io.EOF {
-							break
-						}
-						if err != nil {
-							reportErr("Read tar-encoded content: ERROR", err)
-							job.Status = StatusError
-							break
-						}
-
-						if _, err = io.Copy(&content, tr); err != nil {
-							reportErr("Copy decoded content: ERROR", err)
-							job.Status = StatusError
-							break
-						}
-					}
-
-					job.Result = content.Bytes()
-				}
-			}
+		if timedOut {
+			job.Status = StatusTimedOut
+		} else if killed {
+			job.Status = StatusKilled
 		} else {
-			// Something went wrong.
-
-			// See if a kill was explicitly requested. If so, transition to StatusKilled. Otherwise,
-			// transition to StatusError.
-			killed, err := c.JobKillRequested(job.JID)
-			if err != nil {
-				reportErr("Check the job kill status: ERROR", err)
-				return
-			}
+			job.Status = StatusError
+		}
+	}
 
-			if killed {
-				job.Status = StatusKilled
-			} else {
-				job.Status = StatusError
-			}
+	// Job execution has completed successfully.
+	e.finish()
+}
+
+// resultFromStdout uses the job's stdout as its result. A recovered container's stdout is replayed
+// from its logs, since the job runner wasn't listening to all of it.
+func (e *execution) resultFromStdout() {
+	job := e.job
+
+	if !e.recovered {
+		job.Result = []byte(job.Stdout)
+		e.debug("Acquired job result from stdout: ok")
+		return
+	}
+
+	var replay bytes.Buffer
+	err := e.docker.AttachToContainer(docker.AttachToContainerOptions{
+		Container:    e.container.ID,
+		Logs:         true,
+		OutputStream: &replay,
+		Stdout:       true,
+	})
+	if e.checkErr("Acquired job result from the container's stdout log", err) {
+		job.Status = StatusError
+		return
+	}
+	job.Result = replay.Bytes()
+}
+
+// resultFromFile copies the job's result from a file within its container.
+func (e *execution) resultFromFile(resultPath string) {
+	job := e.job
+
+	var resultBuffer bytes.Buffer
+	err := e.docker.CopyFromContainer(docker.CopyFromContainerOptions{
+		Container:    e.container.ID,
+		Resource:     resultPath,
+		OutputStream: &resultBuffer,
+	})
+	if e.checkErr(fmt.Sprintf("Acquired the job's result from the file [%s]", resultPath), err) {
+		job.Status = StatusError
+		return
+	}
+
+	// CopyFromContainer returns the file contents as a tarball.
+	var content bytes.Buffer
+	r := bytes.NewReader(resultBuffer.Bytes())
+	tr := tar.NewReader(r)
+
+	for {
+		_, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			e.reportErr("Read tar-encoded content: ERROR", err)
+			job.Status = StatusError
+			break
+		}
+
+		if _, err = io.Copy(&content, tr); err != nil {
+			e.reportErr("Copy decoded content: ERROR", err)
+			job.Status = StatusError
+			break
 		}
+	}
+
+	job.Result = content.Bytes()
+}
 
-		// Job execution has completed successfully.
+// finish records the attempt, removes the container, and saves the job's final state.
+func (e *execution) finish() {
+	c, job := e.c, e.job
+
+	now := StoreTime(time.Now())
+	e.attempt.FinishedAt = now
+	job.Attempts = append(job.Attempts, e.attempt)
+	if job.FinishedAt == 0 {
+		job.FinishedAt = now
 	}
 
-	err = c.RemoveContainer(docker.RemoveContainerOptions{ID: container.ID})
-	checkErr("Removed the container", err)
+	err := e.docker.RemoveContainer(docker.RemoveContainerOptions{ID: e.container.ID})
+	e.checkErr("Removed the container", err)
 
 	err = c.UpdateAccountUsage(job.Account, job.Runtime)
 	if err != nil {
-		reportErr("Update account usage: ERROR", err)
+		e.reportErr("Update account usage: ERROR", err)
 	}
-	updateJob("status and final result")
+	e.updateJob("status and final result")
 
 	log.WithFields(log.Fields{
 		"jid":      job.JID,
@@ -318,3 +589,101 @@ func Execute(c *Context, job *SubmittedJob) {
 		"queue":    job.QueueDelay,
 	}).Info("Job complete.")
 }
+
+// Execute launches a container to process the submitted job. It passes any provided stdin data
+// to the container and consumes stdout and stderr, updating Mongo as it runs. Once completed, it
+// acquires the job's result from its configured source and marks the job as finished.
+func Execute(c *Context, job *SubmittedJob) {
+	e := newExecution(c, job)
+
+	stop := e.startHeartbeat()
+	defer stop()
+
+	log.WithFields(e.fields).Info("Launching a job.")
+
+	job.StartedAt = e.attempt.StartedAt
+	// Scheduled jobs only start queueing once their time has come.
+	queuedAt := job.CreatedAt
+	if job.RunAt > queuedAt {
+		queuedAt = job.RunAt
+	}
+	job.QueueDelay = job.StartedAt.AsTime().Sub(queuedAt.AsTime()).Nanoseconds()
+
+	image := c.DefaultImage
+	if len(job.Layers) != 0 {
+		image = job.Layers[0].Name
+		if len(job.Layers) > 1 {
+			log.WithFields(log.Fields{
+				"layers": job.Layers,
+			}).Warn("More than one layer used")
+		}
+
+	}
+
+	config := &docker.Config{
+		Image:     image,
+		Cmd:       []string{"/bin/bash", "-c", job.Command},
+		Env:       job.Env(),
+		OpenStdin: true,
+		StdinOnce: true,
+	}
+
+	// Limit the container to the resources of the job's cores.
+	core, apiErr := c.Cores.Resolve(&job.Job)
+	if apiErr != nil {
+		e.reportErr("Resolved the job's core type: ERROR", apiErr)
+		e.fail(apiErr)
+		return
+	}
+	core.Constrain(config, job.Multicore)
+
+	if !e.ensureImage(image) {
+		return
+	}
+
+	container, err := e.docker.CreateContainer(docker.CreateContainerOptions{
+		Name:   job.ContainerName(),
+		Config: config,
+	})
+	if e.checkErr("Created the job's container", err) {
+		e.fail(err)
+		return
+	}
+
+	// Record the job's container ID.
+	e.adopt(container)
+	if !e.updateJob("start timestamp and container id") {
+		return
+	}
+
+	// Was a kill requested between the time the job was claimed, and the time the container was
+	// created? If so: transition the job to StatusKilled and jump ahead to removing the container
+	// we just created. If not: continue with job execution normally.
+
+	// If a kill was requested before the job was claimed, it would have been removed from the queue.
+	// If a kill is requested after the container was created, it will have the containerID that we
+	// just sent and be able to kill the running container.
+
+	if job.KillRequested {
+		job.Status = StatusKilled
+		e.finish()
+		return
+	}
+
+	// Prepare the input stream.
+	e.attach(bytes.NewReader(job.Stdin))
+
+	// Start the created container.
+	err = e.docker.StartContainer(container.ID, &docker.HostConfig{})
+	if e.checkErr("Started the container", err) {
+		e.fail(err)
+		return
+	}
+
+	// Measure the container-launch overhead here.
+	e.launched = time.Now()
+	job.OverheadDelay = e.launched.Sub(job.StartedAt.AsTime()).Nanoseconds()
+	e.updateJob("overhead delay")
+
+	e.wait()
+}
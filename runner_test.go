@@ -0,0 +1,660 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// ScriptedDocker is a fake Docker implementation that "runs" every container by writing fixed
+// output to its attached streams and exiting with a fixed status.
+type ScriptedDocker struct {
+	NullDocker
+
+	Stdout   string
+	Stderr   string
+	Status   int
+	Created  docker.CreateContainerOptions
+	attached chan struct{}
+}
+
+func (d *ScriptedDocker) CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error) {
+	d.Created = opts
+	d.attached = make(chan struct{})
+	return &docker.Container{ID: "c0ffee", Name: opts.Name}, nil
+}
+
+func (d *ScriptedDocker) AttachToContainer(opts docker.AttachToContainerOptions) error {
+	defer close(d.attached)
+
+	if d.Stdout != "" {
+		opts.OutputStream.Write([]byte(d.Stdout))
+	}
+	if d.Stderr != "" {
+		opts.ErrorStream.Write([]byte(d.Stderr))
+	}
+	return nil
+}
+
+func (d *ScriptedDocker) WaitContainer(id string) (int, error) {
+	<-d.attached
+	return d.Status, nil
+}
+
+func TestRunJobInMemory(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{
+		Settings: Settings{DefaultImage: "cloudpipe/runner-py2"},
+		Storage:  s,
+		Docker:   &ScriptedDocker{Stdout: "hello\n", Stderr: "warning\n"},
+		Results:  NewMemoryBlobStore(),
+		Cores:    DefaultCoreCatalog(),
+	}
+	s.GetAccount("admin")
+
+	jid, err := s.InsertJob(SubmittedJob{
+		Job: Job{
+			Command:      "echo hello",
+			ResultSource: "stdout",
+			ResultType:   ResultBinary,
+		},
+		Account: "admin",
+		Status:  StatusQueued,
+	})
+	if err != nil {
+		t.Fatalf("Unable to insert a job: %v", err)
+	}
+
+	job, err := s.ClaimJob(Lease{}, nil)
+	if err != nil || job == nil {
+		t.Fatalf("Unable to claim a job: %v", err)
+	}
+	Execute(c, job)
+
+	jobs, err := s.ListJobs(JobQuery{JIDs: []uint64{jid}})
+	if err != nil {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+	finished := jobs[0]
+
+	if finished.Status != StatusDone {
+		t.Errorf("Expected the job to be done, was [%s]", finished.Status)
+	}
+	if finished.Stdout != "hello\n" {
+		t.Errorf("Unexpected stdout: [%s]", finished.Stdout)
+	}
+	if finished.Stderr != "warning\n" {
+		t.Errorf("Unexpected stderr: [%s]", finished.Stderr)
+	}
+	if len(finished.Result) != 0 {
+		t.Errorf("Expected the result to be kept out of the job document, got [%s]", finished.Result)
+	}
+	if err := LoadResult(c, &finished); err != nil {
+		t.Fatalf("Unable to load the job's result: %v", err)
+	}
+	if string(finished.Result) != "hello\n" {
+		t.Errorf("Unexpected result: [%s]", finished.Result)
+	}
+	if finished.ContainerID != "c0ffee" {
+		t.Errorf("Unexpected container ID: [%s]", finished.ContainerID)
+	}
+
+	account, err := s.GetAccount("admin")
+	if err != nil {
+		t.Fatalf("Unable to get account: %v", err)
+	}
+	if account.TotalJobs != 1 {
+		t.Errorf("Expected the account's usage to be updated, got [%d] jobs", account.TotalJobs)
+	}
+}
+
+func TestRunJobWithCoreLimits(t *testing.T) {
+	s := NewMemoryStorage()
+	d := &ScriptedDocker{}
+	c := &Context{
+		Storage: s,
+		Docker:  d,
+		Results: NewMemoryBlobStore(),
+		Cores:   DefaultCoreCatalog(),
+	}
+
+	jid, _ := s.InsertJob(SubmittedJob{
+		Job: Job{
+			Command:      "true",
+			Core:         "c2",
+			Multicore:    2,
+			ResultSource: "stdout",
+			ResultType:   ResultBinary,
+			Environment:  map[string]string{"B": "2", "A": "1"},
+		},
+		Account: "admin",
+		Status:  StatusQueued,
+	})
+
+	job, err := s.ClaimJob(Lease{}, nil)
+	if err != nil || job == nil {
+		t.Fatalf("Unable to claim a job: %v", err)
+	}
+	Execute(c, job)
+
+	config := d.Created.Config
+	if config.CPUShares != 2048 || config.Memory != 8<<30 || config.MemorySwap != 8<<30 {
+		t.Errorf("Unexpected container limits: %#v", config)
+	}
+	if len(config.Env) != 2 || config.Env[0] != "A=1" || config.Env[1] != "B=2" {
+		t.Errorf("Expected the job's environment to be passed to the container, got %v", config.Env)
+	}
+
+	// Jobs with a core type that's no longer in the catalog fail, even if they're restartable.
+	c.Cores.Types = map[string]CoreType{"c1": c.Cores.Types["c1"]}
+	c.MaxAttempts = 5
+	job.Restartable = true
+	job.Status = StatusQueued
+	s.UpdateJob(job)
+	job, _ = s.ClaimJob(Lease{}, nil)
+	Execute(c, job)
+
+	jobs, _ := s.ListJobs(JobQuery{JIDs: []uint64{jid}})
+	if jobs[0].Status != StatusError || jobs[0].FinishedAt == 0 {
+		t.Errorf("Expected the job to fail, was [%s] finishing at [%d]", jobs[0].Status, jobs[0].FinishedAt)
+	}
+	if attempts := jobs[0].Attempts; len(attempts) != 2 || attempts[1].Error == "" {
+		t.Errorf("Expected the failed attempt to be recorded, got %#v", attempts)
+	}
+}
+
+func TestRunFailingJobInMemory(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{
+		Storage:   s,
+		Docker:    &ScriptedDocker{Stderr: "boom\n", Status: 1},
+		Results:   NewMemoryBlobStore(),
+		Cores:     DefaultCoreCatalog(),
+		Pool:      NewWorkerPool(1),
+		Scheduler: FIFOScheduler{},
+	}
+
+	jid, _ := s.InsertJob(SubmittedJob{
+		Job:     Job{Command: "false", ResultSource: "stdout", ResultType: ResultBinary},
+		Account: "admin",
+		Status:  StatusQueued,
+	})
+
+	Claim(c)
+
+	// Claim launches Execute in a goroutine, so wait for the job to finish.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the job to finish")
+		}
+
+		jobs, err := s.ListJobs(JobQuery{JIDs: []uint64{jid}})
+		if err != nil {
+			t.Fatalf("Unable to list jobs: %v", err)
+		}
+		if completedStatus[jobs[0].Status] {
+			if jobs[0].Status != StatusError {
+				t.Errorf("Expected the job to fail, was [%s]", jobs[0].Status)
+			}
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// HangingDocker is a fake Docker implementation whose containers write their output and then run
+// until they're killed. It only supports a single container.
+type HangingDocker struct {
+	ScriptedDocker
+
+	killed chan struct{}
+}
+
+func NewHangingDocker(stdout string) *HangingDocker {
+	return &HangingDocker{
+		ScriptedDocker: ScriptedDocker{Stdout: stdout},
+		killed:         make(chan struct{}),
+	}
+}
+
+func (d *HangingDocker) WaitContainer(id string) (int, error) {
+	<-d.attached
+	<-d.killed
+	return 137, nil
+}
+
+func (d *HangingDocker) KillContainer(opts docker.KillContainerOptions) error {
+	close(d.killed)
+	return nil
+}
+
+func TestMaxRuntime(t *testing.T) {
+	c := &Context{}
+	job := &SubmittedJob{}
+
+	if runtime := c.MaxRuntime(job); runtime != 0 {
+		t.Errorf("Expected no limit by default, got [%v]", runtime)
+	}
+
+	job.MaxRuntime = 30
+	if runtime := c.MaxRuntime(job); runtime != 30*time.Second {
+		t.Errorf("Expected the job's own limit, got [%v]", runtime)
+	}
+
+	c.RuntimeLimit = 10
+	if runtime := c.MaxRuntime(job); runtime != 10*time.Second {
+		t.Errorf("Expected the job's limit to be capped, got [%v]", runtime)
+	}
+
+	job.MaxRuntime = 0
+	c.DefaultMaxRuntime = 5
+	if runtime := c.MaxRuntime(job); runtime != 5*time.Second {
+		t.Errorf("Expected the server default, got [%v]", runtime)
+	}
+
+	c.DefaultMaxRuntime = 0
+	if runtime := c.MaxRuntime(job); runtime != 10*time.Second {
+		t.Errorf("Expected unlimited jobs to be capped, got [%v]", runtime)
+	}
+}
+
+func TestRunOverdueJobInMemory(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{
+		Storage: s,
+		Docker:  NewHangingDocker("working\n"),
+		Results: NewMemoryBlobStore(),
+		Cores:   DefaultCoreCatalog(),
+	}
+	s.GetAccount("admin")
+
+	jid, _ := s.InsertJob(SubmittedJob{
+		Job: Job{
+			Command:      "sleep 1000",
+			ResultSource: "stdout",
+			ResultType:   ResultBinary,
+			MaxRuntime:   1,
+		},
+		Account: "admin",
+		Status:  StatusQueued,
+	})
+
+	job, err := s.ClaimJob(Lease{}, nil)
+	if err != nil || job == nil {
+		t.Fatalf("Unable to claim a job: %v", err)
+	}
+	Execute(c, job)
+
+	jobs, err := s.ListJobs(JobQuery{JIDs: []uint64{jid}})
+	if err != nil {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+	finished := jobs[0]
+
+	if finished.Status != StatusTimedOut {
+		t.Errorf("Expected the job to time out, was [%s]", finished.Status)
+	}
+	if finished.Stdout != "working\n" {
+		t.Errorf("Unexpected stdout: [%s]", finished.Stdout)
+	}
+	if finished.Runtime < int64(time.Second) || finished.FinishedAt == 0 {
+		t.Errorf("Expected the job's runtime to be recorded, got [%d]", finished.Runtime)
+	}
+
+	account, _ := s.GetAccount("admin")
+	if account.TotalJobs != 1 || account.TotalRuntime != finished.Runtime {
+		t.Errorf("Expected the account's usage to be updated, got %#v", account)
+	}
+}
+
+func TestClaimStopsWhenPoolIsFull(t *testing.T) {
+	s := NewMemoryStorage()
+	d := NewHangingDocker("")
+	c := &Context{
+		Storage:   s,
+		Docker:    d,
+		Results:   NewMemoryBlobStore(),
+		Cores:     DefaultCoreCatalog(),
+		Pool:      NewWorkerPool(1),
+		Scheduler: FIFOScheduler{},
+	}
+
+	job := SubmittedJob{
+		Job:     Job{Command: "sleep 1000", ResultSource: "stdout", ResultType: ResultBinary},
+		Account: "admin",
+		Status:  StatusQueued,
+	}
+	jids := insertJobs(t, s, job, job)
+
+	if !Claim(c) {
+		t.Error("Expected the first job to be claimed")
+	}
+	if Claim(c) {
+		t.Error("Expected nothing to be claimed while the pool is full")
+	}
+
+	expectJIDs(t, s, JobQuery{Statuses: []string{StatusQueued}}, jids[1])
+	if stats := c.Pool.Stats(); stats.Busy != 1 {
+		t.Errorf("Expected one busy worker, got %#v", stats)
+	}
+
+	// Once the running job finishes, its slot is free for the next one.
+	deadline := time.Now().Add(5 * time.Second)
+	for len(c.Pool.Stats().Running) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the job to start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	d.KillContainer(docker.KillContainerOptions{})
+
+	for c.Pool.Stats().Busy != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the job to finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	Claim(c)
+	expectJIDs(t, s, JobQuery{Statuses: []string{StatusQueued}})
+}
+
+// RefusingDocker is a fake Docker implementation that fails to create any containers.
+type RefusingDocker struct {
+	NullDocker
+}
+
+func (d RefusingDocker) CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error) {
+	return nil, errors.New("no room at the inn")
+}
+
+func TestClaimDrainsQueueInMemory(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{
+		Storage:   s,
+		Docker:    RefusingDocker{},
+		Results:   NewMemoryBlobStore(),
+		Cores:     DefaultCoreCatalog(),
+		Pool:      NewWorkerPool(4),
+		Scheduler: FIFOScheduler{},
+	}
+
+	job := SubmittedJob{
+		Job:     Job{Command: "true", ResultSource: "stdout", ResultType: ResultBinary},
+		Account: "admin",
+		Status:  StatusQueued,
+	}
+	jids := insertJobs(t, s, job, job, job)
+
+	claimed := 0
+	for Claim(c) {
+		claimed++
+	}
+
+	if claimed != 3 {
+		t.Errorf("Expected every queued job to be claimed at once, claimed [%d]", claimed)
+	}
+	expectJIDs(t, s, JobQuery{Statuses: []string{StatusQueued}})
+	awaitCompletion(t, s, jids...)
+}
+
+func TestClaimRejectsInvalidJobInMemory(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{
+		Storage:   s,
+		Docker:    NullDocker{},
+		Results:   NewMemoryBlobStore(),
+		Cores:     DefaultCoreCatalog(),
+		Pool:      NewWorkerPool(1),
+		Scheduler: FIFOScheduler{},
+	}
+
+	jids := insertJobs(t, s, SubmittedJob{
+		Job:     Job{ResultSource: "stdout", ResultType: ResultBinary},
+		Account: "admin",
+		Status:  StatusQueued,
+	})
+
+	if !Claim(c) {
+		t.Fatal("Expected the invalid job to be claimed")
+	}
+
+	jobs, err := s.ListJobs(JobQuery{JIDs: jids})
+	if err != nil || len(jobs) != 1 {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+	if jobs[0].Status != StatusError {
+		t.Errorf("Expected the invalid job to be in error, was [%s]", jobs[0].Status)
+	}
+	if jobs[0].FinishedAt == 0 {
+		t.Error("Expected the invalid job to record when it finished")
+	}
+}
+
+func TestKillBeforeStartInMemory(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{
+		Storage: s,
+		Docker:  &ScriptedDocker{},
+		Results: NewMemoryBlobStore(),
+		Cores:   DefaultCoreCatalog(),
+	}
+
+	jids := insertJobs(t, s, SubmittedJob{
+		Job:     Job{Command: "true", ResultSource: "stdout", ResultType: ResultBinary},
+		Account: "admin",
+		Status:  StatusQueued,
+	})
+
+	job, err := s.ClaimJob(Lease{}, nil)
+	if err != nil || job == nil {
+		t.Fatalf("Unable to claim a job: %v", err)
+	}
+	job.KillRequested = true
+	Execute(c, job)
+
+	jobs, err := s.ListJobs(JobQuery{JIDs: jids})
+	if err != nil || len(jobs) != 1 {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+	if jobs[0].Status != StatusKilled {
+		t.Errorf("Expected the job to be killed, was [%s]", jobs[0].Status)
+	}
+	if jobs[0].FinishedAt == 0 {
+		t.Error("Expected the killed job to record when it finished")
+	}
+	if len(jobs[0].Attempts) != 1 {
+		t.Errorf("Expected one recorded attempt, got [%d]", len(jobs[0].Attempts))
+	}
+}
+
+// FlakyDocker is a ScriptedDocker that fails to create its first few containers, with cause or a
+// generic hiccup.
+type FlakyDocker struct {
+	*ScriptedDocker
+
+	failures int
+	cause    error
+}
+
+func (d *FlakyDocker) CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error) {
+	if d.failures > 0 {
+		d.failures--
+		if d.cause != nil {
+			return nil, d.cause
+		}
+		return nil, errors.New("docker hiccup")
+	}
+	return d.ScriptedDocker.CreateContainer(opts)
+}
+
+func TestTransientFailures(t *testing.T) {
+	for err, expected := range map[error]bool{
+		errors.New("connection refused"):                   true,
+		&docker.Error{Status: 500, Message: "oops"}:        true,
+		&docker.Error{Status: 400, Message: "bad request"}: false,
+		docker.ErrNoSuchImage:                              false,
+		&APIError{Code: CodeInvalidCore}:                   false,
+	} {
+		if actual := transient(err); actual != expected {
+			t.Errorf("Expected [%v] to be transient: [%v], got [%v]", err, expected, actual)
+		}
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	c := &Context{Settings: Settings{RetryBackoff: 10}}
+
+	for attempts, expected := range map[int]time.Duration{
+		1:  10 * time.Second,
+		2:  20 * time.Second,
+		3:  40 * time.Second,
+		20: time.Hour,
+	} {
+		if actual := c.RetryBackoff(attempts); actual != expected {
+			t.Errorf("Expected a backoff of [%v] after [%d] attempts, got [%v]", expected, attempts, actual)
+		}
+	}
+}
+
+func TestRetryRestartableJobInMemory(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{
+		Settings: Settings{MaxAttempts: 3, RetryBackoff: 10},
+		Storage:  s,
+		Docker:   &FlakyDocker{ScriptedDocker: &ScriptedDocker{Stdout: "hello\n"}, failures: 1},
+		Results:  NewMemoryBlobStore(),
+		Cores:    DefaultCoreCatalog(),
+	}
+	s.GetAccount("admin")
+
+	jids := insertJobs(t, s, SubmittedJob{
+		Job:     Job{Command: "echo hello", Restartable: true, ResultSource: "stdout", ResultType: ResultBinary},
+		Account: "admin",
+		Status:  StatusQueued,
+	})
+
+	job, err := s.ClaimJob(Lease{}, nil)
+	if err != nil || job == nil {
+		t.Fatalf("Unable to claim a job: %v", err)
+	}
+	before := time.Now()
+	Execute(c, job)
+
+	jobs, err := s.ListJobs(JobQuery{JIDs: jids})
+	if err != nil {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+	retrying := jobs[0]
+
+	if retrying.Status != StatusWaiting {
+		t.Errorf("Expected the job to wait for a retry, was [%s]", retrying.Status)
+	}
+	if len(retrying.Attempts) != 1 || retrying.Attempts[0].Error != "docker hiccup" {
+		t.Errorf("Expected the failed attempt to be recorded, got %#v", retrying.Attempts)
+	}
+	if retrying.RetryAt.AsTime().Before(before.Add(10 * time.Second)) {
+		t.Errorf("Expected the retry to be delayed by the backoff, got [%v]", retrying.RetryAt.AsTime())
+	}
+	if retrying.Stderr != "Attempt 1 failed: docker hiccup. Retrying in 10s.\n" {
+		t.Errorf("Unexpected stderr: [%s]", retrying.Stderr)
+	}
+
+	if err := ReleaseWaitingJobs(c, time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("Unable to release waiting jobs: %v", err)
+	}
+	job, err = s.ClaimJob(Lease{}, nil)
+	if err != nil || job == nil {
+		t.Fatalf("Unable to claim the retried job: %v", err)
+	}
+	Execute(c, job)
+
+	jobs, err = s.ListJobs(JobQuery{JIDs: jids})
+	if err != nil {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+	finished := jobs[0]
+
+	if finished.Status != StatusDone {
+		t.Errorf("Expected the retried job to be done, was [%s]", finished.Status)
+	}
+	if len(finished.Attempts) != 2 || finished.Attempts[1].Error != "" || finished.Attempts[1].FinishedAt == 0 {
+		t.Errorf("Expected both attempts to be recorded, got %#v", finished.Attempts)
+	}
+	if finished.Stdout != "hello\n" {
+		t.Errorf("Unexpected stdout: [%s]", finished.Stdout)
+	}
+}
+
+func TestNoRetriesInMemory(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{
+		Settings: Settings{MaxAttempts: 1, RetryBackoff: 10},
+		Storage:  s,
+		Docker:   &FlakyDocker{ScriptedDocker: &ScriptedDocker{}, failures: 2},
+		Results:  NewMemoryBlobStore(),
+		Cores:    DefaultCoreCatalog(),
+	}
+
+	// One job isn't restartable, and the other has no attempts left.
+	jids := insertJobs(t, s,
+		SubmittedJob{Job: Job{Command: "id", ResultSource: "stdout", ResultType: ResultBinary}, Status: StatusQueued},
+		SubmittedJob{Job: Job{Command: "id", Restartable: true, ResultSource: "stdout", ResultType: ResultBinary}, Status: StatusQueued},
+	)
+
+	for range jids {
+		job, err := s.ClaimJob(Lease{}, nil)
+		if err != nil || job == nil {
+			t.Fatalf("Unable to claim a job: %v", err)
+		}
+		Execute(c, job)
+	}
+
+	jobs, err := s.ListJobs(JobQuery{JIDs: jids})
+	if err != nil {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+	for _, job := range jobs {
+		if job.Status != StatusError || len(job.Attempts) != 1 || job.FinishedAt == 0 {
+			t.Errorf("Expected job [%d] to fail after one attempt, got %#v", job.JID, job)
+		}
+	}
+}
+
+func TestNoRetriesForRejectedContainersInMemory(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{
+		Settings: Settings{MaxAttempts: 3, RetryBackoff: 10},
+		Storage:  s,
+		Docker: &FlakyDocker{
+			ScriptedDocker: &ScriptedDocker{},
+			failures:       1,
+			cause:          &docker.Error{Status: 400, Message: "invalid environment"},
+		},
+		Results: NewMemoryBlobStore(),
+		Cores:   DefaultCoreCatalog(),
+	}
+
+	jids := insertJobs(t, s, SubmittedJob{
+		Job:     Job{Command: "id", Restartable: true, ResultSource: "stdout", ResultType: ResultBinary},
+		Account: "admin",
+		Status:  StatusQueued,
+	})
+
+	job, err := s.ClaimJob(Lease{}, nil)
+	if err != nil || job == nil {
+		t.Fatalf("Unable to claim a job: %v", err)
+	}
+	Execute(c, job)
+
+	jobs, err := s.ListJobs(JobQuery{JIDs: jids})
+	if err != nil {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+	if job := jobs[0]; job.Status != StatusError || len(job.Attempts) != 1 || job.FinishedAt == 0 {
+		t.Errorf("Expected the rejected job to fail after one attempt, got %#v", job)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Overlap policies decide what a Schedule does when its previous run is still going.
+const (
+	// OverlapSkip skips the new run. It's the default.
+	OverlapSkip = "skip"
+	// OverlapAllow submits the new run regardless.
+	OverlapAllow = "allow"
+	// OverlapReplace kills the previous run and submits the new one.
+	OverlapReplace = "replace"
+)
+
+var validOverlap = map[string]bool{
+	OverlapSkip:    true,
+	OverlapAllow:   true,
+	OverlapReplace: true,
+}
+
+// scheduleHistoryLimit is the number of runs that each Schedule remembers.
+const scheduleHistoryLimit = 100
+
+// Schedule submits a job built from its template every time its cron expression matches, in its
+// timezone, on behalf of the account that created it.
+type Schedule struct {
+	ID       uint64 `json:"id" bson:"_id"`
+	Account  string `json:"-" bson:"account"`
+	Name     string `json:"name,omitempty" bson:"name,omitempty"`
+	Cron     string `json:"cron" bson:"cron"`
+	Timezone string `json:"timezone" bson:"timezone"`
+	Overlap  string `json:"overlap" bson:"overlap"`
+	Job      Job    `json:"job" bson:"job"`
+
+	// NextRunAt is zero while the schedule is paused.
+	Paused    bool       `json:"paused" bson:"paused"`
+	CreatedAt StoredTime `json:"created_at" bson:"created_at"`
+	NextRunAt StoredTime `json:"next_run_at,omitempty" bson:"next_run_at"`
+
+	// Runs holds the most recent runs, oldest first. It's only shipped with a schedule's history.
+	Runs []ScheduleRun `json:"-" bson:"runs,omitempty"`
+}
+
+// ScheduleRun records a single time that a Schedule came due. JID is the job that it submitted, if
+// any; a run that was skipped because of the overlap policy says why instead.
+type ScheduleRun struct {
+	ScheduledAt StoredTime `json:"scheduled_at" bson:"scheduled_at"`
+	JID         uint64     `json:"jid,omitempty" bson:"jid,omitempty"`
+	Skipped     string     `json:"skipped,omitempty" bson:"skipped,omitempty"`
+	Replaced    uint64     `json:"replaced,omitempty" bson:"replaced,omitempty"`
+
+	// Status is the current status of the submitted job. It's looked up when the history is listed.
+	Status string `json:"status,omitempty" bson:"-"`
+}
+
+// record appends a run to the schedule's history, forgetting the oldest runs beyond the limit.
+func (s *Schedule) record(run ScheduleRun) {
+	s.Runs = append(s.Runs, run)
+	if extra := len(s.Runs) - scheduleHistoryLimit; extra > 0 {
+		s.Runs = s.Runs[extra:]
+	}
+}
+
+// Validate ensures that the schedule's cron expression, timezone and overlap policy are usable.
+// The job template is validated separately, like any other submitted job.
+func (s *Schedule) Validate() *APIError {
+	if _, err := ParseCron(s.Cron); err != nil {
+		return &APIError{
+			Code:    CodeInvalidCron,
+			Message: fmt.Sprintf("Invalid cron expression: %v", err),
+			Hint:    `Use five fields, like "30 4 * * mon-fri", or a macro like "@daily".`,
+		}
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return &APIError{
+			Code:    CodeInvalidTimezone,
+			Message: fmt.Sprintf("Unknown timezone [%s]", s.Timezone),
+			Hint:    `Use an IANA timezone name, like "America/New_York" or "UTC".`,
+		}
+	}
+	if !validOverlap[s.Overlap] {
+		return &APIError{
+			Code:    CodeInvalidOverlap,
+			Message: fmt.Sprintf("Invalid overlap policy [%s]", s.Overlap),
+			Hint:    `The "overlap" must be "skip", "allow" or "replace".`,
+		}
+	}
+	if s.Job.DependsOn != nil || s.Job.RunAt != 0 {
+		return &APIError{
+			Code:    CodeInvalidScheduleJob,
+			Message: "Scheduled jobs may not set depends_on or run_at.",
+			Hint:    "The schedule decides when its jobs run.",
+		}
+	}
+	return nil
+}
+
+// NextRun returns the first time after now that the schedule's cron expression matches in its
+// timezone. It returns zero if it never matches again.
+func (s *Schedule) NextRun(now time.Time) (StoredTime, error) {
+	spec, err := ParseCron(s.Cron)
+	if err != nil {
+		return 0, err
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return 0, err
+	}
+
+	next := spec.Next(now.In(loc))
+	if next.IsZero() {
+		return 0, nil
+	}
+	return StoreTime(next), nil
+}
+
+// FireSchedules runs every schedule that has come due. Each schedule advances to its next run
+// before its job is submitted, so that only one of several cloudpipe instances sharing the same
+// storage fires it. Runs that were missed while no runner was around aren't made up.
+func FireSchedules(c *Context, now time.Time) error {
+	schedules, err := c.ListSchedules("")
+	if err != nil {
+		return err
+	}
+
+	for i := range schedules {
+		schedule := &schedules[i]
+		if schedule.Paused || schedule.NextRunAt == 0 || schedule.NextRunAt.AsTime().After(now) {
+			continue
+		}
+
+		next, err := schedule.NextRun(now)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"schedule": schedule.ID,
+				"account":  schedule.Account,
+				"error":    err,
+			}).Error("Invalid schedule.")
+			continue
+		}
+
+		err = c.AdvanceSchedule(schedule.ID, schedule.NextRunAt, next)
+		if err == ErrNotFound {
+			// Another runner fired it first, or it was paused or deleted in the meantime.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		run, err := fireSchedule(c, schedule, now)
+		if err != nil {
+			return err
+		}
+		if err := c.RecordScheduleRun(schedule.ID, run); err != nil {
+			return err
+		}
+
+		log.WithFields(log.Fields{
+			"schedule": schedule.ID,
+			"account":  schedule.Account,
+			"jid":      run.JID,
+			"skipped":  run.Skipped,
+			"replaced": run.Replaced,
+		}).Info("Schedule fired.")
+	}
+
+	return nil
+}
+
+// fireSchedule applies a schedule's overlap policy and submits its job.
+func fireSchedule(c *Context, schedule *Schedule, now time.Time) (ScheduleRun, error) {
+	run := ScheduleRun{ScheduledAt: schedule.NextRunAt}
+
+	if schedule.Overlap != OverlapAllow {
+		previous, err := lastScheduledJob(c, schedule)
+		if err != nil {
+			return run, err
+		}
+
+		if previous != nil && !completedStatus[previous.Status] {
+			if schedule.Overlap == OverlapSkip {
+				run.Skipped = fmt.Sprintf("job [%d] from the previous run is still %s", previous.JID, previous.Status)
+				return run, nil
+			}
+
+			if _, err := KillJob(c, previous); err != nil {
+				return run, err
+			}
+			run.Replaced = previous.JID
+		}
+	}
+
+	jid, err := c.InsertJob(SubmittedJob{
+		Job:       schedule.Job,
+		CreatedAt: StoreTime(now),
+		Status:    StatusQueued,
+		Account:   schedule.Account,
+		Schedule:  schedule.ID,
+	})
+	if err != nil {
+		return run, err
+	}
+	run.JID = jid
+
+	c.Wakeup.Notify()
+	return run, nil
+}
+
+// lastScheduledJob loads the job that a schedule submitted most recently, or returns nil if it
+// hasn't submitted any that still exist.
+func lastScheduledJob(c *Context, schedule *Schedule) (*SubmittedJob, error) {
+	for i := len(schedule.Runs) - 1; i >= 0; i-- {
+		if schedule.Runs[i].JID == 0 {
+			continue
+		}
+
+		jobs, err := c.ListJobs(JobQuery{JIDs: []uint64{schedule.Runs[i].JID}, SkipOutput: true})
+		if err != nil || len(jobs) == 0 {
+			return nil, err
+		}
+		return &jobs[0], nil
+	}
+	return nil, nil
+}
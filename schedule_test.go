@@ -0,0 +1,145 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// insertSchedule stores a schedule that's due at a given time, and returns its ID.
+func insertSchedule(t *testing.T, s Storage, overlap string, due time.Time) uint64 {
+	id, err := s.InsertSchedule(Schedule{
+		Account:   "alice",
+		Cron:      "*/5 * * * *",
+		Timezone:  "UTC",
+		Overlap:   overlap,
+		Job:       Job{Command: "report", ResultSource: "stdout", ResultType: ResultBinary},
+		NextRunAt: StoreTime(due),
+	})
+	if err != nil {
+		t.Fatalf("Unable to insert a schedule: %v", err)
+	}
+	return id
+}
+
+// getSchedule loads a single schedule by ID.
+func getSchedule(t *testing.T, s Storage, id uint64) Schedule {
+	schedules, err := s.ListSchedules("")
+	if err != nil {
+		t.Fatalf("Unable to list schedules: %v", err)
+	}
+	for _, schedule := range schedules {
+		if schedule.ID == id {
+			return schedule
+		}
+	}
+	t.Fatalf("Unable to find schedule [%d]", id)
+	return Schedule{}
+}
+
+func TestFireSchedulesInMemory(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{Storage: s, Wakeup: NewWakeup()}
+
+	now := time.Date(2015, 6, 1, 4, 2, 0, 0, time.UTC)
+	due := insertSchedule(t, s, OverlapAllow, now.Add(-2*time.Minute))
+	later := insertSchedule(t, s, OverlapAllow, now.Add(time.Minute))
+	paused := insertSchedule(t, s, OverlapAllow, now.Add(-time.Minute))
+	if err := s.PauseSchedule(paused, true, 0); err != nil {
+		t.Fatalf("Unable to pause a schedule: %v", err)
+	}
+
+	if err := FireSchedules(c, now); err != nil {
+		t.Fatalf("Unable to fire schedules: %v", err)
+	}
+
+	jobs, err := s.ListJobs(JobQuery{})
+	if err != nil {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+	if len(jobs) != 1 {
+		t.Fatalf("Expected only the due schedule to fire, got %#v", jobs)
+	}
+	job := jobs[0]
+	if job.Account != "alice" || job.Status != StatusQueued || job.Schedule != due || job.Command != "report" {
+		t.Errorf("Unexpected scheduled job: %#v", job)
+	}
+	if !c.Wakeup.Wait(0) {
+		t.Error("Expected the job runner to be woken")
+	}
+
+	// The schedule moves on to the next match after now, rather than making up missed runs.
+	schedule := getSchedule(t, s, due)
+	if expected := StoreTime(time.Date(2015, 6, 1, 4, 5, 0, 0, time.UTC)); schedule.NextRunAt != expected {
+		t.Errorf("Expected the next run at [%s], got [%s]", expected.String(), schedule.NextRunAt.String())
+	}
+	if len(schedule.Runs) != 1 || schedule.Runs[0].JID != job.JID || schedule.Runs[0].ScheduledAt != StoreTime(now.Add(-2*time.Minute)) {
+		t.Errorf("Expected the run to be recorded, got %#v", schedule.Runs)
+	}
+
+	if getSchedule(t, s, later).Runs != nil || getSchedule(t, s, paused).Runs != nil {
+		t.Error("Expected schedules that aren't due to be left alone")
+	}
+
+	// Firing again before the next run does nothing.
+	if err := FireSchedules(c, now); err != nil {
+		t.Fatalf("Unable to fire schedules: %v", err)
+	}
+	if jobs, _ := s.ListJobs(JobQuery{}); len(jobs) != 1 {
+		t.Errorf("Expected no more jobs, got %d", len(jobs))
+	}
+}
+
+func TestScheduleOverlapInMemory(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{Storage: s}
+
+	now := time.Date(2015, 6, 1, 4, 0, 0, 0, time.UTC)
+	skip := insertSchedule(t, s, OverlapSkip, now)
+	allow := insertSchedule(t, s, OverlapAllow, now)
+	replace := insertSchedule(t, s, OverlapReplace, now)
+
+	// Every schedule fires the first time, and its job is left queued.
+	if err := FireSchedules(c, now); err != nil {
+		t.Fatalf("Unable to fire schedules: %v", err)
+	}
+	first := make(map[uint64]uint64)
+	for _, id := range []uint64{skip, allow, replace} {
+		first[id] = getSchedule(t, s, id).Runs[0].JID
+	}
+
+	next := now.Add(5 * time.Minute)
+	if err := FireSchedules(c, next); err != nil {
+		t.Fatalf("Unable to fire schedules: %v", err)
+	}
+
+	runs := getSchedule(t, s, skip).Runs
+	if len(runs) != 2 || runs[1].JID != 0 || runs[1].Skipped == "" {
+		t.Errorf("Expected the skip schedule to skip its second run, got %#v", runs)
+	}
+
+	runs = getSchedule(t, s, allow).Runs
+	if len(runs) != 2 || runs[1].JID == 0 {
+		t.Errorf("Expected the allow schedule to submit its second run, got %#v", runs)
+	}
+	expectJIDs(t, s, JobQuery{JIDs: []uint64{first[allow]}, Statuses: []string{StatusQueued}}, first[allow])
+
+	runs = getSchedule(t, s, replace).Runs
+	if len(runs) != 2 || runs[1].JID == 0 || runs[1].Replaced != first[replace] {
+		t.Errorf("Expected the replace schedule to replace its first run, got %#v", runs)
+	}
+	expectJIDs(t, s, JobQuery{JIDs: []uint64{first[replace]}, Statuses: []string{StatusKilled}}, first[replace])
+
+	// Once the skipped schedule's job has finished, it runs again.
+	jobs, _ := s.ListJobs(JobQuery{JIDs: []uint64{first[skip]}})
+	jobs[0].Status = StatusDone
+	if err := s.UpdateJob(&jobs[0]); err != nil {
+		t.Fatalf("Unable to update a job: %v", err)
+	}
+	if err := FireSchedules(c, next.Add(5*time.Minute)); err != nil {
+		t.Fatalf("Unable to fire schedules: %v", err)
+	}
+	runs = getSchedule(t, s, skip).Runs
+	if len(runs) != 3 || runs[2].JID == 0 {
+		t.Errorf("Expected the skip schedule to run once its job finished, got %#v", runs)
+	}
+}
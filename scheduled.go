@@ -0,0 +1,42 @@
+package main
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// ReleaseScheduledJobs moves jobs out of StatusScheduled once their RunAt time has come. Jobs with
+// a dependency move on to StatusWaiting, so that ReleaseWaitingJobs can check it again; the rest are
+// queued.
+func ReleaseScheduledJobs(c *Context, now time.Time) error {
+	scheduled, err := c.ListJobs(JobQuery{Statuses: []string{StatusScheduled}, SkipOutput: true})
+	if err != nil {
+		return err
+	}
+
+	for i := range scheduled {
+		job := &scheduled[i]
+		if job.KillRequested || job.RunAt.AsTime().After(now) {
+			continue
+		}
+
+		if _, ok := job.Dependency(); ok {
+			job.Status = StatusWaiting
+		} else {
+			job.Status = StatusQueued
+		}
+
+		if err := c.UpdateJob(job); err != nil {
+			return err
+		}
+
+		log.WithFields(log.Fields{
+			"jid":     job.JID,
+			"account": job.Account,
+			"status":  job.Status,
+		}).Info("Scheduled job released.")
+	}
+
+	return nil
+}
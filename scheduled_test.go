@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReleaseScheduledJobsInMemory(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{Storage: s}
+
+	now := time.Now()
+	dependency := "1"
+	jids := insertJobs(t, s,
+		SubmittedJob{Job: Job{RunAt: StoreTime(now.Add(-time.Minute))}, Account: "alice", Status: StatusScheduled},
+		SubmittedJob{Job: Job{RunAt: StoreTime(now.Add(time.Minute))}, Account: "alice", Status: StatusScheduled},
+		SubmittedJob{Job: Job{RunAt: StoreTime(now), DependsOn: &dependency}, Account: "alice", Status: StatusScheduled},
+		SubmittedJob{Job: Job{RunAt: StoreTime(now)}, Account: "alice", Status: StatusScheduled, KillRequested: true},
+	)
+
+	if err := ReleaseScheduledJobs(c, now); err != nil {
+		t.Fatalf("Unable to release scheduled jobs: %v", err)
+	}
+
+	expectJIDs(t, s, JobQuery{Statuses: []string{StatusQueued}}, jids[0])
+	expectJIDs(t, s, JobQuery{Statuses: []string{StatusScheduled}}, jids[1], jids[3])
+	expectJIDs(t, s, JobQuery{Statuses: []string{StatusWaiting}}, jids[2])
+
+	if err := ReleaseScheduledJobs(c, now.Add(2*time.Minute)); err != nil {
+		t.Fatalf("Unable to release scheduled jobs: %v", err)
+	}
+	expectJIDs(t, s, JobQuery{Statuses: []string{StatusQueued}}, jids[0], jids[1])
+}
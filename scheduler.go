@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Scheduler decides which queued job the runner claims next, passing over jobs of the core types that
+// every Docker host is too busy to run. The runner calls Begin before each pass in which it claims
+// as many jobs as it can, so a Scheduler may reuse what it learns about the queue until the pass
+// ends.
+type Scheduler interface {
+	Begin()
+	Claim(c *Context, full []string) (*SubmittedJob, error)
+}
+
+// NewScheduler initializes the Scheduler selected by the Scheduler setting. "fifo" claims jobs in
+// submission order, regardless of the account that submitted them. "fairshare" favors the accounts
+// that are using the least of the cluster.
+func NewScheduler(c *Context) (Scheduler, error) {
+	switch c.Settings.Scheduler {
+	case "fifo":
+		return FIFOScheduler{}, nil
+	case "fairshare":
+		return NewFairShareScheduler(time.Duration(c.FairShareHalfLife) * time.Minute), nil
+	default:
+		return nil, fmt.Errorf("unrecognized scheduler [%s]", c.Settings.Scheduler)
+	}
+}
+
+// FIFOScheduler claims queued jobs in order of priority, then submission time.
+type FIFOScheduler struct{}
+
+// Begin does nothing, because a FIFOScheduler doesn't look at the queue before claiming from it.
+func (FIFOScheduler) Begin() {}
+
+// Claim claims the next queued job, or returns nil if the queue is empty.
+func (FIFOScheduler) Claim(c *Context, full []string) (*SubmittedJob, error) {
+	return c.ClaimJob(c.NewLease(time.Now()), full)
+}
+
+// FairShareScheduler claims the next queued job of the account with the smallest share of the
+// cluster. Accounts with fewer running jobs come first. Among accounts with the same number of
+// running jobs, the account with the least recent usage comes first.
+//
+// Recent usage is derived from each Account's TotalRuntime. Runtime accrued while the scheduler is
+// watching decays exponentially with HalfLife, so past heavy use is gradually forgiven. Usage from
+// before the scheduler first sees an account isn't counted. A HalfLife of zero compares lifetime
+// TotalRuntime instead.
+//
+// The queue and the accounts are read once per pass. Within a pass, the running and queued counts
+// of each account are kept up to date as its jobs are claimed.
+type FairShareScheduler struct {
+	sync.Mutex
+
+	HalfLife time.Duration
+
+	usage map[string]*accountUsage
+	pass  []fairShare
+}
+
+// accountUsage tracks the decayed recent usage of a single account.
+type accountUsage struct {
+	total  int64
+	recent float64
+	seen   time.Time
+}
+
+// NewFairShareScheduler creates a FairShareScheduler that forgets usage with the given half-life.
+func NewFairShareScheduler(halfLife time.Duration) *FairShareScheduler {
+	return &FairShareScheduler{
+		HalfLife: halfLife,
+		usage:    make(map[string]*accountUsage),
+	}
+}
+
+// observe records an account's TotalRuntime as of now and returns its recent usage.
+func (s *FairShareScheduler) observe(account string, total int64, now time.Time) float64 {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.HalfLife <= 0 {
+		return float64(total)
+	}
+
+	u, ok := s.usage[account]
+	if !ok {
+		u = &accountUsage{total: total, seen: now}
+		s.usage[account] = u
+		return 0
+	}
+
+	elapsed := now.Sub(u.seen)
+	if elapsed > 0 {
+		u.recent *= math.Pow(0.5, float64(elapsed)/float64(s.HalfLife))
+		u.seen = now
+	}
+	if delta := total - u.total; delta > 0 {
+		u.recent += float64(delta)
+	}
+	u.total = total
+
+	return u.recent
+}
+
+// fairShare is a candidate account, ranked by its running jobs and recent usage.
+type fairShare struct {
+	account string
+	running int
+	queued  int
+	recent  float64
+}
+
+type byFairShare []fairShare
+
+func (s byFairShare) Len() int      { return len(s) }
+func (s byFairShare) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byFairShare) Less(i, j int) bool {
+	if s[i].running != s[j].running {
+		return s[i].running < s[j].running
+	}
+	if s[i].recent != s[j].recent {
+		return s[i].recent < s[j].recent
+	}
+	return s[i].account < s[j].account
+}
+
+// Begin forgets the queue as it was read during the previous pass.
+func (s *FairShareScheduler) Begin() {
+	s.pass = nil
+}
+
+// Claim claims the next queued job of the account with the smallest share, or returns nil if the
+// queue is empty. If another runner empties that account's queue first, the next account is tried.
+func (s *FairShareScheduler) Claim(c *Context, full []string) (*SubmittedJob, error) {
+	now := time.Now()
+	if s.pass == nil {
+		candidates, err := s.candidates(c, now)
+		if err != nil {
+			return nil, err
+		}
+		s.pass = candidates
+	}
+
+	for len(s.pass) > 0 {
+		sort.Sort(byFairShare(s.pass))
+		candidate := &s.pass[0]
+
+		job, err := c.ClaimAccountJob(candidate.account, c.NewLease(now), full)
+		if err != nil {
+			return nil, err
+		}
+		if job != nil {
+			candidate.running++
+			candidate.queued--
+		}
+		if job == nil || candidate.queued <= 0 {
+			s.pass = s.pass[1:]
+		}
+		if job != nil {
+			return job, nil
+		}
+	}
+
+	s.pass = nil
+	return nil, nil
+}
+
+// candidates reads the queue and ranks every account that has jobs queued.
+func (s *FairShareScheduler) candidates(c *Context, now time.Time) ([]fairShare, error) {
+	queues, err := c.QueueSummary()
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]fairShare, 0, len(queues))
+	for _, queue := range queues {
+		if queue.Queued == 0 {
+			continue
+		}
+
+		account, err := c.GetAccount(queue.Account)
+		if err != nil {
+			return nil, err
+		}
+
+		candidates = append(candidates, fairShare{
+			account: queue.Account,
+			running: queue.Running,
+			queued:  queue.Queued,
+			recent:  s.observe(queue.Account, account.TotalRuntime, now),
+		})
+	}
+	return candidates, nil
+}
+
+// Ensure that the Scheduler implementations adhere to the Scheduler interface.
+var (
+	_ Scheduler = FIFOScheduler{}
+	_ Scheduler = &FairShareScheduler{}
+)
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewScheduler(t *testing.T) {
+	c := &Context{Settings: Settings{Scheduler: "fifo"}}
+	if s, err := NewScheduler(c); err != nil {
+		t.Errorf("Unable to create a fifo scheduler: %v", err)
+	} else if _, ok := s.(FIFOScheduler); !ok {
+		t.Errorf("Expected a FIFOScheduler, got %#v", s)
+	}
+
+	c.Settings.Scheduler = "fairshare"
+	c.FairShareHalfLife = 30
+	if s, err := NewScheduler(c); err != nil {
+		t.Errorf("Unable to create a fairshare scheduler: %v", err)
+	} else if fs, ok := s.(*FairShareScheduler); !ok || fs.HalfLife != 30*time.Minute {
+		t.Errorf("Expected a FairShareScheduler with a 30 minute half-life, got %#v", s)
+	}
+
+	c.Settings.Scheduler = "lottery"
+	if _, err := NewScheduler(c); err == nil {
+		t.Error("Expected an unrecognized scheduler to be rejected")
+	}
+}
+
+func TestFairShareUsageDecays(t *testing.T) {
+	s := NewFairShareScheduler(time.Hour)
+	start := time.Now()
+
+	// Usage from before the account was first seen isn't counted.
+	if recent := s.observe("alice", 5000, start); recent != 0 {
+		t.Errorf("Expected no recent usage at first sight, got [%v]", recent)
+	}
+	if recent := s.observe("alice", 6000, start); recent != 1000 {
+		t.Errorf("Expected [1000] recent usage, got [%v]", recent)
+	}
+	if recent := s.observe("alice", 6000, start.Add(time.Hour)); recent != 500 {
+		t.Errorf("Expected usage to halve after one half-life, got [%v]", recent)
+	}
+	if recent := s.observe("alice", 6100, start.Add(3*time.Hour)); recent != 225 {
+		t.Errorf("Expected [225] recent usage, got [%v]", recent)
+	}
+
+	lifetime := NewFairShareScheduler(0)
+	if recent := lifetime.observe("alice", 5000, start); recent != 5000 {
+		t.Errorf("Expected lifetime usage without a half-life, got [%v]", recent)
+	}
+}
+
+func TestFairShareClaimInMemory(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{Storage: s}
+	scheduler := NewFairShareScheduler(time.Hour)
+
+	if job, err := scheduler.Claim(c, nil); err != nil || job != nil {
+		t.Fatalf("Expected nothing to claim from an empty queue, got [%#v] and [%v]", job, err)
+	}
+
+	jids := insertJobs(t, s,
+		SubmittedJob{CreatedAt: 100, Account: "alice", Status: StatusQueued},
+		SubmittedJob{CreatedAt: 200, Account: "alice", Status: StatusQueued},
+		SubmittedJob{CreatedAt: 300, Account: "bob", Status: StatusQueued},
+		SubmittedJob{CreatedAt: 400, Account: "carol", Status: StatusQueued},
+		SubmittedJob{CreatedAt: 500, Account: "carol", Status: StatusQueued},
+	)
+
+	// Observe every account once, then charge carol for recent usage.
+	for _, name := range []string{"alice", "bob", "carol"} {
+		if _, err := s.GetAccount(name); err != nil {
+			t.Fatalf("Unable to create account [%s]: %v", name, err)
+		}
+		scheduler.observe(name, 0, time.Now())
+	}
+	if err := s.UpdateAccountUsage("carol", 1000); err != nil {
+		t.Fatalf("Unable to update carol's usage: %v", err)
+	}
+
+	// Each claim goes to the account with the fewest running jobs, breaking ties by recent usage
+	// and then by name.
+	expected := []uint64{jids[0], jids[2], jids[3], jids[1], jids[4]}
+	for _, jid := range expected {
+		job, err := scheduler.Claim(c, nil)
+		if err != nil {
+			t.Fatalf("Unable to claim a job: %v", err)
+		}
+		if job == nil || job.JID != jid {
+			t.Fatalf("Expected to claim job [%d], got %#v", jid, job)
+		}
+	}
+
+	if job, err := scheduler.Claim(c, nil); err != nil || job != nil {
+		t.Errorf("Expected the queue to be empty, got [%#v] and [%v]", job, err)
+	}
+}
+
+// countingStorage counts the queue summaries and account lookups that pass through it.
+type countingStorage struct {
+	Storage
+
+	summaries int
+	accounts  int
+}
+
+func (s *countingStorage) QueueSummary() ([]AccountQueue, error) {
+	s.summaries++
+	return s.Storage.QueueSummary()
+}
+
+func (s *countingStorage) GetAccount(name string) (*Account, error) {
+	s.accounts++
+	return s.Storage.GetAccount(name)
+}
+
+func TestFairShareReadsQueueOncePerPass(t *testing.T) {
+	s := &countingStorage{Storage: NewMemoryStorage()}
+	c := &Context{Storage: s}
+	scheduler := NewFairShareScheduler(time.Hour)
+
+	insertJobs(t, s,
+		SubmittedJob{CreatedAt: 100, Account: "alice", Status: StatusQueued},
+		SubmittedJob{CreatedAt: 200, Account: "alice", Status: StatusQueued},
+		SubmittedJob{CreatedAt: 300, Account: "bob", Status: StatusQueued},
+	)
+
+	scheduler.Begin()
+	claimed := 0
+	for {
+		job, err := scheduler.Claim(c, nil)
+		if err != nil {
+			t.Fatalf("Unable to claim a job: %v", err)
+		}
+		if job == nil {
+			break
+		}
+		claimed++
+	}
+	if claimed != 3 {
+		t.Errorf("Expected to claim three jobs, got %d", claimed)
+	}
+	if s.summaries != 1 || s.accounts != 2 {
+		t.Errorf("Expected one summary and two account lookups, got [%d] and [%d]", s.summaries, s.accounts)
+	}
+
+	// The next pass reads the queue again.
+	insertJobs(t, s, SubmittedJob{CreatedAt: 400, Account: "bob", Status: StatusQueued})
+	scheduler.Begin()
+	if job, err := scheduler.Claim(c, nil); err != nil || job == nil {
+		t.Fatalf("Expected to claim bob's new job, got [%#v] and [%v]", job, err)
+	}
+	if s.summaries != 2 {
+		t.Errorf("Expected the queue to be read again, got [%d] summaries", s.summaries)
+	}
+}
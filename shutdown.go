@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// DrainingServer is an http.Server that can stop accepting connections and wait for the requests
+// that it's already handling to complete.
+type DrainingServer struct {
+	*http.Server
+	sync.Mutex
+
+	listener net.Listener
+	draining bool
+	conns    map[net.Conn]http.ConnState
+}
+
+// NewDrainingServer creates a DrainingServer that dispatches requests to handler. A nil handler
+// uses http.DefaultServeMux.
+func NewDrainingServer(handler http.Handler) *DrainingServer {
+	s := &DrainingServer{
+		Server: &http.Server{Handler: handler},
+		conns:  make(map[net.Conn]http.ConnState),
+	}
+	s.ConnState = s.track
+	return s
+}
+
+// Serve accepts connections from a listener until it's drained. The error that it returns once
+// it's drained is expected.
+func (s *DrainingServer) Serve(l net.Listener) error {
+	s.Lock()
+	s.listener = l
+	s.Unlock()
+
+	return s.Server.Serve(l)
+}
+
+// track follows the state of each connection, closing idle connections once the server is draining.
+func (s *DrainingServer) track(conn net.Conn, state http.ConnState) {
+	s.Lock()
+	defer s.Unlock()
+
+	switch state {
+	case http.StateClosed, http.StateHijacked:
+		delete(s.conns, conn)
+		return
+	case http.StateIdle:
+		if s.draining {
+			conn.Close()
+		}
+	}
+	s.conns[conn] = state
+}
+
+// Drain stops accepting connections and waits until every open connection is closed, or until the
+// deadline passes. Connections close as soon as their current request has completed. It returns the
+// number of connections that are still open.
+func (s *DrainingServer) Drain(deadline time.Time) int {
+	s.Lock()
+	s.draining = true
+	s.SetKeepAlivesEnabled(false)
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	for conn, state := range s.conns {
+		if state == http.StateIdle {
+			conn.Close()
+		}
+	}
+	s.Unlock()
+
+	for {
+		s.Lock()
+		open := len(s.conns)
+		s.Unlock()
+
+		if open == 0 || !time.Now().Before(deadline) {
+			return open
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// Shutdown stops the job runner from claiming new jobs and the server from accepting new requests,
+// then waits until the deadline for the jobs and requests in flight to complete. Jobs that are still
+// running at the deadline are left processing, with their containers running, so that Recover can
+// reattach to them when the job runner starts again.
+func Shutdown(c *Context, server *DrainingServer, deadline time.Time) {
+	log.WithFields(log.Fields{
+		"deadline": deadline,
+	}).Info("Draining the job runner and web API.")
+
+	c.Pool.Drain()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+
+		if running := c.Pool.Wait(deadline); len(running) > 0 {
+			log.WithFields(log.Fields{
+				"jids": running,
+			}).Warn("Jobs are still running. They'll be recovered when the job runner starts again.")
+		} else {
+			log.Info("Every running job has finished.")
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+
+		if open := server.Drain(deadline); open > 0 {
+			log.WithFields(log.Fields{
+				"connections": open,
+			}).Warn("Abandoning unfinished requests.")
+		} else {
+			log.Info("Every request has completed.")
+		}
+	}()
+
+	wg.Wait()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDrainingServerCompletesRequests(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/fast", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "fast")
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		fmt.Fprint(w, "slow")
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	server := NewDrainingServer(mux)
+	go server.Serve(listener)
+
+	base := "http://" + listener.Addr().String()
+	client := &http.Client{Transport: &http.Transport{}}
+
+	// Leave an idle keep-alive connection open.
+	resp, err := client.Get(base + "/fast")
+	if err != nil {
+		t.Fatalf("Unable to make a request: %v", err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	type result struct {
+		body string
+		err  error
+	}
+	slow := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(base + "/slow")
+		if err != nil {
+			slow <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		slow <- result{body: string(body), err: err}
+	}()
+	<-started
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(release)
+	}()
+
+	if open := server.Drain(time.Now().Add(5 * time.Second)); open != 0 {
+		t.Errorf("Expected every connection to close, [%d] are still open", open)
+	}
+
+	if r := <-slow; r.err != nil || r.body != "slow" {
+		t.Errorf("Expected the in-flight request to complete, got [%s] and [%v]", r.body, r.err)
+	}
+
+	if _, err := http.Get(base + "/fast"); err == nil {
+		t.Error("Expected a drained server to refuse new requests")
+	}
+}
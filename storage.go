@@ -1,6 +1,10 @@
 package main
 
 import (
+	"fmt"
+	"sort"
+	"strings"
+
 	mgo "github.com/cloudpipe/mgo"
 	"github.com/cloudpipe/mgo/bson"
 
@@ -13,17 +17,62 @@ type Storage interface {
 	Bootstrap() error
 
 	InsertJob(SubmittedJob) (uint64, error)
+	InsertJobs([]SubmittedJob) ([]uint64, error)
 	ListJobs(JobQuery) ([]SubmittedJob, error)
 	JobKillRequested(id uint64) (bool, error)
-	ClaimJob() (*SubmittedJob, error)
+	ClaimJob(lease Lease, full []string) (*SubmittedJob, error)
+	ClaimAccountJob(account string, lease Lease, full []string) (*SubmittedJob, error)
+	ClaimLapsedJob(lease Lease, now StoredTime) (*SubmittedJob, error)
+	RenewLease(jid uint64, lease Lease) error
+	UnclaimJob(jid uint64, lease Lease) error
+	QueueSummary() ([]AccountQueue, error)
+	QueueRevision() (uint64, error)
 	UpdateJob(*SubmittedJob) error
+	ReprioritizeJob(jid uint64, priority int) error
+	AppendOutput(OutputChunk) error
+	DeleteJobs(jids []uint64) error
+	NextArrayID() (uint64, error)
+
+	InsertSchedule(Schedule) (uint64, error)
+	ListSchedules(account string) ([]Schedule, error)
+	AdvanceSchedule(id uint64, from, next StoredTime) error
+	RecordScheduleRun(id uint64, run ScheduleRun) error
+	PauseSchedule(id uint64, paused bool, next StoredTime) error
+	DeleteSchedule(id uint64) error
 
 	GetAccount(name string) (*Account, error)
 	UpdateAccountAdmin(name string, admin bool) error
+	UpdateAccountMaxPriority(name string, max int) error
 	UpdateAccountUsage(name string, runtime int64) error
 }
 
-// JobQuery specifies (all optional) query parameters for fetching jobs.
+// ErrNotFound is returned by Storage implementations when an operation targets a job, account or
+// schedule that doesn't exist.
+var ErrNotFound = mgo.ErrNotFound
+
+// NewStorage connects to the storage engine selected by the Storage setting. "mongo" uses the
+// MongoDB cluster at MongoURL, "bolt:///path/to/file.db" opens (or creates) an embedded BoltDB
+// file, "sqlite:///path/to/file.db" opens a SQLite database (in builds tagged with "sqlite"), and
+// "memory" keeps everything in process memory until the server exits.
+func NewStorage(c *Context) (Storage, error) {
+	address := c.Settings.Storage
+
+	switch {
+	case address == "mongo":
+		return NewMongoStorage(c)
+	case strings.HasPrefix(address, "bolt://"):
+		return NewBoltStorage(address[len("bolt://"):])
+	case strings.HasPrefix(address, "sqlite://"):
+		return NewSQLStorage("sqlite3", address[len("sqlite://"):])
+	case address == "memory":
+		return NewMemoryStorage(), nil
+	default:
+		return nil, fmt.Errorf("unrecognized storage URL [%s]", address)
+	}
+}
+
+// JobQuery specifies (all optional) query parameters for fetching jobs. An empty AccountName
+// matches jobs belonging to any account.
 type JobQuery struct {
 	AccountName string
 
@@ -31,9 +80,242 @@ type JobQuery struct {
 	Names    []string
 	Statuses []string
 
-	Limit  int
-	Before uint64
-	After  uint64
+	// Results are ordered by JID: ascending unless Descending is set. Limit is applied after ordering.
+	Limit      int
+	Before     uint64
+	After      uint64
+	Descending bool
+
+	// FinishedBefore only matches jobs that finished strictly before the given time. Jobs that never
+	// recorded when they finished are matched by when they were created instead.
+	FinishedBefore StoredTime
+
+	// Array only matches the elements of a single job array.
+	Array uint64
+
+	// SkipOutput leaves Stdout and Stderr empty, for callers that don't need to read them.
+	SkipOutput bool
+}
+
+// passedOver reports whether claims pass over a job of a core type because it's one of the core
+// types that every Docker host is too busy to run.
+func passedOver(core string, full []string) bool {
+	for _, name := range full {
+		if name == core {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches returns true if a SubmittedJob satisfies every criterion of the query. Limit is not
+// considered.
+func (query JobQuery) Matches(job *SubmittedJob) bool {
+	if query.AccountName != "" && job.Account != query.AccountName {
+		return false
+	}
+
+	if query.Before != 0 && job.JID >= query.Before {
+		return false
+	}
+	if query.After != 0 && job.JID < query.After {
+		return false
+	}
+
+	if query.FinishedBefore != 0 {
+		finished := job.FinishedAt
+		if finished == 0 {
+			finished = job.CreatedAt
+		}
+		if finished >= query.FinishedBefore {
+			return false
+		}
+	}
+
+	if query.Array != 0 && job.Array != query.Array {
+		return false
+	}
+
+	if len(query.JIDs) > 0 {
+		found := false
+		for _, jid := range query.JIDs {
+			if jid == job.JID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(query.Names) > 0 {
+		if job.Name == nil {
+			return false
+		}
+		found := false
+		for _, name := range query.Names {
+			if name == *job.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(query.Statuses) > 0 {
+		found := false
+		for _, status := range query.Statuses {
+			if status == job.Status {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// AccountQueue summarizes the jobs belonging to a single account that are waiting in the queue or
+// currently running.
+type AccountQueue struct {
+	Account string
+	Queued  int
+	Running int
+}
+
+// accountQueues accumulates AccountQueue summaries by account.
+type accountQueues map[string]*AccountQueue
+
+// add counts n jobs toward an account's summary if they're queued or running.
+func (queues accountQueues) add(account, status string, n int) {
+	if status != StatusQueued && status != StatusProcessing {
+		return
+	}
+
+	queue, ok := queues[account]
+	if !ok {
+		queue = &AccountQueue{Account: account}
+		queues[account] = queue
+	}
+	if status == StatusQueued {
+		queue.Queued += n
+	} else {
+		queue.Running += n
+	}
+}
+
+// list returns the accumulated summaries ordered by account name.
+func (queues accountQueues) list() []AccountQueue {
+	names := make([]string, 0, len(queues))
+	for name := range queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]AccountQueue, len(names))
+	for i, name := range names {
+		result[i] = *queues[name]
+	}
+	return result
+}
+
+// OutputChunk is a single write to one of a job's output streams. Chunks are numbered in sequence
+// within each stream and reassembled into SubmittedJob.Stdout and SubmittedJob.Stderr when the job
+// is listed, so that collecting output never rewrites the job document.
+type OutputChunk struct {
+	JID      uint64 `bson:"jid"`
+	Stream   string `bson:"stream"`
+	Sequence int    `bson:"seq"`
+	Data     []byte `bson:"data"`
+}
+
+// outputChunkSlice attaches the methods of sort.Interface to []OutputChunk, sorting by JID, stream
+// and sequence.
+type outputChunkSlice []OutputChunk
+
+func (s outputChunkSlice) Len() int      { return len(s) }
+func (s outputChunkSlice) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s outputChunkSlice) Less(i, j int) bool {
+	if s[i].JID != s[j].JID {
+		return s[i].JID < s[j].JID
+	}
+	if s[i].Stream != s[j].Stream {
+		return s[i].Stream < s[j].Stream
+	}
+	return s[i].Sequence < s[j].Sequence
+}
+
+// assembleOutput populates the Stdout and Stderr of each job from an unordered collection of
+// OutputChunks.
+func assembleOutput(jobs []SubmittedJob, chunks []OutputChunk) {
+	sort.Sort(outputChunkSlice(chunks))
+
+	stdout := make(map[uint64][]byte)
+	stderr := make(map[uint64][]byte)
+	for _, chunk := range chunks {
+		switch chunk.Stream {
+		case StreamStdout:
+			stdout[chunk.JID] = append(stdout[chunk.JID], chunk.Data...)
+		case StreamStderr:
+			stderr[chunk.JID] = append(stderr[chunk.JID], chunk.Data...)
+		}
+	}
+
+	for i := range jobs {
+		jobs[i].Stdout = string(stdout[jobs[i].JID])
+		jobs[i].Stderr = string(stderr[jobs[i].JID])
+	}
+}
+
+// jidSlice attaches the methods of sort.Interface to []uint64, sorting in increasing order.
+type jidSlice []uint64
+
+func (s jidSlice) Len() int           { return len(s) }
+func (s jidSlice) Less(i, j int) bool { return s[i] < s[j] }
+func (s jidSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// mergeJobUpdate applies the changes made to a job model onto its previously stored state, with the
+// same semantics as the MongoDB "$set" that MongoStorage.UpdateJob performs: fields that are omitted
+// from the updated job's BSON encoding, like an unset ContainerID or KillRequested flag, retain
+// their stored values.
+func mergeJobUpdate(stored, updated *SubmittedJob) (*SubmittedJob, error) {
+	var storedDoc, updatedDoc bson.M
+
+	raw, err := bson.Marshal(stored)
+	if err != nil {
+		return nil, err
+	}
+	if err := bson.Unmarshal(raw, &storedDoc); err != nil {
+		return nil, err
+	}
+
+	raw, err = bson.Marshal(updated)
+	if err != nil {
+		return nil, err
+	}
+	if err := bson.Unmarshal(raw, &updatedDoc); err != nil {
+		return nil, err
+	}
+
+	for k, v := range updatedDoc {
+		storedDoc[k] = v
+	}
+
+	raw, err = bson.Marshal(storedDoc)
+	if err != nil {
+		return nil, err
+	}
+	var merged SubmittedJob
+	if err := bson.Unmarshal(raw, &merged); err != nil {
+		return nil, err
+	}
+	return &merged, nil
 }
 
 // MongoStorage is a Storage implementation that connects to a real MongoDB cluster.
@@ -62,10 +344,21 @@ func (storage *MongoStorage) root() *mgo.Collection {
 	return storage.Database.C("root")
 }
 
+func (storage *MongoStorage) output() *mgo.Collection {
+	return storage.Database.C("output")
+}
+
+func (storage *MongoStorage) schedules() *mgo.Collection {
+	return storage.Database.C("schedules")
+}
+
 // MongoRoot contains global metadata, counters and statistics used by various storage functions.
 // Exactly one instance of MongoRoot should exist in the "root" collection.
 type MongoRoot struct {
-	JobID uint64 `bson:"job_id"`
+	JobID         uint64 `bson:"job_id"`
+	QueueRevision uint64 `bson:"queue_revision"`
+	ScheduleID    uint64 `bson:"schedule_id"`
+	ArrayID       uint64 `bson:"array_id"`
 }
 
 // Bootstrap creates indices and metadata objects.
@@ -85,6 +378,66 @@ func (storage *MongoStorage) Bootstrap() error {
 		"removed": info.Removed,
 	}).Debug("MongoRoot object initialized.")
 
+	err = storage.output().EnsureIndex(mgo.Index{
+		Key:    []string{"jid", "stream", "seq"},
+		Unique: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	return storage.migrateInlineOutput()
+}
+
+// migrateInlineOutput moves output from jobs that were stored with inline "stdout" and "stderr"
+// fields into the output collection.
+func (storage *MongoStorage) migrateInlineOutput() error {
+	var legacy struct {
+		JID    uint64 `bson:"_id"`
+		Stdout string `bson:"stdout"`
+		Stderr string `bson:"stderr"`
+	}
+
+	inline := bson.M{"$or": []bson.M{
+		bson.M{"stdout": bson.M{"$exists": true}},
+		bson.M{"stderr": bson.M{"$exists": true}},
+	}}
+
+	migrated := 0
+	iter := storage.jobs().Find(inline).Select(bson.M{"stdout": 1, "stderr": 1}).Iter()
+	for iter.Next(&legacy) {
+		for stream, data := range map[string]string{StreamStdout: legacy.Stdout, StreamStderr: legacy.Stderr} {
+			if data == "" {
+				continue
+			}
+
+			// Inline output always precedes any chunks, so it claims a negative sequence number.
+			_, err := storage.output().Upsert(
+				bson.M{"jid": legacy.JID, "stream": stream, "seq": -1},
+				OutputChunk{JID: legacy.JID, Stream: stream, Sequence: -1, Data: []byte(data)},
+			)
+			if err != nil {
+				iter.Close()
+				return err
+			}
+		}
+
+		err := storage.jobs().UpdateId(legacy.JID, bson.M{"$unset": bson.M{"stdout": "", "stderr": ""}})
+		if err != nil {
+			iter.Close()
+			return err
+		}
+		migrated++
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+
+	if migrated > 0 {
+		log.WithFields(log.Fields{
+			"jobs": migrated,
+		}).Info("Moved inline job output to the output collection.")
+	}
 	return nil
 }
 
@@ -106,22 +459,122 @@ func (storage *MongoStorage) InsertJob(job SubmittedJob) (uint64, error) {
 	if err := storage.jobs().Insert(job); err != nil {
 		return 0, err
 	}
+	if job.Status == StatusQueued {
+		storage.advanceQueue(1)
+	}
 
 	return job.JID, nil
 }
 
-// ListJobs queries jobs that have been submitted to the cluster.
+// mongoPendingJob is a job document from a batch that's still being inserted. Pending documents
+// are invisible to listing and claims until the whole batch has been inserted.
+type mongoPendingJob struct {
+	SubmittedJob `bson:",inline"`
+	Pending      bool `bson:"pending"`
+}
+
+// mongoNotPending matches job documents that aren't part of a batch that's still being inserted.
+var mongoNotPending = bson.M{"$exists": false}
+
+// InsertJobs appends a batch of jobs to the queue, all or nothing, and returns their JIDs. A
+// contiguous block of JIDs is reserved with a single counter update. MongoDB can't insert several
+// documents atomically, so the batch is inserted as pending documents that nothing will list or
+// claim, and only published once every one of them has been inserted.
+func (storage *MongoStorage) InsertJobs(jobs []SubmittedJob) ([]uint64, error) {
+	if len(jobs) == 0 {
+		return []uint64{}, nil
+	}
+
+	// Reserve a block of job IDs.
+	var root MongoRoot
+	_, err := storage.root().Find(bson.M{}).Apply(mgo.Change{
+		Update:    bson.M{"$inc": bson.M{"job_id": len(jobs)}},
+		ReturnNew: true,
+	}, &root)
+	if err != nil {
+		return nil, err
+	}
+	first := root.JobID - uint64(len(jobs)) + 1
+
+	jids := make([]uint64, len(jobs))
+	docs := make([]interface{}, len(jobs))
+	for i, job := range jobs {
+		job.JID = first + uint64(i)
+		jids[i] = job.JID
+		docs[i] = mongoPendingJob{SubmittedJob: job, Pending: true}
+	}
+	batch := bson.M{"_id": bson.M{"$in": jids}, "pending": true}
+
+	if err := storage.jobs().Insert(docs...); err != nil {
+		// Remove any jobs from the batch that were inserted before the failure. They were never
+		// visible, so if this fails too they're only left behind as garbage. The reserved JIDs are
+		// never reused.
+		if _, rerr := storage.jobs().RemoveAll(batch); rerr != nil {
+			log.WithFields(log.Fields{
+				"jids":  jids,
+				"error": rerr,
+			}).Error("Unable to roll back a partially inserted batch of jobs.")
+		}
+		return nil, err
+	}
+
+	// Publish the batch.
+	if _, err := storage.jobs().UpdateAll(batch, bson.M{"$unset": bson.M{"pending": ""}}); err != nil {
+		if _, rerr := storage.jobs().RemoveAll(batch); rerr != nil {
+			log.WithFields(log.Fields{
+				"jids":  jids,
+				"error": rerr,
+			}).Error("Unable to roll back an unpublished batch of jobs.")
+		}
+		return nil, err
+	}
+
+	queued := 0
+	for _, job := range jobs {
+		if job.Status == StatusQueued {
+			queued++
+		}
+	}
+	storage.advanceQueue(queued)
+
+	return jids, nil
+}
+
+// advanceQueue advances the queue revision once for each job that joined the queue. The jobs are
+// already stored, so a failure is only logged: other instances will still find them when they next
+// poll.
+func (storage *MongoStorage) advanceQueue(count int) {
+	if count == 0 {
+		return
+	}
+
+	err := storage.root().Update(bson.M{}, bson.M{"$inc": bson.M{"queue_revision": count}})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"count": count,
+			"error": err,
+		}).Error("Unable to advance the queue revision.")
+	}
+}
+
+// ListJobs queries jobs that have been submitted to the cluster, in JID order.
 func (storage *MongoStorage) ListJobs(query JobQuery) ([]SubmittedJob, error) {
-	q := bson.M{"account": query.AccountName}
+	q := bson.M{}
+	if query.AccountName != "" {
+		q["account"] = query.AccountName
+	}
 
 	switch len(query.JIDs) {
 	case 0:
+		bounds := bson.M{}
 		if query.Before != 0 {
-			q["_id"] = bson.M{"$lt": query.Before}
+			bounds["$lt"] = query.Before
 		}
-
 		if query.After != 0 {
-			q["_id"] = bson.M{"$gte": query.After}
+			bounds["$gte"] = query.After
+		}
+		if len(bounds) > 0 {
+			q["_id"] = bounds
 		}
 	case 1:
 		only := query.JIDs[0]
@@ -170,10 +623,42 @@ func (storage *MongoStorage) ListJobs(query JobQuery) ([]SubmittedJob, error) {
 		q["status"] = bson.M{"$in": query.Statuses}
 	}
 
-	var result []SubmittedJob
-	if err := storage.jobs().Find(q).Limit(query.Limit).All(&result); err != nil {
+	if query.FinishedBefore != 0 {
+		q["$or"] = []bson.M{
+			bson.M{"finished_at": bson.M{"$gt": 0, "$lt": query.FinishedBefore}},
+			bson.M{"finished_at": bson.M{"$in": []interface{}{0, nil}}, "created_at": bson.M{"$lt": query.FinishedBefore}},
+		}
+	}
+
+	if query.Array != 0 {
+		q["array"] = query.Array
+	}
+
+	q["pending"] = mongoNotPending
+
+	order := "_id"
+	if query.Descending {
+		order = "-_id"
+	}
+
+	result := []SubmittedJob{}
+	if err := storage.jobs().Find(q).Sort(order).Limit(query.Limit).All(&result); err != nil {
 		return nil, err
 	}
+
+	if len(result) > 0 && !query.SkipOutput {
+		jids := make([]uint64, len(result))
+		for i, job := range result {
+			jids[i] = job.JID
+		}
+
+		var chunks []OutputChunk
+		if err := storage.output().Find(bson.M{"jid": bson.M{"$in": jids}}).All(&chunks); err != nil {
+			return nil, err
+		}
+		assembleOutput(result, chunks)
+	}
+
 	return result, nil
 }
 
@@ -185,12 +670,16 @@ func (storage *MongoStorage) JobKillRequested(id uint64) (bool, error) {
 	return result.KillRequested, err
 }
 
-// ClaimJob atomically searches for the oldest pending SubmittedJob, marks it as StatusProcessing,
-// and returns it. nil is returned if no SubmittedJobs are available.
-func (storage *MongoStorage) ClaimJob() (*SubmittedJob, error) {
+// ClaimJob atomically searches for the oldest pending SubmittedJob with the highest priority, marks
+// it as StatusProcessing under the provided lease, and returns it. Jobs of the core types in full
+// are passed over. nil is returned if no SubmittedJobs are available.
+func (storage *MongoStorage) ClaimJob(lease Lease, full []string) (*SubmittedJob, error) {
 	var job SubmittedJob
-	_, err := storage.jobs().Find(bson.M{"status": StatusQueued}).Sort("created_at").Apply(mgo.Change{
-		Update:    bson.M{"$set": bson.M{"status": StatusProcessing}},
+	_, err := storage.jobs().Find(mongoClaimable(bson.M{
+		"status":  StatusQueued,
+		"pending": mongoNotPending,
+	}, full)).Sort("-job.priority", "created_at", "_id").Apply(mgo.Change{
+		Update:    bson.M{"$set": mongoLease(StatusProcessing, lease)},
 		ReturnNew: true,
 	}, &job)
 
@@ -204,15 +693,265 @@ func (storage *MongoStorage) ClaimJob() (*SubmittedJob, error) {
 	return &job, nil
 }
 
+// ClaimAccountJob atomically claims the oldest pending SubmittedJob that belongs to a specific
+// account, in the same manner as ClaimJob.
+func (storage *MongoStorage) ClaimAccountJob(account string, lease Lease, full []string) (*SubmittedJob, error) {
+	var job SubmittedJob
+	_, err := storage.jobs().Find(mongoClaimable(bson.M{
+		"status":  StatusQueued,
+		"account": account,
+		"pending": mongoNotPending,
+	}, full)).Sort("-job.priority", "created_at", "_id").Apply(mgo.Change{
+		Update:    bson.M{"$set": mongoLease(StatusProcessing, lease)},
+		ReturnNew: true,
+	}, &job)
+
+	if err == mgo.ErrNotFound {
+		// No jobs in the account's queue.
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// mongoClaimable narrows a query for queued jobs to pass over the core types in full.
+func mongoClaimable(q bson.M, full []string) bson.M {
+	if len(full) > 0 {
+		q["job.core"] = bson.M{"$nin": full}
+	}
+	return q
+}
+
+// ClaimLapsedJob atomically takes over a processing job whose lease lapsed before now, granting it
+// the provided lease instead, and returns it. nil is returned if no leases have lapsed.
+func (storage *MongoStorage) ClaimLapsedJob(lease Lease, now StoredTime) (*SubmittedJob, error) {
+	var job SubmittedJob
+	_, err := storage.jobs().Find(bson.M{
+		"status":        StatusProcessing,
+		"lease_expires": bson.M{"$gt": 0, "$lt": now},
+	}).Sort("_id").Apply(mgo.Change{
+		Update:    bson.M{"$set": mongoLease(StatusProcessing, lease)},
+		ReturnNew: true,
+	}, &job)
+
+	if err == mgo.ErrNotFound {
+		// No lapsed leases.
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// RenewLease replaces the lease of a processing job that's held by lease.Owner, or by nobody. It
+// returns ErrNotFound if the job isn't processing or another runner holds it.
+func (storage *MongoStorage) RenewLease(jid uint64, lease Lease) error {
+	return storage.jobs().Update(
+		bson.M{
+			"_id":    jid,
+			"status": StatusProcessing,
+			"owner":  bson.M{"$in": []interface{}{lease.Owner, nil}},
+		},
+		bson.M{"$set": mongoLease(StatusProcessing, lease)},
+	)
+}
+
+// UnclaimJob returns a processing job that's held by lease.Owner, or by nobody, to the queue as
+// though it had never been claimed. Unlike UpdateJob, it doesn't advance the queue revision. It
+// returns ErrNotFound if the job isn't processing or another runner holds it.
+func (storage *MongoStorage) UnclaimJob(jid uint64, lease Lease) error {
+	return storage.jobs().Update(
+		bson.M{
+			"_id":    jid,
+			"status": StatusProcessing,
+			"owner":  bson.M{"$in": []interface{}{lease.Owner, nil}},
+		},
+		bson.M{
+			"$set":   bson.M{"status": StatusQueued},
+			"$unset": bson.M{"owner": "", "lease_expires": ""},
+		},
+	)
+}
+
+// mongoLease builds the fields that place a job in a status under a lease.
+func mongoLease(status string, lease Lease) bson.M {
+	return bson.M{
+		"status":        status,
+		"owner":         lease.Owner,
+		"lease_expires": lease.Expires,
+	}
+}
+
+// QueueSummary counts the queued and running jobs of each account that has any.
+func (storage *MongoStorage) QueueSummary() ([]AccountQueue, error) {
+	var groups []struct {
+		ID struct {
+			Account string `bson:"account"`
+			Status  string `bson:"status"`
+		} `bson:"_id"`
+		Count int `bson:"count"`
+	}
+
+	err := storage.jobs().Pipe([]bson.M{
+		{"$match": bson.M{
+			"status":  bson.M{"$in": []string{StatusQueued, StatusProcessing}},
+			"pending": mongoNotPending,
+		}},
+		{"$group": bson.M{
+			"_id":   bson.M{"account": "$account", "status": "$status"},
+			"count": bson.M{"$sum": 1},
+		}},
+	}).All(&groups)
+	if err != nil {
+		return nil, err
+	}
+
+	queues := accountQueues{}
+	for _, group := range groups {
+		queues.add(group.ID.Account, group.ID.Status, group.Count)
+	}
+	return queues.list(), nil
+}
+
+// QueueRevision returns a number that advances each time a job joins the queue.
+func (storage *MongoStorage) QueueRevision() (uint64, error) {
+	var root MongoRoot
+	if err := storage.root().Find(bson.M{}).One(&root); err != nil {
+		return 0, err
+	}
+	return root.QueueRevision, nil
+}
+
 // UpdateJob updates the state of a job in the database to match any changes made to the model.
 func (storage *MongoStorage) UpdateJob(job *SubmittedJob) error {
+	// The job as it was before the update is returned.
 	var out SubmittedJob
 	_, err := storage.jobs().FindId(job.JID).Apply(mgo.Change{
 		Update: bson.M{"$set": job},
 	}, &out)
+	if err != nil {
+		return err
+	}
+
+	if job.Status == StatusQueued && out.Status != StatusQueued {
+		storage.advanceQueue(1)
+	}
+	return nil
+}
+
+// ReprioritizeJob changes the priority of a job that's still queued. It returns ErrNotFound if no
+// queued job has the requested JID.
+func (storage *MongoStorage) ReprioritizeJob(jid uint64, priority int) error {
+	return storage.jobs().Update(
+		bson.M{"_id": jid, "status": StatusQueued},
+		bson.M{"$set": bson.M{"job.priority": priority}},
+	)
+}
+
+// AppendOutput stores a chunk of output from a running job.
+func (storage *MongoStorage) AppendOutput(chunk OutputChunk) error {
+	return storage.output().Insert(chunk)
+}
+
+// DeleteJobs permanently removes jobs and their output. JIDs that don't exist are ignored.
+func (storage *MongoStorage) DeleteJobs(jids []uint64) error {
+	if len(jids) == 0 {
+		return nil
+	}
+
+	if _, err := storage.output().RemoveAll(bson.M{"jid": bson.M{"$in": jids}}); err != nil {
+		return err
+	}
+	_, err := storage.jobs().RemoveAll(bson.M{"_id": bson.M{"$in": jids}})
 	return err
 }
 
+// NextArrayID allocates a new job array ID.
+func (storage *MongoStorage) NextArrayID() (uint64, error) {
+	var root MongoRoot
+	_, err := storage.root().Find(bson.M{}).Apply(mgo.Change{
+		Update:    bson.M{"$inc": bson.M{"array_id": 1}},
+		ReturnNew: true,
+	}, &root)
+	if err != nil {
+		return 0, err
+	}
+	return root.ArrayID, nil
+}
+
+// Schedule storage
+
+// InsertSchedule stores a new schedule and returns a newly allocated schedule ID.
+func (storage *MongoStorage) InsertSchedule(schedule Schedule) (uint64, error) {
+	var root MongoRoot
+	_, err := storage.root().Find(bson.M{}).Apply(mgo.Change{
+		Update:    bson.M{"$inc": bson.M{"schedule_id": 1}},
+		ReturnNew: true,
+	}, &root)
+	if err != nil {
+		return 0, err
+	}
+	schedule.ID = root.ScheduleID
+
+	if err := storage.schedules().Insert(schedule); err != nil {
+		return 0, err
+	}
+	return schedule.ID, nil
+}
+
+// ListSchedules lists the schedules that belong to an account, or to every account if it's empty,
+// in ID order.
+func (storage *MongoStorage) ListSchedules(account string) ([]Schedule, error) {
+	q := bson.M{}
+	if account != "" {
+		q["account"] = account
+	}
+
+	var schedules []Schedule
+	if err := storage.schedules().Find(q).Sort("_id").All(&schedules); err != nil {
+		return nil, err
+	}
+	if schedules == nil {
+		schedules = []Schedule{}
+	}
+	return schedules, nil
+}
+
+// AdvanceSchedule moves a schedule's next run from one time to the next. It returns ErrNotFound if
+// the schedule doesn't exist or its next run isn't at from any longer.
+func (storage *MongoStorage) AdvanceSchedule(id uint64, from, next StoredTime) error {
+	return storage.schedules().Update(
+		bson.M{"_id": id, "next_run_at": from},
+		bson.M{"$set": bson.M{"next_run_at": next}},
+	)
+}
+
+// RecordScheduleRun adds a run to a schedule's history, forgetting the oldest runs beyond the
+// history limit.
+func (storage *MongoStorage) RecordScheduleRun(id uint64, run ScheduleRun) error {
+	return storage.schedules().UpdateId(id, bson.M{
+		"$push": bson.M{"runs": bson.M{
+			"$each":  []ScheduleRun{run},
+			"$slice": -scheduleHistoryLimit,
+		}},
+	})
+}
+
+// PauseSchedule pauses or resumes a schedule, and sets its next run.
+func (storage *MongoStorage) PauseSchedule(id uint64, paused bool, next StoredTime) error {
+	return storage.schedules().UpdateId(id, bson.M{
+		"$set": bson.M{"paused": paused, "next_run_at": next},
+	})
+}
+
+// DeleteSchedule permanently removes a schedule. The jobs that it submitted are left alone.
+func (storage *MongoStorage) DeleteSchedule(id uint64) error {
+	return storage.schedules().RemoveId(id)
+}
+
 // Account storage
 
 // GetAccount loads an account by its unique account name, creating it if it doesn't already exist.
@@ -236,6 +975,13 @@ func (storage *MongoStorage) UpdateAccountAdmin(name string, admin bool) error {
 	})
 }
 
+// UpdateAccountMaxPriority changes the highest priority that an account may request.
+func (storage *MongoStorage) UpdateAccountMaxPriority(name string, max int) error {
+	return storage.accounts().UpdateId(name, bson.M{
+		"$set": bson.M{"max_priority": max},
+	})
+}
+
 // UpdateAccountUsage updates an account to take a new job into account.
 func (storage *MongoStorage) UpdateAccountUsage(name string, runtime int64) error {
 	return storage.accounts().UpdateId(name, bson.M{
@@ -263,6 +1009,11 @@ func (storage NullStorage) InsertJob(job SubmittedJob) (uint64, error) {
 	return 0, nil
 }
 
+// InsertJobs is a no-op.
+func (storage NullStorage) InsertJobs(jobs []SubmittedJob) ([]uint64, error) {
+	return make([]uint64, len(jobs)), nil
+}
+
 // ListJobs returns an empty collection.
 func (storage NullStorage) ListJobs(query JobQuery) ([]SubmittedJob, error) {
 	return []SubmittedJob{}, nil
@@ -274,15 +1025,95 @@ func (storage NullStorage) JobKillRequested(id uint64) (bool, error) {
 }
 
 // ClaimJob always returns nil.
-func (storage NullStorage) ClaimJob() (*SubmittedJob, error) {
+func (storage NullStorage) ClaimJob(lease Lease, full []string) (*SubmittedJob, error) {
 	return nil, nil
 }
 
+// ClaimAccountJob always returns nil.
+func (storage NullStorage) ClaimAccountJob(account string, lease Lease, full []string) (*SubmittedJob, error) {
+	return nil, nil
+}
+
+// ClaimLapsedJob always returns nil.
+func (storage NullStorage) ClaimLapsedJob(lease Lease, now StoredTime) (*SubmittedJob, error) {
+	return nil, nil
+}
+
+// RenewLease is a no-op.
+func (storage NullStorage) RenewLease(jid uint64, lease Lease) error {
+	return nil
+}
+
+// UnclaimJob is a no-op.
+func (storage NullStorage) UnclaimJob(jid uint64, lease Lease) error {
+	return nil
+}
+
+// QueueSummary returns an empty summary.
+func (storage NullStorage) QueueSummary() ([]AccountQueue, error) {
+	return []AccountQueue{}, nil
+}
+
+// QueueRevision always returns zero.
+func (storage NullStorage) QueueRevision() (uint64, error) {
+	return 0, nil
+}
+
+// ReprioritizeJob always returns ErrNotFound.
+func (storage NullStorage) ReprioritizeJob(jid uint64, priority int) error {
+	return ErrNotFound
+}
+
 // UpdateJob is a no-op.
 func (storage NullStorage) UpdateJob(job *SubmittedJob) error {
 	return nil
 }
 
+// AppendOutput is a no-op.
+func (storage NullStorage) AppendOutput(chunk OutputChunk) error {
+	return nil
+}
+
+// DeleteJobs is a no-op.
+func (storage NullStorage) DeleteJobs(jids []uint64) error {
+	return nil
+}
+
+// NextArrayID always returns zero.
+func (storage NullStorage) NextArrayID() (uint64, error) {
+	return 0, nil
+}
+
+// InsertSchedule is a no-op.
+func (storage NullStorage) InsertSchedule(schedule Schedule) (uint64, error) {
+	return 0, nil
+}
+
+// ListSchedules returns an empty collection.
+func (storage NullStorage) ListSchedules(account string) ([]Schedule, error) {
+	return []Schedule{}, nil
+}
+
+// AdvanceSchedule always returns ErrNotFound.
+func (storage NullStorage) AdvanceSchedule(id uint64, from, next StoredTime) error {
+	return ErrNotFound
+}
+
+// RecordScheduleRun is a no-op.
+func (storage NullStorage) RecordScheduleRun(id uint64, run ScheduleRun) error {
+	return nil
+}
+
+// PauseSchedule always returns ErrNotFound.
+func (storage NullStorage) PauseSchedule(id uint64, paused bool, next StoredTime) error {
+	return ErrNotFound
+}
+
+// DeleteSchedule always returns ErrNotFound.
+func (storage NullStorage) DeleteSchedule(id uint64) error {
+	return ErrNotFound
+}
+
 // GetAccount returns a fake, zero-initialized Account.
 func (storage NullStorage) GetAccount(name string) (*Account, error) {
 	return &Account{Name: name}, nil
@@ -293,6 +1124,11 @@ func (storage NullStorage) UpdateAccountAdmin(name string, admin bool) error {
 	return nil
 }
 
+// UpdateAccountMaxPriority is a no-op.
+func (storage NullStorage) UpdateAccountMaxPriority(name string, max int) error {
+	return nil
+}
+
 // UpdateAccountUsage is a no-op.
 func (storage NullStorage) UpdateAccountUsage(name string, runtime int64) error {
 	return nil
@@ -0,0 +1,817 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"time"
+
+	"github.com/cloudpipe/mgo/bson"
+	bolt "go.etcd.io/bbolt"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	// boltJobs holds BSON-encoded SubmittedJobs keyed by JID.
+	boltJobs = []byte("jobs")
+
+	// boltQueue indexes queued jobs by descending priority, then creation time, then JID, so that
+	// ClaimJob can find the next pending job without scanning every job.
+	boltQueue = []byte("queue")
+
+	// boltOutput holds the data of each OutputChunk, keyed by JID, stream and sequence.
+	boltOutput = []byte("output")
+
+	// boltAccounts holds BSON-encoded Accounts keyed by account name.
+	boltAccounts = []byte("accounts")
+
+	// boltSchedules holds BSON-encoded Schedules keyed by schedule ID.
+	boltSchedules = []byte("schedules")
+
+	// boltArrays is empty. Its sequence allocates job array IDs.
+	boltArrays = []byte("arrays")
+)
+
+// BoltStorage is a Storage implementation that keeps everything in a single, embedded BoltDB file.
+// It's useful for development and small deployments that don't want to run a MongoDB cluster.
+type BoltStorage struct {
+	DB *bolt.DB
+}
+
+// Ensure that BoltStorage adheres to the Storage interface.
+var _ Storage = &BoltStorage{}
+
+// NewBoltStorage opens or creates the BoltDB file at the provided path.
+func NewBoltStorage(path string) (*BoltStorage, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltStorage{DB: db}, nil
+}
+
+// boltID encodes a JID as a big-endian key, so that bolt's byte-ordered keys sort numerically.
+func boltID(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+// boltQueueKeySize is the length of the keys within the queue bucket.
+const boltQueueKeySize = 24
+
+// boltQueueKey derives the key used to index a queued job in the queue bucket. The priority is
+// stored with its sign bit flipped and then inverted, so that higher priorities sort first.
+func boltQueueKey(job *SubmittedJob) []byte {
+	key := make([]byte, boltQueueKeySize)
+	binary.BigEndian.PutUint64(key[0:8], ^(uint64(job.Priority) ^ 1<<63))
+	binary.BigEndian.PutUint64(key[8:16], uint64(job.CreatedAt))
+	binary.BigEndian.PutUint64(key[16:24], job.JID)
+	return key
+}
+
+// boltOutputKey derives the key used to store an OutputChunk in the output bucket. Keys begin with
+// the JID, so all of a job's output can be found with a single prefix scan.
+func boltOutputKey(chunk OutputChunk) []byte {
+	key := make([]byte, 0, 8+len(chunk.Stream)+9)
+	key = append(key, boltID(chunk.JID)...)
+	key = append(key, chunk.Stream...)
+	key = append(key, 0)
+	return append(key, boltID(uint64(chunk.Sequence))...)
+}
+
+// Bootstrap creates the buckets used by the other storage calls.
+func (storage *BoltStorage) Bootstrap() error {
+	err := storage.DB.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltJobs, boltQueue, boltOutput, boltAccounts, boltSchedules, boltArrays} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"path": storage.DB.Path(),
+	}).Debug("BoltDB buckets initialized.")
+
+	return nil
+}
+
+// Job storage
+
+// getJob loads and decodes a single job within a transaction. It returns ErrNotFound if no job
+// with the requested JID exists.
+func (storage *BoltStorage) getJob(tx *bolt.Tx, id uint64) (*SubmittedJob, error) {
+	raw := tx.Bucket(boltJobs).Get(boltID(id))
+	if raw == nil {
+		return nil, ErrNotFound
+	}
+
+	var job SubmittedJob
+	if err := bson.Unmarshal(raw, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// putJob encodes and stores a job within a transaction, keeping the queue index consistent with
+// its previous state.
+func (storage *BoltStorage) putJob(tx *bolt.Tx, previous, job *SubmittedJob) error {
+	raw, err := bson.Marshal(job)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(boltJobs).Put(boltID(job.JID), raw); err != nil {
+		return err
+	}
+
+	queue := tx.Bucket(boltQueue)
+	if previous != nil && previous.Status == StatusQueued {
+		if err := queue.Delete(boltQueueKey(previous)); err != nil {
+			return err
+		}
+	}
+	if job.Status == StatusQueued {
+		if err := queue.Put(boltQueueKey(job), boltID(job.JID)); err != nil {
+			return err
+		}
+
+		// The queue bucket's sequence is the queue revision.
+		if previous == nil || previous.Status != StatusQueued {
+			if _, err := queue.NextSequence(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// InsertJob appends a job to the queue and returns a newly allocated job ID.
+func (storage *BoltStorage) InsertJob(job SubmittedJob) (uint64, error) {
+	err := storage.DB.Update(func(tx *bolt.Tx) error {
+		// Assign the job a job ID.
+		id, err := tx.Bucket(boltJobs).NextSequence()
+		if err != nil {
+			return err
+		}
+		job.JID = id
+
+		return storage.putJob(tx, nil, &job)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return job.JID, nil
+}
+
+// InsertJobs appends a batch of jobs to the queue, all or nothing, and returns their JIDs.
+func (storage *BoltStorage) InsertJobs(jobs []SubmittedJob) ([]uint64, error) {
+	jids := make([]uint64, len(jobs))
+	err := storage.DB.Update(func(tx *bolt.Tx) error {
+		if len(jobs) == 0 {
+			return nil
+		}
+
+		// Reserve a block of job IDs.
+		bucket := tx.Bucket(boltJobs)
+		first := bucket.Sequence() + 1
+		if err := bucket.SetSequence(bucket.Sequence() + uint64(len(jobs))); err != nil {
+			return err
+		}
+
+		for i, job := range jobs {
+			job.JID = first + uint64(i)
+			if err := storage.putJob(tx, nil, &job); err != nil {
+				return err
+			}
+			jids[i] = job.JID
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return jids, nil
+}
+
+// ListJobs queries jobs that have been submitted to the cluster, in JID order.
+func (storage *BoltStorage) ListJobs(query JobQuery) ([]SubmittedJob, error) {
+	result := []SubmittedJob{}
+	full := func() bool {
+		return query.Limit > 0 && len(result) >= query.Limit
+	}
+
+	collect := func(tx *bolt.Tx) error {
+		if len(query.JIDs) > 0 {
+			jids := make([]uint64, len(query.JIDs))
+			copy(jids, query.JIDs)
+			if query.Descending {
+				sort.Sort(sort.Reverse(jidSlice(jids)))
+			} else {
+				sort.Sort(jidSlice(jids))
+			}
+
+			for i, jid := range jids {
+				if full() {
+					break
+				}
+				if i > 0 && jids[i-1] == jid {
+					continue
+				}
+
+				job, err := storage.getJob(tx, jid)
+				if err == ErrNotFound {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+
+				if query.Matches(job) {
+					result = append(result, *job)
+				}
+			}
+			return nil
+		}
+
+		c := tx.Bucket(boltJobs).Cursor()
+
+		// Walk the jobs bucket from one bound towards the other.
+		var k, v []byte
+		next := c.Next
+		inBounds := func(id uint64) bool {
+			return query.Before == 0 || id < query.Before
+		}
+
+		if query.Descending {
+			next = c.Prev
+			inBounds = func(id uint64) bool {
+				return query.After == 0 || id >= query.After
+			}
+
+			if query.Before != 0 {
+				// Seek finds the first key at or after Before, so step back from it.
+				if k, _ = c.Seek(boltID(query.Before)); k != nil {
+					k, v = c.Prev()
+				} else {
+					k, v = c.Last()
+				}
+			} else {
+				k, v = c.Last()
+			}
+		} else if query.After != 0 {
+			k, v = c.Seek(boltID(query.After))
+		} else {
+			k, v = c.First()
+		}
+
+		for ; k != nil && !full(); k, v = next() {
+			if !inBounds(binary.BigEndian.Uint64(k)) {
+				break
+			}
+
+			var job SubmittedJob
+			if err := bson.Unmarshal(v, &job); err != nil {
+				return err
+			}
+
+			if query.Matches(&job) {
+				result = append(result, job)
+			}
+		}
+		return nil
+	}
+
+	err := storage.DB.View(func(tx *bolt.Tx) error {
+		if err := collect(tx); err != nil {
+			return err
+		}
+		if query.SkipOutput {
+			return nil
+		}
+
+		// Assemble each job's output from its chunks.
+		var chunks []OutputChunk
+		c := tx.Bucket(boltOutput).Cursor()
+		for _, job := range result {
+			prefix := boltID(job.JID)
+			for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+				chunks = append(chunks, OutputChunk{
+					JID:      job.JID,
+					Stream:   string(k[8 : len(k)-9]),
+					Sequence: int(binary.BigEndian.Uint64(k[len(k)-8:])),
+					Data:     append([]byte(nil), v...),
+				})
+			}
+		}
+		assembleOutput(result, chunks)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// JobKillRequested returns true if a request has been submitted to kill the job with with provided
+// JID, and false otherwise.
+func (storage *BoltStorage) JobKillRequested(id uint64) (bool, error) {
+	var killed bool
+	err := storage.DB.View(func(tx *bolt.Tx) error {
+		job, err := storage.getJob(tx, id)
+		if err != nil {
+			return err
+		}
+		killed = job.KillRequested
+		return nil
+	})
+	return killed, err
+}
+
+// ClaimJob atomically searches for the oldest pending SubmittedJob with the highest priority, marks
+// it as StatusProcessing under the provided lease, and returns it. Jobs of the core types in full
+// are passed over. nil is returned if no SubmittedJobs are available.
+func (storage *BoltStorage) ClaimJob(lease Lease, full []string) (*SubmittedJob, error) {
+	return storage.claim("", lease, full)
+}
+
+// ClaimAccountJob atomically claims the oldest pending SubmittedJob that belongs to a specific
+// account, in the same manner as ClaimJob.
+func (storage *BoltStorage) ClaimAccountJob(account string, lease Lease, full []string) (*SubmittedJob, error) {
+	return storage.claim(account, lease, full)
+}
+
+// claim marks the next queued job as StatusProcessing and returns it. If account is non-empty, the
+// queue is scanned for that account's next job.
+func (storage *BoltStorage) claim(account string, lease Lease, full []string) (*SubmittedJob, error) {
+	var claimed *SubmittedJob
+	err := storage.DB.Update(func(tx *bolt.Tx) error {
+		var previous *SubmittedJob
+
+		c := tx.Bucket(boltQueue).Cursor()
+		for _, v := c.First(); v != nil; _, v = c.Next() {
+			job, err := storage.getJob(tx, binary.BigEndian.Uint64(v))
+			if err != nil {
+				return err
+			}
+			if (account == "" || job.Account == account) && !passedOver(job.Core, full) {
+				previous = job
+				break
+			}
+		}
+		if previous == nil {
+			// No jobs in the queue.
+			return nil
+		}
+
+		job := *previous
+		job.Status = StatusProcessing
+		job.grant(lease)
+		if err := storage.putJob(tx, previous, &job); err != nil {
+			return err
+		}
+
+		claimed = &job
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return claimed, nil
+}
+
+// ClaimLapsedJob atomically takes over a processing job whose lease lapsed before now, granting it
+// the provided lease instead, and returns it. nil is returned if no leases have lapsed. Processing
+// jobs aren't indexed, so every job is scanned.
+func (storage *BoltStorage) ClaimLapsedJob(lease Lease, now StoredTime) (*SubmittedJob, error) {
+	var claimed *SubmittedJob
+	err := storage.DB.Update(func(tx *bolt.Tx) error {
+		var previous *SubmittedJob
+
+		c := tx.Bucket(boltJobs).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var summary struct {
+				Status       string     `bson:"status"`
+				LeaseExpires StoredTime `bson:"lease_expires"`
+			}
+			if err := bson.Unmarshal(v, &summary); err != nil {
+				return err
+			}
+			if summary.Status != StatusProcessing || summary.LeaseExpires == 0 || summary.LeaseExpires >= now {
+				continue
+			}
+
+			job, err := storage.getJob(tx, binary.BigEndian.Uint64(k))
+			if err != nil {
+				return err
+			}
+			previous = job
+			break
+		}
+		if previous == nil {
+			// No lapsed leases.
+			return nil
+		}
+
+		job := *previous
+		job.grant(lease)
+		if err := storage.putJob(tx, previous, &job); err != nil {
+			return err
+		}
+
+		claimed = &job
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return claimed, nil
+}
+
+// RenewLease replaces the lease of a processing job that's held by lease.Owner, or by nobody. It
+// returns ErrNotFound if the job isn't processing or another runner holds it.
+func (storage *BoltStorage) RenewLease(jid uint64, lease Lease) error {
+	return storage.DB.Update(func(tx *bolt.Tx) error {
+		previous, err := storage.getJob(tx, jid)
+		if err != nil {
+			return err
+		}
+		if !previous.leaseHeldBy(lease.Owner) {
+			return ErrNotFound
+		}
+
+		job := *previous
+		job.grant(lease)
+		return storage.putJob(tx, previous, &job)
+	})
+}
+
+// UnclaimJob returns a processing job that's held by lease.Owner, or by nobody, to the queue as
+// though it had never been claimed. Unlike UpdateJob, it doesn't advance the queue revision. It
+// returns ErrNotFound if the job isn't processing or another runner holds it.
+func (storage *BoltStorage) UnclaimJob(jid uint64, lease Lease) error {
+	return storage.DB.Update(func(tx *bolt.Tx) error {
+		previous, err := storage.getJob(tx, jid)
+		if err != nil {
+			return err
+		}
+		if !previous.leaseHeldBy(lease.Owner) {
+			return ErrNotFound
+		}
+
+		job := *previous
+		job.Status = StatusQueued
+		job.grant(Lease{})
+
+		// putJob would advance the queue bucket's sequence, so index the job here instead.
+		raw, err := bson.Marshal(&job)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(boltJobs).Put(boltID(jid), raw); err != nil {
+			return err
+		}
+		return tx.Bucket(boltQueue).Put(boltQueueKey(&job), boltID(jid))
+	})
+}
+
+// QueueSummary counts the queued and running jobs of each account that has any.
+func (storage *BoltStorage) QueueSummary() ([]AccountQueue, error) {
+	queues := accountQueues{}
+	err := storage.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltJobs).ForEach(func(k, v []byte) error {
+			var job struct {
+				Account string `bson:"account"`
+				Status  string `bson:"status"`
+			}
+			if err := bson.Unmarshal(v, &job); err != nil {
+				return err
+			}
+
+			queues.add(job.Account, job.Status, 1)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return queues.list(), nil
+}
+
+// QueueRevision returns a number that advances each time a job joins the queue.
+func (storage *BoltStorage) QueueRevision() (uint64, error) {
+	var revision uint64
+	err := storage.DB.View(func(tx *bolt.Tx) error {
+		revision = tx.Bucket(boltQueue).Sequence()
+		return nil
+	})
+	return revision, err
+}
+
+// UpdateJob updates the state of a job in the database to match any changes made to the model.
+func (storage *BoltStorage) UpdateJob(job *SubmittedJob) error {
+	return storage.DB.Update(func(tx *bolt.Tx) error {
+		previous, err := storage.getJob(tx, job.JID)
+		if err != nil {
+			return err
+		}
+
+		merged, err := mergeJobUpdate(previous, job)
+		if err != nil {
+			return err
+		}
+
+		return storage.putJob(tx, previous, merged)
+	})
+}
+
+// ReprioritizeJob changes the priority of a job that's still queued. It returns ErrNotFound if no
+// queued job has the requested JID.
+func (storage *BoltStorage) ReprioritizeJob(jid uint64, priority int) error {
+	return storage.DB.Update(func(tx *bolt.Tx) error {
+		previous, err := storage.getJob(tx, jid)
+		if err != nil {
+			return err
+		}
+		if previous.Status != StatusQueued {
+			return ErrNotFound
+		}
+
+		job := *previous
+		job.Priority = priority
+		return storage.putJob(tx, previous, &job)
+	})
+}
+
+// AppendOutput stores a chunk of output from a running job.
+func (storage *BoltStorage) AppendOutput(chunk OutputChunk) error {
+	return storage.DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltOutput).Put(boltOutputKey(chunk), chunk.Data)
+	})
+}
+
+// DeleteJobs permanently removes jobs and their output. JIDs that don't exist are ignored.
+func (storage *BoltStorage) DeleteJobs(jids []uint64) error {
+	return storage.DB.Update(func(tx *bolt.Tx) error {
+		jobs, queue, output := tx.Bucket(boltJobs), tx.Bucket(boltQueue), tx.Bucket(boltOutput)
+
+		for _, jid := range jids {
+			job, err := storage.getJob(tx, jid)
+			if err == ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			if job.Status == StatusQueued {
+				if err := queue.Delete(boltQueueKey(job)); err != nil {
+					return err
+				}
+			}
+			if err := jobs.Delete(boltID(jid)); err != nil {
+				return err
+			}
+
+			// Collect the keys first: deleting while iterating would skip entries.
+			var keys [][]byte
+			prefix := boltID(jid)
+			c := output.Cursor()
+			for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+			for _, k := range keys {
+				if err := output.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// NextArrayID allocates a new job array ID.
+func (storage *BoltStorage) NextArrayID() (uint64, error) {
+	var id uint64
+	err := storage.DB.Update(func(tx *bolt.Tx) error {
+		var err error
+		id, err = tx.Bucket(boltArrays).NextSequence()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// Schedule storage
+
+// putSchedule encodes and stores a schedule within a transaction.
+func (storage *BoltStorage) putSchedule(tx *bolt.Tx, schedule *Schedule) error {
+	raw, err := bson.Marshal(schedule)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltSchedules).Put(boltID(schedule.ID), raw)
+}
+
+// updateSchedule applies a modification to an existing schedule. It returns ErrNotFound if the
+// schedule doesn't exist, or whatever error the modification returns.
+func (storage *BoltStorage) updateSchedule(id uint64, modify func(*Schedule) error) error {
+	return storage.DB.Update(func(tx *bolt.Tx) error {
+		raw := tx.Bucket(boltSchedules).Get(boltID(id))
+		if raw == nil {
+			return ErrNotFound
+		}
+
+		var schedule Schedule
+		if err := bson.Unmarshal(raw, &schedule); err != nil {
+			return err
+		}
+		if err := modify(&schedule); err != nil {
+			return err
+		}
+		return storage.putSchedule(tx, &schedule)
+	})
+}
+
+// InsertSchedule stores a new schedule and returns a newly allocated schedule ID.
+func (storage *BoltStorage) InsertSchedule(schedule Schedule) (uint64, error) {
+	err := storage.DB.Update(func(tx *bolt.Tx) error {
+		id, err := tx.Bucket(boltSchedules).NextSequence()
+		if err != nil {
+			return err
+		}
+		schedule.ID = id
+
+		return storage.putSchedule(tx, &schedule)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return schedule.ID, nil
+}
+
+// ListSchedules lists the schedules that belong to an account, or to every account if it's empty,
+// in ID order.
+func (storage *BoltStorage) ListSchedules(account string) ([]Schedule, error) {
+	schedules := []Schedule{}
+	err := storage.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltSchedules).ForEach(func(k, v []byte) error {
+			var schedule Schedule
+			if err := bson.Unmarshal(v, &schedule); err != nil {
+				return err
+			}
+			if account == "" || schedule.Account == account {
+				schedules = append(schedules, schedule)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+// AdvanceSchedule moves a schedule's next run from one time to the next. It returns ErrNotFound if
+// the schedule doesn't exist or its next run isn't at from any longer.
+func (storage *BoltStorage) AdvanceSchedule(id uint64, from, next StoredTime) error {
+	return storage.updateSchedule(id, func(schedule *Schedule) error {
+		if schedule.NextRunAt != from {
+			return ErrNotFound
+		}
+		schedule.NextRunAt = next
+		return nil
+	})
+}
+
+// RecordScheduleRun adds a run to a schedule's history, forgetting the oldest runs beyond the
+// history limit.
+func (storage *BoltStorage) RecordScheduleRun(id uint64, run ScheduleRun) error {
+	return storage.updateSchedule(id, func(schedule *Schedule) error {
+		schedule.record(run)
+		return nil
+	})
+}
+
+// PauseSchedule pauses or resumes a schedule, and sets its next run.
+func (storage *BoltStorage) PauseSchedule(id uint64, paused bool, next StoredTime) error {
+	return storage.updateSchedule(id, func(schedule *Schedule) error {
+		schedule.Paused = paused
+		schedule.NextRunAt = next
+		return nil
+	})
+}
+
+// DeleteSchedule permanently removes a schedule. The jobs that it submitted are left alone.
+func (storage *BoltStorage) DeleteSchedule(id uint64) error {
+	return storage.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltSchedules)
+		if bucket.Get(boltID(id)) == nil {
+			return ErrNotFound
+		}
+		return bucket.Delete(boltID(id))
+	})
+}
+
+// Account storage
+
+// getAccount loads and decodes a single account within a transaction, returning nil if no account
+// with that name exists.
+func (storage *BoltStorage) getAccount(tx *bolt.Tx, name string) (*Account, error) {
+	raw := tx.Bucket(boltAccounts).Get([]byte(name))
+	if raw == nil {
+		return nil, nil
+	}
+
+	var account Account
+	if err := bson.Unmarshal(raw, &account); err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// putAccount encodes and stores an account within a transaction.
+func (storage *BoltStorage) putAccount(tx *bolt.Tx, account *Account) error {
+	raw, err := bson.Marshal(account)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltAccounts).Put([]byte(account.Name), raw)
+}
+
+// updateAccount applies a modification to an existing account. It returns ErrNotFound if the
+// account doesn't exist.
+func (storage *BoltStorage) updateAccount(name string, modify func(*Account)) error {
+	return storage.DB.Update(func(tx *bolt.Tx) error {
+		account, err := storage.getAccount(tx, name)
+		if err != nil {
+			return err
+		}
+		if account == nil {
+			return ErrNotFound
+		}
+
+		modify(account)
+		return storage.putAccount(tx, account)
+	})
+}
+
+// GetAccount loads an account by its unique account name, creating it if it doesn't already exist.
+func (storage *BoltStorage) GetAccount(name string) (*Account, error) {
+	var out *Account
+	err := storage.DB.Update(func(tx *bolt.Tx) error {
+		account, err := storage.getAccount(tx, name)
+		if err != nil {
+			return err
+		}
+		if account == nil {
+			account = &Account{Name: name}
+			if err := storage.putAccount(tx, account); err != nil {
+				return err
+			}
+		}
+
+		out = account
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// UpdateAccountAdmin flags or unflags an account as an administrator.
+func (storage *BoltStorage) UpdateAccountAdmin(name string, admin bool) error {
+	return storage.updateAccount(name, func(account *Account) {
+		account.Admin = admin
+	})
+}
+
+// UpdateAccountMaxPriority changes the highest priority that an account may request.
+func (storage *BoltStorage) UpdateAccountMaxPriority(name string, max int) error {
+	return storage.updateAccount(name, func(account *Account) {
+		account.MaxPriority = max
+	})
+}
+
+// UpdateAccountUsage updates an account to take a new job into account.
+func (storage *BoltStorage) UpdateAccountUsage(name string, runtime int64) error {
+	return storage.updateAccount(name, func(account *Account) {
+		account.TotalRuntime += runtime
+		account.TotalJobs++
+	})
+}
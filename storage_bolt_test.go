@@ -0,0 +1,224 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// withBoltStorage creates a bootstrapped BoltStorage in a temporary directory and invokes the
+// provided function with it. The database file is removed afterwards.
+func withBoltStorage(t *testing.T, f func(storage *BoltStorage, path string)) {
+	dir, err := ioutil.TempDir("", "cloudpipe-bolt")
+	if err != nil {
+		t.Fatalf("Unable to create a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	dbPath := path.Join(dir, "pipe.db")
+	storage, err := NewBoltStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Unable to open BoltDB storage: %v", err)
+	}
+	if err := storage.Bootstrap(); err != nil {
+		t.Fatalf("Unable to bootstrap BoltDB storage: %v", err)
+	}
+
+	f(storage, dbPath)
+
+	storage.DB.Close()
+}
+
+func TestNewStorageBolt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloudpipe-bolt")
+	if err != nil {
+		t.Fatalf("Unable to create a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &Context{Settings: Settings{Storage: "bolt://" + path.Join(dir, "pipe.db")}}
+	storage, err := NewStorage(c)
+	if err != nil {
+		t.Fatalf("Unexpected error creating storage: %v", err)
+	}
+	bs, ok := storage.(*BoltStorage)
+	if !ok {
+		t.Fatalf("Expected %#v to be a BoltStorage", storage)
+	}
+	bs.DB.Close()
+}
+
+func TestNewStorageUnrecognized(t *testing.T) {
+	c := &Context{Settings: Settings{Storage: "carrier-pigeon://coop"}}
+	if _, err := NewStorage(c); err == nil {
+		t.Error("Expected an error for an unrecognized storage URL")
+	}
+}
+
+func TestBoltJIDsSurviveReopen(t *testing.T) {
+	withBoltStorage(t, func(storage *BoltStorage, dbPath string) {
+		first, err := storage.InsertJob(SubmittedJob{Status: StatusQueued})
+		if err != nil {
+			t.Fatalf("Unable to insert a job: %v", err)
+		}
+		second, err := storage.InsertJob(SubmittedJob{Status: StatusQueued})
+		if err != nil {
+			t.Fatalf("Unable to insert a job: %v", err)
+		}
+		if second <= first {
+			t.Errorf("Expected JIDs to increase, got [%d] then [%d]", first, second)
+		}
+
+		storage.DB.Close()
+		reopened, err := NewBoltStorage(dbPath)
+		if err != nil {
+			t.Fatalf("Unable to reopen BoltDB storage: %v", err)
+		}
+		storage.DB = reopened.DB
+
+		third, err := storage.InsertJob(SubmittedJob{Status: StatusQueued})
+		if err != nil {
+			t.Fatalf("Unable to insert a job: %v", err)
+		}
+		if third <= second {
+			t.Errorf("Expected JIDs to keep increasing after a reopen, got [%d] then [%d]", second, third)
+		}
+	})
+}
+
+func TestBoltClaimOldestJob(t *testing.T) {
+	withBoltStorage(t, func(storage *BoltStorage, dbPath string) {
+		newer, _ := storage.InsertJob(SubmittedJob{CreatedAt: 200, Status: StatusQueued})
+		older, _ := storage.InsertJob(SubmittedJob{CreatedAt: 100, Status: StatusQueued})
+
+		job, err := storage.ClaimJob(Lease{}, nil)
+		if err != nil {
+			t.Fatalf("Unable to claim a job: %v", err)
+		}
+		if job == nil || job.JID != older {
+			t.Fatalf("Expected to claim job [%d], got %#v", older, job)
+		}
+		if job.Status != StatusProcessing {
+			t.Errorf("Expected the claimed job to be processing, was [%s]", job.Status)
+		}
+
+		job, err = storage.ClaimJob(Lease{}, nil)
+		if err != nil {
+			t.Fatalf("Unable to claim a job: %v", err)
+		}
+		if job == nil || job.JID != newer {
+			t.Fatalf("Expected to claim job [%d], got %#v", newer, job)
+		}
+
+		job, err = storage.ClaimJob(Lease{}, nil)
+		if err != nil {
+			t.Fatalf("Unable to claim a job: %v", err)
+		}
+		if job != nil {
+			t.Errorf("Expected an empty queue, but claimed job [%d]", job.JID)
+		}
+	})
+}
+
+func TestBoltListJobsFilters(t *testing.T) {
+	withBoltStorage(t, func(storage *BoltStorage, dbPath string) {
+		foo, bar := "foo", "bar"
+		storage.InsertJob(SubmittedJob{Job: Job{Name: &foo}, Account: "alice", Status: StatusQueued})
+		storage.InsertJob(SubmittedJob{Job: Job{Name: &bar}, Account: "alice", Status: StatusDone})
+		storage.InsertJob(SubmittedJob{Job: Job{Name: &foo}, Account: "bob", Status: StatusQueued})
+		storage.InsertJob(SubmittedJob{Job: Job{Name: &foo}, Account: "alice", Status: StatusDone})
+
+		jobs, err := storage.ListJobs(JobQuery{AccountName: "alice", Names: []string{"foo"}})
+		if err != nil {
+			t.Fatalf("Unable to list jobs: %v", err)
+		}
+		if len(jobs) != 2 || jobs[0].JID != 1 || jobs[1].JID != 4 {
+			t.Errorf("Expected jobs 1 and 4, got %#v", jobs)
+		}
+
+		jobs, err = storage.ListJobs(JobQuery{AccountName: "alice", Statuses: []string{StatusDone}, After: 3})
+		if err != nil {
+			t.Fatalf("Unable to list jobs: %v", err)
+		}
+		if len(jobs) != 1 || jobs[0].JID != 4 {
+			t.Errorf("Expected job 4, got %#v", jobs)
+		}
+
+		jobs, err = storage.ListJobs(JobQuery{JIDs: []uint64{4, 3, 1}, Before: 4, Limit: 1})
+		if err != nil {
+			t.Fatalf("Unable to list jobs: %v", err)
+		}
+		if len(jobs) != 1 || jobs[0].JID != 1 {
+			t.Errorf("Expected job 1, got %#v", jobs)
+		}
+	})
+}
+
+func TestBoltUpdateJobKeepsKillRequest(t *testing.T) {
+	withBoltStorage(t, func(storage *BoltStorage, dbPath string) {
+		jid, _ := storage.InsertJob(SubmittedJob{Status: StatusQueued})
+
+		if err := storage.UpdateJob(&SubmittedJob{JID: jid, Status: StatusQueued, KillRequested: true}); err != nil {
+			t.Fatalf("Unable to update job: %v", err)
+		}
+
+		// A runner that hasn't seen the kill request shouldn't clear it.
+		if err := storage.UpdateJob(&SubmittedJob{JID: jid, Status: StatusProcessing}); err != nil {
+			t.Fatalf("Unable to update job: %v", err)
+		}
+
+		killed, err := storage.JobKillRequested(jid)
+		if err != nil {
+			t.Fatalf("Unable to check the kill flag: %v", err)
+		}
+		if !killed {
+			t.Error("Expected the kill request to survive an update")
+		}
+
+		if job, _ := storage.ClaimJob(Lease{}, nil); job != nil {
+			t.Errorf("Expected the processing job to leave the queue, but claimed [%d]", job.JID)
+		}
+
+		if err := storage.UpdateJob(&SubmittedJob{JID: 999}); err != ErrNotFound {
+			t.Errorf("Expected ErrNotFound updating a missing job, got [%v]", err)
+		}
+	})
+}
+
+func TestBoltAccounts(t *testing.T) {
+	withBoltStorage(t, func(storage *BoltStorage, dbPath string) {
+		if err := storage.UpdateAccountAdmin("alice", true); err != ErrNotFound {
+			t.Errorf("Expected ErrNotFound for a missing account, got [%v]", err)
+		}
+
+		account, err := storage.GetAccount("alice")
+		if err != nil {
+			t.Fatalf("Unable to get account: %v", err)
+		}
+		if account.Name != "alice" || account.Admin {
+			t.Errorf("Unexpected new account: %#v", account)
+		}
+
+		if err := storage.UpdateAccountAdmin("alice", true); err != nil {
+			t.Fatalf("Unable to update account: %v", err)
+		}
+		if err := storage.UpdateAccountUsage("alice", 1000); err != nil {
+			t.Fatalf("Unable to update account: %v", err)
+		}
+		if err := storage.UpdateAccountUsage("alice", 500); err != nil {
+			t.Fatalf("Unable to update account: %v", err)
+		}
+
+		account, err = storage.GetAccount("alice")
+		if err != nil {
+			t.Fatalf("Unable to get account: %v", err)
+		}
+		if !account.Admin {
+			t.Error("Expected account to be an administrator")
+		}
+		if account.TotalRuntime != 1500 || account.TotalJobs != 2 {
+			t.Errorf("Unexpected account usage: %#v", account)
+		}
+	})
+}
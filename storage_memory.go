@@ -0,0 +1,518 @@
+package main
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/cloudpipe/mgo/bson"
+)
+
+// MemoryStorage is a thread-safe Storage implementation that keeps everything in process memory. It
+// honors the same query, claiming and update semantics as MongoStorage, so it's suitable for tests
+// and for running cloudpipe locally. Nothing survives a restart.
+type MemoryStorage struct {
+	sync.Mutex
+
+	jobID      uint64
+	revision   uint64
+	scheduleID uint64
+	arrayID    uint64
+	jobs       map[uint64]*SubmittedJob
+	output     map[uint64][]OutputChunk
+	accounts   map[string]*Account
+	schedules  map[uint64]*Schedule
+}
+
+// Ensure that MemoryStorage adheres to the Storage interface.
+var _ Storage = &MemoryStorage{}
+
+// NewMemoryStorage creates an empty MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		jobs:      make(map[uint64]*SubmittedJob),
+		output:    make(map[uint64][]OutputChunk),
+		accounts:  make(map[string]*Account),
+		schedules: make(map[uint64]*Schedule),
+	}
+}
+
+// cloneJob produces a deep copy of a SubmittedJob, so that callers never share maps, slices or
+// pointers with the stored models.
+func cloneJob(job *SubmittedJob) (*SubmittedJob, error) {
+	raw, err := bson.Marshal(job)
+	if err != nil {
+		return nil, err
+	}
+
+	var out SubmittedJob
+	if err := bson.Unmarshal(raw, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// cloneSchedule produces a deep copy of a Schedule, including its job template and history.
+func cloneSchedule(schedule *Schedule) (*Schedule, error) {
+	raw, err := bson.Marshal(schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	var out Schedule
+	if err := bson.Unmarshal(raw, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Bootstrap is a no-op.
+func (storage *MemoryStorage) Bootstrap() error {
+	return nil
+}
+
+// Job storage
+
+// InsertJob appends a job to the queue and returns a newly allocated job ID.
+func (storage *MemoryStorage) InsertJob(job SubmittedJob) (uint64, error) {
+	storage.Lock()
+	defer storage.Unlock()
+
+	stored, err := cloneJob(&job)
+	if err != nil {
+		return 0, err
+	}
+
+	storage.jobID++
+	stored.JID = storage.jobID
+	storage.jobs[stored.JID] = stored
+	if stored.Status == StatusQueued {
+		storage.revision++
+	}
+
+	return stored.JID, nil
+}
+
+// InsertJobs appends a batch of jobs to the queue, all or nothing, and returns their JIDs.
+func (storage *MemoryStorage) InsertJobs(jobs []SubmittedJob) ([]uint64, error) {
+	storage.Lock()
+	defer storage.Unlock()
+
+	stored := make([]*SubmittedJob, len(jobs))
+	for i := range jobs {
+		job, err := cloneJob(&jobs[i])
+		if err != nil {
+			return nil, err
+		}
+		stored[i] = job
+	}
+
+	jids := make([]uint64, len(jobs))
+	for i, job := range stored {
+		storage.jobID++
+		job.JID = storage.jobID
+		storage.jobs[job.JID] = job
+		jids[i] = job.JID
+		if job.Status == StatusQueued {
+			storage.revision++
+		}
+	}
+
+	return jids, nil
+}
+
+// ListJobs queries jobs that have been submitted to the cluster, in JID order.
+func (storage *MemoryStorage) ListJobs(query JobQuery) ([]SubmittedJob, error) {
+	storage.Lock()
+	defer storage.Unlock()
+
+	jids := make([]uint64, 0, len(storage.jobs))
+	for jid, job := range storage.jobs {
+		if query.Matches(job) {
+			jids = append(jids, jid)
+		}
+	}
+	if query.Descending {
+		sort.Sort(sort.Reverse(jidSlice(jids)))
+	} else {
+		sort.Sort(jidSlice(jids))
+	}
+
+	if query.Limit > 0 && len(jids) > query.Limit {
+		jids = jids[:query.Limit]
+	}
+
+	result := make([]SubmittedJob, len(jids))
+	var chunks []OutputChunk
+	for i, jid := range jids {
+		job, err := cloneJob(storage.jobs[jid])
+		if err != nil {
+			return nil, err
+		}
+		result[i] = *job
+		if !query.SkipOutput {
+			chunks = append(chunks, storage.output[jid]...)
+		}
+	}
+	assembleOutput(result, chunks)
+
+	return result, nil
+}
+
+// JobKillRequested returns true if a request has been submitted to kill the job with with provided
+// JID, and false otherwise.
+func (storage *MemoryStorage) JobKillRequested(id uint64) (bool, error) {
+	storage.Lock()
+	defer storage.Unlock()
+
+	job, ok := storage.jobs[id]
+	if !ok {
+		return false, ErrNotFound
+	}
+	return job.KillRequested, nil
+}
+
+// ClaimJob atomically searches for the oldest pending SubmittedJob with the highest priority, marks
+// it as StatusProcessing under the provided lease, and returns it. Jobs of the core types in full
+// are passed over. nil is returned if no SubmittedJobs are available.
+func (storage *MemoryStorage) ClaimJob(lease Lease, full []string) (*SubmittedJob, error) {
+	return storage.claim("", lease, full)
+}
+
+// ClaimAccountJob atomically claims the oldest pending SubmittedJob that belongs to a specific
+// account, in the same manner as ClaimJob.
+func (storage *MemoryStorage) ClaimAccountJob(account string, lease Lease, full []string) (*SubmittedJob, error) {
+	return storage.claim(account, lease, full)
+}
+
+// claim marks the next queued job as StatusProcessing and returns a copy of it. If account is
+// non-empty, only that account's jobs are considered.
+func (storage *MemoryStorage) claim(account string, lease Lease, full []string) (*SubmittedJob, error) {
+	storage.Lock()
+	defer storage.Unlock()
+
+	var next *SubmittedJob
+	for _, job := range storage.jobs {
+		if job.Status != StatusQueued || (account != "" && job.Account != account) || passedOver(job.Core, full) {
+			continue
+		}
+		if next == nil || queuedBefore(job, next) {
+			next = job
+		}
+	}
+
+	if next == nil {
+		// No jobs in the queue.
+		return nil, nil
+	}
+
+	next.Status = StatusProcessing
+	next.grant(lease)
+	return cloneJob(next)
+}
+
+// ClaimLapsedJob atomically takes over a processing job whose lease lapsed before now, granting it
+// the provided lease instead, and returns a copy of it. nil is returned if no leases have lapsed.
+func (storage *MemoryStorage) ClaimLapsedJob(lease Lease, now StoredTime) (*SubmittedJob, error) {
+	storage.Lock()
+	defer storage.Unlock()
+
+	var next *SubmittedJob
+	for _, job := range storage.jobs {
+		if job.leaseLapsed(now) && (next == nil || job.JID < next.JID) {
+			next = job
+		}
+	}
+
+	if next == nil {
+		// No lapsed leases.
+		return nil, nil
+	}
+
+	next.grant(lease)
+	return cloneJob(next)
+}
+
+// RenewLease replaces the lease of a processing job that's held by lease.Owner, or by nobody. It
+// returns ErrNotFound if the job isn't processing or another runner holds it.
+func (storage *MemoryStorage) RenewLease(jid uint64, lease Lease) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	job, ok := storage.jobs[jid]
+	if !ok || !job.leaseHeldBy(lease.Owner) {
+		return ErrNotFound
+	}
+	job.grant(lease)
+	return nil
+}
+
+// UnclaimJob returns a processing job that's held by lease.Owner, or by nobody, to the queue as
+// though it had never been claimed. Unlike UpdateJob, it doesn't advance the queue revision. It
+// returns ErrNotFound if the job isn't processing or another runner holds it.
+func (storage *MemoryStorage) UnclaimJob(jid uint64, lease Lease) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	job, ok := storage.jobs[jid]
+	if !ok || !job.leaseHeldBy(lease.Owner) {
+		return ErrNotFound
+	}
+	job.Status = StatusQueued
+	job.grant(Lease{})
+	return nil
+}
+
+// queuedBefore reports whether job a should be claimed before job b: higher priorities first, then
+// older jobs, then lower JIDs.
+func queuedBefore(a, b *SubmittedJob) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	if a.CreatedAt != b.CreatedAt {
+		return a.CreatedAt < b.CreatedAt
+	}
+	return a.JID < b.JID
+}
+
+// QueueSummary counts the queued and running jobs of each account that has any.
+func (storage *MemoryStorage) QueueSummary() ([]AccountQueue, error) {
+	storage.Lock()
+	defer storage.Unlock()
+
+	queues := accountQueues{}
+	for _, job := range storage.jobs {
+		queues.add(job.Account, job.Status, 1)
+	}
+	return queues.list(), nil
+}
+
+// QueueRevision returns a number that advances each time a job joins the queue.
+func (storage *MemoryStorage) QueueRevision() (uint64, error) {
+	storage.Lock()
+	defer storage.Unlock()
+
+	return storage.revision, nil
+}
+
+// UpdateJob updates the state of a job in memory to match any changes made to the model.
+func (storage *MemoryStorage) UpdateJob(job *SubmittedJob) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	stored, ok := storage.jobs[job.JID]
+	if !ok {
+		return ErrNotFound
+	}
+
+	merged, err := mergeJobUpdate(stored, job)
+	if err != nil {
+		return err
+	}
+	if merged.Status == StatusQueued && stored.Status != StatusQueued {
+		storage.revision++
+	}
+	storage.jobs[job.JID] = merged
+	return nil
+}
+
+// ReprioritizeJob changes the priority of a job that's still queued. It returns ErrNotFound if no
+// queued job has the requested JID.
+func (storage *MemoryStorage) ReprioritizeJob(jid uint64, priority int) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	job, ok := storage.jobs[jid]
+	if !ok || job.Status != StatusQueued {
+		return ErrNotFound
+	}
+	job.Priority = priority
+	return nil
+}
+
+// AppendOutput stores a chunk of output from a running job.
+func (storage *MemoryStorage) AppendOutput(chunk OutputChunk) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	data := make([]byte, len(chunk.Data))
+	copy(data, chunk.Data)
+	chunk.Data = data
+
+	storage.output[chunk.JID] = append(storage.output[chunk.JID], chunk)
+	return nil
+}
+
+// DeleteJobs permanently removes jobs and their output. JIDs that don't exist are ignored.
+func (storage *MemoryStorage) DeleteJobs(jids []uint64) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	for _, jid := range jids {
+		delete(storage.jobs, jid)
+		delete(storage.output, jid)
+	}
+	return nil
+}
+
+// NextArrayID allocates a new job array ID.
+func (storage *MemoryStorage) NextArrayID() (uint64, error) {
+	storage.Lock()
+	defer storage.Unlock()
+
+	storage.arrayID++
+	return storage.arrayID, nil
+}
+
+// Schedule storage
+
+// InsertSchedule stores a new schedule and returns a newly allocated schedule ID.
+func (storage *MemoryStorage) InsertSchedule(schedule Schedule) (uint64, error) {
+	storage.Lock()
+	defer storage.Unlock()
+
+	stored, err := cloneSchedule(&schedule)
+	if err != nil {
+		return 0, err
+	}
+
+	storage.scheduleID++
+	stored.ID = storage.scheduleID
+	storage.schedules[stored.ID] = stored
+	return stored.ID, nil
+}
+
+// ListSchedules lists the schedules that belong to an account, or to every account if it's empty,
+// in ID order.
+func (storage *MemoryStorage) ListSchedules(account string) ([]Schedule, error) {
+	storage.Lock()
+	defer storage.Unlock()
+
+	ids := make(jidSlice, 0, len(storage.schedules))
+	for id, schedule := range storage.schedules {
+		if account == "" || schedule.Account == account {
+			ids = append(ids, id)
+		}
+	}
+	sort.Sort(ids)
+
+	schedules := make([]Schedule, 0, len(ids))
+	for _, id := range ids {
+		out, err := cloneSchedule(storage.schedules[id])
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, *out)
+	}
+	return schedules, nil
+}
+
+// AdvanceSchedule moves a schedule's next run from one time to the next. It returns ErrNotFound if
+// the schedule doesn't exist or its next run isn't at from any longer.
+func (storage *MemoryStorage) AdvanceSchedule(id uint64, from, next StoredTime) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	schedule, ok := storage.schedules[id]
+	if !ok || schedule.NextRunAt != from {
+		return ErrNotFound
+	}
+	schedule.NextRunAt = next
+	return nil
+}
+
+// RecordScheduleRun adds a run to a schedule's history, forgetting the oldest runs beyond the
+// history limit.
+func (storage *MemoryStorage) RecordScheduleRun(id uint64, run ScheduleRun) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	schedule, ok := storage.schedules[id]
+	if !ok {
+		return ErrNotFound
+	}
+	schedule.record(run)
+	return nil
+}
+
+// PauseSchedule pauses or resumes a schedule, and sets its next run.
+func (storage *MemoryStorage) PauseSchedule(id uint64, paused bool, next StoredTime) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	schedule, ok := storage.schedules[id]
+	if !ok {
+		return ErrNotFound
+	}
+	schedule.Paused = paused
+	schedule.NextRunAt = next
+	return nil
+}
+
+// DeleteSchedule permanently removes a schedule. The jobs that it submitted are left alone.
+func (storage *MemoryStorage) DeleteSchedule(id uint64) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	if _, ok := storage.schedules[id]; !ok {
+		return ErrNotFound
+	}
+	delete(storage.schedules, id)
+	return nil
+}
+
+// Account storage
+
+// GetAccount loads an account by its unique account name, creating it if it doesn't already exist.
+func (storage *MemoryStorage) GetAccount(name string) (*Account, error) {
+	storage.Lock()
+	defer storage.Unlock()
+
+	account, ok := storage.accounts[name]
+	if !ok {
+		account = &Account{Name: name}
+		storage.accounts[name] = account
+	}
+
+	out := *account
+	return &out, nil
+}
+
+// UpdateAccountMaxPriority changes the highest priority that an account may request.
+func (storage *MemoryStorage) UpdateAccountMaxPriority(name string, max int) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	account, ok := storage.accounts[name]
+	if !ok {
+		return ErrNotFound
+	}
+	account.MaxPriority = max
+	return nil
+}
+
+// UpdateAccountAdmin flags or unflags an account as an administrator.
+func (storage *MemoryStorage) UpdateAccountAdmin(name string, admin bool) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	account, ok := storage.accounts[name]
+	if !ok {
+		return ErrNotFound
+	}
+	account.Admin = admin
+	return nil
+}
+
+// UpdateAccountUsage updates an account to take a new job into account.
+func (storage *MemoryStorage) UpdateAccountUsage(name string, runtime int64) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	account, ok := storage.accounts[name]
+	if !ok {
+		return ErrNotFound
+	}
+	account.TotalRuntime += runtime
+	account.TotalJobs++
+	return nil
+}
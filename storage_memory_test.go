@@ -0,0 +1,106 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestNewStorageMemory(t *testing.T) {
+	c := &Context{Settings: Settings{Storage: "memory"}}
+	storage, err := NewStorage(c)
+	if err != nil {
+		t.Fatalf("Unexpected error creating storage: %v", err)
+	}
+	if _, ok := storage.(*MemoryStorage); !ok {
+		t.Errorf("Expected %#v to be a MemoryStorage", storage)
+	}
+}
+
+func TestMemoryListJobsFilters(t *testing.T) {
+	s := NewMemoryStorage()
+	foo, bar := "foo", "bar"
+	s.InsertJob(SubmittedJob{Job: Job{Name: &foo}, Account: "alice", Status: StatusQueued})
+	s.InsertJob(SubmittedJob{Job: Job{Name: &bar}, Account: "alice", Status: StatusDone})
+	s.InsertJob(SubmittedJob{Job: Job{Name: &foo}, Account: "bob", Status: StatusQueued})
+	s.InsertJob(SubmittedJob{Job: Job{Name: &foo}, Account: "alice", Status: StatusDone})
+
+	jobs, err := s.ListJobs(JobQuery{AccountName: "alice", Names: []string{"foo", "baz"}})
+	if err != nil {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+	if len(jobs) != 2 || jobs[0].JID != 1 || jobs[1].JID != 4 {
+		t.Errorf("Expected jobs 1 and 4, got %#v", jobs)
+	}
+
+	jobs, err = s.ListJobs(JobQuery{Statuses: []string{StatusQueued}, After: 2, Before: 4})
+	if err != nil {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].JID != 3 {
+		t.Errorf("Expected job 3, got %#v", jobs)
+	}
+
+	jobs, err = s.ListJobs(JobQuery{AccountName: "alice", Limit: 2})
+	if err != nil {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+	if len(jobs) != 2 || jobs[0].JID != 1 || jobs[1].JID != 2 {
+		t.Errorf("Expected jobs 1 and 2, got %#v", jobs)
+	}
+}
+
+func TestMemoryJobsAreCopied(t *testing.T) {
+	s := NewMemoryStorage()
+	jid, _ := s.InsertJob(SubmittedJob{Job: Job{Tags: map[string]string{"a": "1"}}, Status: StatusQueued})
+
+	jobs, _ := s.ListJobs(JobQuery{JIDs: []uint64{jid}})
+	jobs[0].Tags["a"] = "2"
+	jobs[0].Status = StatusDone
+
+	jobs, _ = s.ListJobs(JobQuery{JIDs: []uint64{jid}})
+	if jobs[0].Tags["a"] != "1" || jobs[0].Status != StatusQueued {
+		t.Errorf("Expected the stored job to be unaffected by changes to a listed copy, got %#v", jobs[0])
+	}
+}
+
+func TestMemoryClaimJobConcurrently(t *testing.T) {
+	s := NewMemoryStorage()
+	for i := 0; i < 50; i++ {
+		s.InsertJob(SubmittedJob{CreatedAt: StoredTime(100 - i), Status: StatusQueued})
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	claimed := make(map[uint64]int)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				job, err := s.ClaimJob(Lease{}, nil)
+				if err != nil {
+					t.Errorf("Unable to claim a job: %v", err)
+					return
+				}
+				if job == nil {
+					return
+				}
+
+				mu.Lock()
+				claimed[job.JID]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(claimed) != 50 {
+		t.Errorf("Expected all 50 jobs to be claimed, got [%d]", len(claimed))
+	}
+	for jid, count := range claimed {
+		if count != 1 {
+			t.Errorf("Job [%d] was claimed [%d] times", jid, count)
+		}
+	}
+}
@@ -0,0 +1,933 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/cloudpipe/mgo/bson"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// sqlMigration is a single, versioned change to the SQL schema.
+type sqlMigration struct {
+	Version    int
+	Statements []string
+}
+
+// sqlMigrations lists every schema change in the order that they must be applied. Each version is
+// recorded in the schema_version table once it's been applied. Never edit a migration that has been
+// released; append a new one instead.
+var sqlMigrations = []sqlMigration{
+	{
+		Version: 1,
+		Statements: []string{
+			`CREATE TABLE counters (
+				name TEXT PRIMARY KEY,
+				value INTEGER NOT NULL
+			)`,
+			`INSERT INTO counters (name, value) VALUES ('job_id', 0)`,
+			`CREATE TABLE jobs (
+				jid INTEGER PRIMARY KEY,
+				account TEXT NOT NULL,
+				name TEXT,
+				status TEXT NOT NULL,
+				cmd TEXT NOT NULL,
+				core TEXT NOT NULL,
+				multicore INTEGER NOT NULL,
+				created_at INTEGER NOT NULL,
+				started_at INTEGER NOT NULL,
+				finished_at INTEGER NOT NULL,
+				return_code TEXT NOT NULL,
+				runtime INTEGER NOT NULL,
+				queue_delay INTEGER NOT NULL,
+				overhead_delay INTEGER NOT NULL,
+				container_id TEXT NOT NULL,
+				kill_requested INTEGER NOT NULL,
+				document BLOB NOT NULL
+			)`,
+			`CREATE INDEX jobs_account_jid ON jobs (account, jid)`,
+			`CREATE INDEX jobs_account_name ON jobs (account, name)`,
+			`CREATE INDEX jobs_status_created_at ON jobs (status, created_at, jid)`,
+			`CREATE TABLE accounts (
+				name TEXT PRIMARY KEY,
+				admin INTEGER NOT NULL DEFAULT 0,
+				total_runtime INTEGER NOT NULL DEFAULT 0,
+				total_jobs INTEGER NOT NULL DEFAULT 0
+			)`,
+		},
+	},
+	{
+		Version: 2,
+		Statements: []string{
+			`CREATE TABLE output (
+				jid INTEGER NOT NULL,
+				stream TEXT NOT NULL,
+				seq INTEGER NOT NULL,
+				data BLOB NOT NULL,
+				PRIMARY KEY (jid, stream, seq)
+			)`,
+		},
+	},
+	{
+		Version: 3,
+		Statements: []string{
+			`ALTER TABLE jobs ADD COLUMN priority INTEGER NOT NULL DEFAULT 0`,
+			`DROP INDEX jobs_status_created_at`,
+			`CREATE INDEX jobs_status_priority ON jobs (status, priority DESC, created_at, jid)`,
+			`ALTER TABLE accounts ADD COLUMN max_priority INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		Version: 4,
+		Statements: []string{
+			`ALTER TABLE jobs ADD COLUMN owner TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE jobs ADD COLUMN lease_expires INTEGER NOT NULL DEFAULT 0`,
+			`CREATE INDEX jobs_status_lease_expires ON jobs (status, lease_expires)`,
+		},
+	},
+	{
+		Version: 5,
+		Statements: []string{
+			`INSERT INTO counters (name, value) VALUES ('queue_revision', 0)`,
+		},
+	},
+	{
+		Version: 6,
+		Statements: []string{
+			`INSERT INTO counters (name, value) VALUES ('schedule_id', 0)`,
+			`CREATE TABLE schedules (
+				id INTEGER PRIMARY KEY,
+				account TEXT NOT NULL,
+				document BLOB NOT NULL
+			)`,
+			`CREATE INDEX schedules_account_id ON schedules (account, id)`,
+		},
+	},
+	{
+		Version: 7,
+		Statements: []string{
+			`INSERT INTO counters (name, value) VALUES ('array_id', 0)`,
+			`ALTER TABLE jobs ADD COLUMN array_id INTEGER NOT NULL DEFAULT 0`,
+			`CREATE INDEX jobs_array_id ON jobs (array_id, jid)`,
+		},
+	},
+}
+
+// SQLStorage is a Storage implementation backed by a relational database through database/sql. Job
+// attributes that are useful for reporting are stored in their own indexed columns, while the full
+// SubmittedJob is kept as a BSON document alongside them. Queries are written for SQLite.
+type SQLStorage struct {
+	DB *sql.DB
+}
+
+// Ensure that SQLStorage adheres to the Storage interface.
+var _ Storage = &SQLStorage{}
+
+// NewSQLStorage opens a database/sql connection using a registered driver. SQLite only supports a
+// single writer, so all access is funneled through a single connection.
+func NewSQLStorage(driver, dsn string) (*SQLStorage, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLStorage{DB: db}, nil
+}
+
+// Bootstrap brings the schema up to date by applying any migrations that haven't been applied yet.
+func (storage *SQLStorage) Bootstrap() error {
+	_, err := storage.DB.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		applied_at INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+
+	current, err := storage.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range sqlMigrations {
+		if migration.Version <= current {
+			continue
+		}
+
+		err := storage.transaction(func(tx *sql.Tx) error {
+			for _, statement := range migration.Statements {
+				if _, err := tx.Exec(statement); err != nil {
+					return err
+				}
+			}
+
+			_, err := tx.Exec(
+				`INSERT INTO schema_version (version, applied_at) VALUES (?, ?)`,
+				migration.Version, int64(StoreTime(time.Now())),
+			)
+			return err
+		})
+		if err != nil {
+			log.WithFields(log.Fields{
+				"version": migration.Version,
+				"error":   err,
+			}).Error("Unable to apply a schema migration.")
+			return err
+		}
+
+		log.WithFields(log.Fields{
+			"version": migration.Version,
+		}).Info("Applied a schema migration.")
+	}
+
+	return nil
+}
+
+// SchemaVersion returns the version of the most recently applied migration, or 0 if none have been
+// applied.
+func (storage *SQLStorage) SchemaVersion() (int, error) {
+	var version int
+	err := storage.DB.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	return version, err
+}
+
+// transaction executes a function within a database transaction, committing it if the function
+// succeeds and rolling it back otherwise.
+func (storage *SQLStorage) transaction(f func(tx *sql.Tx) error) error {
+	tx, err := storage.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Job storage
+
+const sqlJobColumns = `jid, account, name, status, cmd, core, multicore, priority, created_at,
+	started_at, finished_at, return_code, runtime, queue_delay, overhead_delay, container_id,
+	kill_requested, owner, lease_expires, array_id, document`
+
+// sqlJobValues flattens a SubmittedJob into values for each of the sqlJobColumns.
+func sqlJobValues(job *SubmittedJob) ([]interface{}, error) {
+	document, err := bson.Marshal(job)
+	if err != nil {
+		return nil, err
+	}
+
+	var name sql.NullString
+	if job.Name != nil {
+		name = sql.NullString{String: *job.Name, Valid: true}
+	}
+
+	return []interface{}{
+		int64(job.JID), job.Account, name, job.Status, job.Command, job.Core, job.Multicore,
+		job.Priority, int64(job.CreatedAt), int64(job.StartedAt), int64(job.FinishedAt), job.ReturnCode,
+		job.Runtime, job.QueueDelay, job.OverheadDelay, job.ContainerID, job.KillRequested,
+		job.Owner, int64(job.LeaseExpires), int64(job.Array), document,
+	}, nil
+}
+
+// getJob loads a single job within a transaction. It returns ErrNotFound if no job with the
+// requested JID exists.
+func (storage *SQLStorage) getJob(tx *sql.Tx, id uint64) (*SubmittedJob, error) {
+	var document []byte
+	err := tx.QueryRow(`SELECT document FROM jobs WHERE jid = ?`, int64(id)).Scan(&document)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var job SubmittedJob
+	if err := bson.Unmarshal(document, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// putJob inserts or replaces a job's row within a transaction.
+func (storage *SQLStorage) putJob(tx *sql.Tx, job *SubmittedJob) error {
+	values, err := sqlJobValues(job)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`INSERT OR REPLACE INTO jobs (`+sqlJobColumns+`) VALUES (`+sqlPlaceholders(len(values))+`)`,
+		values...,
+	)
+	return err
+}
+
+// InsertJob appends a job to the queue and returns a newly allocated job ID.
+func (storage *SQLStorage) InsertJob(job SubmittedJob) (uint64, error) {
+	err := storage.transaction(func(tx *sql.Tx) error {
+		// Assign the job a job ID.
+		if _, err := tx.Exec(`UPDATE counters SET value = value + 1 WHERE name = 'job_id'`); err != nil {
+			return err
+		}
+
+		var id int64
+		if err := tx.QueryRow(`SELECT value FROM counters WHERE name = 'job_id'`).Scan(&id); err != nil {
+			return err
+		}
+		job.JID = uint64(id)
+
+		if err := storage.putJob(tx, &job); err != nil {
+			return err
+		}
+		if job.Status == StatusQueued {
+			return storage.advanceQueue(tx, 1)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return job.JID, nil
+}
+
+// InsertJobs appends a batch of jobs to the queue, all or nothing, and returns their JIDs.
+func (storage *SQLStorage) InsertJobs(jobs []SubmittedJob) ([]uint64, error) {
+	jids := make([]uint64, len(jobs))
+	err := storage.transaction(func(tx *sql.Tx) error {
+		if len(jobs) == 0 {
+			return nil
+		}
+
+		// Reserve a block of job IDs.
+		_, err := tx.Exec(`UPDATE counters SET value = value + ? WHERE name = 'job_id'`, len(jobs))
+		if err != nil {
+			return err
+		}
+
+		var last int64
+		if err := tx.QueryRow(`SELECT value FROM counters WHERE name = 'job_id'`).Scan(&last); err != nil {
+			return err
+		}
+		first := uint64(last) - uint64(len(jobs)) + 1
+
+		queued := 0
+		for i, job := range jobs {
+			job.JID = first + uint64(i)
+			if err := storage.putJob(tx, &job); err != nil {
+				return err
+			}
+			jids[i] = job.JID
+
+			if job.Status == StatusQueued {
+				queued++
+			}
+		}
+		return storage.advanceQueue(tx, queued)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return jids, nil
+}
+
+// ListJobs queries jobs that have been submitted to the cluster, in JID order.
+func (storage *SQLStorage) ListJobs(query JobQuery) ([]SubmittedJob, error) {
+	var where []string
+	var args []interface{}
+
+	if query.AccountName != "" {
+		where = append(where, "account = ?")
+		args = append(args, query.AccountName)
+	}
+
+	if len(query.JIDs) > 0 {
+		where = append(where, "jid IN ("+sqlPlaceholders(len(query.JIDs))+")")
+		for _, jid := range query.JIDs {
+			args = append(args, int64(jid))
+		}
+	}
+	if query.Before != 0 {
+		where = append(where, "jid < ?")
+		args = append(args, int64(query.Before))
+	}
+	if query.After != 0 {
+		where = append(where, "jid >= ?")
+		args = append(args, int64(query.After))
+	}
+	if query.FinishedBefore != 0 {
+		where = append(where, "((finished_at > 0 AND finished_at < ?) OR (finished_at = 0 AND created_at < ?))")
+		args = append(args, int64(query.FinishedBefore), int64(query.FinishedBefore))
+	}
+	if query.Array != 0 {
+		where = append(where, "array_id = ?")
+		args = append(args, int64(query.Array))
+	}
+
+	if len(query.Names) > 0 {
+		where = append(where, "name IN ("+sqlPlaceholders(len(query.Names))+")")
+		for _, name := range query.Names {
+			args = append(args, name)
+		}
+	}
+
+	if len(query.Statuses) > 0 {
+		where = append(where, "status IN ("+sqlPlaceholders(len(query.Statuses))+")")
+		for _, status := range query.Statuses {
+			args = append(args, status)
+		}
+	}
+
+	q := `SELECT document FROM jobs`
+	if len(where) > 0 {
+		q += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	if query.Descending {
+		q += ` ORDER BY jid DESC`
+	} else {
+		q += ` ORDER BY jid`
+	}
+	if query.Limit > 0 {
+		q += ` LIMIT ?`
+		args = append(args, query.Limit)
+	}
+
+	result := []SubmittedJob{}
+	err := storage.query(q, args, func(rows *sql.Rows) error {
+		var document []byte
+		if err := rows.Scan(&document); err != nil {
+			return err
+		}
+
+		var job SubmittedJob
+		if err := bson.Unmarshal(document, &job); err != nil {
+			return err
+		}
+		result = append(result, job)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if query.SkipOutput {
+		return result, nil
+	}
+
+	// Assemble each job's output from its chunks, loading the chunks of as many jobs at once as
+	// the driver allows bind parameters for.
+	var chunks []OutputChunk
+	for start := 0; start < len(result); start += sqlMaxParameters {
+		end := start + sqlMaxParameters
+		if end > len(result) {
+			end = len(result)
+		}
+
+		jids := make([]interface{}, 0, end-start)
+		for _, job := range result[start:end] {
+			jids = append(jids, int64(job.JID))
+		}
+
+		err := storage.query(
+			`SELECT jid, stream, seq, data FROM output WHERE jid IN (`+sqlPlaceholders(len(jids))+`)`,
+			jids,
+			func(rows *sql.Rows) error {
+				var chunk OutputChunk
+				var jid int64
+				if err := rows.Scan(&jid, &chunk.Stream, &chunk.Sequence, &chunk.Data); err != nil {
+					return err
+				}
+				chunk.JID = uint64(jid)
+				chunks = append(chunks, chunk)
+				return nil
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	assembleOutput(result, chunks)
+
+	return result, nil
+}
+
+// query executes a query and calls a function for each row of its results. The rows are closed
+// before it returns, releasing the connection for other queries.
+func (storage *SQLStorage) query(q string, args []interface{}, each func(*sql.Rows) error) error {
+	rows, err := storage.DB.Query(q, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := each(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// JobKillRequested returns true if a request has been submitted to kill the job with with provided
+// JID, and false otherwise.
+func (storage *SQLStorage) JobKillRequested(id uint64) (bool, error) {
+	var killed bool
+	err := storage.DB.QueryRow(`SELECT kill_requested FROM jobs WHERE jid = ?`, int64(id)).Scan(&killed)
+	if err == sql.ErrNoRows {
+		return false, ErrNotFound
+	}
+	return killed, err
+}
+
+// ClaimJob atomically searches for the oldest pending SubmittedJob with the highest priority, marks
+// it as StatusProcessing under the provided lease, and returns it. Jobs of the core types in full
+// are passed over. nil is returned if no SubmittedJobs are available.
+func (storage *SQLStorage) ClaimJob(lease Lease, full []string) (*SubmittedJob, error) {
+	return storage.claimQueued(lease, full, `status = ?`, StatusQueued)
+}
+
+// ClaimAccountJob atomically claims the oldest pending SubmittedJob that belongs to a specific
+// account, in the same manner as ClaimJob.
+func (storage *SQLStorage) ClaimAccountJob(account string, lease Lease, full []string) (*SubmittedJob, error) {
+	return storage.claimQueued(lease, full, `status = ? AND account = ?`, StatusQueued, account)
+}
+
+// claimQueued claims the next queued job that satisfies a condition, in priority order, passing over
+// the core types in full.
+func (storage *SQLStorage) claimQueued(lease Lease, full []string, where string, args ...interface{}) (*SubmittedJob, error) {
+	if len(full) > 0 {
+		where += ` AND core NOT IN (` + sqlPlaceholders(len(full)) + `)`
+		for _, core := range full {
+			args = append(args, core)
+		}
+	}
+
+	return storage.claim(lease,
+		`SELECT jid FROM jobs WHERE `+where+` ORDER BY priority DESC, created_at, jid LIMIT 1`,
+		args...,
+	)
+}
+
+// ClaimLapsedJob atomically takes over a processing job whose lease lapsed before now, granting it
+// the provided lease instead, and returns it. nil is returned if no leases have lapsed.
+func (storage *SQLStorage) ClaimLapsedJob(lease Lease, now StoredTime) (*SubmittedJob, error) {
+	return storage.claim(lease,
+		`SELECT jid FROM jobs WHERE status = ? AND lease_expires > 0 AND lease_expires < ? ORDER BY jid LIMIT 1`,
+		StatusProcessing, int64(now),
+	)
+}
+
+// claim marks the job selected by a query as StatusProcessing under a lease and returns it.
+func (storage *SQLStorage) claim(lease Lease, q string, args ...interface{}) (*SubmittedJob, error) {
+	var claimed *SubmittedJob
+	err := storage.transaction(func(tx *sql.Tx) error {
+		var id int64
+		err := tx.QueryRow(q, args...).Scan(&id)
+		if err == sql.ErrNoRows {
+			// No jobs to claim.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		job, err := storage.getJob(tx, uint64(id))
+		if err != nil {
+			return err
+		}
+
+		job.Status = StatusProcessing
+		job.grant(lease)
+		if err := storage.putJob(tx, job); err != nil {
+			return err
+		}
+
+		claimed = job
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return claimed, nil
+}
+
+// RenewLease replaces the lease of a processing job that's held by lease.Owner, or by nobody. It
+// returns ErrNotFound if the job isn't processing or another runner holds it.
+func (storage *SQLStorage) RenewLease(jid uint64, lease Lease) error {
+	return storage.transaction(func(tx *sql.Tx) error {
+		job, err := storage.getJob(tx, jid)
+		if err != nil {
+			return err
+		}
+		if !job.leaseHeldBy(lease.Owner) {
+			return ErrNotFound
+		}
+
+		job.grant(lease)
+		return storage.putJob(tx, job)
+	})
+}
+
+// UnclaimJob returns a processing job that's held by lease.Owner, or by nobody, to the queue as
+// though it had never been claimed. Unlike UpdateJob, it doesn't advance the queue revision. It
+// returns ErrNotFound if the job isn't processing or another runner holds it.
+func (storage *SQLStorage) UnclaimJob(jid uint64, lease Lease) error {
+	return storage.transaction(func(tx *sql.Tx) error {
+		job, err := storage.getJob(tx, jid)
+		if err != nil {
+			return err
+		}
+		if !job.leaseHeldBy(lease.Owner) {
+			return ErrNotFound
+		}
+
+		job.Status = StatusQueued
+		job.grant(Lease{})
+		return storage.putJob(tx, job)
+	})
+}
+
+// QueueSummary counts the queued and running jobs of each account that has any.
+func (storage *SQLStorage) QueueSummary() ([]AccountQueue, error) {
+	queues := accountQueues{}
+	err := storage.query(
+		`SELECT account, status, COUNT(*) FROM jobs WHERE status IN (?, ?) GROUP BY account, status`,
+		[]interface{}{StatusQueued, StatusProcessing},
+		func(rows *sql.Rows) error {
+			var account, status string
+			var count int
+			if err := rows.Scan(&account, &status, &count); err != nil {
+				return err
+			}
+
+			queues.add(account, status, count)
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return queues.list(), nil
+}
+
+// QueueRevision returns a number that advances each time a job joins the queue.
+func (storage *SQLStorage) QueueRevision() (uint64, error) {
+	var revision int64
+	err := storage.DB.QueryRow(`SELECT value FROM counters WHERE name = 'queue_revision'`).Scan(&revision)
+	return uint64(revision), err
+}
+
+// advanceQueue advances the queue revision once for each job that joined the queue within a
+// transaction.
+func (storage *SQLStorage) advanceQueue(tx *sql.Tx, count int) error {
+	if count == 0 {
+		return nil
+	}
+
+	_, err := tx.Exec(`UPDATE counters SET value = value + ? WHERE name = 'queue_revision'`, count)
+	return err
+}
+
+// UpdateJob updates the state of a job in the database to match any changes made to the model.
+func (storage *SQLStorage) UpdateJob(job *SubmittedJob) error {
+	return storage.transaction(func(tx *sql.Tx) error {
+		previous, err := storage.getJob(tx, job.JID)
+		if err != nil {
+			return err
+		}
+
+		merged, err := mergeJobUpdate(previous, job)
+		if err != nil {
+			return err
+		}
+
+		if err := storage.putJob(tx, merged); err != nil {
+			return err
+		}
+		if merged.Status == StatusQueued && previous.Status != StatusQueued {
+			return storage.advanceQueue(tx, 1)
+		}
+		return nil
+	})
+}
+
+// ReprioritizeJob changes the priority of a job that's still queued. It returns ErrNotFound if no
+// queued job has the requested JID.
+func (storage *SQLStorage) ReprioritizeJob(jid uint64, priority int) error {
+	return storage.transaction(func(tx *sql.Tx) error {
+		job, err := storage.getJob(tx, jid)
+		if err != nil {
+			return err
+		}
+		if job.Status != StatusQueued {
+			return ErrNotFound
+		}
+
+		job.Priority = priority
+		return storage.putJob(tx, job)
+	})
+}
+
+// AppendOutput stores a chunk of output from a running job.
+func (storage *SQLStorage) AppendOutput(chunk OutputChunk) error {
+	_, err := storage.DB.Exec(
+		`INSERT INTO output (jid, stream, seq, data) VALUES (?, ?, ?, ?)`,
+		int64(chunk.JID), chunk.Stream, chunk.Sequence, chunk.Data,
+	)
+	return err
+}
+
+// DeleteJobs permanently removes jobs and their output. JIDs that don't exist are ignored.
+func (storage *SQLStorage) DeleteJobs(jids []uint64) error {
+	if len(jids) == 0 {
+		return nil
+	}
+
+	args := make([]interface{}, len(jids))
+	for i, jid := range jids {
+		args[i] = int64(jid)
+	}
+	in := "(" + sqlPlaceholders(len(jids)) + ")"
+
+	return storage.transaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM output WHERE jid IN `+in, args...); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`DELETE FROM jobs WHERE jid IN `+in, args...)
+		return err
+	})
+}
+
+// NextArrayID allocates a new job array ID.
+func (storage *SQLStorage) NextArrayID() (uint64, error) {
+	var id int64
+	err := storage.transaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`UPDATE counters SET value = value + 1 WHERE name = 'array_id'`); err != nil {
+			return err
+		}
+		return tx.QueryRow(`SELECT value FROM counters WHERE name = 'array_id'`).Scan(&id)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return uint64(id), nil
+}
+
+// Schedule storage
+
+// putSchedule inserts or replaces a schedule's row within a transaction.
+func (storage *SQLStorage) putSchedule(tx *sql.Tx, schedule *Schedule) error {
+	document, err := bson.Marshal(schedule)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`INSERT OR REPLACE INTO schedules (id, account, document) VALUES (?, ?, ?)`,
+		int64(schedule.ID), schedule.Account, document,
+	)
+	return err
+}
+
+// updateSchedule applies a modification to an existing schedule within a transaction. It returns
+// ErrNotFound if the schedule doesn't exist, or whatever error the modification returns.
+func (storage *SQLStorage) updateSchedule(id uint64, modify func(*Schedule) error) error {
+	return storage.transaction(func(tx *sql.Tx) error {
+		var document []byte
+		err := tx.QueryRow(`SELECT document FROM schedules WHERE id = ?`, int64(id)).Scan(&document)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		var schedule Schedule
+		if err := bson.Unmarshal(document, &schedule); err != nil {
+			return err
+		}
+		if err := modify(&schedule); err != nil {
+			return err
+		}
+		return storage.putSchedule(tx, &schedule)
+	})
+}
+
+// InsertSchedule stores a new schedule and returns a newly allocated schedule ID.
+func (storage *SQLStorage) InsertSchedule(schedule Schedule) (uint64, error) {
+	err := storage.transaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`UPDATE counters SET value = value + 1 WHERE name = 'schedule_id'`); err != nil {
+			return err
+		}
+
+		var id int64
+		if err := tx.QueryRow(`SELECT value FROM counters WHERE name = 'schedule_id'`).Scan(&id); err != nil {
+			return err
+		}
+		schedule.ID = uint64(id)
+
+		return storage.putSchedule(tx, &schedule)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return schedule.ID, nil
+}
+
+// ListSchedules lists the schedules that belong to an account, or to every account if it's empty,
+// in ID order.
+func (storage *SQLStorage) ListSchedules(account string) ([]Schedule, error) {
+	q := `SELECT document FROM schedules`
+	var args []interface{}
+	if account != "" {
+		q += ` WHERE account = ?`
+		args = append(args, account)
+	}
+	q += ` ORDER BY id`
+
+	schedules := []Schedule{}
+	err := storage.query(q, args, func(rows *sql.Rows) error {
+		var document []byte
+		if err := rows.Scan(&document); err != nil {
+			return err
+		}
+
+		var schedule Schedule
+		if err := bson.Unmarshal(document, &schedule); err != nil {
+			return err
+		}
+		schedules = append(schedules, schedule)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+// AdvanceSchedule moves a schedule's next run from one time to the next. It returns ErrNotFound if
+// the schedule doesn't exist or its next run isn't at from any longer.
+func (storage *SQLStorage) AdvanceSchedule(id uint64, from, next StoredTime) error {
+	return storage.updateSchedule(id, func(schedule *Schedule) error {
+		if schedule.NextRunAt != from {
+			return ErrNotFound
+		}
+		schedule.NextRunAt = next
+		return nil
+	})
+}
+
+// RecordScheduleRun adds a run to a schedule's history, forgetting the oldest runs beyond the
+// history limit.
+func (storage *SQLStorage) RecordScheduleRun(id uint64, run ScheduleRun) error {
+	return storage.updateSchedule(id, func(schedule *Schedule) error {
+		schedule.record(run)
+		return nil
+	})
+}
+
+// PauseSchedule pauses or resumes a schedule, and sets its next run.
+func (storage *SQLStorage) PauseSchedule(id uint64, paused bool, next StoredTime) error {
+	return storage.updateSchedule(id, func(schedule *Schedule) error {
+		schedule.Paused = paused
+		schedule.NextRunAt = next
+		return nil
+	})
+}
+
+// DeleteSchedule permanently removes a schedule. The jobs that it submitted are left alone.
+func (storage *SQLStorage) DeleteSchedule(id uint64) error {
+	result, err := storage.DB.Exec(`DELETE FROM schedules WHERE id = ?`, int64(id))
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Account storage
+
+// GetAccount loads an account by its unique account name, creating it if it doesn't already exist.
+func (storage *SQLStorage) GetAccount(name string) (*Account, error) {
+	out := Account{Name: name}
+	err := storage.transaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO accounts (name) VALUES (?)`, name); err != nil {
+			return err
+		}
+
+		return tx.QueryRow(
+			`SELECT admin, total_runtime, total_jobs, max_priority FROM accounts WHERE name = ?`, name,
+		).Scan(&out.Admin, &out.TotalRuntime, &out.TotalJobs, &out.MaxPriority)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// updateAccount executes an UPDATE statement against the accounts table, returning ErrNotFound if
+// no account was affected.
+func (storage *SQLStorage) updateAccount(statement string, args ...interface{}) error {
+	result, err := storage.DB.Exec(statement, args...)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// UpdateAccountAdmin flags or unflags an account as an administrator.
+func (storage *SQLStorage) UpdateAccountAdmin(name string, admin bool) error {
+	return storage.updateAccount(`UPDATE accounts SET admin = ? WHERE name = ?`, admin, name)
+}
+
+// UpdateAccountMaxPriority changes the highest priority that an account may request.
+func (storage *SQLStorage) UpdateAccountMaxPriority(name string, max int) error {
+	return storage.updateAccount(`UPDATE accounts SET max_priority = ? WHERE name = ?`, max, name)
+}
+
+// UpdateAccountUsage updates an account to take a new job into account.
+func (storage *SQLStorage) UpdateAccountUsage(name string, runtime int64) error {
+	return storage.updateAccount(
+		`UPDATE accounts SET total_runtime = total_runtime + ?, total_jobs = total_jobs + 1 WHERE name = ?`,
+		runtime, name,
+	)
+}
+
+// sqlMaxParameters is the most bind parameters that a single batched query uses. SQLite refuses
+// statements with more than 999 of them before version 3.32.
+const sqlMaxParameters = 900
+
+// sqlPlaceholders generates a comma-separated list of n bind parameters.
+func sqlPlaceholders(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strings.Repeat("?, ", n-1) + "?"
+}
@@ -0,0 +1,173 @@
+//go:build sqlite
+// +build sqlite
+
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func newTestSQLStorage(t *testing.T) *SQLStorage {
+	storage, err := NewSQLStorage("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Unable to open SQLite storage: %v", err)
+	}
+	if err := storage.Bootstrap(); err != nil {
+		t.Fatalf("Unable to bootstrap SQLite storage: %v", err)
+	}
+	return storage
+}
+
+func TestSQLMigrationsAreRecorded(t *testing.T) {
+	storage := newTestSQLStorage(t)
+	defer storage.DB.Close()
+
+	version, err := storage.SchemaVersion()
+	if err != nil {
+		t.Fatalf("Unable to read the schema version: %v", err)
+	}
+	latest := sqlMigrations[len(sqlMigrations)-1].Version
+	if version != latest {
+		t.Errorf("Expected schema version [%d], got [%d]", latest, version)
+	}
+
+	// Bootstrapping an up-to-date schema must be a no-op.
+	if err := storage.Bootstrap(); err != nil {
+		t.Fatalf("Unable to bootstrap SQLite storage a second time: %v", err)
+	}
+
+	var applied int
+	if err := storage.DB.QueryRow(`SELECT COUNT(*) FROM schema_version`).Scan(&applied); err != nil {
+		t.Fatalf("Unable to count applied migrations: %v", err)
+	}
+	if applied != len(sqlMigrations) {
+		t.Errorf("Expected [%d] applied migrations, got [%d]", len(sqlMigrations), applied)
+	}
+}
+
+func TestSQLJobLifecycle(t *testing.T) {
+	storage := newTestSQLStorage(t)
+	defer storage.DB.Close()
+
+	name := "report"
+	first, err := storage.InsertJob(SubmittedJob{
+		Job:       Job{Command: "id", Name: &name},
+		CreatedAt: 200,
+		Status:    StatusQueued,
+		Account:   "alice",
+	})
+	if err != nil {
+		t.Fatalf("Unable to insert a job: %v", err)
+	}
+	second, err := storage.InsertJob(SubmittedJob{
+		Job:       Job{Command: "id"},
+		CreatedAt: 100,
+		Status:    StatusQueued,
+		Account:   "bob",
+	})
+	if err != nil {
+		t.Fatalf("Unable to insert a job: %v", err)
+	}
+	if second <= first {
+		t.Errorf("Expected JIDs to increase, got [%d] then [%d]", first, second)
+	}
+
+	jobs, err := storage.ListJobs(JobQuery{AccountName: "alice", Names: []string{"report"}})
+	if err != nil {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].JID != first || jobs[0].Command != "id" {
+		t.Errorf("Expected job [%d], got %#v", first, jobs)
+	}
+
+	claimed, err := storage.ClaimJob(Lease{}, nil)
+	if err != nil {
+		t.Fatalf("Unable to claim a job: %v", err)
+	}
+	if claimed == nil || claimed.JID != second {
+		t.Fatalf("Expected to claim the older job [%d], got %#v", second, claimed)
+	}
+
+	claimed.Status = StatusDone
+	claimed.Runtime = 1234
+	if err := storage.UpdateJob(claimed); err != nil {
+		t.Fatalf("Unable to update a job: %v", err)
+	}
+
+	var runtime int64
+	err = storage.DB.QueryRow(`SELECT runtime FROM jobs WHERE status = ?`, StatusDone).Scan(&runtime)
+	if err != nil {
+		t.Fatalf("Unable to query the reporting columns: %v", err)
+	}
+	if runtime != 1234 {
+		t.Errorf("Expected the runtime column to be updated, got [%d]", runtime)
+	}
+}
+
+func TestSQLAccounts(t *testing.T) {
+	storage := newTestSQLStorage(t)
+	defer storage.DB.Close()
+
+	if err := storage.UpdateAccountUsage("alice", 10); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for a missing account, got [%v]", err)
+	}
+
+	if _, err := storage.GetAccount("alice"); err != nil {
+		t.Fatalf("Unable to get account: %v", err)
+	}
+	if err := storage.UpdateAccountAdmin("alice", true); err != nil {
+		t.Fatalf("Unable to update account: %v", err)
+	}
+	if err := storage.UpdateAccountUsage("alice", 10); err != nil {
+		t.Fatalf("Unable to update account: %v", err)
+	}
+
+	account, err := storage.GetAccount("alice")
+	if err != nil {
+		t.Fatalf("Unable to get account: %v", err)
+	}
+	if !account.Admin || account.TotalRuntime != 10 || account.TotalJobs != 1 {
+		t.Errorf("Unexpected account: %#v", account)
+	}
+}
+
+func TestSQLOutputAcrossBatches(t *testing.T) {
+	storage := newTestSQLStorage(t)
+	defer storage.DB.Close()
+
+	jobs := make([]SubmittedJob, sqlMaxParameters+10)
+	for i := range jobs {
+		jobs[i] = SubmittedJob{Account: "alice", Status: StatusDone}
+	}
+	jids, err := storage.InsertJobs(jobs)
+	if err != nil {
+		t.Fatalf("Unable to insert jobs: %v", err)
+	}
+	for _, jid := range []uint64{jids[0], jids[sqlMaxParameters-1], jids[sqlMaxParameters], jids[len(jids)-1]} {
+		chunk := OutputChunk{JID: jid, Stream: StreamStdout, Data: []byte(strconv.FormatUint(jid, 10))}
+		if err := storage.AppendOutput(chunk); err != nil {
+			t.Fatalf("Unable to append output: %v", err)
+		}
+	}
+
+	listed, err := storage.ListJobs(JobQuery{})
+	if err != nil || len(listed) != len(jids) {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+	for _, i := range []int{0, sqlMaxParameters - 1, sqlMaxParameters, len(jids) - 1} {
+		if expected := strconv.FormatUint(jids[i], 10); listed[i].Stdout != expected {
+			t.Errorf("Expected output [%s] for job [%d], got [%s]", expected, jids[i], listed[i].Stdout)
+		}
+	}
+	if listed[1].Stdout != "" {
+		t.Errorf("Expected no output for job [%d], got [%s]", jids[1], listed[1].Stdout)
+	}
+}
+
+func TestSQLStorageConformance(t *testing.T) {
+	testStorageConformance(t, func(t *testing.T) (Storage, func()) {
+		s := newTestSQLStorage(t)
+		return s, func() { s.DB.Close() }
+	})
+}
@@ -0,0 +1,10 @@
+//go:build sqlite
+// +build sqlite
+
+package main
+
+import (
+	// Register the "sqlite3" driver with database/sql. It requires cgo, so it's only linked into
+	// builds that ask for it with "-tags sqlite".
+	_ "github.com/mattn/go-sqlite3"
+)
@@ -0,0 +1,1245 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"reflect"
+	"sync"
+	"testing"
+
+	mgo "github.com/cloudpipe/mgo"
+)
+
+// StorageFactory creates a new, empty and bootstrapped Storage for a single conformance check. The
+// returned function releases any resources that the Storage holds.
+type StorageFactory func(t *testing.T) (Storage, func())
+
+// storageConformanceChecks enumerate the behavior that every Storage implementation must share.
+// They're written against the semantics of MongoStorage.
+var storageConformanceChecks = []struct {
+	name  string
+	check func(t *testing.T, s Storage)
+}{
+	{"InsertJob allocates increasing JIDs", checkInsertJobJIDs},
+	{"InsertJobs allocates a contiguous block of JIDs", checkInsertJobsJIDs},
+	{"InsertJobs inserts nothing if the batch fails partway", checkInsertJobsFailure},
+	{"ListJobs filters by account", checkListJobsAccount},
+	{"ListJobs applies Before and After bounds", checkListJobsBounds},
+	{"ListJobs orders results by JID", checkListJobsOrder},
+	{"ListJobs filters by JIDs", checkListJobsJIDs},
+	{"ListJobs filters by names and statuses", checkListJobsNamesStatuses},
+	{"ListJobs filters by array", checkListJobsArray},
+	{"ListJobs applies a Limit", checkListJobsLimit},
+	{"ListJobs filters by finish time", checkListJobsFinishedBefore},
+	{"ListJobs falls back to creation time for jobs without a finish time", checkListJobsNeverFinished},
+	{"ClaimJob claims the oldest queued job", checkClaimJobOrder},
+	{"ClaimJob never claims a job twice", checkClaimJobConcurrently},
+	{"ClaimJob claims higher priorities first", checkClaimJobPriority},
+	{"ReprioritizeJob changes queued jobs only", checkReprioritizeJob},
+	{"ClaimAccountJob claims the oldest job of one account", checkClaimAccountJob},
+	{"Claims pass over full core types", checkClaimFullCores},
+	{"Claims record their lease", checkClaimLease},
+	{"RenewLease renews leases held by their owner", checkRenewLease},
+	{"UnclaimJob returns claimed jobs to the queue", checkUnclaimJob},
+	{"ClaimLapsedJob takes over lapsed leases", checkClaimLapsedJob},
+	{"QueueSummary counts queued and running jobs", checkQueueSummary},
+	{"QueueRevision advances as jobs join the queue", checkQueueRevision},
+	{"UpdateJob has $set semantics", checkUpdateJob},
+	{"JobKillRequested reports kill requests", checkJobKillRequested},
+	{"ListJobs assembles output chunks", checkOutputChunks},
+	{"ListJobs skips output on request", checkSkipOutput},
+	{"DeleteJobs removes jobs and their output", checkDeleteJobs},
+	{"GetAccount upserts accounts", checkGetAccount},
+	{"Account updates accumulate", checkAccountUpdates},
+	{"UpdateAccountMaxPriority sets the priority limit", checkAccountMaxPriority},
+	{"Schedules are inserted, listed, paused and deleted", checkSchedules},
+	{"AdvanceSchedule only moves schedules from their next run", checkAdvanceSchedule},
+	{"RecordScheduleRun keeps a bounded history", checkScheduleHistory},
+}
+
+// testStorageConformance runs every conformance check against fresh Storage instances created by
+// the factory. Every Storage implementation should have a test that calls this.
+func testStorageConformance(t *testing.T, factory StorageFactory) {
+	for _, c := range storageConformanceChecks {
+		t.Logf("Conformance check: %s", c.name)
+
+		s, cleanup := factory(t)
+		c.check(t, s)
+		cleanup()
+	}
+}
+
+func TestMemoryStorageConformance(t *testing.T) {
+	testStorageConformance(t, func(t *testing.T) (Storage, func()) {
+		return NewMemoryStorage(), func() {}
+	})
+}
+
+func TestBoltStorageConformance(t *testing.T) {
+	testStorageConformance(t, func(t *testing.T) (Storage, func()) {
+		dir, err := ioutil.TempDir("", "cloudpipe-bolt")
+		if err != nil {
+			t.Fatalf("Unable to create a temporary directory: %v", err)
+		}
+
+		s, err := NewBoltStorage(path.Join(dir, "pipe.db"))
+		if err != nil {
+			t.Fatalf("Unable to open BoltDB storage: %v", err)
+		}
+		if err := s.Bootstrap(); err != nil {
+			t.Fatalf("Unable to bootstrap BoltDB storage: %v", err)
+		}
+
+		return s, func() {
+			s.DB.Close()
+			os.RemoveAll(dir)
+		}
+	})
+}
+
+// TestMongoStorageConformance runs against a real MongoDB server, so it's skipped unless
+// PIPE_TEST_MONGOURL is set. The "pipe_test" database is dropped before each check.
+func TestMongoStorageConformance(t *testing.T) {
+	url := os.Getenv("PIPE_TEST_MONGOURL")
+	if url == "" {
+		t.Skip("Set PIPE_TEST_MONGOURL to check MongoStorage conformance.")
+	}
+
+	testStorageConformance(t, func(t *testing.T) (Storage, func()) {
+		session, err := mgo.Dial(url)
+		if err != nil {
+			t.Fatalf("Unable to connect to MongoDB: %v", err)
+		}
+
+		db := session.DB("pipe_test")
+		if err := db.DropDatabase(); err != nil {
+			t.Fatalf("Unable to drop the test database: %v", err)
+		}
+
+		s := &MongoStorage{Database: db}
+		if err := s.Bootstrap(); err != nil {
+			t.Fatalf("Unable to bootstrap MongoDB storage: %v", err)
+		}
+
+		return s, session.Close
+	})
+}
+
+// insertJobs inserts each job in order, failing the test on any error, and returns their JIDs.
+func insertJobs(t *testing.T, s Storage, jobs ...SubmittedJob) []uint64 {
+	jids := make([]uint64, len(jobs))
+	for i, job := range jobs {
+		jid, err := s.InsertJob(job)
+		if err != nil {
+			t.Fatalf("Unable to insert a job: %v", err)
+		}
+		jids[i] = jid
+	}
+	return jids
+}
+
+// listJIDs runs a job query, failing the test on any error, and returns the JIDs of the results.
+func listJIDs(t *testing.T, s Storage, q JobQuery) []uint64 {
+	jobs, err := s.ListJobs(q)
+	if err != nil {
+		t.Fatalf("Unable to list jobs with query %#v: %v", q, err)
+	}
+
+	jids := make([]uint64, len(jobs))
+	for i, job := range jobs {
+		jids[i] = job.JID
+	}
+	return jids
+}
+
+// expectJIDs reports an error if the JIDs returned by a query aren't the expected set, in any
+// order.
+func expectJIDs(t *testing.T, s Storage, q JobQuery, expected ...uint64) {
+	actual := listJIDs(t, s, q)
+
+	remaining := make(map[uint64]bool, len(expected))
+	for _, jid := range expected {
+		remaining[jid] = true
+	}
+	ok := len(actual) == len(expected)
+	for _, jid := range actual {
+		if !remaining[jid] {
+			ok = false
+		}
+		delete(remaining, jid)
+	}
+
+	if !ok {
+		t.Errorf("Query %#v: expected JIDs %v, got %v", q, expected, actual)
+	}
+}
+
+func checkInsertJobJIDs(t *testing.T, s Storage) {
+	jids := insertJobs(t, s,
+		SubmittedJob{Account: "alice", Status: StatusQueued},
+		SubmittedJob{Account: "bob", Status: StatusQueued},
+		SubmittedJob{Account: "alice", Status: StatusQueued},
+	)
+
+	for i := 1; i < len(jids); i++ {
+		if jids[i] <= jids[i-1] {
+			t.Errorf("Expected JIDs to increase, got %v", jids)
+		}
+	}
+
+	jobs, err := s.ListJobs(JobQuery{JIDs: []uint64{jids[1]}})
+	if err != nil {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].JID != jids[1] || jobs[0].Account != "bob" {
+		t.Errorf("Expected to find job [%d] belonging to bob, got %#v", jids[1], jobs)
+	}
+}
+
+func checkInsertJobsJIDs(t *testing.T, s Storage) {
+	before := insertJobs(t, s, SubmittedJob{Account: "alice", Status: StatusQueued})
+
+	jids, err := s.InsertJobs([]SubmittedJob{
+		{Account: "alice", Status: StatusQueued},
+		{Account: "bob", Status: StatusQueued},
+		{Account: "alice", Status: StatusDone},
+	})
+	if err != nil {
+		t.Fatalf("Unable to insert jobs: %v", err)
+	}
+	if len(jids) != 3 || jids[0] <= before[0] || jids[1] != jids[0]+1 || jids[2] != jids[0]+2 {
+		t.Fatalf("Expected three contiguous JIDs after [%d], got %v", before[0], jids)
+	}
+
+	expectJIDs(t, s, JobQuery{AccountName: "bob"}, jids[1])
+	expectJIDs(t, s, JobQuery{Statuses: []string{StatusDone}}, jids[2])
+
+	// Single inserts continue after the block.
+	after := insertJobs(t, s, SubmittedJob{Account: "alice", Status: StatusQueued})
+	if after[0] <= jids[2] {
+		t.Errorf("Expected a JID after [%d], got [%d]", jids[2], after[0])
+	}
+
+	if jids, err := s.InsertJobs(nil); err != nil || len(jids) != 0 {
+		t.Errorf("Expected inserting no jobs to succeed, got %v [%v]", jids, err)
+	}
+}
+
+func checkInsertJobsFailure(t *testing.T, s Storage) {
+	before := insertJobs(t, s, SubmittedJob{Account: "alice", Status: StatusDone})
+
+	// BSON can't encode the last job. MongoDB inserts batches of more than 1000 documents in
+	// several round trips, so the jobs before it are written first.
+	jobs := make([]SubmittedJob, 1002)
+	for i := range jobs {
+		jobs[i] = SubmittedJob{Account: "alice", Status: StatusQueued}
+	}
+	jobs[len(jobs)-1].Array = math.MaxUint64
+
+	if jids, err := s.InsertJobs(jobs); err == nil {
+		t.Fatalf("Expected the batch to fail, got %d JIDs", len(jids))
+	}
+
+	expectJIDs(t, s, JobQuery{}, before[0])
+	if job, err := s.ClaimJob(Lease{}, nil); err != nil || job != nil {
+		t.Errorf("Expected nothing to claim, got %#v [%v]", job, err)
+	}
+	if queues, err := s.QueueSummary(); err != nil || len(queues) != 0 {
+		t.Errorf("Expected an empty queue, got %#v [%v]", queues, err)
+	}
+
+	// Later batches are unaffected.
+	jids, err := s.InsertJobs(jobs[:2])
+	if err != nil || len(jids) != 2 {
+		t.Fatalf("Unable to insert jobs: %v", err)
+	}
+	expectJIDs(t, s, JobQuery{}, before[0], jids[0], jids[1])
+}
+
+func checkListJobsAccount(t *testing.T, s Storage) {
+	jids := insertJobs(t, s,
+		SubmittedJob{Account: "alice", Status: StatusQueued},
+		SubmittedJob{Account: "bob", Status: StatusQueued},
+		SubmittedJob{Account: "alice", Status: StatusQueued},
+	)
+
+	expectJIDs(t, s, JobQuery{AccountName: "alice"}, jids[0], jids[2])
+	expectJIDs(t, s, JobQuery{AccountName: "bob"}, jids[1])
+	expectJIDs(t, s, JobQuery{AccountName: "carol"})
+	expectJIDs(t, s, JobQuery{}, jids...)
+
+	// Another account's JIDs must not leak through a JID query.
+	expectJIDs(t, s, JobQuery{AccountName: "alice", JIDs: []uint64{jids[1]}})
+}
+
+func checkListJobsBounds(t *testing.T, s Storage) {
+	jids := insertJobs(t, s,
+		SubmittedJob{Account: "alice", Status: StatusQueued},
+		SubmittedJob{Account: "alice", Status: StatusQueued},
+		SubmittedJob{Account: "alice", Status: StatusQueued},
+		SubmittedJob{Account: "alice", Status: StatusQueued},
+	)
+
+	// Before is exclusive.
+	expectJIDs(t, s, JobQuery{AccountName: "alice", Before: jids[2]}, jids[0], jids[1])
+
+	// After is inclusive.
+	expectJIDs(t, s, JobQuery{AccountName: "alice", After: jids[2]}, jids[2], jids[3])
+
+	// Both bounds apply together.
+	expectJIDs(t, s, JobQuery{AccountName: "alice", After: jids[1], Before: jids[3]}, jids[1], jids[2])
+	expectJIDs(t, s, JobQuery{AccountName: "alice", After: jids[2], Before: jids[2]})
+}
+
+func checkListJobsOrder(t *testing.T, s Storage) {
+	var jobs []SubmittedJob
+	for i := 0; i < 6; i++ {
+		jobs = append(jobs, SubmittedJob{Account: "alice", Status: StatusQueued})
+	}
+	jids := insertJobs(t, s, jobs...)
+
+	expectOrder := func(q JobQuery, expected ...uint64) {
+		actual := listJIDs(t, s, q)
+		ok := len(actual) == len(expected)
+		for i := 0; ok && i < len(actual); i++ {
+			ok = actual[i] == expected[i]
+		}
+		if !ok {
+			t.Errorf("Query %#v: expected JIDs %v in order, got %v", q, expected, actual)
+		}
+	}
+
+	expectOrder(JobQuery{Limit: 3}, jids[0], jids[1], jids[2])
+	expectOrder(JobQuery{Limit: 3, Descending: true}, jids[5], jids[4], jids[3])
+	expectOrder(JobQuery{After: jids[1], Before: jids[4], Descending: true}, jids[3], jids[2], jids[1])
+	expectOrder(JobQuery{Before: jids[4], Limit: 2, Descending: true}, jids[3], jids[2])
+	expectOrder(JobQuery{After: jids[4], Limit: 5}, jids[4], jids[5])
+	expectOrder(JobQuery{JIDs: []uint64{jids[2], jids[5], jids[0]}, Descending: true}, jids[5], jids[2], jids[0])
+	expectOrder(JobQuery{JIDs: []uint64{jids[2], jids[5], jids[0]}}, jids[0], jids[2], jids[5])
+}
+
+func checkListJobsJIDs(t *testing.T, s Storage) {
+	jids := insertJobs(t, s,
+		SubmittedJob{Account: "alice", Status: StatusQueued},
+		SubmittedJob{Account: "alice", Status: StatusQueued},
+		SubmittedJob{Account: "alice", Status: StatusQueued},
+		SubmittedJob{Account: "alice", Status: StatusQueued},
+	)
+	missing := jids[3] + 1000
+
+	expectJIDs(t, s, JobQuery{JIDs: []uint64{jids[2]}}, jids[2])
+	expectJIDs(t, s, JobQuery{JIDs: []uint64{missing}})
+	expectJIDs(t, s, JobQuery{JIDs: []uint64{jids[3], jids[0], missing}}, jids[0], jids[3])
+
+	// JID filters combine with the Before and After bounds.
+	expectJIDs(t, s, JobQuery{JIDs: []uint64{jids[2]}, Before: jids[2]})
+	expectJIDs(t, s, JobQuery{JIDs: []uint64{jids[2]}, After: jids[3]})
+	expectJIDs(t, s, JobQuery{JIDs: []uint64{jids[0], jids[1], jids[3]}, Before: jids[3]}, jids[0], jids[1])
+	expectJIDs(t, s, JobQuery{JIDs: []uint64{jids[0], jids[1], jids[3]}, After: jids[1]}, jids[1], jids[3])
+	expectJIDs(t, s, JobQuery{JIDs: []uint64{jids[0], jids[1]}, After: jids[2]})
+}
+
+func checkListJobsNamesStatuses(t *testing.T, s Storage) {
+	foo, bar := "foo", "bar"
+	jids := insertJobs(t, s,
+		SubmittedJob{Job: Job{Name: &foo}, Account: "alice", Status: StatusQueued},
+		SubmittedJob{Job: Job{Name: &bar}, Account: "alice", Status: StatusDone},
+		SubmittedJob{Account: "alice", Status: StatusError},
+		SubmittedJob{Job: Job{Name: &foo}, Account: "alice", Status: StatusDone},
+	)
+
+	expectJIDs(t, s, JobQuery{Names: []string{"foo"}}, jids[0], jids[3])
+	expectJIDs(t, s, JobQuery{Names: []string{"foo", "bar"}}, jids[0], jids[1], jids[3])
+	expectJIDs(t, s, JobQuery{Names: []string{"baz"}})
+
+	expectJIDs(t, s, JobQuery{Statuses: []string{StatusDone}}, jids[1], jids[3])
+	expectJIDs(t, s, JobQuery{Statuses: []string{StatusQueued, StatusError}}, jids[0], jids[2])
+
+	expectJIDs(t, s, JobQuery{Names: []string{"foo"}, Statuses: []string{StatusDone}}, jids[3])
+}
+
+func checkListJobsArray(t *testing.T, s Storage) {
+	first, err := s.NextArrayID()
+	if err != nil {
+		t.Fatalf("Unable to allocate an array ID: %v", err)
+	}
+	second, err := s.NextArrayID()
+	if err != nil {
+		t.Fatalf("Unable to allocate an array ID: %v", err)
+	}
+	if first == 0 || second <= first {
+		t.Errorf("Expected increasing array IDs, got [%d] and [%d]", first, second)
+	}
+
+	zero, one := 0, 1
+	jids := insertJobs(t, s,
+		SubmittedJob{Account: "alice", Status: StatusQueued, Array: first, ArrayIndex: &zero},
+		SubmittedJob{Account: "alice", Status: StatusQueued},
+		SubmittedJob{Account: "alice", Status: StatusDone, Array: second, ArrayIndex: &zero},
+		SubmittedJob{Account: "alice", Status: StatusQueued, Array: first, ArrayIndex: &one},
+	)
+
+	expectJIDs(t, s, JobQuery{Array: first}, jids[0], jids[3])
+	expectJIDs(t, s, JobQuery{Array: second}, jids[2])
+	expectJIDs(t, s, JobQuery{Array: second + 1})
+
+	jobs, _ := s.ListJobs(JobQuery{JIDs: []uint64{jids[3]}})
+	if len(jobs) != 1 || jobs[0].ArrayIndex == nil || *jobs[0].ArrayIndex != 1 {
+		t.Errorf("Expected the array index to be stored, got %#v", jobs)
+	}
+}
+
+func checkListJobsLimit(t *testing.T, s Storage) {
+	insertJobs(t, s,
+		SubmittedJob{Account: "alice", Status: StatusQueued},
+		SubmittedJob{Account: "alice", Status: StatusQueued},
+		SubmittedJob{Account: "alice", Status: StatusQueued},
+	)
+
+	if jids := listJIDs(t, s, JobQuery{AccountName: "alice", Limit: 2}); len(jids) != 2 {
+		t.Errorf("Expected a limit of 2 to return two jobs, got %v", jids)
+	}
+	if jids := listJIDs(t, s, JobQuery{AccountName: "alice"}); len(jids) != 3 {
+		t.Errorf("Expected no limit to return every job, got %v", jids)
+	}
+}
+
+func checkListJobsFinishedBefore(t *testing.T, s Storage) {
+	jids := insertJobs(t, s,
+		SubmittedJob{Account: "alice", Status: StatusDone, FinishedAt: 100},
+		SubmittedJob{Account: "alice", Status: StatusDone, FinishedAt: 200},
+		SubmittedJob{Account: "alice", Status: StatusError, FinishedAt: 300},
+	)
+
+	// FinishedBefore is exclusive.
+	expectJIDs(t, s, JobQuery{FinishedBefore: 200}, jids[0])
+	expectJIDs(t, s, JobQuery{FinishedBefore: 301}, jids...)
+	expectJIDs(t, s, JobQuery{Statuses: []string{StatusDone}, FinishedBefore: 301}, jids[0], jids[1])
+}
+
+func checkListJobsNeverFinished(t *testing.T, s Storage) {
+	jids := insertJobs(t, s,
+		SubmittedJob{Account: "alice", Status: StatusKilled, CreatedAt: 100},
+		SubmittedJob{Account: "alice", Status: StatusError, CreatedAt: 300},
+		SubmittedJob{Account: "alice", Status: StatusDone, CreatedAt: 50, FinishedAt: 400},
+	)
+
+	expectJIDs(t, s, JobQuery{FinishedBefore: 200}, jids[0])
+	expectJIDs(t, s, JobQuery{FinishedBefore: 301}, jids[0], jids[1])
+	expectJIDs(t, s, JobQuery{FinishedBefore: 401}, jids...)
+}
+
+func checkClaimJobOrder(t *testing.T, s Storage) {
+	if job, err := s.ClaimJob(Lease{}, nil); err != nil || job != nil {
+		t.Fatalf("Expected nothing to claim from an empty queue, got [%#v] and [%v]", job, err)
+	}
+
+	// JIDs are allocated in a different order than creation times, to ensure that ClaimJob orders
+	// by created_at.
+	jids := insertJobs(t, s,
+		SubmittedJob{CreatedAt: 300, Account: "alice", Status: StatusQueued},
+		SubmittedJob{CreatedAt: 100, Account: "alice", Status: StatusDone},
+		SubmittedJob{CreatedAt: 200, Account: "bob", Status: StatusQueued},
+		SubmittedJob{CreatedAt: 150, Account: "alice", Status: StatusKilled},
+	)
+
+	for _, expected := range []uint64{jids[2], jids[0]} {
+		job, err := s.ClaimJob(Lease{}, nil)
+		if err != nil {
+			t.Fatalf("Unable to claim a job: %v", err)
+		}
+		if job == nil || job.JID != expected {
+			t.Fatalf("Expected to claim job [%d], got %#v", expected, job)
+		}
+		if job.Status != StatusProcessing {
+			t.Errorf("Expected a claimed job to be returned as processing, was [%s]", job.Status)
+		}
+	}
+
+	if job, err := s.ClaimJob(Lease{}, nil); err != nil || job != nil {
+		t.Errorf("Expected the queue to be empty, got [%#v] and [%v]", job, err)
+	}
+
+	expectJIDs(t, s, JobQuery{Statuses: []string{StatusProcessing}}, jids[0], jids[2])
+}
+
+func checkClaimJobConcurrently(t *testing.T, s Storage) {
+	const jobCount, workers = 40, 8
+
+	for i := 0; i < jobCount; i++ {
+		insertJobs(t, s, SubmittedJob{CreatedAt: StoredTime(i), Account: "alice", Status: StatusQueued})
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	claims := make(map[uint64]int)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				job, err := s.ClaimJob(Lease{}, nil)
+				if err != nil {
+					t.Errorf("Unable to claim a job: %v", err)
+					return
+				}
+				if job == nil {
+					return
+				}
+
+				mu.Lock()
+				claims[job.JID]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(claims) != jobCount {
+		t.Errorf("Expected [%d] distinct jobs to be claimed, got [%d]", jobCount, len(claims))
+	}
+	for jid, count := range claims {
+		if count != 1 {
+			t.Errorf("Job [%d] was claimed [%d] times", jid, count)
+		}
+	}
+}
+
+func checkClaimJobPriority(t *testing.T, s Storage) {
+	jids := insertJobs(t, s,
+		SubmittedJob{CreatedAt: 100, Account: "alice", Status: StatusQueued},
+		SubmittedJob{Job: Job{Priority: 5}, CreatedAt: 300, Account: "bob", Status: StatusQueued},
+		SubmittedJob{Job: Job{Priority: 5}, CreatedAt: 200, Account: "alice", Status: StatusQueued},
+		SubmittedJob{Job: Job{Priority: 2}, CreatedAt: 50, Account: "bob", Status: StatusQueued},
+		SubmittedJob{Job: Job{Priority: 9}, CreatedAt: 10, Account: "bob", Status: StatusDone},
+	)
+
+	for _, expected := range []uint64{jids[2], jids[1], jids[3], jids[0]} {
+		job, err := s.ClaimJob(Lease{}, nil)
+		if err != nil {
+			t.Fatalf("Unable to claim a job: %v", err)
+		}
+		if job == nil || job.JID != expected {
+			t.Fatalf("Expected to claim job [%d], got %#v", expected, job)
+		}
+	}
+
+	// ClaimAccountJob honors priority within an account.
+	more := insertJobs(t, s,
+		SubmittedJob{CreatedAt: 100, Account: "alice", Status: StatusQueued},
+		SubmittedJob{Job: Job{Priority: 1}, CreatedAt: 200, Account: "alice", Status: StatusQueued},
+	)
+	job, err := s.ClaimAccountJob("alice", Lease{}, nil)
+	if err != nil {
+		t.Fatalf("Unable to claim a job: %v", err)
+	}
+	if job == nil || job.JID != more[1] {
+		t.Errorf("Expected to claim job [%d], got %#v", more[1], job)
+	}
+}
+
+func checkReprioritizeJob(t *testing.T, s Storage) {
+	jids := insertJobs(t, s,
+		SubmittedJob{CreatedAt: 100, Account: "alice", Status: StatusQueued},
+		SubmittedJob{CreatedAt: 200, Account: "alice", Status: StatusQueued},
+		SubmittedJob{CreatedAt: 50, Account: "alice", Status: StatusDone},
+	)
+
+	if err := s.ReprioritizeJob(jids[1], 3); err != nil {
+		t.Fatalf("Unable to reprioritize a queued job: %v", err)
+	}
+	if err := s.ReprioritizeJob(jids[2], 3); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound when reprioritizing a finished job, got [%v]", err)
+	}
+	if err := s.ReprioritizeJob(jids[2]+100, 3); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound when reprioritizing a missing job, got [%v]", err)
+	}
+
+	jobs, err := s.ListJobs(JobQuery{JIDs: jids})
+	if err != nil {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+	for _, job := range jobs {
+		expected := 0
+		if job.JID == jids[1] {
+			expected = 3
+		}
+		if job.Priority != expected {
+			t.Errorf("Expected job [%d] to have priority [%d], got [%d]", job.JID, expected, job.Priority)
+		}
+	}
+
+	job, err := s.ClaimJob(Lease{}, nil)
+	if err != nil {
+		t.Fatalf("Unable to claim a job: %v", err)
+	}
+	if job == nil || job.JID != jids[1] {
+		t.Errorf("Expected to claim the reprioritized job [%d], got %#v", jids[1], job)
+	}
+}
+
+func checkClaimAccountJob(t *testing.T, s Storage) {
+	jids := insertJobs(t, s,
+		SubmittedJob{CreatedAt: 100, Account: "alice", Status: StatusQueued},
+		SubmittedJob{CreatedAt: 300, Account: "bob", Status: StatusQueued},
+		SubmittedJob{CreatedAt: 200, Account: "bob", Status: StatusQueued},
+		SubmittedJob{CreatedAt: 50, Account: "bob", Status: StatusDone},
+	)
+
+	for _, expected := range []uint64{jids[2], jids[1]} {
+		job, err := s.ClaimAccountJob("bob", Lease{}, nil)
+		if err != nil {
+			t.Fatalf("Unable to claim a job: %v", err)
+		}
+		if job == nil || job.JID != expected {
+			t.Fatalf("Expected to claim job [%d], got %#v", expected, job)
+		}
+		if job.Status != StatusProcessing {
+			t.Errorf("Expected a claimed job to be returned as processing, was [%s]", job.Status)
+		}
+	}
+
+	if job, err := s.ClaimAccountJob("bob", Lease{}, nil); err != nil || job != nil {
+		t.Errorf("Expected bob's queue to be empty, got [%#v] and [%v]", job, err)
+	}
+
+	expectJIDs(t, s, JobQuery{Statuses: []string{StatusQueued}}, jids[0])
+}
+
+func checkClaimFullCores(t *testing.T, s Storage) {
+	jids := insertJobs(t, s,
+		SubmittedJob{Job: Job{Core: "c2"}, CreatedAt: 100, Account: "alice", Status: StatusQueued},
+		SubmittedJob{Job: Job{Core: "c1"}, CreatedAt: 200, Account: "alice", Status: StatusQueued},
+		SubmittedJob{Job: Job{Core: "c3"}, CreatedAt: 300, Account: "alice", Status: StatusQueued},
+		SubmittedJob{Job: Job{Core: "c1"}, CreatedAt: 400, Account: "alice", Status: StatusQueued},
+	)
+
+	job, err := s.ClaimJob(Lease{}, []string{"c2"})
+	if err != nil || job == nil || job.JID != jids[1] {
+		t.Fatalf("Expected to claim job [%d] past the full core type, got [%#v] and [%v]", jids[1], job, err)
+	}
+
+	job, err = s.ClaimAccountJob("alice", Lease{}, []string{"c1", "c2"})
+	if err != nil || job == nil || job.JID != jids[2] {
+		t.Fatalf("Expected to claim job [%d] past the full core types, got [%#v] and [%v]", jids[2], job, err)
+	}
+
+	if job, err := s.ClaimJob(Lease{}, []string{"c1", "c2"}); err != nil || job != nil {
+		t.Errorf("Expected every remaining job to be passed over, got [%#v] and [%v]", job, err)
+	}
+	expectJIDs(t, s, JobQuery{Statuses: []string{StatusQueued}}, jids[0], jids[3])
+}
+
+func checkClaimLease(t *testing.T, s Storage) {
+	insertJobs(t, s,
+		SubmittedJob{CreatedAt: 100, Account: "alice", Status: StatusQueued},
+		SubmittedJob{CreatedAt: 200, Account: "alice", Status: StatusQueued},
+	)
+
+	claimed, err := s.ClaimJob(Lease{Owner: "runner-a", Expires: 1000}, nil)
+	if err != nil || claimed == nil {
+		t.Fatalf("Unable to claim a job: [%#v] and [%v]", claimed, err)
+	}
+	if claimed.Owner != "runner-a" || claimed.LeaseExpires != 1000 {
+		t.Errorf("Expected the claimed job to carry its lease, got [%s] [%d]", claimed.Owner, claimed.LeaseExpires)
+	}
+
+	other, err := s.ClaimAccountJob("alice", Lease{Owner: "runner-b", Expires: 2000}, nil)
+	if err != nil || other == nil {
+		t.Fatalf("Unable to claim an account's job: [%#v] and [%v]", other, err)
+	}
+
+	jobs, err := s.ListJobs(JobQuery{JIDs: []uint64{claimed.JID, other.JID}})
+	if err != nil {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+	if len(jobs) != 2 || jobs[0].Owner != "runner-a" || jobs[1].Owner != "runner-b" || jobs[1].LeaseExpires != 2000 {
+		t.Errorf("Expected the leases to be stored, got %#v", jobs)
+	}
+}
+
+func checkRenewLease(t *testing.T, s Storage) {
+	jids := insertJobs(t, s,
+		SubmittedJob{Account: "alice", Status: StatusProcessing, Owner: "runner-a", LeaseExpires: 1000},
+		SubmittedJob{Account: "alice", Status: StatusProcessing},
+		SubmittedJob{Account: "alice", Status: StatusDone, Owner: "runner-a", LeaseExpires: 1000},
+	)
+
+	if err := s.RenewLease(jids[0], Lease{Owner: "runner-a", Expires: 3000}); err != nil {
+		t.Errorf("Unable to renew a lease: %v", err)
+	}
+	if err := s.RenewLease(jids[0], Lease{Owner: "runner-b", Expires: 4000}); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound when renewing another runner's lease, got [%v]", err)
+	}
+	if err := s.RenewLease(jids[1], Lease{Owner: "runner-b", Expires: 4000}); err != nil {
+		t.Errorf("Unable to lease a job that nobody holds: %v", err)
+	}
+	if err := s.RenewLease(jids[2], Lease{Owner: "runner-a", Expires: 4000}); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound when renewing the lease of a finished job, got [%v]", err)
+	}
+	if err := s.RenewLease(jids[2]+100, Lease{Owner: "runner-a", Expires: 4000}); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound when renewing the lease of a missing job, got [%v]", err)
+	}
+
+	jobs, err := s.ListJobs(JobQuery{JIDs: jids})
+	if err != nil {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+	expected := []Lease{{"runner-a", 3000}, {"runner-b", 4000}, {"runner-a", 1000}}
+	for i, job := range jobs {
+		if job.Owner != expected[i].Owner || job.LeaseExpires != expected[i].Expires {
+			t.Errorf("Expected job [%d] to have lease %#v, got [%s] [%d]", job.JID, expected[i], job.Owner, job.LeaseExpires)
+		}
+	}
+}
+
+func checkUnclaimJob(t *testing.T, s Storage) {
+	jids := insertJobs(t, s,
+		SubmittedJob{CreatedAt: 100, Account: "alice", Status: StatusQueued},
+		SubmittedJob{CreatedAt: 200, Account: "alice", Status: StatusQueued},
+	)
+
+	job, err := s.ClaimJob(Lease{Owner: "runner-a", Expires: 1000}, nil)
+	if err != nil || job == nil || job.JID != jids[0] {
+		t.Fatalf("Unable to claim a job: [%#v] and [%v]", job, err)
+	}
+
+	if err := s.UnclaimJob(job.JID, Lease{Owner: "runner-b"}); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound when unclaiming another runner's job, got [%v]", err)
+	}
+	if err := s.UnclaimJob(jids[1], Lease{Owner: "runner-a"}); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound when unclaiming a queued job, got [%v]", err)
+	}
+	if err := s.UnclaimJob(job.JID, Lease{Owner: "runner-a"}); err != nil {
+		t.Fatalf("Unable to unclaim a job: %v", err)
+	}
+
+	jobs, err := s.ListJobs(JobQuery{JIDs: jids[:1]})
+	if err != nil || len(jobs) != 1 {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+	if jobs[0].Status != StatusQueued || jobs[0].Owner != "" || jobs[0].LeaseExpires != 0 {
+		t.Errorf("Expected the job to be queued without a lease, got [%s] [%s] [%d]", jobs[0].Status, jobs[0].Owner, jobs[0].LeaseExpires)
+	}
+
+	// The job keeps its place at the front of the queue.
+	job, err = s.ClaimJob(Lease{}, nil)
+	if err != nil || job == nil || job.JID != jids[0] {
+		t.Errorf("Expected to claim job [%d] again, got [%#v] and [%v]", jids[0], job, err)
+	}
+}
+
+func checkClaimLapsedJob(t *testing.T, s Storage) {
+	jids := insertJobs(t, s,
+		SubmittedJob{Account: "alice", Status: StatusProcessing, Owner: "runner-a", LeaseExpires: 3000},
+		SubmittedJob{Account: "alice", Status: StatusProcessing, Owner: "runner-a", LeaseExpires: 1000},
+		SubmittedJob{Account: "alice", Status: StatusProcessing},
+		SubmittedJob{Account: "alice", Status: StatusDone, Owner: "runner-a", LeaseExpires: 1000},
+		SubmittedJob{Account: "alice", Status: StatusProcessing, Owner: "runner-b", LeaseExpires: 1500},
+	)
+
+	for _, expected := range []uint64{jids[1], jids[4]} {
+		job, err := s.ClaimLapsedJob(Lease{Owner: "sweeper", Expires: 9000}, 2000)
+		if err != nil {
+			t.Fatalf("Unable to claim a lapsed job: %v", err)
+		}
+		if job == nil || job.JID != expected {
+			t.Fatalf("Expected to claim lapsed job [%d], got %#v", expected, job)
+		}
+		if job.Owner != "sweeper" || job.LeaseExpires != 9000 || job.Status != StatusProcessing {
+			t.Errorf("Expected the lapsed job to be taken over, got %#v", job)
+		}
+	}
+
+	if job, err := s.ClaimLapsedJob(Lease{Owner: "sweeper", Expires: 9000}, 2000); err != nil || job != nil {
+		t.Errorf("Expected no more lapsed leases, got [%#v] and [%v]", job, err)
+	}
+
+	// The original owner can no longer renew its lease.
+	if err := s.RenewLease(jids[1], Lease{Owner: "runner-a", Expires: 5000}); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound when renewing a lease that was taken over, got [%v]", err)
+	}
+}
+
+func checkQueueSummary(t *testing.T, s Storage) {
+	queues, err := s.QueueSummary()
+	if err != nil {
+		t.Fatalf("Unable to summarize an empty queue: %v", err)
+	}
+	if len(queues) != 0 {
+		t.Errorf("Expected an empty summary, got %#v", queues)
+	}
+
+	insertJobs(t, s,
+		SubmittedJob{Account: "bob", Status: StatusQueued},
+		SubmittedJob{Account: "alice", Status: StatusProcessing},
+		SubmittedJob{Account: "bob", Status: StatusQueued},
+		SubmittedJob{Account: "alice", Status: StatusQueued},
+		SubmittedJob{Account: "bob", Status: StatusProcessing},
+		SubmittedJob{Account: "alice", Status: StatusProcessing},
+		SubmittedJob{Account: "carol", Status: StatusDone},
+	)
+
+	queues, err = s.QueueSummary()
+	if err != nil {
+		t.Fatalf("Unable to summarize the queue: %v", err)
+	}
+
+	expected := []AccountQueue{
+		{Account: "alice", Queued: 1, Running: 2},
+		{Account: "bob", Queued: 2, Running: 1},
+	}
+	if !reflect.DeepEqual(queues, expected) {
+		t.Errorf("Expected summary %#v, got %#v", expected, queues)
+	}
+}
+
+func checkQueueRevision(t *testing.T, s Storage) {
+	last, err := s.QueueRevision()
+	if err != nil {
+		t.Fatalf("Unable to read the queue revision: %v", err)
+	}
+
+	expect := func(advanced bool, action string) {
+		revision, err := s.QueueRevision()
+		if err != nil {
+			t.Fatalf("Unable to read the queue revision: %v", err)
+		}
+		if (revision != last) != advanced {
+			t.Errorf("Expected the queue revision to advance [%v] after %s, went from [%d] to [%d]", advanced, action, last, revision)
+		}
+		last = revision
+	}
+
+	jids := insertJobs(t, s, SubmittedJob{Account: "alice", Status: StatusQueued})
+	expect(true, "inserting a queued job")
+
+	insertJobs(t, s, SubmittedJob{Account: "alice", Status: StatusWaiting})
+	expect(false, "inserting a waiting job")
+
+	if _, err := s.InsertJobs([]SubmittedJob{{Account: "bob", Status: StatusQueued}}); err != nil {
+		t.Fatalf("Unable to insert a batch of jobs: %v", err)
+	}
+	expect(true, "inserting a batch of queued jobs")
+
+	if err := s.ReprioritizeJob(jids[0], 5); err != nil {
+		t.Fatalf("Unable to reprioritize a job: %v", err)
+	}
+	expect(false, "reprioritizing a queued job")
+
+	job, err := s.ClaimJob(Lease{}, nil)
+	if err != nil || job == nil {
+		t.Fatalf("Unable to claim a job: %v", err)
+	}
+	expect(false, "claiming a job")
+
+	job.Status = StatusQueued
+	if err := s.UpdateJob(job); err != nil {
+		t.Fatalf("Unable to update a job: %v", err)
+	}
+	expect(true, "returning a job to the queue")
+
+	if err := s.UpdateJob(job); err != nil {
+		t.Fatalf("Unable to update a job: %v", err)
+	}
+	expect(false, "updating a job that's already queued")
+
+	job, err = s.ClaimJob(Lease{}, nil)
+	if err != nil || job == nil {
+		t.Fatalf("Unable to claim a job: %v", err)
+	}
+	if err := s.UnclaimJob(job.JID, Lease{}); err != nil {
+		t.Fatalf("Unable to unclaim a job: %v", err)
+	}
+	expect(false, "unclaiming a job")
+}
+
+func checkUpdateJob(t *testing.T, s Storage) {
+	jids := insertJobs(t, s, SubmittedJob{Job: Job{Command: "id"}, Account: "alice", Status: StatusQueued})
+
+	jobs, err := s.ListJobs(JobQuery{JIDs: jids})
+	if err != nil || len(jobs) != 1 {
+		t.Fatalf("Unable to load the inserted job: %v", err)
+	}
+	runnerCopy := jobs[0]
+
+	// A kill is requested by another party.
+	killCopy := jobs[0]
+	killCopy.KillRequested = true
+	if err := s.UpdateJob(&killCopy); err != nil {
+		t.Fatalf("Unable to update the job: %v", err)
+	}
+
+	// A stale copy that never saw the kill request must not clear it.
+	runnerCopy.Status = StatusProcessing
+	runnerCopy.OverheadDelay = 42
+	runnerCopy.ContainerID = "c0ffee"
+	if err := s.UpdateJob(&runnerCopy); err != nil {
+		t.Fatalf("Unable to update the job: %v", err)
+	}
+
+	jobs, err = s.ListJobs(JobQuery{JIDs: jids})
+	if err != nil || len(jobs) != 1 {
+		t.Fatalf("Unable to load the updated job: %v", err)
+	}
+	updated := jobs[0]
+
+	if updated.Status != StatusProcessing || updated.OverheadDelay != 42 ||
+		updated.ContainerID != "c0ffee" || updated.Command != "id" {
+		t.Errorf("Expected the update to be stored, got %#v", updated)
+	}
+	if !updated.KillRequested {
+		t.Error("Expected the kill request to survive an update from a stale copy")
+	}
+
+	if job, _ := s.ClaimJob(Lease{}, nil); job != nil {
+		t.Errorf("Expected a job that left the queue to be unclaimable, but claimed [%d]", job.JID)
+	}
+
+	if err := s.UpdateJob(&SubmittedJob{JID: jids[0] + 1000}); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound when updating a missing job, got [%v]", err)
+	}
+}
+
+func checkJobKillRequested(t *testing.T, s Storage) {
+	jids := insertJobs(t, s,
+		SubmittedJob{Account: "alice", Status: StatusQueued},
+		SubmittedJob{Account: "alice", Status: StatusQueued, KillRequested: true},
+	)
+
+	if killed, err := s.JobKillRequested(jids[0]); err != nil || killed {
+		t.Errorf("Expected no kill request for job [%d], got [%v] and [%v]", jids[0], killed, err)
+	}
+	if killed, err := s.JobKillRequested(jids[1]); err != nil || !killed {
+		t.Errorf("Expected a kill request for job [%d], got [%v] and [%v]", jids[1], killed, err)
+	}
+	if _, err := s.JobKillRequested(jids[1] + 1000); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for a missing job, got [%v]", err)
+	}
+}
+
+func checkOutputChunks(t *testing.T, s Storage) {
+	jids := insertJobs(t, s,
+		SubmittedJob{Account: "alice", Status: StatusProcessing},
+		SubmittedJob{Account: "alice", Status: StatusProcessing},
+	)
+
+	chunks := []OutputChunk{
+		{JID: jids[0], Stream: StreamStdout, Sequence: 1, Data: []byte("world\n")},
+		{JID: jids[1], Stream: StreamStdout, Sequence: 0, Data: []byte("other")},
+		{JID: jids[0], Stream: StreamStderr, Sequence: 0, Data: []byte("oops")},
+		{JID: jids[0], Stream: StreamStdout, Sequence: 0, Data: []byte("hello ")},
+	}
+	for _, chunk := range chunks {
+		if err := s.AppendOutput(chunk); err != nil {
+			t.Fatalf("Unable to append output: %v", err)
+		}
+	}
+
+	jobs, err := s.ListJobs(JobQuery{JIDs: jids})
+	if err != nil || len(jobs) != 2 {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+	for _, job := range jobs {
+		switch job.JID {
+		case jids[0]:
+			if job.Stdout != "hello world\n" || job.Stderr != "oops" {
+				t.Errorf("Unexpected output for job [%d]: [%s] [%s]", job.JID, job.Stdout, job.Stderr)
+			}
+		case jids[1]:
+			if job.Stdout != "other" || job.Stderr != "" {
+				t.Errorf("Unexpected output for job [%d]: [%s] [%s]", job.JID, job.Stdout, job.Stderr)
+			}
+		}
+	}
+
+	// Updating a listed job must not copy its assembled output into the job document.
+	if err := s.UpdateJob(&jobs[0]); err != nil {
+		t.Fatalf("Unable to update a job: %v", err)
+	}
+	jobs, err = s.ListJobs(JobQuery{JIDs: jids[:1]})
+	if err != nil || len(jobs) != 1 {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+	if jobs[0].Stdout != "hello world\n" {
+		t.Errorf("Expected output to be unchanged by an update, got [%s]", jobs[0].Stdout)
+	}
+}
+
+func checkSkipOutput(t *testing.T, s Storage) {
+	jids := insertJobs(t, s, SubmittedJob{Account: "alice", Status: StatusWaiting})
+	if err := s.AppendOutput(OutputChunk{JID: jids[0], Stream: StreamStdout, Data: []byte("hello")}); err != nil {
+		t.Fatalf("Unable to append output: %v", err)
+	}
+
+	jobs, err := s.ListJobs(JobQuery{Statuses: []string{StatusWaiting}, SkipOutput: true})
+	if err != nil || len(jobs) != 1 {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+	if jobs[0].JID != jids[0] || jobs[0].Status != StatusWaiting || jobs[0].Stdout != "" {
+		t.Errorf("Expected the job without its output, got %#v", jobs[0])
+	}
+
+	// Updating a job that was listed without its output leaves the output alone.
+	jobs[0].Status = StatusQueued
+	if err := s.UpdateJob(&jobs[0]); err != nil {
+		t.Fatalf("Unable to update a job: %v", err)
+	}
+	jobs, err = s.ListJobs(JobQuery{JIDs: jids})
+	if err != nil || len(jobs) != 1 {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+	if jobs[0].Status != StatusQueued || jobs[0].Stdout != "hello" {
+		t.Errorf("Expected the output to survive the update, got [%s] [%s]", jobs[0].Status, jobs[0].Stdout)
+	}
+}
+
+func checkDeleteJobs(t *testing.T, s Storage) {
+	jids := insertJobs(t, s,
+		SubmittedJob{Account: "alice", Status: StatusDone},
+		SubmittedJob{Account: "alice", Status: StatusQueued},
+		SubmittedJob{Account: "alice", Status: StatusDone},
+	)
+	for _, jid := range jids {
+		if err := s.AppendOutput(OutputChunk{JID: jid, Stream: StreamStdout, Data: []byte("out")}); err != nil {
+			t.Fatalf("Unable to append output: %v", err)
+		}
+	}
+
+	if err := s.DeleteJobs([]uint64{jids[0], jids[1], jids[2] + 1000}); err != nil {
+		t.Fatalf("Unable to delete jobs: %v", err)
+	}
+	expectJIDs(t, s, JobQuery{}, jids[2])
+
+	// Deleted queued jobs are never claimed.
+	job, err := s.ClaimJob(Lease{}, nil)
+	if err != nil {
+		t.Fatalf("Unable to claim a job: %v", err)
+	}
+	if job != nil {
+		t.Errorf("Expected no job to be claimed, got [%d]", job.JID)
+	}
+
+	jobs, err := s.ListJobs(JobQuery{JIDs: jids[2:]})
+	if err != nil || len(jobs) != 1 {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+	if jobs[0].Stdout != "out" {
+		t.Errorf("Expected the surviving job's output to be intact, got [%s]", jobs[0].Stdout)
+	}
+}
+
+func checkGetAccount(t *testing.T, s Storage) {
+	account, err := s.GetAccount("alice")
+	if err != nil {
+		t.Fatalf("Unable to get an account: %v", err)
+	}
+	if account.Name != "alice" || account.Admin || account.TotalJobs != 0 || account.TotalRuntime != 0 {
+		t.Errorf("Expected a fresh account, got %#v", account)
+	}
+
+	if err := s.UpdateAccountUsage("alice", 100); err != nil {
+		t.Fatalf("Unable to update an account: %v", err)
+	}
+
+	// A second GetAccount must find the existing account rather than resetting it.
+	account, err = s.GetAccount("alice")
+	if err != nil {
+		t.Fatalf("Unable to get an account: %v", err)
+	}
+	if account.TotalJobs != 1 || account.TotalRuntime != 100 {
+		t.Errorf("Expected GetAccount to preserve an existing account, got %#v", account)
+	}
+}
+
+func checkAccountUpdates(t *testing.T, s Storage) {
+	if err := s.UpdateAccountAdmin("alice", true); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound when updating a missing account, got [%v]", err)
+	}
+
+	if _, err := s.GetAccount("alice"); err != nil {
+		t.Fatalf("Unable to get an account: %v", err)
+	}
+	if _, err := s.GetAccount("bob"); err != nil {
+		t.Fatalf("Unable to get an account: %v", err)
+	}
+
+	if err := s.UpdateAccountAdmin("alice", true); err != nil {
+		t.Fatalf("Unable to update an account: %v", err)
+	}
+	for _, runtime := range []int64{100, 250} {
+		if err := s.UpdateAccountUsage("alice", runtime); err != nil {
+			t.Fatalf("Unable to update an account: %v", err)
+		}
+	}
+
+	alice, err := s.GetAccount("alice")
+	if err != nil {
+		t.Fatalf("Unable to get an account: %v", err)
+	}
+	if !alice.Admin || alice.TotalJobs != 2 || alice.TotalRuntime != 350 {
+		t.Errorf("Unexpected account after updates: %#v", alice)
+	}
+
+	bob, err := s.GetAccount("bob")
+	if err != nil {
+		t.Fatalf("Unable to get an account: %v", err)
+	}
+	if bob.Admin || bob.TotalJobs != 0 {
+		t.Errorf("Expected updates to one account to leave others alone, got %#v", bob)
+	}
+
+	if err := s.UpdateAccountAdmin("alice", false); err != nil {
+		t.Fatalf("Unable to update an account: %v", err)
+	}
+	if alice, _ = s.GetAccount("alice"); alice.Admin {
+		t.Error("Expected the admin flag to be cleared")
+	}
+}
+
+func checkAccountMaxPriority(t *testing.T, s Storage) {
+	if err := s.UpdateAccountMaxPriority("alice", 5); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound when updating a missing account, got [%v]", err)
+	}
+
+	if _, err := s.GetAccount("alice"); err != nil {
+		t.Fatalf("Unable to get an account: %v", err)
+	}
+	if err := s.UpdateAccountMaxPriority("alice", 5); err != nil {
+		t.Fatalf("Unable to update an account: %v", err)
+	}
+	if err := s.UpdateAccountUsage("alice", 10); err != nil {
+		t.Fatalf("Unable to update an account: %v", err)
+	}
+
+	alice, err := s.GetAccount("alice")
+	if err != nil {
+		t.Fatalf("Unable to get an account: %v", err)
+	}
+	if alice.MaxPriority != 5 || alice.TotalRuntime != 10 {
+		t.Errorf("Unexpected account after updates: %#v", alice)
+	}
+}
+
+func checkSchedules(t *testing.T, s Storage) {
+	name := "nightly"
+	first, err := s.InsertSchedule(Schedule{
+		Account:   "alice",
+		Cron:      "@daily",
+		Job:       Job{Command: "backup", Name: &name, Environment: map[string]string{"A": "1"}},
+		NextRunAt: 100,
+	})
+	if err != nil {
+		t.Fatalf("Unable to insert a schedule: %v", err)
+	}
+	second, err := s.InsertSchedule(Schedule{Account: "bob", Cron: "@hourly", NextRunAt: 200})
+	if err != nil {
+		t.Fatalf("Unable to insert a schedule: %v", err)
+	}
+	if first == 0 || second <= first {
+		t.Errorf("Expected increasing schedule IDs, got [%d] and [%d]", first, second)
+	}
+
+	all, err := s.ListSchedules("")
+	if err != nil {
+		t.Fatalf("Unable to list schedules: %v", err)
+	}
+	if len(all) != 2 || all[0].ID != first || all[1].ID != second {
+		t.Fatalf("Expected both schedules in ID order, got %#v", all)
+	}
+	job := all[0].Job
+	if job.Command != "backup" || job.Name == nil || *job.Name != "nightly" || job.Environment["A"] != "1" {
+		t.Errorf("Expected the job template to be stored, got %#v", job)
+	}
+
+	own, err := s.ListSchedules("bob")
+	if err != nil {
+		t.Fatalf("Unable to list schedules: %v", err)
+	}
+	if len(own) != 1 || own[0].ID != second || own[0].Account != "bob" {
+		t.Errorf("Expected only bob's schedule, got %#v", own)
+	}
+
+	if err := s.PauseSchedule(first, true, 0); err != nil {
+		t.Fatalf("Unable to pause a schedule: %v", err)
+	}
+	all, _ = s.ListSchedules("alice")
+	if len(all) != 1 || !all[0].Paused || all[0].NextRunAt != 0 {
+		t.Errorf("Expected the schedule to be paused, got %#v", all)
+	}
+	if err := s.PauseSchedule(first, false, 300); err != nil {
+		t.Fatalf("Unable to resume a schedule: %v", err)
+	}
+	all, _ = s.ListSchedules("alice")
+	if len(all) != 1 || all[0].Paused || all[0].NextRunAt != 300 {
+		t.Errorf("Expected the schedule to be resumed, got %#v", all)
+	}
+
+	if err := s.DeleteSchedule(first); err != nil {
+		t.Fatalf("Unable to delete a schedule: %v", err)
+	}
+	if err := s.DeleteSchedule(first); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound deleting a missing schedule, got [%v]", err)
+	}
+	if err := s.PauseSchedule(first, true, 0); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound pausing a missing schedule, got [%v]", err)
+	}
+	all, _ = s.ListSchedules("")
+	if len(all) != 1 || all[0].ID != second {
+		t.Errorf("Expected only the remaining schedule, got %#v", all)
+	}
+}
+
+func checkAdvanceSchedule(t *testing.T, s Storage) {
+	id, err := s.InsertSchedule(Schedule{Account: "alice", Cron: "@hourly", NextRunAt: 100})
+	if err != nil {
+		t.Fatalf("Unable to insert a schedule: %v", err)
+	}
+
+	if err := s.AdvanceSchedule(id, 100, 200); err != nil {
+		t.Fatalf("Unable to advance a schedule: %v", err)
+	}
+	if err := s.AdvanceSchedule(id, 100, 300); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound advancing from a stale run, got [%v]", err)
+	}
+	if err := s.AdvanceSchedule(id+1, 200, 300); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound advancing a missing schedule, got [%v]", err)
+	}
+
+	schedules, _ := s.ListSchedules("")
+	if len(schedules) != 1 || schedules[0].NextRunAt != 200 {
+		t.Errorf("Expected the schedule to advance once, got %#v", schedules)
+	}
+}
+
+func checkScheduleHistory(t *testing.T, s Storage) {
+	id, err := s.InsertSchedule(Schedule{Account: "alice", Cron: "@hourly"})
+	if err != nil {
+		t.Fatalf("Unable to insert a schedule: %v", err)
+	}
+
+	total := scheduleHistoryLimit + 5
+	for i := 1; i <= total; i++ {
+		if err := s.RecordScheduleRun(id, ScheduleRun{ScheduledAt: StoredTime(i), JID: uint64(i)}); err != nil {
+			t.Fatalf("Unable to record a schedule run: %v", err)
+		}
+	}
+	if err := s.RecordScheduleRun(id+1, ScheduleRun{}); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound recording a run of a missing schedule, got [%v]", err)
+	}
+
+	schedules, _ := s.ListSchedules("")
+	if len(schedules) != 1 {
+		t.Fatalf("Expected one schedule, got %#v", schedules)
+	}
+	runs := schedules[0].Runs
+	if len(runs) != scheduleHistoryLimit {
+		t.Fatalf("Expected [%d] runs, got [%d]", scheduleHistoryLimit, len(runs))
+	}
+	if runs[0].JID != 6 || runs[len(runs)-1].JID != uint64(total) {
+		t.Errorf("Expected the oldest runs to be forgotten, got runs from [%d] to [%d]", runs[0].JID, runs[len(runs)-1].JID)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// ReleaseWaitingJobs moves jobs out of StatusWaiting once nothing holds them back. A job waits for
+// its dependency, if it has one, and until its RetryAt time, if it's waiting out a retry backoff.
+//
+// Jobs whose dependency is done are queued. Jobs whose dependency failed, was killed, or no longer
+// exists fail with StatusError. Jobs that depend on another waiting job are resolved on a later
+// pass, once that job leaves StatusWaiting itself.
+func ReleaseWaitingJobs(c *Context, now time.Time) error {
+	waiting, err := c.ListJobs(JobQuery{Statuses: []string{StatusWaiting}, SkipOutput: true})
+	if err != nil {
+		return err
+	}
+	if len(waiting) == 0 {
+		return nil
+	}
+
+	seen := make(map[uint64]bool)
+	jids := make([]uint64, 0, len(waiting))
+	for _, job := range waiting {
+		if jid, ok := job.Dependency(); ok && !seen[jid] {
+			seen[jid] = true
+			jids = append(jids, jid)
+		}
+	}
+
+	statuses := make(map[uint64]string, len(jids))
+	if len(jids) > 0 {
+		dependencies, err := c.ListJobs(JobQuery{JIDs: jids, SkipOutput: true})
+		if err != nil {
+			return err
+		}
+		for _, dependency := range dependencies {
+			statuses[dependency.JID] = dependency.Status
+		}
+	}
+
+	for i := range waiting {
+		job := &waiting[i]
+		if job.KillRequested {
+			// The kill handler removes it from StatusWaiting.
+			continue
+		}
+
+		jid, hasDependency := job.Dependency()
+		status, ok := statuses[jid]
+
+		var reason string
+		switch {
+		case hasDependency && !ok:
+			reason = fmt.Sprintf("Dependency [%d] no longer exists.\n", jid)
+		case hasDependency && status != StatusDone && completedStatus[status]:
+			reason = fmt.Sprintf("Dependency [%d] finished with status [%s].\n", jid, status)
+		case hasDependency && status != StatusDone:
+			continue
+		case job.RetryAt.AsTime().After(now):
+			continue
+		default:
+			job.Status = StatusQueued
+		}
+
+		if reason != "" {
+			// Explain the failure on the job's stderr, since it will never run to produce any.
+			chunk := OutputChunk{JID: job.JID, Stream: StreamStderr, Sequence: outputBlock(job), Data: []byte(reason)}
+			if err := c.AppendOutput(chunk); err != nil {
+				log.WithFields(log.Fields{
+					"jid":   job.JID,
+					"error": err,
+				}).Error("Unable to record why a dependent job failed.")
+			}
+
+			job.Status = StatusError
+			job.FinishedAt = StoreTime(now)
+		}
+
+		if err := c.UpdateJob(job); err != nil {
+			return err
+		}
+
+		log.WithFields(log.Fields{
+			"jid":     job.JID,
+			"account": job.Account,
+			"status":  job.Status,
+		}).Info("Waiting job released.")
+	}
+
+	return nil
+}
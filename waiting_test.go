@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestReleaseDependentJobsInMemory(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{Storage: s}
+
+	deps := insertJobs(t, s,
+		SubmittedJob{Account: "alice", Status: StatusDone},
+		SubmittedJob{Account: "alice", Status: StatusError},
+		SubmittedJob{Account: "alice", Status: StatusKilled},
+		SubmittedJob{Account: "alice", Status: StatusProcessing},
+	)
+	dependOn := func(jid uint64) Job {
+		raw := fmt.Sprintf("%d", jid)
+		return Job{DependsOn: &raw}
+	}
+
+	waiting := insertJobs(t, s,
+		SubmittedJob{Job: dependOn(deps[0]), Account: "alice", Status: StatusWaiting},
+		SubmittedJob{Job: dependOn(deps[1]), Account: "alice", Status: StatusWaiting},
+		SubmittedJob{Job: dependOn(deps[2]), Account: "alice", Status: StatusWaiting},
+		SubmittedJob{Job: dependOn(deps[3]), Account: "alice", Status: StatusWaiting},
+		SubmittedJob{Job: dependOn(999), Account: "alice", Status: StatusWaiting},
+	)
+	chained := insertJobs(t, s, SubmittedJob{Job: dependOn(waiting[1]), Account: "alice", Status: StatusWaiting})
+
+	if err := ReleaseWaitingJobs(c, time.Now()); err != nil {
+		t.Fatalf("Unable to release waiting jobs: %v", err)
+	}
+
+	expectJIDs(t, s, JobQuery{Statuses: []string{StatusQueued}}, waiting[0])
+	expectJIDs(t, s, JobQuery{Statuses: []string{StatusWaiting}}, waiting[3], chained[0])
+	expectJIDs(t, s, JobQuery{Statuses: []string{StatusError}}, deps[1], waiting[1], waiting[2], waiting[4])
+
+	jobs, err := s.ListJobs(JobQuery{JIDs: waiting[2:3]})
+	if err != nil {
+		t.Fatalf("Unable to list jobs: %v", err)
+	}
+	expected := fmt.Sprintf("Dependency [%d] finished with status [killed].\n", deps[2])
+	if len(jobs) != 1 || jobs[0].Stderr != expected || jobs[0].FinishedAt == 0 {
+		t.Errorf("Expected the failed dependent to explain itself, got %#v", jobs)
+	}
+
+	// Failures cascade along chains of dependencies on later passes.
+	if err := ReleaseWaitingJobs(c, time.Now()); err != nil {
+		t.Fatalf("Unable to release waiting jobs: %v", err)
+	}
+	expectJIDs(t, s, JobQuery{Statuses: []string{StatusWaiting}}, waiting[3])
+}
+
+func TestReleaseRetriedJobsInMemory(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{Storage: s}
+	now := time.Now()
+
+	jids := insertJobs(t, s,
+		SubmittedJob{Account: "alice", Status: StatusWaiting, RetryAt: StoreTime(now.Add(-time.Second))},
+		SubmittedJob{Account: "alice", Status: StatusWaiting, RetryAt: StoreTime(now.Add(time.Minute))},
+	)
+
+	if err := ReleaseWaitingJobs(c, now); err != nil {
+		t.Fatalf("Unable to release waiting jobs: %v", err)
+	}
+	expectJIDs(t, s, JobQuery{Statuses: []string{StatusQueued}}, jids[0])
+	expectJIDs(t, s, JobQuery{Statuses: []string{StatusWaiting}}, jids[1])
+
+	if err := ReleaseWaitingJobs(c, now.Add(2*time.Minute)); err != nil {
+		t.Fatalf("Unable to release waiting jobs: %v", err)
+	}
+	expectJIDs(t, s, JobQuery{Statuses: []string{StatusQueued}}, jids...)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Wakeup wakes the job runner as soon as there may be work for it to claim, instead of leaving it
+// to notice on its next poll. Notifications that arrive while the runner is busy are coalesced, so
+// that it wakes once to claim everything that's been queued in the meantime.
+//
+// Notifying a nil Wakeup does nothing, and waiting on one simply sleeps.
+type Wakeup struct {
+	notified chan struct{}
+}
+
+// NewWakeup creates a Wakeup with no pending notification.
+func NewWakeup() *Wakeup {
+	return &Wakeup{notified: make(chan struct{}, 1)}
+}
+
+// Notify wakes the job runner if it's waiting, or makes its next Wait return immediately if it
+// isn't. It never blocks.
+func (w *Wakeup) Notify() {
+	if w == nil {
+		return
+	}
+
+	select {
+	case w.notified <- struct{}{}:
+	default:
+		// A notification is already pending.
+	}
+}
+
+// Wait blocks until Notify is called or the timeout elapses, and reports whether it was notified.
+func (w *Wakeup) Wait(timeout time.Duration) bool {
+	if w == nil {
+		time.Sleep(timeout)
+		return false
+	}
+
+	// A pending notification wins, even if the timeout has already elapsed.
+	select {
+	case <-w.notified:
+		return true
+	default:
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-w.notified:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// WatchQueue follows the storage's queue revision, waking the job runner whenever it advances. This
+// is how jobs that are submitted to another cloudpipe instance sharing the same storage, or that
+// another instance's runner queued again, come to the attention of this one.
+func WatchQueue(c *Context) {
+	var last uint64
+	for {
+		last = checkQueue(c, last)
+
+		time.Sleep(time.Duration(c.FeedPoll) * time.Millisecond)
+	}
+}
+
+// checkQueue wakes the job runner if the queue revision has moved on from last, and returns the
+// current revision.
+func checkQueue(c *Context, last uint64) uint64 {
+	revision, err := c.QueueRevision()
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Unable to read the queue revision.")
+		return last
+	}
+
+	if revision != last {
+		c.Wakeup.Notify()
+	}
+	return revision
+}
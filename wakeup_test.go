@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWakeup(t *testing.T) {
+	w := NewWakeup()
+
+	if w.Wait(10 * time.Millisecond) {
+		t.Error("Expected to time out without a notification")
+	}
+
+	// Notifications are coalesced until the next Wait.
+	w.Notify()
+	w.Notify()
+	if !w.Wait(time.Second) {
+		t.Error("Expected a pending notification to wake immediately")
+	}
+	if w.Wait(10 * time.Millisecond) {
+		t.Error("Expected both notifications to be consumed by a single Wait")
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		w.Notify()
+	}()
+	if !w.Wait(5 * time.Second) {
+		t.Error("Expected a notification to wake a waiting runner")
+	}
+
+	var none *Wakeup
+	none.Notify()
+	if none.Wait(time.Millisecond) {
+		t.Error("Expected a nil Wakeup to never wake")
+	}
+}
+
+func TestCheckQueueInMemory(t *testing.T) {
+	s := NewMemoryStorage()
+	c := &Context{Storage: s, Wakeup: NewWakeup()}
+
+	last := checkQueue(c, 0)
+	if c.Wakeup.Wait(0) {
+		t.Error("Expected an empty queue not to wake the job runner")
+	}
+
+	// Another instance queues a job in the shared storage.
+	insertJobs(t, s, SubmittedJob{Account: "admin", Status: StatusQueued})
+
+	last = checkQueue(c, last)
+	if !c.Wakeup.Wait(0) {
+		t.Error("Expected the new job to wake the job runner")
+	}
+
+	checkQueue(c, last)
+	if c.Wakeup.Wait(0) {
+		t.Error("Expected an unchanged queue not to wake the job runner again")
+	}
+}